@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lucendex/backend/internal/api"
+	"github.com/lucendex/backend/internal/kv"
+)
+
+var (
+	version   = "dev"
+	buildTime = "unknown"
+)
+
+const usage = `usage: lucendex-admin <command> [flags] [args]
+
+Partners:
+  partners                              list partners
+  partner <partner-id>                  show a partner
+  create -name NAME -plan PLAN [-bps N] create a partner
+  update <partner-id> [-name] [-plan] [-bps]
+  suspend <partner-id>
+  activate <partner-id>
+
+Keys:
+  keys <partner-id>                     list keys, revoked included
+  add-key <partner-id> -public-key HEX [-label L] [-scopes quote,pairs,usage]
+  rotate-key <partner-id> <key-id> -public-key HEX [-label L] [-scopes S] [-overlap 168h]
+  revoke-key <partner-id> <key-id>
+
+Client certificates (mTLS):
+  certs <partner-id>                    list bound certificates
+  bind-cert <partner-id> (-cert FILE | -fingerprint HEX) [-label L]
+  revoke-cert <partner-id> <cert-id>
+
+Reporting:
+  usage <partner-id> [-month YYYY-MM]
+  audit <partner-id> [-limit N]
+
+Billing:
+  close-month [-month YYYY-MM]          invoice every partner for a finished month (default last month)
+  invoices <partner-id> [-month YYYY-MM [-text]]
+                                        list invoices, or show one, -text as a statement
+
+Environment:
+  ADMIN_URL       admin API base URL (default http://localhost:8081)
+  INTERNAL_TOKEN  internal token; ADMIN_ACTOR (default $USER) names you in the audit log
+  ADMIN_TLS_CERT, ADMIN_TLS_KEY, ADMIN_TLS_CA
+                  client certificate; its CommonName is recorded as the actor
+`
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "-version" {
+		fmt.Printf("lucendex-admin %s, build %s\n", version, buildTime)
+		return
+	}
+
+	c, err := newClientFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lucendex-admin: %v\n", err)
+		os.Exit(1)
+	}
+	if err := run(c, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "lucendex-admin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// client calls the admin API with either the internal token or a client
+// certificate
+type client struct {
+	baseURL string
+	token   string
+	actor   string
+	http    *http.Client
+}
+
+func newClientFromEnv() (*client, error) {
+	c := &client{
+		baseURL: strings.TrimRight(getEnv("ADMIN_URL", "http://localhost:8081"), "/"),
+		token:   os.Getenv("INTERNAL_TOKEN"),
+		actor:   getEnv("ADMIN_ACTOR", os.Getenv("USER")),
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	if certFile := os.Getenv("ADMIN_TLS_CERT"); certFile != "" {
+		tlsConfig, err := kv.ClientTLSConfig(certFile, os.Getenv("ADMIN_TLS_KEY"), os.Getenv("ADMIN_TLS_CA"), "")
+		if err != nil {
+			return nil, fmt.Errorf("load TLS material: %w", err)
+		}
+		c.http.Transport = &http.Transport{TLSClientConfig: tlsConfig}
+	}
+	return c, nil
+}
+
+// do sends body as JSON and copies the response, indented, to out
+func (c *client) do(method, path string, body any, out io.Writer) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+"/admin/v1"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("X-Internal-Token", c.token)
+		req.Header.Set("X-Admin-Actor", c.actor)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s: %s (%d)", method, path, apiErr.Error, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: status %d", method, path, resp.StatusCode)
+	}
+	if len(respBody) == 0 {
+		return nil
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, respBody, "", "  "); err != nil {
+		_, err = out.Write(respBody)
+		return err
+	}
+	_, err = indented.WriteTo(out)
+	return err
+}
+
+func run(c *client, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	cmd, args := args[0], args[1:]
+	fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	switch cmd {
+	case "partners":
+		return c.do(http.MethodGet, "/partners", nil, out)
+
+	case "partner":
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return c.do(http.MethodGet, "/partners/"+ids[0], nil, out)
+
+	case "create":
+		name := fs.String("name", "", "partner name")
+		plan := fs.String("plan", "free", "free, pro or enterprise")
+		bps := fs.Int("bps", 20, "router fee in basis points")
+		if _, err := parseArgs(fs, args, 0); err != nil {
+			return err
+		}
+		return c.do(http.MethodPost, "/partners", map[string]any{"name": *name, "plan": *plan, "router_bps": *bps}, out)
+
+	case "update":
+		name := fs.String("name", "", "new partner name")
+		plan := fs.String("plan", "", "new plan")
+		bps := fs.Int("bps", -1, "new router fee in basis points")
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		update := make(map[string]any)
+		if *name != "" {
+			update["name"] = *name
+		}
+		if *plan != "" {
+			update["plan"] = *plan
+		}
+		if *bps >= 0 {
+			update["router_bps"] = *bps
+		}
+		if len(update) == 0 {
+			return errors.New("update: nothing to change")
+		}
+		return c.do(http.MethodPatch, "/partners/"+ids[0], update, out)
+
+	case "suspend", "activate":
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		status := map[string]string{"suspend": "suspended", "activate": "active"}[cmd]
+		return c.do(http.MethodPatch, "/partners/"+ids[0], map[string]any{"status": status}, out)
+
+	case "keys":
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return c.do(http.MethodGet, "/partners/"+ids[0]+"/keys", nil, out)
+
+	case "add-key":
+		publicKey := fs.String("public-key", "", "hex encoded Ed25519 public key")
+		label := fs.String("label", "", "key label")
+		scopes := fs.String("scopes", "", "comma separated scopes, all endpoints if empty")
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		body := map[string]any{"public_key": *publicKey, "label": *label}
+		addScopes(body, *scopes)
+		return c.do(http.MethodPost, "/partners/"+ids[0]+"/keys", body, out)
+
+	case "rotate-key":
+		publicKey := fs.String("public-key", "", "hex encoded Ed25519 public key")
+		label := fs.String("label", "", "key label")
+		scopes := fs.String("scopes", "", "comma separated scopes, the old key's if empty")
+		overlap := fs.Duration("overlap", 7*24*time.Hour, "how long the old key keeps verifying")
+		ids, err := parseArgs(fs, args, 2)
+		if err != nil {
+			return err
+		}
+		body := map[string]any{"public_key": *publicKey, "label": *label, "overlap": overlap.String()}
+		addScopes(body, *scopes)
+		return c.do(http.MethodPost, "/partners/"+ids[0]+"/keys/"+ids[1]+"/rotate", body, out)
+
+	case "revoke-key":
+		ids, err := parseArgs(fs, args, 2)
+		if err != nil {
+			return err
+		}
+		if err := c.do(http.MethodDelete, "/partners/"+ids[0]+"/keys/"+ids[1], nil, out); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked key %s\n", ids[1])
+		return nil
+
+	case "certs":
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return c.do(http.MethodGet, "/partners/"+ids[0]+"/certificates", nil, out)
+
+	case "bind-cert":
+		certFile := fs.String("cert", "", "PEM encoded client certificate")
+		fingerprint := fs.String("fingerprint", "", "hex SHA-256 of the DER certificate")
+		label := fs.String("label", "", "certificate label")
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		if *certFile != "" {
+			pemBytes, err := os.ReadFile(*certFile)
+			if err != nil {
+				return err
+			}
+			if *fingerprint, err = api.PEMFingerprint(pemBytes); err != nil {
+				return fmt.Errorf("%s: %w", *certFile, err)
+			}
+		}
+		if *fingerprint == "" {
+			return errors.New("bind-cert: -cert or -fingerprint required")
+		}
+		return c.do(http.MethodPost, "/partners/"+ids[0]+"/certificates", map[string]any{"fingerprint": *fingerprint, "label": *label}, out)
+
+	case "revoke-cert":
+		ids, err := parseArgs(fs, args, 2)
+		if err != nil {
+			return err
+		}
+		if err := c.do(http.MethodDelete, "/partners/"+ids[0]+"/certificates/"+ids[1], nil, out); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "revoked certificate %s\n", ids[1])
+		return nil
+
+	case "usage":
+		month := fs.String("month", time.Now().Format("2006-01"), "billing month (YYYY-MM)")
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return c.do(http.MethodGet, "/partners/"+ids[0]+"/usage?month="+*month, nil, out)
+
+	case "audit":
+		limit := fs.Int("limit", 100, "number of records")
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		return c.do(http.MethodGet, fmt.Sprintf("/partners/%s/audit?limit=%d", ids[0], *limit), nil, out)
+
+	case "close-month":
+		now := time.Now().UTC()
+		lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+		month := fs.String("month", lastMonth.Format("2006-01"), "month to close (YYYY-MM)")
+		if _, err := parseArgs(fs, args, 0); err != nil {
+			return err
+		}
+		return c.do(http.MethodPost, "/billing/close", map[string]any{"month": *month}, out)
+
+	case "invoices":
+		month := fs.String("month", "", "show one month's invoice (YYYY-MM)")
+		text := fs.Bool("text", false, "print the invoice as a text statement")
+		ids, err := parseArgs(fs, args, 1)
+		if err != nil {
+			return err
+		}
+		path := "/partners/" + ids[0] + "/invoices"
+		switch {
+		case *month != "" && *text:
+			path += "?month=" + *month + "&format=text"
+		case *month != "":
+			path += "?month=" + *month
+		case *text:
+			return errors.New("invoices: -text needs -month")
+		}
+		return c.do(http.MethodGet, path, nil, out)
+
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", cmd, usage)
+	}
+}
+
+// parseArgs accepts flags before or after the positional IDs and requires
+// exactly n of them
+func parseArgs(fs *flag.FlagSet, args []string, n int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, fmt.Errorf("%s: %w", fs.Name(), err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != n {
+		return nil, fmt.Errorf("%s: expected %d argument(s), got %d", fs.Name(), n, len(positional))
+	}
+	return positional, nil
+}
+
+// addScopes sets body's scopes from a comma separated flag, if given
+func addScopes(body map[string]any, scopes string) {
+	if scopes != "" {
+		body["scopes"] = strings.Split(scopes, ",")
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
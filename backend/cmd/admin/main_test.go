@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type capturedRequest struct {
+	method, path, query string
+	actor               string
+	body                map[string]any
+}
+
+func newTestClient(t *testing.T, status int, response string) (*client, *capturedRequest) {
+	t.Helper()
+	got := &capturedRequest{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.method, got.path, got.query = r.Method, r.URL.Path, r.URL.RawQuery
+		got.actor = r.Header.Get("X-Admin-Actor")
+		json.NewDecoder(r.Body).Decode(&got.body)
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(srv.Close)
+	return &client{baseURL: srv.URL, token: "secret", actor: "alice", http: srv.Client()}, got
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantMethod string
+		wantPath   string
+		wantQuery  string
+		wantBody   map[string]any
+	}{
+		{
+			name:       "suspend",
+			args:       []string{"suspend", "p1"},
+			wantMethod: http.MethodPatch,
+			wantPath:   "/admin/v1/partners/p1",
+			wantBody:   map[string]any{"status": "suspended"},
+		},
+		{
+			name:       "update with flags after id",
+			args:       []string{"update", "p1", "-bps", "15"},
+			wantMethod: http.MethodPatch,
+			wantPath:   "/admin/v1/partners/p1",
+			wantBody:   map[string]any{"router_bps": float64(15)},
+		},
+		{
+			name:       "add scoped key",
+			args:       []string{"add-key", "p1", "-public-key", "ab", "-scopes", "pairs,usage"},
+			wantMethod: http.MethodPost,
+			wantPath:   "/admin/v1/partners/p1/keys",
+			wantBody:   map[string]any{"public_key": "ab", "label": "", "scopes": []any{"pairs", "usage"}},
+		},
+		{
+			name:       "rotate key",
+			args:       []string{"rotate-key", "-public-key", "ab", "-overlap", "24h", "p1", "k1"},
+			wantMethod: http.MethodPost,
+			wantPath:   "/admin/v1/partners/p1/keys/k1/rotate",
+			wantBody:   map[string]any{"public_key": "ab", "label": "", "overlap": "24h0m0s"},
+		},
+		{
+			name:       "bind certificate by fingerprint",
+			args:       []string{"bind-cert", "p1", "-fingerprint", "AB:CD", "-label", "prod"},
+			wantMethod: http.MethodPost,
+			wantPath:   "/admin/v1/partners/p1/certificates",
+			wantBody:   map[string]any{"fingerprint": "AB:CD", "label": "prod"},
+		},
+		{
+			name:       "audit",
+			args:       []string{"audit", "p1", "-limit", "5"},
+			wantMethod: http.MethodGet,
+			wantPath:   "/admin/v1/partners/p1/audit",
+			wantQuery:  "limit=5",
+		},
+		{
+			name:       "close month",
+			args:       []string{"close-month", "-month", "2025-11"},
+			wantMethod: http.MethodPost,
+			wantPath:   "/admin/v1/billing/close",
+			wantBody:   map[string]any{"month": "2025-11"},
+		},
+		{
+			name:       "invoice statement",
+			args:       []string{"invoices", "p1", "-month", "2025-11", "-text"},
+			wantMethod: http.MethodGet,
+			wantPath:   "/admin/v1/partners/p1/invoices",
+			wantQuery:  "month=2025-11&format=text",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, got := newTestClient(t, http.StatusOK, `{"ok":true}`)
+			var out bytes.Buffer
+			if err := run(c, tt.args, &out); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+			if got.method != tt.wantMethod || got.path != tt.wantPath || got.query != tt.wantQuery {
+				t.Errorf("request = %s %s?%s, want %s %s?%s", got.method, got.path, got.query, tt.wantMethod, tt.wantPath, tt.wantQuery)
+			}
+			if got.actor != "alice" {
+				t.Errorf("X-Admin-Actor = %q, want alice", got.actor)
+			}
+			if tt.wantBody != nil {
+				gotBody, _ := json.Marshal(got.body)
+				wantBody, _ := json.Marshal(tt.wantBody)
+				if !bytes.Equal(gotBody, wantBody) {
+					t.Errorf("body = %s, want %s", gotBody, wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	c, _ := newTestClient(t, http.StatusNotFound, `{"error":"not found"}`)
+
+	if err := run(c, []string{"partner", "p1"}, &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "not found (404)") {
+		t.Errorf("run(404) error = %v, want the API error", err)
+	}
+	if err := run(c, []string{"revoke-key", "p1"}, &bytes.Buffer{}); err == nil {
+		t.Error("run(revoke-key without key id) succeeded")
+	}
+	if err := run(c, []string{"update", "p1"}, &bytes.Buffer{}); err == nil {
+		t.Error("run(update without changes) succeeded")
+	}
+	if err := run(c, []string{"invoices", "p1", "-text"}, &bytes.Buffer{}); err == nil {
+		t.Error("run(invoices -text without month) succeeded")
+	}
+	if err := run(c, []string{"bogus"}, &bytes.Buffer{}); err == nil {
+		t.Error("run(unknown command) succeeded")
+	}
+}
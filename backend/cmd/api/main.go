@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -59,7 +61,11 @@ func main() {
 		log.Fatalf("failed to ping database: %v", err)
 	}
 
-	kvStore := kv.NewMemoryStore()
+	kvStore, err := kv.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to open kv store: %v", err)
+	}
+	defer kvStore.Close()
 	internalToken := getEnv("INTERNAL_TOKEN", "")
 
 	// Router uses same database for read-only access (reuse connection)
@@ -70,14 +76,30 @@ func main() {
 	defer routerStore.Close()
 
 	validator := router.NewValidator()
-	pathfinder := router.NewPathfinder([]router.AMMPool{}, []router.Offer{})
-	breaker := router.NewCircuitBreaker(0.05)
+	issuers, err := routerStore.LoadIssuers(ctx)
+	if err != nil {
+		log.Printf("failed to load issuers: %v", err)
+	}
+	pathfinder := router.NewPathfinderWithIssuers([]router.AMMPool{}, []router.Offer{}, issuers)
+	breaker := router.NewCircuitBreaker(router.DefaultThreshold)
 	quoteEngine := router.NewQuoteEngine(validator, pathfinder, breaker, kvStore, 20)
+	quoteEngine.SetPolicyProvider(router.NewPolicyProvider(routerStore.PolicyLoader(), kvStore))
 	r := router.NewRouter(quoteEngine, routerStore, kvStore)
+	reference, err := router.ParseAsset(getEnv("PAIRS_REFERENCE_ASSET", "XRP"))
+	if err != nil {
+		log.Fatalf("invalid PAIRS_REFERENCE_ASSET: %v", err)
+	}
+	r.SetPairStats(router.NewPairStats(routerStore.MarketLoader(), reference))
 
 	apiStore := api.NewPostgresStore(db)
 
-	authMiddleware := api.NewAuthMiddleware(apiStore)
+	// Replay protection claims request IDs in KV; REQUEST_ID_AUDIT=true
+	// also records them in Postgres, off the request path
+	authMiddleware := api.NewAuthMiddleware(apiStore, kvStore)
+	authMiddleware.SetRequestIDAudit(getEnv("REQUEST_ID_AUDIT", "false") == "true")
+	if plans := os.Getenv("MTLS_CERT_ONLY_PLANS"); plans != "" {
+		authMiddleware.SetCertificateOnlyPlans(strings.Split(plans, ",")...)
+	}
 	rateLimiter := api.NewRateLimiter(kvStore, apiStore)
 	handlers := api.NewHandlers(r, apiStore, kvStore, internalToken)
 
@@ -86,12 +108,37 @@ func main() {
 	partnerMux := http.NewServeMux()
 	partnerMux.HandleFunc("/partner/v1/quote", handlers.QuoteHandler)
 	partnerMux.HandleFunc("/partner/v1/pairs", handlers.PairsHandler)
+	partnerMux.HandleFunc("/partner/v1/orderbook", handlers.OrderbookHandler)
 	partnerMux.HandleFunc("/partner/v1/usage", handlers.UsageHandler)
+	partnerMux.HandleFunc("/partner/v1/invoices", handlers.InvoicesHandler)
 	partnerMux.HandleFunc("/partner/v1/health", handlers.HealthHandler)
 
-	mux.Handle("/partner/", rateLimiter.Middleware(authMiddleware.Middleware(partnerMux)))
+	// Auth runs first: the rate limiter keys on the authenticated partner
+	mux.Handle("/partner/", authMiddleware.Middleware(rateLimiter.Middleware(partnerMux)))
+
+	// Unsigned evaluation surface, limited per client IP. Quotes are
+	// indicative and never registered for attribution.
+	if getEnv("PUBLIC_API", "true") == "true" {
+		publicMux := http.NewServeMux()
+		publicMux.HandleFunc("/public/v1/pairs", handlers.PairsHandler)
+		publicMux.HandleFunc("/public/v1/orderbook", handlers.OrderbookHandler)
+		publicMux.HandleFunc("/public/v1/quote", handlers.PublicQuoteHandler)
+
+		ipLimiter := api.NewIPRateLimiter(kvStore, api.PlanLimit{PerMinute: api.PublicIPLimit, Burst: api.PublicIPBurst})
+		ipLimiter.SetTrustForwardedFor(getEnv("PUBLIC_TRUST_FORWARDED_FOR", "false") == "true")
+		mux.Handle("/public/", ipLimiter.Middleware(http.MaxBytesHandler(publicMux, 1<<16)))
+	}
 	mux.HandleFunc("/internal/v1/ledger", handlers.LedgerUpdateHandler)
 
+	feedCtx, stopFeed := context.WithCancel(ctx)
+	defer stopFeed()
+	go routerStore.TradeFeed(breaker).Run(feedCtx, router.DefaultBucketWidth)
+	go pathfinder.RefreshIssuers(feedCtx, routerStore.LoadIssuers, router.DefaultIssuerRefreshInterval)
+
+	billingCtx, stopBilling := context.WithCancel(ctx)
+	defer stopBilling()
+	adminSrv := newAdminServer(billingCtx, internalToken)
+
 	port := getEnv("API_PORT", "8080")
 	srv := &http.Server{
 		Addr:         ":" + port,
@@ -101,9 +148,22 @@ func main() {
 		IdleTimeout:  60 * time.Second,
 	}
 
+	tlsCtx, stopTLS := context.WithCancel(ctx)
+	defer stopTLS()
+	reloader := newPartnerTLS(tlsCtx)
+	if reloader != nil {
+		srv.TLSConfig = reloader.TLSConfig()
+	}
+
 	go func() {
-		log.Printf("API server listening on :%s", port)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("API server listening on :%s (tls: %t)", port, reloader != nil)
+		var err error
+		if reloader != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("failed to start server: %v", err)
 		}
 	}()
@@ -117,6 +177,11 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Printf("admin server forced to shutdown: %v", err)
+		}
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("server forced to shutdown: %v", err)
 	}
@@ -124,6 +189,121 @@ func main() {
 	log.Println("server exited")
 }
 
+// newPartnerTLS terminates TLS in the API server when API_TLS_CERT and
+// API_TLS_KEY are set. API_TLS_CLIENT_CA enables client certificates signed
+// by the partner CA; each must be bound to a partner in partner_certificates.
+// The files are re-read when they change or on SIGHUP.
+func newPartnerTLS(ctx context.Context) *api.TLSReloader {
+	certFile := os.Getenv("API_TLS_CERT")
+	if certFile == "" {
+		return nil
+	}
+
+	reloader, err := api.NewTLSReloader(certFile, mustEnv("API_TLS_KEY"), os.Getenv("API_TLS_CLIENT_CA"))
+	if err != nil {
+		log.Fatalf("failed to load API TLS material: %v", err)
+	}
+	go reloader.Watch(ctx, time.Minute)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				signal.Stop(hup)
+				return
+			case <-hup:
+				if err := reloader.Reload(); err != nil {
+					log.Printf("tls reload failed, keeping previous certificates: %v", err)
+					continue
+				}
+				log.Printf("reloaded tls certificates")
+			}
+		}
+	}()
+	return reloader
+}
+
+// newAdminServer starts the partner admin API on ADMIN_PORT when it is set.
+// It connects as the partner_admin role through ADMIN_DATABASE_URL, since
+// api_ro cannot write partners, keys or the audit log. With ADMIN_TLS_CERT,
+// ADMIN_TLS_KEY and ADMIN_TLS_CA it serves TLS and accepts client
+// certificates signed by the CA in place of the internal token.
+//
+// Unless BILLING_AUTO_CLOSE=false it also closes each month for billing once
+// BILLING_CLOSE_DELAY (default 1h) has passed since the month ended and the
+// indexer has checkpointed a ledger closed after it.
+// Closing is idempotent, so several API instances may run it.
+func newAdminServer(ctx context.Context, internalToken string) *http.Server {
+	port := os.Getenv("ADMIN_PORT")
+	if port == "" {
+		return nil
+	}
+
+	connStr := os.Getenv("ADMIN_DATABASE_URL")
+	if connStr == "" {
+		log.Fatalf("ADMIN_DATABASE_URL required when ADMIN_PORT is set")
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		log.Fatalf("failed to connect to admin database: %v", err)
+	}
+
+	var tlsConfig *tls.Config
+	if certFile := os.Getenv("ADMIN_TLS_CERT"); certFile != "" {
+		tlsConfig, err = kv.ServerTLSConfig(certFile, os.Getenv("ADMIN_TLS_KEY"), os.Getenv("ADMIN_TLS_CA"))
+		if err != nil {
+			log.Fatalf("failed to load admin TLS material: %v", err)
+		}
+		// Operators without a certificate may still use the internal token
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	} else if internalToken == "" {
+		log.Fatalf("admin API needs INTERNAL_TOKEN or ADMIN_TLS_CERT")
+	}
+
+	adminStore := api.NewPostgresStore(db)
+	if getEnv("BILLING_AUTO_CLOSE", "true") == "true" {
+		delay, err := time.ParseDuration(getEnv("BILLING_CLOSE_DELAY", api.DefaultBillingCloseDelay.String()))
+		if err != nil {
+			log.Fatalf("invalid BILLING_CLOSE_DELAY: %v", err)
+		}
+		go api.StartBillingLoop(ctx, adminStore, delay)
+	}
+
+	handlers := api.NewAdminHandlers(adminStore, internalToken)
+	srv := &http.Server{
+		Addr:         ":" + port,
+		Handler:      handlers.Handler(),
+		TLSConfig:    tlsConfig,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 15 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	go func() {
+		log.Printf("admin API listening on :%s (tls: %t)", port, tlsConfig != nil)
+		var err error
+		if tlsConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("failed to start admin server: %v", err)
+		}
+	}()
+	return srv
+}
+
+func mustEnv(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		log.Fatalf("%s required", key)
+	}
+	return value
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -15,6 +15,7 @@ import (
 
 	"github.com/shopspring/decimal"
 
+	"github.com/lucendex/backend/internal/kv"
 	"github.com/lucendex/backend/internal/parser"
 	"github.com/lucendex/backend/internal/store"
 	"github.com/lucendex/backend/internal/xrpl"
@@ -35,8 +36,13 @@ var (
 	startLedger       = flag.Uint64("start-ledger", 99984580, "Earliest ledger to index (Nov 1, 2025 00:00 UTC ≈ ledger 99984580)")
 	ledgerUpdateURL   = flag.String("ledger-update-url", getEnv("LEDGER_UPDATE_URL", ""), "Internal URL to POST ledger index updates")
 	ledgerUpdateToken = flag.String("ledger-update-token", getEnv("LEDGER_UPDATE_TOKEN", ""), "Token for ledger update endpoint")
+	kvAddr            = flag.String("kv-addr", getEnv("KV_ADDR", ""), "Shared kv server address (host:port); client cert from KV_TLS_CERT/KV_TLS_KEY/KV_TLS_CA")
+	usdReference      = flag.String("usd-reference-asset", getEnv("USD_REFERENCE_ASSET", ""), "Issued USD (CODE.ISSUER) whose AMM pools price usage in USD; empty records no prices")
 )
 
+// kvClient publishes the ledger index to the shared kv server when configured
+var kvClient *kv.Client
+
 var httpClient = &http.Client{
 	Timeout: 3 * time.Second,
 }
@@ -119,6 +125,16 @@ func main() {
 	defer db.Close()
 	log.Printf("✓ Database connected")
 
+	if *kvAddr != "" {
+		var err error
+		kvClient, err = kv.DialTLS(*kvAddr, os.Getenv("KV_TLS_CERT"), os.Getenv("KV_TLS_KEY"), os.Getenv("KV_TLS_CA"))
+		if err != nil {
+			log.Fatalf("Failed to connect to kv server: %v", err)
+		}
+		defer kvClient.Close()
+		log.Printf("✓ KV server connected (%s)", *kvAddr)
+	}
+
 	// Check for last checkpoint
 	ctx := context.Background()
 	checkpoint, err := db.GetLastCheckpoint(ctx)
@@ -191,7 +207,7 @@ func main() {
 							continue
 						}
 
-						if err := processLedger(ctx, db, ledger, parser.NewAMMParser(), parser.NewOrderbookParser()); err != nil {
+						if err := processLedger(ctx, db, ledger, parser.NewAMMParser(), parser.NewOrderbookParser(), parser.NewIssuerParser()); err != nil {
 							log.Printf("Error processing backfill ledger %d: %v", i, err)
 						}
 
@@ -218,6 +234,11 @@ func main() {
 	// Create parsers for live processing
 	ammParser := parser.NewAMMParser()
 	orderbookParser := parser.NewOrderbookParser()
+	issuerParser := parser.NewIssuerParser()
+
+	if *rippledHTTP != "" {
+		go syncIssuersLoop(ctx, db)
+	}
 
 	// Set up signal handling for graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -236,7 +257,7 @@ func main() {
 			log.Printf("Error from rippled client: %v", err)
 
 		case ledger := <-client.LedgerChan():
-			if err := processLedger(ctx, db, ledger, ammParser, orderbookParser); err != nil {
+			if err := processLedger(ctx, db, ledger, ammParser, orderbookParser, issuerParser); err != nil {
 				log.Printf("Error processing ledger %d: %v", ledger.LedgerIndex, err)
 			} else {
 				publishLedgerIndex(uint64(ledger.LedgerIndex))
@@ -303,10 +324,14 @@ func extractTradeDetails(tx map[string]interface{}) (inAsset, outAsset, amountIn
 		}
 	} else {
 		// IOU amount
-		currency, _ := amount["currency"].(string)
+		code, _ := amount["currency"].(string)
 		issuer, _ := amount["issuer"].(string)
 		value, _ := amount["value"].(string)
-		inAsset = fmt.Sprintf("%s.%s", currency, issuer)
+		asset, err := xrpl.FormatAsset(code, issuer)
+		if err != nil {
+			return
+		}
+		inAsset = asset
 		amountIn = value
 	}
 
@@ -331,10 +356,14 @@ func extractTradeDetails(tx map[string]interface{}) (inAsset, outAsset, amountIn
 		amountOut = deliveredStr
 	} else if deliveredMap, isMap := delivered.(map[string]interface{}); isMap {
 		// IOU
-		currency, _ := deliveredMap["currency"].(string)
+		code, _ := deliveredMap["currency"].(string)
 		issuer, _ := deliveredMap["issuer"].(string)
 		value, _ := deliveredMap["value"].(string)
-		outAsset = fmt.Sprintf("%s.%s", currency, issuer)
+		asset, err := xrpl.FormatAsset(code, issuer)
+		if err != nil {
+			return
+		}
+		outAsset = asset
 		amountOut = value
 	} else {
 		return
@@ -346,6 +375,12 @@ func extractTradeDetails(tx map[string]interface{}) (inAsset, outAsset, amountIn
 }
 
 func publishLedgerIndex(idx uint64) {
+	if kvClient != nil {
+		if err := kvClient.SetLedgerIndex(uint32(idx)); err != nil {
+			log.Printf("Failed to publish ledger index to kv: %v", err)
+		}
+	}
+
 	if *ledgerUpdateURL == "" {
 		return
 	}
@@ -387,6 +422,144 @@ func publishLedgerIndex(idx uint64) {
 	}
 }
 
+// trackIssuers registers the issuers of the given assets and, for issuers seen
+// for the first time, loads their current AccountRoot via HTTP RPC. Issuers
+// whose first load fails are retried by syncIssuersLoop.
+func trackIssuers(ctx context.Context, db *store.Store, ledgerIndex uint64, assets ...string) {
+	for _, asset := range assets {
+		issuer := parser.AssetIssuer(asset)
+		if issuer == "" {
+			continue
+		}
+
+		isNew, err := db.TrackIssuer(ctx, issuer, int64(ledgerIndex))
+		if err != nil {
+			log.Printf("Failed to track issuer %s: %v", issuer, err)
+			continue
+		}
+		if !isNew || *rippledHTTP == "" {
+			continue
+		}
+
+		if err := syncIssuer(ctx, db, issuer, ledgerIndex); err != nil {
+			log.Printf("Failed to load issuer %s: %v", issuer, err)
+		}
+	}
+}
+
+// syncIssuer loads an issuer's AccountRoot via HTTP RPC and stores it as of
+// ledgerIndex, or as of the validated ledger rippled answered for when
+// ledgerIndex is 0
+func syncIssuer(ctx context.Context, db *store.Store, issuer string, ledgerIndex uint64) error {
+	info, err := xrpl.GetAccountInfoHTTP(*rippledHTTP, issuer)
+	if err != nil {
+		return err
+	}
+	if ledgerIndex == 0 {
+		ledgerIndex = info.Result.LedgerIndex
+	}
+
+	state := parser.IssuerStateFromAccountRoot(info.Result.AccountData, ledgerIndex)
+	if err := db.UpsertIssuer(ctx, state); err != nil {
+		return err
+	}
+	logVerbose("  ✓ Issuer tracked: %s (flags=%#x, transfer_rate=%d)", issuer, state.Flags, state.TransferRate)
+	return nil
+}
+
+// issuerSyncInterval is how often syncIssuersLoop retries unsynced issuers
+const issuerSyncInterval = time.Minute
+
+// syncIssuersLoop retries issuers whose AccountRoot has never been loaded.
+// Routing ignores unsynced issuers, so without it one failed account_info
+// would hide an issuer until the indexer sees its AccountRoot change.
+func syncIssuersLoop(ctx context.Context, db *store.Store) {
+	ticker := time.NewTicker(issuerSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			issuers, err := db.ListUnsyncedIssuers(ctx, 100)
+			if err != nil {
+				log.Printf("Failed to list unsynced issuers: %v", err)
+				continue
+			}
+			for _, issuer := range issuers {
+				if err := syncIssuer(ctx, db, issuer, 0); err != nil {
+					log.Printf("Failed to load issuer %s: %v", issuer, err)
+				}
+			}
+		}
+	}
+}
+
+// poolReader is the part of the store referencePriceUSD reads
+type poolReader interface {
+	GetPoolReserves(ctx context.Context, a, b string) (reserveA, reserveB string, found bool, err error)
+}
+
+// referencePriceUSD prices a whole unit of asset in usd from the current AMM
+// pools: directly against usd, or through XRP. It returns nil when usd is
+// unset or there is no such market, so the trade stays unpriced rather than
+// wrongly priced.
+func referencePriceUSD(ctx context.Context, pools poolReader, asset, usd string) *string {
+	if usd == "" {
+		return nil
+	}
+	if asset == usd {
+		one := "1"
+		return &one
+	}
+
+	// price is b per whole unit of a
+	price := func(a, b string) (decimal.Decimal, bool) {
+		ra, rb, found, err := pools.GetPoolReserves(ctx, a, b)
+		if err != nil {
+			log.Printf("Failed to read %s/%s pool: %v", a, b, err)
+			return decimal.Zero, false
+		}
+		if !found {
+			return decimal.Zero, false
+		}
+		reserveA, errA := decimal.NewFromString(ra)
+		reserveB, errB := decimal.NewFromString(rb)
+		if errA != nil || errB != nil {
+			return decimal.Zero, false
+		}
+		if a == "XRP" {
+			reserveA = reserveA.Shift(-6)
+		}
+		if b == "XRP" {
+			reserveB = reserveB.Shift(-6)
+		}
+		if !reserveA.IsPositive() || !reserveB.IsPositive() {
+			return decimal.Zero, false
+		}
+		return reserveB.Div(reserveA), true
+	}
+
+	if p, ok := price(asset, usd); ok {
+		s := p.String()
+		return &s
+	}
+	if asset == "XRP" {
+		return nil
+	}
+	xrpPerUnit, ok := price(asset, "XRP")
+	if !ok {
+		return nil
+	}
+	usdPerXRP, ok := price("XRP", usd)
+	if !ok {
+		return nil
+	}
+	s := xrpPerUnit.Mul(usdPerXRP).String()
+	return &s
+}
+
 // processLedger processes a single ledger
 func processLedger(
 	ctx context.Context,
@@ -394,6 +567,7 @@ func processLedger(
 	ledger *xrpl.LedgerResponse,
 	ammParser *parser.AMMParser,
 	orderbookParser *parser.OrderbookParser,
+	issuerParser *parser.IssuerParser,
 ) error {
 	start := time.Now()
 
@@ -444,6 +618,7 @@ func processLedger(
 				log.Printf("Failed to upsert AMM pool: %v", err)
 			} else {
 				log.Printf("  ✓ AMM pool updated: %s/%s", pool.Asset1, pool.Asset2)
+				trackIssuers(ctx, db, ledger.LedgerIndex, pool.Asset1, pool.Asset2)
 			}
 		} else {
 			logVerbose("  Skipped (not AMM transaction)")
@@ -461,12 +636,20 @@ func processLedger(
 					logVerbose("  ⚠ Invalid offer stored: %v", offer.Meta["error"])
 				} else {
 					log.Printf("  ✓ Offer created: %s/%s @ %s", offer.BaseAsset, offer.QuoteAsset, offer.Price)
+					trackIssuers(ctx, db, ledger.LedgerIndex, offer.BaseAsset, offer.QuoteAsset)
 				}
 			}
 		} else {
 			logVerbose("  Skipped (not orderbook transaction)")
 		}
 
+		// Apply issuer flag / TransferRate changes (no-op for untracked accounts)
+		for _, issuer := range issuerParser.ParseTransaction(txMap, ledger.LedgerIndex) {
+			if err := db.UpdateTrackedIssuer(ctx, issuer); err != nil {
+				log.Printf("Failed to update issuer %s: %v", issuer.Account, err)
+			}
+		}
+
 		// Check for Lucendex-executed trade
 		if quoteHash, hasQuote := hasLucendexQuoteHash(txMap); hasQuote {
 			inAsset, outAsset, amountIn, amountOut, valid := extractTradeDetails(txMap)
@@ -529,12 +712,16 @@ func processLedger(
 				PartnerID:   entry.PartnerID,
 				QuoteHash:   quoteHash,
 				Pair:        fmt.Sprintf("%s-%s", inAsset, outAsset),
+				InAsset:     inAsset,
+				OutAsset:    outAsset,
 				AmountIn:    trade.AmountIn,
 				AmountOut:   trade.AmountOut,
 				RouterBps:   routerFee,
 				FeeAmount:   feeAmount.String(),
 				TxHash:      tx.Hash,
 				LedgerIndex: int64(ledger.LedgerIndex),
+				InUSDPrice:  referencePriceUSD(ctx, db, inAsset, *usdReference),
+				OutUSDPrice: referencePriceUSD(ctx, db, outAsset, *usdReference),
 			}
 
 			if err := db.InsertUsageEvent(ctx, usage); err != nil {
@@ -567,7 +754,7 @@ func processLedger(
 		LedgerIndex:          int64(ledger.LedgerIndex),
 		LedgerHash:           ledger.LedgerHash,
 		CloseTime:            int64(ledger.LedgerTime),
-		CloseTimeHuman:       time.Unix(int64(ledger.LedgerTime)+946684800, 0), // Ripple epoch to Unix
+		CloseTimeHuman:       time.Unix(int64(ledger.LedgerTime)+xrpl.RippleEpoch, 0),
 		TransactionCount:     ledger.TxnCount,
 		ProcessingDurationMs: int(duration.Milliseconds()),
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"reflect"
 	"testing"
 )
@@ -194,3 +195,40 @@ func TestExtractTradeDetails(t *testing.T) {
 		})
 	}
 }
+
+type fakePools map[string][2]string
+
+func (f fakePools) GetPoolReserves(ctx context.Context, a, b string) (string, string, bool, error) {
+	if r, ok := f[a+"/"+b]; ok {
+		return r[0], r[1], true, nil
+	}
+	if r, ok := f[b+"/"+a]; ok {
+		return r[1], r[0], true, nil
+	}
+	return "", "", false, nil
+}
+
+func TestReferencePriceUSD(t *testing.T) {
+	usd := "USD.rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq"
+	pools := fakePools{
+		"XRP/" + usd: {"10000000000", "25000"}, // 2.5 USD per XRP
+		"EUR.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B/XRP": {"1000", "400000000"}, // 0.4 XRP per EUR
+	}
+
+	tests := []struct {
+		asset, usd string
+		want       string
+	}{
+		{usd, usd, "1"},
+		{"XRP", usd, "2.5"},
+		{"EUR.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B", usd, "1"},
+		{"GBP.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B", usd, ""},
+		{"XRP", "", ""},
+	}
+	for _, tt := range tests {
+		got := referencePriceUSD(context.Background(), pools, tt.asset, tt.usd)
+		if (got == nil) != (tt.want == "") || (got != nil && *got != tt.want) {
+			t.Errorf("referencePriceUSD(%s, %q) = %v, want %q", tt.asset, tt.usd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lucendex/backend/internal/kv"
+)
+
+var (
+	version     = "dev"
+	buildTime   = "unknown"
+	showVersion = flag.Bool("version", false, "Show version and exit")
+)
+
+func main() {
+	flag.Parse()
+
+	if *showVersion {
+		log.Printf("lucendex-kv %s, build %s", version, buildTime)
+		return
+	}
+
+	tlsConfig, err := kv.ServerTLSConfig(
+		mustEnv("KV_TLS_CERT"),
+		mustEnv("KV_TLS_KEY"),
+		mustEnv("KV_TLS_CA"),
+	)
+	if err != nil {
+		log.Fatalf("failed to load TLS material: %v", err)
+	}
+
+	acl := kv.DefaultACL()
+	if spec := os.Getenv("KV_ACL"); spec != "" {
+		if acl, err = kv.ParseACL(spec); err != nil {
+			log.Fatalf("invalid KV_ACL: %v", err)
+		}
+	}
+
+	// A local store: the kv server is what the other services share
+	store, err := kv.LocalFromEnv()
+	if err != nil {
+		log.Fatalf("failed to open store: %v", err)
+	}
+	defer store.Close()
+
+	listen := getEnv("KV_LISTEN", ":7400")
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		log.Fatalf("failed to listen on %s: %v", listen, err)
+	}
+
+	srv := kv.NewServer(store, acl, tlsConfig)
+	go func() {
+		log.Printf("kv server listening on %s (services: %d)", listen, len(acl))
+		if err := srv.Serve(ln); err != nil {
+			log.Fatalf("kv server failed: %v", err)
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("shutting down kv server...")
+	srv.Close()
+}
+
+func mustEnv(key string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		log.Fatalf("%s required", key)
+	}
+	return value
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
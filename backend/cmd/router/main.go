@@ -21,9 +21,12 @@ func main() {
 	}
 
 	routerBps := 20
-	threshold := 0.05
+	threshold := router.DefaultThreshold
 
-	kvStore := kv.NewMemoryStore()
+	kvStore, err := kv.FromEnv()
+	if err != nil {
+		log.Fatalf("failed to open kv store: %v", err)
+	}
 	defer kvStore.Close()
 
 	dbStore, err := store.NewRouterStore(dbURL)
@@ -35,19 +38,26 @@ func main() {
 	validator := router.NewValidator()
 	breaker := router.NewCircuitBreaker(threshold)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
 	pools := []router.AMMPool{}
 	offers := []router.Offer{}
-	pathfinder := router.NewPathfinder(pools, offers)
+	issuers, err := dbStore.LoadIssuers(ctx)
+	if err != nil {
+		log.Printf("failed to load issuers: %v", err)
+	}
+	pathfinder := router.NewPathfinderWithIssuers(pools, offers, issuers)
 
 	quoteEngine := router.NewQuoteEngine(validator, pathfinder, breaker, kvStore, routerBps)
+	quoteEngine.SetPolicyProvider(router.NewPolicyProvider(dbStore.PolicyLoader(), kvStore))
 	_ = router.NewRouter(quoteEngine, dbStore, kvStore)
 
-	log.Printf("Router started: routerBps=%d, threshold=%.2f%%", routerBps, threshold*100)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	log.Printf("Router started: routerBps=%d, threshold=%.1f sigma, issuers=%d", routerBps, threshold, len(issuers))
 
 	go startCleanupLoop(ctx, dbStore)
+	go dbStore.TradeFeed(breaker).Run(ctx, router.DefaultBucketWidth)
+	go pathfinder.RefreshIssuers(ctx, dbStore.LoadIssuers, router.DefaultIssuerRefreshInterval)
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
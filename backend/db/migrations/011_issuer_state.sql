@@ -0,0 +1,49 @@
+-- Migration: 011_issuer_state.sql
+-- Description: Track AccountRoot flags and TransferRate for issuers seen in pools and offers
+-- Author: Lucendex Team
+-- Date: 2025-11-14
+
+CREATE TABLE IF NOT EXISTS core.issuers (
+    account TEXT PRIMARY KEY,
+
+    -- Raw AccountRoot Flags and TransferRate (1000000000 = no fee, 0 = unset)
+    flags BIGINT NOT NULL DEFAULT 0,
+    transfer_rate BIGINT NOT NULL DEFAULT 0,
+
+    -- Decoded risk flags
+    global_freeze BOOLEAN NOT NULL DEFAULT FALSE,
+    no_freeze BOOLEAN NOT NULL DEFAULT FALSE,
+    allow_clawback BOOLEAN NOT NULL DEFAULT FALSE,
+
+    -- FALSE until account_info or an AccountRoot change has been observed
+    synced BOOLEAN NOT NULL DEFAULT FALSE,
+
+    -- Ledger tracking
+    ledger_index BIGINT NOT NULL,
+
+    -- Timestamps
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT issuers_transfer_rate_range CHECK (
+        transfer_rate = 0 OR transfer_rate BETWEEN 1000000000 AND 2000000000
+    )
+);
+
+CREATE INDEX idx_issuers_global_freeze ON core.issuers(account) WHERE global_freeze;
+CREATE INDEX idx_issuers_unsynced ON core.issuers(account) WHERE NOT synced;
+
+CREATE TRIGGER issuers_audit_trigger
+    BEFORE INSERT OR UPDATE ON core.issuers
+    FOR EACH ROW EXECUTE FUNCTION core.audit_trigger_func();
+
+COMMENT ON TABLE core.issuers IS 'Issuer risk state (freeze, clawback, transfer fee)';
+COMMENT ON COLUMN core.issuers.flags IS 'AccountRoot Flags bitfield';
+COMMENT ON COLUMN core.issuers.transfer_rate IS 'AccountRoot TransferRate (1000000000 = 0%, 1002000000 = 0.2%)';
+COMMENT ON COLUMN core.issuers.global_freeze IS 'lsfGlobalFreeze - all trust lines frozen, excluded from routing';
+COMMENT ON COLUMN core.issuers.no_freeze IS 'lsfNoFreeze - issuer permanently gave up freezing';
+COMMENT ON COLUMN core.issuers.allow_clawback IS 'lsfAllowTrustLineClawback - issuer can claw back balances';
+
+GRANT ALL PRIVILEGES ON core.issuers TO indexer_rw;
+GRANT SELECT ON core.issuers TO router_ro;
+GRANT SELECT ON core.issuers TO api_ro;
@@ -0,0 +1,40 @@
+-- Migration: 012_asset_policies.sql
+-- Description: Global and per-partner asset allow/deny lists enforced by the router
+-- Author: Lucendex Team
+-- Date: 2025-11-18
+
+CREATE TABLE IF NOT EXISTS metering.asset_policies (
+    id BIGSERIAL PRIMARY KEY,
+
+    -- NULL applies to every partner
+    partner_id UUID REFERENCES partners(id) ON DELETE CASCADE,
+
+    list TEXT NOT NULL CHECK (list IN ('allow', 'deny')),
+
+    -- Rule target: an issuer (currency NULL matches all its currencies),
+    -- a specific issued asset, or XRP (currency 'XRP', issuer NULL)
+    currency TEXT,
+    issuer TEXT,
+
+    note TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT asset_policies_target CHECK (
+        issuer IS NOT NULL OR currency = 'XRP'
+    )
+);
+
+CREATE UNIQUE INDEX idx_asset_policies_rule ON metering.asset_policies(
+    COALESCE(partner_id, '00000000-0000-0000-0000-000000000000'::uuid),
+    list,
+    COALESCE(currency, ''),
+    COALESCE(issuer, '')
+);
+CREATE INDEX idx_asset_policies_partner ON metering.asset_policies(partner_id);
+
+COMMENT ON TABLE metering.asset_policies IS 'Asset allow/deny lists applied to route endpoints and intermediate hops';
+COMMENT ON COLUMN metering.asset_policies.partner_id IS 'Partner the rule applies to (NULL = global)';
+COMMENT ON COLUMN metering.asset_policies.list IS 'allow: a non-empty allowlist restricts routing to listed assets; deny: never route through';
+
+GRANT SELECT ON metering.asset_policies TO router_ro;
+GRANT SELECT ON metering.asset_policies TO api_ro;
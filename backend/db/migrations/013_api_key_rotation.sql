@@ -0,0 +1,19 @@
+-- Migration: 013_api_key_rotation.sql
+-- Description: Several active API keys per partner with an overlap window for rotation
+-- Author: Lucendex Team
+-- Date: 2025-11-24
+
+-- A rotated-out key keeps verifying until not_after, then stops without
+-- being revoked; NULL never expires
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS not_after TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_partner_active ON api_keys(partner_id, created_at DESC) WHERE NOT revoked;
+
+-- Keys revoked before revoked_at was recorded
+UPDATE api_keys SET revoked_at = created_at WHERE revoked AND revoked_at IS NULL;
+
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_revoked_at CHECK (NOT revoked OR revoked_at IS NOT NULL);
+
+COMMENT ON COLUMN api_keys.id IS 'Key ID sent by clients in the X-Key-Id header';
+COMMENT ON COLUMN api_keys.not_after IS 'End of the overlap window after rotation (NULL = no expiry)';
+COMMENT ON COLUMN api_keys.revoked_at IS 'When the key was revoked; set together with revoked';
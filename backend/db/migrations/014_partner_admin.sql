@@ -0,0 +1,42 @@
+-- Migration: 014_partner_admin.sql
+-- Description: Audit trail and database role for the partner admin API
+-- Author: Lucendex Team
+-- Date: 2025-11-26
+
+-- Every admin change to partners and api_keys, written in the same
+-- transaction as the change itself
+CREATE TABLE IF NOT EXISTS partner_audit (
+    id BIGSERIAL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL CHECK (action IN (
+        'partner.create', 'partner.update', 'key.create', 'key.rotate', 'key.revoke'
+    )),
+    partner_id UUID NOT NULL REFERENCES partners(id),
+    api_key_id UUID REFERENCES api_keys(id),
+    changes JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_partner_audit_partner ON partner_audit(partner_id, created_at DESC);
+
+COMMENT ON TABLE partner_audit IS 'Append-only log of partner administration changes';
+COMMENT ON COLUMN partner_audit.actor IS 'Admin client certificate CN or the operator named by X-Admin-Actor';
+COMMENT ON COLUMN partner_audit.changes IS 'Changed fields as {"field": {"from": old, "to": new}}';
+
+-- partner_admin: used by the admin listener only. It can create and edit
+-- partners and keys and append to the audit log, never rewrite it.
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'partner_admin') THEN
+        CREATE ROLE partner_admin WITH LOGIN;
+    END IF;
+END
+$$;
+
+GRANT CONNECT ON DATABASE lucendex TO partner_admin;
+GRANT USAGE ON SCHEMA public TO partner_admin;
+GRANT SELECT, INSERT, UPDATE ON partners TO partner_admin;
+GRANT SELECT, INSERT, UPDATE ON api_keys TO partner_admin;
+GRANT SELECT, INSERT ON partner_audit TO partner_admin;
+GRANT USAGE, SELECT ON SEQUENCE partner_audit_id_seq TO partner_admin;
+GRANT SELECT ON usage_events TO partner_admin;
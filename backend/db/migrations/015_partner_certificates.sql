@@ -0,0 +1,30 @@
+-- Migration: 015_partner_certificates.sql
+-- Description: Client certificates bound to partners for mTLS on the partner API
+-- Author: Lucendex Team
+-- Date: 2025-11-28
+
+CREATE TABLE IF NOT EXISTS partner_certificates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    partner_id UUID NOT NULL REFERENCES partners(id) ON DELETE CASCADE,
+    fingerprint TEXT NOT NULL UNIQUE CHECK (fingerprint ~ '^[0-9a-f]{64}$'),
+    label TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked BOOLEAN NOT NULL DEFAULT FALSE,
+    revoked_at TIMESTAMPTZ,
+    CONSTRAINT partner_certificates_revoked_at CHECK (NOT revoked OR revoked_at IS NOT NULL)
+);
+
+CREATE INDEX IF NOT EXISTS idx_partner_certificates_partner ON partner_certificates(partner_id);
+
+COMMENT ON TABLE partner_certificates IS 'Client certificates accepted on the partner API, signed by the partner CA';
+COMMENT ON COLUMN partner_certificates.fingerprint IS 'Lowercase hex SHA-256 of the DER encoded certificate';
+
+-- Certificate changes go through the admin API and are audited like keys
+ALTER TABLE partner_audit DROP CONSTRAINT IF EXISTS partner_audit_action_check;
+ALTER TABLE partner_audit ADD CONSTRAINT partner_audit_action_check CHECK (action IN (
+    'partner.create', 'partner.update', 'key.create', 'key.rotate', 'key.revoke',
+    'cert.bind', 'cert.revoke'
+));
+
+GRANT SELECT ON partner_certificates TO api_ro;
+GRANT SELECT, INSERT, UPDATE ON partner_certificates TO partner_admin;
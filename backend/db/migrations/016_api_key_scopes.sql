@@ -0,0 +1,13 @@
+-- Migration: 016_api_key_scopes.sql
+-- Description: Per-key scopes limiting which partner endpoints a key may call
+-- Author: Lucendex Team
+-- Date: 2025-12-02
+
+-- NULL keeps a key unrestricted, so keys issued before scoping keep working
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS scopes TEXT[];
+
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_scopes CHECK (
+    scopes IS NULL OR (cardinality(scopes) > 0 AND scopes <@ ARRAY['quote', 'pairs', 'usage']::TEXT[])
+);
+
+COMMENT ON COLUMN api_keys.scopes IS 'Endpoints the key may call (quote, pairs, usage); NULL for all';
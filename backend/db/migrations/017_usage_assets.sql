@@ -0,0 +1,29 @@
+-- Migration: 017_usage_assets.sql
+-- Description: Per-asset usage totals, trade-time USD reference prices and cursor pagination
+-- Author: Lucendex Team
+-- Date: 2025-12-09
+
+-- fee_amount is taken from amount_out, so it is denominated in out_asset
+ALTER TABLE usage_events
+    ADD COLUMN IF NOT EXISTS in_asset TEXT,
+    ADD COLUMN IF NOT EXISTS out_asset TEXT,
+    ADD COLUMN IF NOT EXISTS in_usd_price NUMERIC,
+    ADD COLUMN IF NOT EXISTS out_usd_price NUMERIC;
+
+UPDATE usage_events
+SET in_asset = split_part(pair, '-', 1), out_asset = split_part(pair, '-', 2)
+WHERE in_asset IS NULL;
+
+ALTER TABLE usage_events
+    ALTER COLUMN in_asset SET NOT NULL,
+    ALTER COLUMN out_asset SET NOT NULL;
+
+COMMENT ON COLUMN usage_events.amount_in IS 'Amount of in_asset, XRP in drops';
+COMMENT ON COLUMN usage_events.amount_out IS 'Amount of out_asset, XRP in drops';
+COMMENT ON COLUMN usage_events.fee_amount IS 'Routing fee in out_asset, XRP in drops';
+COMMENT ON COLUMN usage_events.in_usd_price IS 'USD per whole unit of in_asset when the trade was indexed; NULL without a reference market';
+COMMENT ON COLUMN usage_events.out_usd_price IS 'USD per whole unit of out_asset when the trade was indexed; NULL without a reference market';
+
+-- Keyset pagination walks (ts, id) newest first
+CREATE INDEX IF NOT EXISTS idx_usage_events_partner_page ON usage_events(partner_id, ts DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_usage_events_partner_pair ON usage_events(partner_id, pair);
@@ -0,0 +1,100 @@
+-- Migration: 018_invoices.sql
+-- Description: Billing plan terms, immutable monthly invoices and their per-asset line items
+-- Author: Lucendex Team
+-- Date: 2025-12-16
+
+-- Terms applied when a month is closed. Invoices copy them, so editing a
+-- plan never changes a closed month.
+CREATE TABLE IF NOT EXISTS billing_plans (
+    plan TEXT PRIMARY KEY CHECK (plan IN ('free', 'pro', 'enterprise')),
+    minimum_usd NUMERIC NOT NULL DEFAULT 0 CHECK (minimum_usd >= 0),
+    discount_bps INT NOT NULL DEFAULT 0 CHECK (discount_bps BETWEEN 0 AND 10000),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO billing_plans (plan) VALUES ('free'), ('pro'), ('enterprise')
+ON CONFLICT (plan) DO NOTHING;
+
+COMMENT ON COLUMN billing_plans.minimum_usd IS 'Monthly minimum charge in USD, applied after the discount';
+COMMENT ON COLUMN billing_plans.discount_bps IS 'Discount on routing fees in basis points';
+
+-- One invoice per partner and month, written once when the month is closed
+CREATE TABLE IF NOT EXISTS invoices (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    partner_id UUID NOT NULL REFERENCES partners(id),
+    partner_name TEXT NOT NULL,
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    plan TEXT NOT NULL,
+    minimum_usd NUMERIC NOT NULL,
+    discount_bps INT NOT NULL,
+    tx_count BIGINT NOT NULL,
+    unpriced_count BIGINT NOT NULL,
+    subtotal_usd NUMERIC NOT NULL,
+    discount_usd NUMERIC NOT NULL,
+    minimum_adjustment_usd NUMERIC NOT NULL,
+    total_usd NUMERIC NOT NULL,
+    closed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (partner_id, period_start)
+);
+
+CREATE TABLE IF NOT EXISTS invoice_line_items (
+    invoice_id UUID NOT NULL REFERENCES invoices(id),
+    asset TEXT NOT NULL,
+    tx_count BIGINT NOT NULL,
+    fees NUMERIC NOT NULL,
+    priced_fees NUMERIC NOT NULL,
+    usd_rate NUMERIC,
+    fees_usd NUMERIC NOT NULL,
+    unpriced_count BIGINT NOT NULL,
+    PRIMARY KEY (invoice_id, asset)
+);
+
+COMMENT ON COLUMN invoices.period_start IS 'First day of the billed month, UTC';
+COMMENT ON COLUMN invoices.period_end IS 'First day of the following month, exclusive';
+COMMENT ON COLUMN invoices.unpriced_count IS 'Trades whose fees had no USD reference price and are not in subtotal_usd';
+COMMENT ON COLUMN invoices.minimum_adjustment_usd IS 'Amount added to reach the plan minimum';
+COMMENT ON COLUMN invoice_line_items.asset IS 'Fee asset, the out asset of the trades';
+COMMENT ON COLUMN invoice_line_items.fees IS 'All routing fees in whole units of asset';
+COMMENT ON COLUMN invoice_line_items.priced_fees IS 'Part of fees that had a USD reference price';
+COMMENT ON COLUMN invoice_line_items.usd_rate IS 'Effective USD per unit over priced_fees; NULL when nothing was priced';
+
+-- Corrections are made on a later invoice, never by editing a closed one
+CREATE OR REPLACE FUNCTION reject_invoice_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION '% rows are immutable', TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invoices_immutable
+    BEFORE UPDATE OR DELETE ON invoices
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_invoice_change();
+
+CREATE TRIGGER invoices_no_truncate
+    BEFORE TRUNCATE ON invoices
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION reject_invoice_change();
+
+CREATE TRIGGER invoice_line_items_immutable
+    BEFORE UPDATE OR DELETE ON invoice_line_items
+    FOR EACH ROW
+    EXECUTE FUNCTION reject_invoice_change();
+
+CREATE TRIGGER invoice_line_items_no_truncate
+    BEFORE TRUNCATE ON invoice_line_items
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION reject_invoice_change();
+
+-- Superseded by invoices: it summed fees across assets and labelled them USD
+DROP VIEW IF EXISTS monthly_billing;
+
+GRANT SELECT ON billing_plans TO api_ro;
+GRANT SELECT ON invoices TO api_ro;
+GRANT SELECT ON invoice_line_items TO api_ro;
+
+-- The admin listener closes months
+GRANT SELECT ON billing_plans TO partner_admin;
+GRANT SELECT, INSERT ON invoices TO partner_admin;
+GRANT SELECT, INSERT ON invoice_line_items TO partner_admin;
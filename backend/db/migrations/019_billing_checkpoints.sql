@@ -0,0 +1,11 @@
+-- Migration: 019_billing_checkpoints.sql
+-- Description: Let the billing loop see how far the indexer has got
+-- Author: Lucendex Team
+-- Date: 2025-12-20
+
+-- A month is closed only once a ledger closed after it has been
+-- checkpointed, so its trades are recorded before it is invoiced
+GRANT USAGE ON SCHEMA core TO partner_admin;
+GRANT SELECT ON core.ledger_checkpoints TO partner_admin;
+
+COMMENT ON COLUMN usage_events.ts IS 'When the row was recorded; invoices bill by it, so usage recorded after its month closed goes on the next invoice';
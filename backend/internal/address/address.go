@@ -0,0 +1,250 @@
+package address
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Alphabet is the XRPL base58 alphabet (differs from Bitcoin's ordering)
+const Alphabet = "rpshnaf39wBUDNEGHJKLM4PQRST7VWXYZ2bcdeCg65jkm8oFqi1tuvAxyz"
+
+const (
+	accountIDLength = 20
+	checksumLength  = 4
+	xPayloadLength  = 2 + accountIDLength + 1 + 8
+)
+
+var (
+	ErrInvalidEncoding = errors.New("invalid base58 encoding")
+	ErrInvalidChecksum = errors.New("invalid address checksum")
+	ErrInvalidAddress  = errors.New("invalid XRPL address")
+	ErrUnexpectedTag   = errors.New("address carries a destination tag")
+)
+
+var (
+	classicPrefix = []byte{0x00}
+	xMainPrefix   = []byte{0x05, 0x44}
+	xTestPrefix   = []byte{0x04, 0x93}
+)
+
+// AccountID is the 160-bit identifier behind every XRPL address
+type AccountID [accountIDLength]byte
+
+// XAddress is a decoded X-address: an account plus an optional destination tag
+type XAddress struct {
+	Account AccountID
+	Tag     uint32
+	HasTag  bool
+	Testnet bool
+}
+
+// DecodeClassic decodes an "r..." address and verifies its checksum
+func DecodeClassic(s string) (AccountID, error) {
+	var id AccountID
+
+	payload, err := decodeCheck(s)
+	if err != nil {
+		return id, err
+	}
+	if len(payload) != 1+accountIDLength || !bytes.HasPrefix(payload, classicPrefix) {
+		return id, ErrInvalidAddress
+	}
+
+	copy(id[:], payload[1:])
+	return id, nil
+}
+
+// EncodeClassic returns the "r..." address for id
+func EncodeClassic(id AccountID) string {
+	return encodeCheck(append(append([]byte{}, classicPrefix...), id[:]...))
+}
+
+// IsValidClassic reports whether s is a classic address with a valid checksum
+func IsValidClassic(s string) bool {
+	_, err := DecodeClassic(s)
+	return err == nil
+}
+
+// DecodeXAddress decodes an "X..." (mainnet) or "T..." (testnet) address
+func DecodeXAddress(s string) (XAddress, error) {
+	var x XAddress
+
+	payload, err := decodeCheck(s)
+	if err != nil {
+		return x, err
+	}
+	if len(payload) != xPayloadLength {
+		return x, ErrInvalidAddress
+	}
+
+	switch {
+	case bytes.HasPrefix(payload, xMainPrefix):
+	case bytes.HasPrefix(payload, xTestPrefix):
+		x.Testnet = true
+	default:
+		return x, ErrInvalidAddress
+	}
+
+	copy(x.Account[:], payload[2:2+accountIDLength])
+
+	flags := payload[2+accountIDLength]
+	tag := binary.LittleEndian.Uint64(payload[3+accountIDLength:])
+	switch {
+	case flags == 0 && tag == 0:
+	case flags == 1 && tag <= 0xFFFFFFFF:
+		// 64-bit tags are reserved; XRPL destination tags are 32-bit
+		x.Tag = uint32(tag)
+		x.HasTag = true
+	default:
+		return x, ErrInvalidAddress
+	}
+
+	return x, nil
+}
+
+// EncodeXAddress returns the X-address form of x
+func EncodeXAddress(x XAddress) string {
+	payload := make([]byte, 0, xPayloadLength)
+	if x.Testnet {
+		payload = append(payload, xTestPrefix...)
+	} else {
+		payload = append(payload, xMainPrefix...)
+	}
+	payload = append(payload, x.Account[:]...)
+
+	var tag [9]byte
+	if x.HasTag {
+		tag[0] = 1
+		binary.LittleEndian.PutUint64(tag[1:], uint64(x.Tag))
+	}
+	payload = append(payload, tag[:]...)
+
+	return encodeCheck(payload)
+}
+
+// ClassicAddress returns the "r..." address of the X-address account
+func (x XAddress) ClassicAddress() string {
+	return EncodeClassic(x.Account)
+}
+
+// Parse accepts a classic address or an X-address and returns the classic
+// address along with the destination tag carried by an X-address, if any.
+func Parse(s string) (string, *uint32, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil, ErrInvalidAddress
+	}
+
+	if s[0] == 'X' || s[0] == 'T' {
+		x, err := DecodeXAddress(s)
+		if err != nil {
+			return "", nil, err
+		}
+		if !x.HasTag {
+			return x.ClassicAddress(), nil, nil
+		}
+		tag := x.Tag
+		return x.ClassicAddress(), &tag, nil
+	}
+
+	if _, err := DecodeClassic(s); err != nil {
+		return "", nil, err
+	}
+	return s, nil, nil
+}
+
+// ParseAccount is Parse for contexts where a destination tag is meaningless,
+// such as token issuers. X-addresses with a tag are rejected.
+func ParseAccount(s string) (string, error) {
+	classic, tag, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	if tag != nil {
+		return "", ErrUnexpectedTag
+	}
+	return classic, nil
+}
+
+func decodeCheck(s string) ([]byte, error) {
+	raw, err := decodeBase58(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) <= checksumLength {
+		return nil, ErrInvalidEncoding
+	}
+
+	payload := raw[:len(raw)-checksumLength]
+	sum := checksum(payload)
+	if !bytes.Equal(sum[:], raw[len(raw)-checksumLength:]) {
+		return nil, ErrInvalidChecksum
+	}
+	return payload, nil
+}
+
+func encodeCheck(payload []byte) string {
+	sum := checksum(payload)
+	return encodeBase58(append(append([]byte{}, payload...), sum[:]...))
+}
+
+func checksum(payload []byte) [checksumLength]byte {
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+
+	var sum [checksumLength]byte
+	copy(sum[:], second[:checksumLength])
+	return sum
+}
+
+var (
+	bigRadix = big.NewInt(58)
+	bigZero  = big.NewInt(0)
+)
+
+func decodeBase58(s string) ([]byte, error) {
+	if s == "" {
+		return nil, ErrInvalidEncoding
+	}
+
+	n := new(big.Int)
+	for i := 0; i < len(s); i++ {
+		digit := strings.IndexByte(Alphabet, s[i])
+		if digit < 0 {
+			return nil, ErrInvalidEncoding
+		}
+		n.Mul(n, bigRadix)
+		n.Add(n, big.NewInt(int64(digit)))
+	}
+
+	// Each leading zero digit encodes a leading zero byte
+	zeros := 0
+	for zeros < len(s) && s[zeros] == Alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), n.Bytes()...), nil
+}
+
+func encodeBase58(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	mod := new(big.Int)
+
+	var out []byte
+	for n.Cmp(bigZero) > 0 {
+		n.DivMod(n, bigRadix, mod)
+		out = append(out, Alphabet[mod.Int64()])
+	}
+	for i := 0; i < len(b) && b[i] == 0; i++ {
+		out = append(out, Alphabet[0])
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
@@ -0,0 +1,101 @@
+package address
+
+import "testing"
+
+func TestDecodeClassic(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		wantErr error
+	}{
+		{"valid", "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B", nil},
+		{"valid genesis", "rHb9CJAWyB4rj91VRWn96DkukG4bwdtyTh", nil},
+		{"checksum typo", "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59C", ErrInvalidChecksum},
+		{"transposed characters", "rvYAfWj5gh67oV6fW32ZzP3Aw4Eusb59B", ErrInvalidChecksum},
+		{"bitcoin alphabet only character", "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs590", ErrInvalidEncoding},
+		{"empty", "", ErrInvalidEncoding},
+		{"x-address", "X7AcgcsBL6XDcUb289X4mJ8djcdyKaB5hJDWMArnXr61cqZ", ErrInvalidAddress},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := DecodeClassic(tt.address)
+			if err != tt.wantErr {
+				t.Fatalf("DecodeClassic() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && EncodeClassic(id) != tt.address {
+				t.Errorf("EncodeClassic() = %s, want %s", EncodeClassic(id), tt.address)
+			}
+		})
+	}
+}
+
+func TestXAddress(t *testing.T) {
+	const classic = "r9cZA1mLK5R5Am25ArfXFmqgNwjZgnfk59"
+
+	tests := []struct {
+		name     string
+		xAddress string
+		tag      uint32
+		hasTag   bool
+		testnet  bool
+	}{
+		{"mainnet no tag", "X7AcgcsBL6XDcUb289X4mJ8djcdyKaB5hJDWMArnXr61cqZ", 0, false, false},
+		{"mainnet tag 1", "X7AcgcsBL6XDcUb289X4mJ8djcdyKaGZMhc9YTE92ehJ2Fu", 1, true, false},
+		{"mainnet tag 11747", "X7AcgcsBL6XDcUb289X4mJ8djcdyKaLFuhLRuNXPrDeJd9A", 11747, true, false},
+		{"testnet no tag", "T719a5UwUCnEs54UsxG9CJYYDhwmFCqkr7wxCcNcfZ6p5GZ", 0, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			x, err := DecodeXAddress(tt.xAddress)
+			if err != nil {
+				t.Fatalf("DecodeXAddress() error = %v", err)
+			}
+			if x.ClassicAddress() != classic {
+				t.Errorf("ClassicAddress() = %s, want %s", x.ClassicAddress(), classic)
+			}
+			if x.Tag != tt.tag || x.HasTag != tt.hasTag || x.Testnet != tt.testnet {
+				t.Errorf("DecodeXAddress() = %+v", x)
+			}
+			if got := EncodeXAddress(x); got != tt.xAddress {
+				t.Errorf("EncodeXAddress() = %s, want %s", got, tt.xAddress)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	classic, tag, err := Parse("X7AcgcsBL6XDcUb289X4mJ8djcdyKaGZMhc9YTE92ehJ2Fu")
+	if err != nil || classic != "r9cZA1mLK5R5Am25ArfXFmqgNwjZgnfk59" || tag == nil || *tag != 1 {
+		t.Errorf("Parse() = %s, %v, %v", classic, tag, err)
+	}
+
+	classic, tag, err = Parse(" rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B ")
+	if err != nil || classic != "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B" || tag != nil {
+		t.Errorf("Parse() = %s, %v, %v", classic, tag, err)
+	}
+
+	if _, err := ParseAccount("X7AcgcsBL6XDcUb289X4mJ8djcdyKaGZMhc9YTE92ehJ2Fu"); err != ErrUnexpectedTag {
+		t.Errorf("ParseAccount() error = %v, want %v", err, ErrUnexpectedTag)
+	}
+	if account, err := ParseAccount("X7AcgcsBL6XDcUb289X4mJ8djcdyKaB5hJDWMArnXr61cqZ"); err != nil || account != "r9cZA1mLK5R5Am25ArfXFmqgNwjZgnfk59" {
+		t.Errorf("ParseAccount() = %s, %v", account, err)
+	}
+}
+
+func TestXAddress_MaxTagRoundTrip(t *testing.T) {
+	id, err := DecodeClassic("rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B")
+	if err != nil {
+		t.Fatalf("DecodeClassic() error = %v", err)
+	}
+
+	want := XAddress{Account: id, Tag: 4294967295, HasTag: true, Testnet: true}
+	got, err := DecodeXAddress(EncodeXAddress(want))
+	if err != nil {
+		t.Fatalf("DecodeXAddress() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("round trip = %+v, want %+v", got, want)
+	}
+}
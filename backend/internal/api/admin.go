@@ -0,0 +1,455 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultRotationOverlap is how long a rotated-out key keeps verifying when
+// the rotate request doesn't say
+const DefaultRotationOverlap = 7 * 24 * time.Hour
+
+// AdminStore is what the admin API needs from the database. Every write
+// records an audit entry under actor in the same transaction.
+type AdminStore interface {
+	ListPartners(ctx context.Context) ([]*Partner, error)
+	GetPartnerByID(ctx context.Context, partnerID uuid.UUID) (*Partner, error)
+	CreatePartner(ctx context.Context, actor, name, plan string, routerBps int, status string) (*Partner, error)
+	UpdatePartner(ctx context.Context, actor string, partnerID uuid.UUID, update PartnerUpdate) (*Partner, error)
+	ListAPIKeys(ctx context.Context, partnerID uuid.UUID) ([]*APIKey, error)
+	CreateAPIKey(ctx context.Context, actor string, partnerID uuid.UUID, publicKey, label string, scopes []string) (*APIKey, error)
+	RotateAPIKey(ctx context.Context, actor string, partnerID, oldKeyID uuid.UUID, publicKey, label string, scopes []string, overlap time.Duration) (*APIKey, error)
+	RevokeAPIKey(ctx context.Context, actor string, partnerID, keyID uuid.UUID) error
+	ListPartnerCertificates(ctx context.Context, partnerID uuid.UUID) ([]*PartnerCertificate, error)
+	BindCertificate(ctx context.Context, actor string, partnerID uuid.UUID, fingerprint, label string) (*PartnerCertificate, error)
+	RevokeCertificate(ctx context.Context, actor string, partnerID, certID uuid.UUID) error
+	GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error)
+	StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error
+	ListInvoices(ctx context.Context, partnerID uuid.UUID, limit int) ([]*Invoice, error)
+	GetInvoice(ctx context.Context, partnerID uuid.UUID, month time.Time) (*Invoice, error)
+	BillingStore
+	ListAuditEvents(ctx context.Context, partnerID uuid.UUID, limit int) ([]*AuditEvent, error)
+}
+
+// Admin request and response bodies
+type CreatePartnerRequest struct {
+	Name      string `json:"name"`
+	Plan      string `json:"plan"`
+	RouterBps *int   `json:"router_bps,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+type CreateAPIKeyRequest struct {
+	PublicKey string   `json:"public_key"`
+	Label     string   `json:"label"`
+	Scopes    []string `json:"scopes,omitempty"`  // omit for every endpoint, or to keep the rotated key's
+	Overlap   string   `json:"overlap,omitempty"` // rotation only, e.g. "168h"
+}
+
+// BindCertificateRequest names the certificate by fingerprint or by its PEM
+type BindCertificateRequest struct {
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	Label       string `json:"label"`
+}
+
+type PartnersResponse struct {
+	Partners []*Partner `json:"partners"`
+}
+
+type APIKeysResponse struct {
+	Keys []*APIKey `json:"keys"`
+}
+
+type CertificatesResponse struct {
+	Certificates []*PartnerCertificate `json:"certificates"`
+}
+
+type AuditResponse struct {
+	Events []*AuditEvent `json:"events"`
+}
+
+// defaultRouterBps matches the partners.router_bps column default
+const defaultRouterBps = 20
+
+// AdminHandlers serves /admin/v1/*. It is meant for a separate listener
+// reachable only from the internal network.
+type AdminHandlers struct {
+	db    AdminStore
+	token string
+}
+
+func NewAdminHandlers(db AdminStore, token string) *AdminHandlers {
+	return &AdminHandlers{db: db, token: token}
+}
+
+// Handler routes the admin API behind authentication
+func (h *AdminHandlers) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/v1/partners", h.listPartners)
+	mux.HandleFunc("POST /admin/v1/partners", h.createPartner)
+	mux.HandleFunc("GET /admin/v1/partners/{partner}", h.getPartner)
+	mux.HandleFunc("PATCH /admin/v1/partners/{partner}", h.updatePartner)
+	mux.HandleFunc("GET /admin/v1/partners/{partner}/keys", h.listKeys)
+	mux.HandleFunc("POST /admin/v1/partners/{partner}/keys", h.createKey)
+	mux.HandleFunc("POST /admin/v1/partners/{partner}/keys/{key}/rotate", h.rotateKey)
+	mux.HandleFunc("DELETE /admin/v1/partners/{partner}/keys/{key}", h.revokeKey)
+	mux.HandleFunc("GET /admin/v1/partners/{partner}/certificates", h.listCertificates)
+	mux.HandleFunc("POST /admin/v1/partners/{partner}/certificates", h.bindCertificate)
+	mux.HandleFunc("DELETE /admin/v1/partners/{partner}/certificates/{cert}", h.revokeCertificate)
+	mux.HandleFunc("GET /admin/v1/partners/{partner}/usage", h.usage)
+	mux.HandleFunc("GET /admin/v1/partners/{partner}/invoices", h.invoices)
+	mux.HandleFunc("GET /admin/v1/partners/{partner}/audit", h.audit)
+	mux.HandleFunc("POST /admin/v1/billing/close", h.closeMonth)
+	return h.authenticate(mux)
+}
+
+// authenticate accepts a verified client certificate, whose CommonName
+// becomes the audit actor, or the internal token with the operator named in
+// X-Admin-Actor
+func (h *AdminHandlers) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var actor string
+		switch {
+		case r.TLS != nil && len(r.TLS.VerifiedChains) > 0:
+			actor = r.TLS.VerifiedChains[0][0].Subject.CommonName
+		case h.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Internal-Token")), []byte(h.token)) == 1:
+			actor = r.Header.Get("X-Admin-Actor")
+			if actor == "" {
+				writeError(w, http.StatusBadRequest, "X-Admin-Actor required")
+				return
+			}
+		default:
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if actor == "" {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), ContextKeyAdminActor, actor)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (h *AdminHandlers) listPartners(w http.ResponseWriter, r *http.Request) {
+	partners, err := h.db.ListPartners(r.Context())
+	if err != nil {
+		h.writeStoreError(w, "list partners", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, PartnersResponse{Partners: partners})
+}
+
+func (h *AdminHandlers) createPartner(w http.ResponseWriter, r *http.Request) {
+	var req CreatePartnerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	routerBps := defaultRouterBps
+	if req.RouterBps != nil {
+		routerBps = *req.RouterBps
+	}
+
+	partner, err := h.db.CreatePartner(r.Context(), adminActor(r), req.Name, req.Plan, routerBps, req.Status)
+	if err != nil {
+		h.writeStoreError(w, "create partner", err)
+		return
+	}
+	log.Printf("admin %s created partner %s (%s)", adminActor(r), partner.ID, partner.Name)
+	writeJSON(w, http.StatusCreated, partner)
+}
+
+func (h *AdminHandlers) getPartner(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	partner, err := h.db.GetPartnerByID(r.Context(), partnerID)
+	if err != nil {
+		h.writeStoreError(w, "get partner", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, partner)
+}
+
+func (h *AdminHandlers) updatePartner(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	var update PartnerUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	partner, err := h.db.UpdatePartner(r.Context(), adminActor(r), partnerID, update)
+	if err != nil {
+		h.writeStoreError(w, "update partner", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, partner)
+}
+
+func (h *AdminHandlers) listKeys(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	keys, err := h.db.ListAPIKeys(r.Context(), partnerID)
+	if err != nil {
+		h.writeStoreError(w, "list keys", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, APIKeysResponse{Keys: keys})
+}
+
+func (h *AdminHandlers) createKey(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if _, err := h.db.GetPartnerByID(r.Context(), partnerID); err != nil {
+		h.writeStoreError(w, "create key", err)
+		return
+	}
+
+	key, err := h.db.CreateAPIKey(r.Context(), adminActor(r), partnerID, req.PublicKey, req.Label, req.Scopes)
+	if err != nil {
+		h.writeStoreError(w, "create key", err)
+		return
+	}
+	log.Printf("admin %s registered key %s for partner %s", adminActor(r), key.ID, partnerID)
+	writeJSON(w, http.StatusCreated, key)
+}
+
+func (h *AdminHandlers) rotateKey(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	keyID, ok := pathUUID(w, r, "key")
+	if !ok {
+		return
+	}
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	overlap := DefaultRotationOverlap
+	if req.Overlap != "" {
+		d, err := time.ParseDuration(req.Overlap)
+		if err != nil || d < 0 {
+			writeError(w, http.StatusBadRequest, "invalid overlap duration")
+			return
+		}
+		overlap = d
+	}
+
+	key, err := h.db.RotateAPIKey(r.Context(), adminActor(r), partnerID, keyID, req.PublicKey, req.Label, req.Scopes, overlap)
+	if err != nil {
+		h.writeStoreError(w, "rotate key", err)
+		return
+	}
+	log.Printf("admin %s rotated key %s to %s for partner %s", adminActor(r), keyID, key.ID, partnerID)
+	writeJSON(w, http.StatusCreated, key)
+}
+
+func (h *AdminHandlers) revokeKey(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	keyID, ok := pathUUID(w, r, "key")
+	if !ok {
+		return
+	}
+
+	if err := h.db.RevokeAPIKey(r.Context(), adminActor(r), partnerID, keyID); err != nil {
+		h.writeStoreError(w, "revoke key", err)
+		return
+	}
+	log.Printf("admin %s revoked key %s for partner %s", adminActor(r), keyID, partnerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandlers) listCertificates(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	certs, err := h.db.ListPartnerCertificates(r.Context(), partnerID)
+	if err != nil {
+		h.writeStoreError(w, "list certificates", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, CertificatesResponse{Certificates: certs})
+}
+
+func (h *AdminHandlers) bindCertificate(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	var req BindCertificateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	fingerprint := req.Fingerprint
+	if req.Certificate != "" {
+		var err error
+		if fingerprint, err = PEMFingerprint([]byte(req.Certificate)); err != nil {
+			writeError(w, http.StatusBadRequest, ErrInvalidCertificate.Error())
+			return
+		}
+	}
+	if _, err := h.db.GetPartnerByID(r.Context(), partnerID); err != nil {
+		h.writeStoreError(w, "bind certificate", err)
+		return
+	}
+
+	cert, err := h.db.BindCertificate(r.Context(), adminActor(r), partnerID, fingerprint, req.Label)
+	if err != nil {
+		h.writeStoreError(w, "bind certificate", err)
+		return
+	}
+	log.Printf("admin %s bound certificate %s to partner %s", adminActor(r), cert.Fingerprint, partnerID)
+	writeJSON(w, http.StatusCreated, cert)
+}
+
+func (h *AdminHandlers) revokeCertificate(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	certID, ok := pathUUID(w, r, "cert")
+	if !ok {
+		return
+	}
+
+	if err := h.db.RevokeCertificate(r.Context(), adminActor(r), partnerID, certID); err != nil {
+		h.writeStoreError(w, "revoke certificate", err)
+		return
+	}
+	log.Printf("admin %s revoked certificate %s for partner %s", adminActor(r), certID, partnerID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandlers) usage(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	serveUsage(w, r, h.db, partnerID)
+}
+
+func (h *AdminHandlers) invoices(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	serveInvoices(w, r, h.db, partnerID)
+}
+
+// closeMonth invoices every billable partner for a month that has ended.
+// Repeating it is safe: partners already invoiced are reported with
+// created false.
+func (h *AdminHandlers) closeMonth(w http.ResponseWriter, r *http.Request) {
+	var req CloseMonthRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	month, err := time.Parse("2006-01", req.Month)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid month format (use YYYY-MM)")
+		return
+	}
+
+	results, err := CloseMonth(r.Context(), h.db, month, time.Now())
+	if err != nil {
+		h.writeStoreError(w, "close month", err)
+		return
+	}
+	logClosedMonth("admin "+adminActor(r), month, results)
+	writeJSON(w, http.StatusOK, CloseMonthResponse{Month: req.Month, Invoices: results})
+}
+
+func (h *AdminHandlers) audit(w http.ResponseWriter, r *http.Request) {
+	partnerID, ok := pathUUID(w, r, "partner")
+	if !ok {
+		return
+	}
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 1000 {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.db.ListAuditEvents(r.Context(), partnerID, limit)
+	if err != nil {
+		h.writeStoreError(w, "audit", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, AuditResponse{Events: events})
+}
+
+// writeStoreError maps validation and lookup failures to client errors and
+// logs the rest
+func (h *AdminHandlers) writeStoreError(w http.ResponseWriter, op string, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		writeError(w, http.StatusNotFound, "not found")
+	case errors.Is(err, ErrDuplicatePublicKey), errors.Is(err, ErrDuplicateCertificate), errors.Is(err, ErrMonthOpen):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrInvalidPublicKey), errors.Is(err, ErrInvalidPlan), errors.Is(err, ErrInvalidStatus),
+		errors.Is(err, ErrInvalidRouterBps), errors.Is(err, ErrMissingName), errors.Is(err, ErrInvalidFingerprint), errors.Is(err, ErrInvalidScope):
+		writeError(w, http.StatusBadRequest, err.Error())
+	default:
+		log.Printf("admin %s failed: %v", op, err)
+		writeError(w, http.StatusInternalServerError, "internal error")
+	}
+}
+
+func adminActor(r *http.Request) string {
+	actor, _ := r.Context().Value(ContextKeyAdminActor).(string)
+	return actor
+}
+
+func pathUUID(w http.ResponseWriter, r *http.Request, name string) (uuid.UUID, bool) {
+	id, err := uuid.Parse(r.PathValue(name))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid "+name+" id")
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,391 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Audit actions, as allowed by the partner_audit CHECK constraint
+const (
+	AuditPartnerCreate = "partner.create"
+	AuditPartnerUpdate = "partner.update"
+	AuditKeyCreate     = "key.create"
+	AuditKeyRotate     = "key.rotate"
+	AuditKeyRevoke     = "key.revoke"
+	AuditCertBind      = "cert.bind"
+	AuditCertRevoke    = "cert.revoke"
+)
+
+var (
+	ErrInvalidPlan      = errors.New("plan must be free, pro or enterprise")
+	ErrInvalidStatus    = errors.New("status must be active or suspended")
+	ErrInvalidRouterBps = errors.New("router_bps must be between 0 and 10000")
+	ErrMissingName      = errors.New("partner name required")
+	ErrMissingActor     = errors.New("audit actor required")
+)
+
+// PartnerUpdate holds the partner fields to change; nil fields are left as is
+type PartnerUpdate struct {
+	Name      *string `json:"name,omitempty"`
+	Plan      *string `json:"plan,omitempty"`
+	RouterBps *int    `json:"router_bps,omitempty"`
+	Status    *string `json:"status,omitempty"`
+}
+
+// fieldChange is one entry of partner_audit.changes
+type fieldChange struct {
+	From any `json:"from"`
+	To   any `json:"to"`
+}
+
+const partnerColumns = `id, name, plan, router_bps, status, created_at, updated_at`
+
+func scanPartner(row rowScanner) (*Partner, error) {
+	var p Partner
+	err := row.Scan(&p.ID, &p.Name, &p.Plan, &p.RouterBps, &p.Status, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func validatePartner(p *Partner) error {
+	switch {
+	case p.Name == "":
+		return ErrMissingName
+	case p.Plan != "free" && p.Plan != "pro" && p.Plan != "enterprise":
+		return ErrInvalidPlan
+	case p.Status != "active" && p.Status != "suspended":
+		return ErrInvalidStatus
+	case p.RouterBps < 0 || p.RouterBps > 10000:
+		return ErrInvalidRouterBps
+	}
+	return nil
+}
+
+// ListPartners returns every partner, oldest first
+func (s *PostgresStore) ListPartners(ctx context.Context) ([]*Partner, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+partnerColumns+`
+		FROM partners
+		ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partners []*Partner
+	for rows.Next() {
+		p, err := scanPartner(rows)
+		if err != nil {
+			return nil, err
+		}
+		partners = append(partners, p)
+	}
+	return partners, rows.Err()
+}
+
+// CreatePartner inserts a partner and its audit record in one transaction.
+// An empty status defaults to active.
+func (s *PostgresStore) CreatePartner(ctx context.Context, actor, name, plan string, routerBps int, status string) (*Partner, error) {
+	if status == "" {
+		status = "active"
+	}
+	if err := validatePartner(&Partner{Name: name, Plan: plan, RouterBps: routerBps, Status: status}); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	p, err := scanPartner(tx.QueryRowContext(ctx, `
+		INSERT INTO partners (name, plan, router_bps, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING `+partnerColumns+`
+	`, name, plan, routerBps, status))
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertAudit(ctx, tx, actor, AuditPartnerCreate, p.ID, nil, map[string]fieldChange{
+		"name":       {To: p.Name},
+		"plan":       {To: p.Plan},
+		"router_bps": {To: p.RouterBps},
+		"status":     {To: p.Status},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// UpdatePartner applies update and records the fields that actually changed.
+// An update that changes nothing writes no audit record.
+func (s *PostgresStore) UpdatePartner(ctx context.Context, actor string, partnerID uuid.UUID, update PartnerUpdate) (*Partner, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	current, err := scanPartner(tx.QueryRowContext(ctx, `
+		SELECT `+partnerColumns+`
+		FROM partners
+		WHERE id = $1
+		FOR UPDATE
+	`, partnerID))
+	if err != nil {
+		return nil, err
+	}
+
+	next := *current
+	changes := make(map[string]fieldChange)
+	if update.Name != nil && *update.Name != current.Name {
+		next.Name = *update.Name
+		changes["name"] = fieldChange{From: current.Name, To: next.Name}
+	}
+	if update.Plan != nil && *update.Plan != current.Plan {
+		next.Plan = *update.Plan
+		changes["plan"] = fieldChange{From: current.Plan, To: next.Plan}
+	}
+	if update.RouterBps != nil && *update.RouterBps != current.RouterBps {
+		next.RouterBps = *update.RouterBps
+		changes["router_bps"] = fieldChange{From: current.RouterBps, To: next.RouterBps}
+	}
+	if update.Status != nil && *update.Status != current.Status {
+		next.Status = *update.Status
+		changes["status"] = fieldChange{From: current.Status, To: next.Status}
+	}
+	if err := validatePartner(&next); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return current, nil
+	}
+
+	updated, err := scanPartner(tx.QueryRowContext(ctx, `
+		UPDATE partners
+		SET name = $2, plan = $3, router_bps = $4, status = $5
+		WHERE id = $1
+		RETURNING `+partnerColumns+`
+	`, partnerID, next.Name, next.Plan, next.RouterBps, next.Status))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertAudit(ctx, tx, actor, AuditPartnerUpdate, partnerID, nil, changes); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// ListAPIKeys returns all of the partner's keys, revoked and expired
+// included, newest first
+func (s *PostgresStore) ListAPIKeys(ctx context.Context, partnerID uuid.UUID) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE partner_id = $1
+		ORDER BY created_at DESC
+	`, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// ListAuditEvents returns the partner's most recent audit records, newest first
+func (s *PostgresStore) ListAuditEvents(ctx context.Context, partnerID uuid.UUID, limit int) ([]*AuditEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, actor, action, partner_id, api_key_id, changes, created_at
+		FROM partner_audit
+		WHERE partner_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+	`, partnerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		var changes []byte
+		if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.PartnerID, &e.APIKeyID, &changes, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		e.Changes = json.RawMessage(changes)
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// insertAudit appends a partner_audit record inside the caller's transaction,
+// so the change and its record commit or roll back together
+func insertAudit(ctx context.Context, tx *sql.Tx, actor, action string, partnerID uuid.UUID, keyID *uuid.UUID, changes map[string]fieldChange) error {
+	if actor == "" {
+		return ErrMissingActor
+	}
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO partner_audit (actor, action, partner_id, api_key_id, changes)
+		VALUES ($1, $2, $3, $4, $5)
+	`, actor, action, partnerID, keyID, encoded)
+	return err
+}
+
+const certificateColumns = `id, partner_id, fingerprint, COALESCE(label, ''), created_at, revoked, revoked_at`
+
+func scanCertificate(row rowScanner) (*PartnerCertificate, error) {
+	var c PartnerCertificate
+	err := row.Scan(&c.ID, &c.PartnerID, &c.Fingerprint, &c.Label, &c.CreatedAt, &c.Revoked, &c.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetPartnerCertificate looks up a client certificate binding by
+// fingerprint. Revoked bindings are returned too; callers check Revoked.
+func (s *PostgresStore) GetPartnerCertificate(ctx context.Context, fingerprint string) (*PartnerCertificate, error) {
+	return scanCertificate(s.db.QueryRowContext(ctx, `
+		SELECT `+certificateColumns+`
+		FROM partner_certificates
+		WHERE fingerprint = $1
+	`, fingerprint))
+}
+
+// ListPartnerCertificates returns all of the partner's certificate
+// bindings, revoked included, newest first
+func (s *PostgresStore) ListPartnerCertificates(ctx context.Context, partnerID uuid.UUID) ([]*PartnerCertificate, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+certificateColumns+`
+		FROM partner_certificates
+		WHERE partner_id = $1
+		ORDER BY created_at DESC
+	`, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var certs []*PartnerCertificate
+	for rows.Next() {
+		c, err := scanCertificate(rows)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	return certs, rows.Err()
+}
+
+// BindCertificate binds a client certificate fingerprint to the partner
+func (s *PostgresStore) BindCertificate(ctx context.Context, actor string, partnerID uuid.UUID, fingerprint, label string) (*PartnerCertificate, error) {
+	fingerprint, err := NormalizeFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cert, err := scanCertificate(tx.QueryRowContext(ctx, `
+		INSERT INTO partner_certificates (partner_id, fingerprint, label)
+		VALUES ($1, $2, $3)
+		RETURNING `+certificateColumns+`
+	`, partnerID, fingerprint, label))
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicateCertificate
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertAudit(ctx, tx, actor, AuditCertBind, partnerID, nil, map[string]fieldChange{
+		"certificate_id": {To: cert.ID},
+		"fingerprint":    {To: cert.Fingerprint},
+		"label":          {To: cert.Label},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// RevokeCertificate stops a certificate from authenticating the partner.
+// Revoking twice is a no-op and is not audited again.
+func (s *PostgresStore) RevokeCertificate(ctx context.Context, actor string, partnerID, certID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var fingerprint string
+	var revoked bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT fingerprint, revoked
+		FROM partner_certificates
+		WHERE id = $1 AND partner_id = $2
+		FOR UPDATE
+	`, certID, partnerID).Scan(&fingerprint, &revoked)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE partner_certificates
+		SET revoked = true, revoked_at = now()
+		WHERE id = $1
+	`, certID); err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, actor, AuditCertRevoke, partnerID, nil, map[string]fieldChange{
+		"certificate_id": {To: certID},
+		"fingerprint":    {To: fingerprint},
+		"revoked":        {From: false, To: true},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,451 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type auditCall struct {
+	actor  string
+	action string
+}
+
+type mockAdminStore struct {
+	partners map[uuid.UUID]*Partner
+	keys     map[uuid.UUID]*APIKey
+	certs    map[uuid.UUID]*PartnerCertificate
+	audit    []auditCall
+	invoices map[string]*Invoice // by partner ID and month
+	indexed  time.Time
+}
+
+func newMockAdminStore() *mockAdminStore {
+	return &mockAdminStore{
+		partners: make(map[uuid.UUID]*Partner),
+		keys:     make(map[uuid.UUID]*APIKey),
+		certs:    make(map[uuid.UUID]*PartnerCertificate),
+		invoices: make(map[string]*Invoice),
+	}
+}
+
+func (m *mockAdminStore) ListPartners(ctx context.Context) ([]*Partner, error) {
+	var partners []*Partner
+	for _, p := range m.partners {
+		partners = append(partners, p)
+	}
+	return partners, nil
+}
+
+func (m *mockAdminStore) GetPartnerByID(ctx context.Context, partnerID uuid.UUID) (*Partner, error) {
+	if p, ok := m.partners[partnerID]; ok {
+		return p, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockAdminStore) CreatePartner(ctx context.Context, actor, name, plan string, routerBps int, status string) (*Partner, error) {
+	if status == "" {
+		status = "active"
+	}
+	p := &Partner{ID: uuid.New(), Name: name, Plan: plan, RouterBps: routerBps, Status: status}
+	if err := validatePartner(p); err != nil {
+		return nil, err
+	}
+	m.partners[p.ID] = p
+	m.audit = append(m.audit, auditCall{actor, AuditPartnerCreate})
+	return p, nil
+}
+
+func (m *mockAdminStore) UpdatePartner(ctx context.Context, actor string, partnerID uuid.UUID, update PartnerUpdate) (*Partner, error) {
+	p, ok := m.partners[partnerID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	next := *p
+	if update.Plan != nil {
+		next.Plan = *update.Plan
+	}
+	if update.RouterBps != nil {
+		next.RouterBps = *update.RouterBps
+	}
+	if update.Status != nil {
+		next.Status = *update.Status
+	}
+	if err := validatePartner(&next); err != nil {
+		return nil, err
+	}
+	m.partners[partnerID] = &next
+	m.audit = append(m.audit, auditCall{actor, AuditPartnerUpdate})
+	return &next, nil
+}
+
+func (m *mockAdminStore) ListAPIKeys(ctx context.Context, partnerID uuid.UUID) ([]*APIKey, error) {
+	var keys []*APIKey
+	for _, k := range m.keys {
+		if k.PartnerID == partnerID {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+func (m *mockAdminStore) CreateAPIKey(ctx context.Context, actor string, partnerID uuid.UUID, publicKey, label string, scopes []string) (*APIKey, error) {
+	if err := validatePublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+	k := &APIKey{ID: uuid.New(), PartnerID: partnerID, PublicKey: publicKey, Label: label, Scopes: scopes}
+	m.keys[k.ID] = k
+	m.audit = append(m.audit, auditCall{actor, AuditKeyCreate})
+	return k, nil
+}
+
+func (m *mockAdminStore) RotateAPIKey(ctx context.Context, actor string, partnerID, oldKeyID uuid.UUID, publicKey, label string, scopes []string, overlap time.Duration) (*APIKey, error) {
+	old, ok := m.keys[oldKeyID]
+	if !ok || old.PartnerID != partnerID {
+		return nil, sql.ErrNoRows
+	}
+	notAfter := time.Now().Add(overlap)
+	old.NotAfter = &notAfter
+	if scopes == nil {
+		scopes = old.Scopes
+	}
+	k, err := m.CreateAPIKey(ctx, actor, partnerID, publicKey, label, scopes)
+	if err != nil {
+		return nil, err
+	}
+	m.audit[len(m.audit)-1].action = AuditKeyRotate
+	return k, nil
+}
+
+func (m *mockAdminStore) RevokeAPIKey(ctx context.Context, actor string, partnerID, keyID uuid.UUID) error {
+	k, ok := m.keys[keyID]
+	if !ok || k.PartnerID != partnerID {
+		return sql.ErrNoRows
+	}
+	k.Revoked = true
+	m.audit = append(m.audit, auditCall{actor, AuditKeyRevoke})
+	return nil
+}
+
+func (m *mockAdminStore) ListPartnerCertificates(ctx context.Context, partnerID uuid.UUID) ([]*PartnerCertificate, error) {
+	var certs []*PartnerCertificate
+	for _, c := range m.certs {
+		if c.PartnerID == partnerID {
+			certs = append(certs, c)
+		}
+	}
+	return certs, nil
+}
+
+func (m *mockAdminStore) BindCertificate(ctx context.Context, actor string, partnerID uuid.UUID, fingerprint, label string) (*PartnerCertificate, error) {
+	fingerprint, err := NormalizeFingerprint(fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range m.certs {
+		if c.Fingerprint == fingerprint {
+			return nil, ErrDuplicateCertificate
+		}
+	}
+	c := &PartnerCertificate{ID: uuid.New(), PartnerID: partnerID, Fingerprint: fingerprint, Label: label}
+	m.certs[c.ID] = c
+	m.audit = append(m.audit, auditCall{actor, AuditCertBind})
+	return c, nil
+}
+
+func (m *mockAdminStore) RevokeCertificate(ctx context.Context, actor string, partnerID, certID uuid.UUID) error {
+	c, ok := m.certs[certID]
+	if !ok || c.PartnerID != partnerID {
+		return sql.ErrNoRows
+	}
+	c.Revoked = true
+	m.audit = append(m.audit, auditCall{actor, AuditCertRevoke})
+	return nil
+}
+
+func (m *mockAdminStore) GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error) {
+	return &UsageResponse{From: q.From, To: q.To}, nil
+}
+
+func (m *mockAdminStore) StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error {
+	return nil
+}
+
+func (m *mockAdminStore) ListInvoices(ctx context.Context, partnerID uuid.UUID, limit int) ([]*Invoice, error) {
+	invoices := []*Invoice{}
+	for _, inv := range m.invoices {
+		if inv.PartnerID == partnerID {
+			invoices = append(invoices, inv)
+		}
+	}
+	return invoices, nil
+}
+
+func (m *mockAdminStore) GetInvoice(ctx context.Context, partnerID uuid.UUID, month time.Time) (*Invoice, error) {
+	if inv, ok := m.invoices[partnerID.String()+month.Format("2006-01")]; ok {
+		return inv, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockAdminStore) ListBillablePartners(ctx context.Context, from, to time.Time) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	for id, p := range m.partners {
+		if p.Status == "active" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (m *mockAdminStore) CloseInvoice(ctx context.Context, partnerID uuid.UUID, from, to time.Time) (*Invoice, bool, error) {
+	key := partnerID.String() + from.Format("2006-01")
+	if inv, ok := m.invoices[key]; ok {
+		return inv, false, nil
+	}
+	p := m.partners[partnerID]
+	inv := BuildInvoice(partnerID, from, to, BillingTerms{PartnerName: p.Name, Plan: p.Plan}, nil)
+	inv.ID = uuid.New()
+	m.invoices[key] = inv
+	return inv, true, nil
+}
+
+func (m *mockAdminStore) IndexedThrough(ctx context.Context) (time.Time, error) {
+	return m.indexed, nil
+}
+
+func (m *mockAdminStore) ListAuditEvents(ctx context.Context, partnerID uuid.UUID, limit int) ([]*AuditEvent, error) {
+	return nil, nil
+}
+
+func adminRequest(t *testing.T, h http.Handler, method, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	req.Header.Set("X-Internal-Token", "secret")
+	req.Header.Set("X-Admin-Actor", "alice")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminHandlers_Auth(t *testing.T) {
+	h := NewAdminHandlers(newMockAdminStore(), "secret").Handler()
+
+	tests := []struct {
+		name    string
+		token   string
+		actor   string
+		cert    string
+		want    int
+		wantErr string
+	}{
+		{name: "no credentials", want: http.StatusUnauthorized},
+		{name: "wrong token", token: "guess", actor: "alice", want: http.StatusUnauthorized},
+		{name: "token without actor", token: "secret", want: http.StatusBadRequest, wantErr: "X-Admin-Actor"},
+		{name: "token with actor", token: "secret", actor: "alice", want: http.StatusOK},
+		{name: "client certificate", cert: "ops-admin", want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/admin/v1/partners", nil)
+			if tt.token != "" {
+				req.Header.Set("X-Internal-Token", tt.token)
+			}
+			if tt.actor != "" {
+				req.Header.Set("X-Admin-Actor", tt.actor)
+			}
+			if tt.cert != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.cert}}
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d (%s)", w.Code, tt.want, w.Body.String())
+			}
+			if tt.wantErr != "" && !strings.Contains(w.Body.String(), tt.wantErr) {
+				t.Errorf("body = %s, want %q", w.Body.String(), tt.wantErr)
+			}
+		})
+	}
+
+	// An empty token disables token auth rather than matching an empty header
+	open := NewAdminHandlers(newMockAdminStore(), "").Handler()
+	req := httptest.NewRequest(http.MethodGet, "/admin/v1/partners", nil)
+	req.Header.Set("X-Admin-Actor", "alice")
+	w := httptest.NewRecorder()
+	open.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("empty token status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestAdminHandlers_PartnerLifecycle(t *testing.T) {
+	store := newMockAdminStore()
+	h := NewAdminHandlers(store, "secret").Handler()
+
+	w := adminRequest(t, h, http.MethodPost, "/admin/v1/partners", CreatePartnerRequest{Name: "Wallet", Plan: "pro"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", w.Code, w.Body.String())
+	}
+	var partner Partner
+	json.NewDecoder(w.Body).Decode(&partner)
+	if partner.RouterBps != defaultRouterBps || partner.Status != "active" {
+		t.Errorf("created partner = %+v, want default router_bps and active", partner)
+	}
+
+	if w := adminRequest(t, h, http.MethodPost, "/admin/v1/partners", CreatePartnerRequest{Name: "Wallet", Plan: "gold"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid plan status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	path := "/admin/v1/partners/" + partner.ID.String()
+	w = adminRequest(t, h, http.MethodPatch, path, map[string]any{"status": "suspended", "router_bps": 15})
+	if w.Code != http.StatusOK {
+		t.Fatalf("suspend status = %d: %s", w.Code, w.Body.String())
+	}
+	if p := store.partners[partner.ID]; p.Status != "suspended" || p.RouterBps != 15 {
+		t.Errorf("updated partner = %+v, want suspended at 15 bps", p)
+	}
+
+	if w := adminRequest(t, h, http.MethodGet, "/admin/v1/partners/"+uuid.NewString(), nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown partner status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if w := adminRequest(t, h, http.MethodGet, "/admin/v1/partners/not-a-uuid", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("malformed partner status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	want := []auditCall{{"alice", AuditPartnerCreate}, {"alice", AuditPartnerUpdate}}
+	if len(store.audit) != len(want) || store.audit[0] != want[0] || store.audit[1] != want[1] {
+		t.Errorf("audit = %v, want %v", store.audit, want)
+	}
+}
+
+func TestAdminHandlers_KeyLifecycle(t *testing.T) {
+	store := newMockAdminStore()
+	h := NewAdminHandlers(store, "secret").Handler()
+	partner, _ := store.CreatePartner(context.Background(), "setup", "Wallet", "pro", 20, "")
+	keysPath := "/admin/v1/partners/" + partner.ID.String() + "/keys"
+
+	w := adminRequest(t, h, http.MethodPost, keysPath, CreateAPIKeyRequest{PublicKey: strings.Repeat("ab", 32), Label: "prod", Scopes: []string{"usage", "pairs"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create key status = %d: %s", w.Code, w.Body.String())
+	}
+	var key APIKey
+	json.NewDecoder(w.Body).Decode(&key)
+
+	if len(key.Scopes) != 2 || key.Scopes[0] != ScopePairs || key.Scopes[1] != ScopeUsage {
+		t.Errorf("key scopes = %v, want [pairs usage]", key.Scopes)
+	}
+
+	if w := adminRequest(t, h, http.MethodPost, keysPath, CreateAPIKeyRequest{PublicKey: "abcd"}); w.Code != http.StatusBadRequest {
+		t.Errorf("short key status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := adminRequest(t, h, http.MethodPost, keysPath, CreateAPIKeyRequest{PublicKey: strings.Repeat("ef", 32), Scopes: []string{"admin"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown scope status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := adminRequest(t, h, http.MethodPost, "/admin/v1/partners/"+uuid.NewString()+"/keys", CreateAPIKeyRequest{PublicKey: strings.Repeat("cd", 32)}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown partner key status = %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	rotatePath := keysPath + "/" + key.ID.String() + "/rotate"
+	if w := adminRequest(t, h, http.MethodPost, rotatePath, CreateAPIKeyRequest{PublicKey: strings.Repeat("cd", 32), Overlap: "soon"}); w.Code != http.StatusBadRequest {
+		t.Errorf("bad overlap status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w = adminRequest(t, h, http.MethodPost, rotatePath, CreateAPIKeyRequest{PublicKey: strings.Repeat("cd", 32), Overlap: "24h"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("rotate status = %d: %s", w.Code, w.Body.String())
+	}
+	if na := store.keys[key.ID].NotAfter; na == nil || time.Until(*na) > 25*time.Hour {
+		t.Errorf("old key not_after = %v, want about 24h out", na)
+	}
+	var rotated APIKey
+	json.NewDecoder(w.Body).Decode(&rotated)
+	if len(rotated.Scopes) != 2 {
+		t.Errorf("rotated key scopes = %v, want the old key's", rotated.Scopes)
+	}
+
+	w = adminRequest(t, h, http.MethodDelete, keysPath+"/"+key.ID.String(), nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d: %s", w.Code, w.Body.String())
+	}
+	if !store.keys[key.ID].Revoked {
+		t.Error("key not revoked")
+	}
+
+	w = adminRequest(t, h, http.MethodGet, keysPath, nil)
+	var keys APIKeysResponse
+	json.NewDecoder(w.Body).Decode(&keys)
+	if len(keys.Keys) != 2 {
+		t.Errorf("listed %d keys, want 2", len(keys.Keys))
+	}
+
+	actions := make([]string, len(store.audit))
+	for i, a := range store.audit {
+		actions[i] = a.action
+	}
+	want := AuditPartnerCreate + "," + AuditKeyCreate + "," + AuditKeyRotate + "," + AuditKeyRevoke
+	if got := strings.Join(actions, ","); got != want {
+		t.Errorf("audit actions = %s, want %s", got, want)
+	}
+}
+
+func TestAdminHandlers_Certificates(t *testing.T) {
+	store := newMockAdminStore()
+	h := NewAdminHandlers(store, "secret").Handler()
+	partner, _ := store.CreatePartner(context.Background(), "setup", "Wallet", "enterprise", 20, "")
+	certsPath := "/admin/v1/partners/" + partner.ID.String() + "/certificates"
+
+	// Colon separated, upper case fingerprints are normalized
+	fingerprint := strings.Repeat("AB:", 31) + "AB"
+	w := adminRequest(t, h, http.MethodPost, certsPath, BindCertificateRequest{Fingerprint: fingerprint, Label: "prod"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("bind status = %d: %s", w.Code, w.Body.String())
+	}
+	var cert PartnerCertificate
+	json.NewDecoder(w.Body).Decode(&cert)
+	if cert.Fingerprint != strings.Repeat("ab", 32) {
+		t.Errorf("fingerprint = %s, want normalized", cert.Fingerprint)
+	}
+
+	if w := adminRequest(t, h, http.MethodPost, certsPath, BindCertificateRequest{Fingerprint: fingerprint}); w.Code != http.StatusConflict {
+		t.Errorf("duplicate bind status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := adminRequest(t, h, http.MethodPost, certsPath, BindCertificateRequest{Fingerprint: "abcd"}); w.Code != http.StatusBadRequest {
+		t.Errorf("short fingerprint status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if w := adminRequest(t, h, http.MethodPost, certsPath, BindCertificateRequest{Certificate: "not a certificate"}); w.Code != http.StatusBadRequest {
+		t.Errorf("bad PEM status = %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	w = adminRequest(t, h, http.MethodDelete, certsPath+"/"+cert.ID.String(), nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("revoke status = %d: %s", w.Code, w.Body.String())
+	}
+	if !store.certs[cert.ID].Revoked {
+		t.Error("certificate not revoked")
+	}
+}
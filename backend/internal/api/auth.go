@@ -8,6 +8,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -16,6 +17,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/lucendex/backend/internal/kv"
 )
 
 var (
@@ -25,26 +28,85 @@ var (
 	ErrInvalidPartner     = errors.New("invalid partner")
 	ErrInvalidSignature   = errors.New("invalid signature")
 	ErrPartnerSuspended   = errors.New("partner account suspended")
+	ErrInvalidAPIKey      = errors.New("unknown, expired or revoked api key")
+	ErrInvalidClientCert  = errors.New("client certificate not bound to this partner")
+	ErrSignatureRequired  = errors.New("request signature required for this plan")
 )
 
+// maxClockDrift is how far X-Timestamp may be from the server clock
+const maxClockDrift = 60 * time.Second
+
 type AuthMiddleware struct {
-	db DB
+	db     DB
+	replay ReplayStore
+
+	// certOnlyPlans may authenticate with a bound client certificate alone
+	certOnlyPlans map[string]bool
+
+	// auditRequestIDs also records accepted request IDs in Postgres, off
+	// the request path
+	auditRequestIDs bool
+
+	// routeScopes maps request paths to the API key scope they require
+	routeScopes map[string]string
+}
+
+// ReplayStore claims request IDs so each is accepted once. kv.Store
+// satisfies it.
+type ReplayStore interface {
+	SetNX(namespace, key string, value []byte, ttl time.Duration) (bool, error)
 }
 
 type DB interface {
 	GetPartnerByID(ctx context.Context, partnerID uuid.UUID) (*Partner, error)
 	GetAPIKeyByPublicKey(ctx context.Context, publicKey string) (*APIKey, error)
-	GetActiveAPIKey(ctx context.Context, partnerID uuid.UUID) (*APIKey, error)
-	CheckRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID) (bool, error)
+	GetAPIKey(ctx context.Context, partnerID, keyID uuid.UUID) (*APIKey, error)
+	GetActiveAPIKeys(ctx context.Context, partnerID uuid.UUID) ([]*APIKey, error)
+	GetPartnerCertificate(ctx context.Context, fingerprint string) (*PartnerCertificate, error)
 	StoreRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID, expiresAt time.Time) error
-	GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, month string) (*UsageResponse, error)
+	GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error)
+	StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error
+	ListInvoices(ctx context.Context, partnerID uuid.UUID, limit int) ([]*Invoice, error)
+	GetInvoice(ctx context.Context, partnerID uuid.UUID, month time.Time) (*Invoice, error)
+	GetOrderbook(ctx context.Context, base, quote string, limit int) (*BookSnapshot, error)
 	StoreQuoteRegistry(ctx context.Context, registry *QuoteRegistry) error
 	GetIndexerLag(ctx context.Context) (int, error)
 	UpdateNetworkLedger(ctx context.Context, ledger uint32) error
 }
 
-func NewAuthMiddleware(db DB) *AuthMiddleware {
-	return &AuthMiddleware{db: db}
+func NewAuthMiddleware(db DB, replay ReplayStore) *AuthMiddleware {
+	return &AuthMiddleware{
+		db:            db,
+		replay:        replay,
+		certOnlyPlans: make(map[string]bool),
+		routeScopes:   DefaultRouteScopes(),
+	}
+}
+
+// SetRouteScope makes path require scope from the signing key, or lifts the
+// requirement when scope is empty
+func (am *AuthMiddleware) SetRouteScope(path, scope string) {
+	if scope == "" {
+		delete(am.routeScopes, path)
+		return
+	}
+	am.routeScopes[path] = scope
+}
+
+// SetRequestIDAudit enables writing accepted request IDs to request_ids as
+// an audit trail. Replay protection never reads them back.
+func (am *AuthMiddleware) SetRequestIDAudit(enabled bool) {
+	am.auditRequestIDs = enabled
+}
+
+// SetCertificateOnlyPlans lets partners on these plans skip Ed25519 request
+// signing when they present a bound client certificate. Other partners with
+// a bound certificate must sign as well.
+func (am *AuthMiddleware) SetCertificateOnlyPlans(plans ...string) {
+	am.certOnlyPlans = make(map[string]bool, len(plans))
+	for _, plan := range plans {
+		am.certOnlyPlans[plan] = true
+	}
 }
 
 func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
@@ -56,8 +118,28 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		requestIDStr := r.Header.Get("X-Request-Id")
 		timestamp := r.Header.Get("X-Timestamp")
 		signature := r.Header.Get("X-Signature")
+		keyIDStr := r.Header.Get("X-Key-Id")
+
+		// A client certificate verified against the partner CA must be bound
+		// to a partner; it then identifies the partner without X-Partner-Id
+		var cert *PartnerCertificate
+		if fingerprint := clientCertFingerprint(r); fingerprint != "" {
+			var err error
+			cert, err = am.db.GetPartnerCertificate(ctx, fingerprint)
+			if err == sql.ErrNoRows || (err == nil && cert.Revoked) {
+				writeError(w, http.StatusUnauthorized, ErrInvalidClientCert.Error())
+				return
+			}
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "authentication error")
+				return
+			}
+			if partnerIDStr == "" {
+				partnerIDStr = cert.PartnerID.String()
+			}
+		}
 
-		if partnerIDStr == "" || requestIDStr == "" || timestamp == "" || signature == "" {
+		if partnerIDStr == "" || requestIDStr == "" || timestamp == "" || (signature == "" && cert == nil) {
 			writeError(w, http.StatusUnauthorized, ErrMissingAuthHeaders.Error())
 			return
 		}
@@ -68,6 +150,10 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			writeError(w, http.StatusUnauthorized, "invalid partner-id format")
 			return
 		}
+		if cert != nil && cert.PartnerID != partnerID {
+			writeError(w, http.StatusUnauthorized, ErrInvalidClientCert.Error())
+			return
+		}
 
 		requestID, err := uuid.Parse(requestIDStr)
 		if err != nil {
@@ -75,6 +161,16 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// X-Key-Id is optional so clients that predate key rotation keep working
+		var keyID uuid.UUID
+		if keyIDStr != "" {
+			keyID, err = uuid.Parse(keyIDStr)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, "invalid key-id format")
+				return
+			}
+		}
+
 		// Validate timestamp (60 second drift max)
 		ts, err := time.Parse(time.RFC3339, timestamp)
 		if err != nil {
@@ -86,22 +182,11 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		if drift < 0 {
 			drift = -drift
 		}
-		if drift > 60*time.Second {
+		if drift > maxClockDrift {
 			writeError(w, http.StatusUnauthorized, ErrInvalidTimestamp.Error())
 			return
 		}
 
-		// Check for replay attack
-		exists, err := am.db.CheckRequestID(ctx, requestID, partnerID)
-		if err != nil {
-			writeError(w, http.StatusInternalServerError, "authentication error")
-			return
-		}
-		if exists {
-			writeError(w, http.StatusUnauthorized, ErrReplayAttack.Error())
-			return
-		}
-
 		// Load partner
 		partner, err := am.db.GetPartnerByID(ctx, partnerID)
 		if err != nil {
@@ -119,75 +204,165 @@ func (am *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Enforce max body size and hash body
-		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			writeError(w, http.StatusBadRequest, "failed to read request body")
+		// Without a signature the bound certificate is the only credential
+		if signature == "" && !am.certOnlyPlans[partner.Plan] {
+			writeError(w, http.StatusUnauthorized, ErrSignatureRequired.Error())
 			return
 		}
-		r.Body = io.NopCloser(bytes.NewReader(body))
 
-		bodyHash := sha256.Sum256(body)
+		// Enforce max body size
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
 
-		// Build canonical request
-		canonical := fmt.Sprintf("%s\n%s\n%s\n%x\n%s",
-			r.Method,
-			r.URL.Path,
-			r.URL.RawQuery,
-			bodyHash,
-			timestamp,
-		)
+		var apiKey *APIKey
+		if signature != "" {
+			// Hash body
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
 
-		// Get API key
-		apiKey, err := am.db.GetActiveAPIKey(ctx, partnerID)
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, ErrInvalidPartner.Error())
-			return
-		}
+			bodyHash := sha256.Sum256(body)
 
-		// Verify signature
-		sigBytes, err := base64.StdEncoding.DecodeString(signature)
-		if err != nil {
-			writeError(w, http.StatusUnauthorized, "invalid signature encoding")
-			return
-		}
+			// Build canonical request
+			canonical := fmt.Sprintf("%s\n%s\n%s\n%x\n%s",
+				r.Method,
+				r.URL.Path,
+				r.URL.RawQuery,
+				bodyHash,
+				timestamp,
+			)
 
-		pubKeyBytes, err := hex.DecodeString(apiKey.PublicKey)
-		if err != nil {
-			log.Printf("invalid public key for partner %s: %v", partnerID, err)
-			writeError(w, http.StatusInternalServerError, "authentication error")
-			return
-		}
+			// Verify signature
+			sigBytes, err := base64.StdEncoding.DecodeString(signature)
+			if err != nil {
+				writeError(w, http.StatusUnauthorized, "invalid signature encoding")
+				return
+			}
 
-		if len(pubKeyBytes) != ed25519.PublicKeySize {
-			log.Printf("invalid public key size for partner %s", partnerID)
-			writeError(w, http.StatusInternalServerError, "authentication error")
-			return
+			if keyID != uuid.Nil {
+				apiKey, err = am.db.GetAPIKey(ctx, partnerID, keyID)
+				if err == sql.ErrNoRows || (err == nil && !apiKey.Active(time.Now())) {
+					writeError(w, http.StatusUnauthorized, ErrInvalidAPIKey.Error())
+					return
+				}
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, "authentication error")
+					return
+				}
+
+				ok, err := verifySignature(apiKey, []byte(canonical), sigBytes)
+				if err != nil {
+					log.Printf("api key %s for partner %s: %v", keyID, partnerID, err)
+					writeError(w, http.StatusInternalServerError, "authentication error")
+					return
+				}
+				if !ok {
+					writeError(w, http.StatusUnauthorized, ErrInvalidSignature.Error())
+					return
+				}
+			} else {
+				// Without a key ID, any of the partner's active keys may have signed
+				keys, err := am.db.GetActiveAPIKeys(ctx, partnerID)
+				if err != nil {
+					writeError(w, http.StatusInternalServerError, "authentication error")
+					return
+				}
+				if len(keys) == 0 {
+					writeError(w, http.StatusUnauthorized, ErrInvalidPartner.Error())
+					return
+				}
+
+				for _, k := range keys {
+					ok, err := verifySignature(k, []byte(canonical), sigBytes)
+					if err != nil {
+						log.Printf("api key %s for partner %s: %v", k.ID, partnerID, err)
+						continue
+					}
+					if ok {
+						apiKey = k
+						break
+					}
+				}
+				if apiKey == nil {
+					writeError(w, http.StatusUnauthorized, ErrInvalidSignature.Error())
+					return
+				}
+			}
 		}
 
-		if !ed25519.Verify(pubKeyBytes, []byte(canonical), sigBytes) {
-			writeError(w, http.StatusUnauthorized, ErrInvalidSignature.Error())
+		// Scopes restrict keys only; a certificate alone carries none
+		if scope, ok := am.routeScopes[r.URL.Path]; ok && apiKey != nil && !apiKey.HasScope(scope) {
+			writeErrorResponse(w, http.StatusForbidden, ErrorResponse{
+				Error:   ErrInsufficientScope.Error(),
+				Code:    "insufficient_scope",
+				Details: "requires scope " + scope,
+			})
 			return
 		}
 
-		// Store request ID (expires in 2 minutes)
-		expiresAt := time.Now().Add(2 * time.Minute)
-		if err := am.db.StoreRequestID(ctx, requestID, partnerID, expiresAt); err != nil {
+		// Claim the request ID once the request is authentic, so unsigned
+		// traffic can't burn a partner's IDs. It only has to outlive the
+		// window in which its timestamp would still be accepted.
+		expiresAt := ts.Add(maxClockDrift)
+		claimed, err := am.replay.SetNX(kv.NamespaceRequestIDs, partnerID.String()+":"+requestID.String(), nil, time.Until(expiresAt)+time.Second)
+		if err != nil {
+			log.Printf("request id claim for partner %s: %v", partnerID, err)
 			writeError(w, http.StatusInternalServerError, "failed to process request")
 			return
 		}
+		if !claimed {
+			writeError(w, http.StatusUnauthorized, ErrReplayAttack.Error())
+			return
+		}
+		if am.auditRequestIDs {
+			go am.auditRequestID(requestID, partnerID, expiresAt)
+		}
 
 		// Add partner context
 		ctx = context.WithValue(ctx, ContextKeyPartnerID, partnerID)
 		ctx = context.WithValue(ctx, ContextKeyPartner, partner)
+		if apiKey != nil {
+			ctx = context.WithValue(ctx, ContextKeyAPIKeyID, apiKey.ID)
+		}
+		if cert != nil {
+			ctx = context.WithValue(ctx, ContextKeyCertID, cert.ID)
+		}
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+func (am *AuthMiddleware) auditRequestID(requestID, partnerID uuid.UUID, expiresAt time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := am.db.StoreRequestID(ctx, requestID, partnerID, expiresAt); err != nil {
+		log.Printf("request id audit for partner %s: %v", partnerID, err)
+	}
+}
+
+// verifySignature checks sig over message with the key's Ed25519 public key.
+// An error means the stored key itself is malformed.
+func verifySignature(key *APIKey, message, sig []byte) (bool, error) {
+	pubKeyBytes, err := hex.DecodeString(key.PublicKey)
+	if err != nil {
+		return false, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(pubKeyBytes) != ed25519.PublicKeySize {
+		return false, fmt.Errorf("invalid public key size %d", len(pubKeyBytes))
+	}
+	return ed25519.Verify(pubKeyBytes, message, sig), nil
+}
+
 func writeError(w http.ResponseWriter, status int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
 	fmt.Fprintf(w, `{"error":"%s"}`, message)
 }
+
+func writeErrorResponse(w http.ResponseWriter, status int, resp ErrorResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
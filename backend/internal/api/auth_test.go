@@ -6,22 +6,38 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/lucendex/backend/internal/kv"
 )
 
 type mockDB struct {
 	partner   *Partner
 	apiKey    *APIKey
-	requestID map[string]bool
+	apiKeys   []*APIKey
+	certs     []*PartnerCertificate
+	book      *BookSnapshot
+	usage     []UsageDetail     // served by the usage methods
+	usageQ    *UsageQueryParams // last usage query
+	invoices  []*Invoice        // newest first
+	requestID map[string]bool // audited request IDs
+	quotes    int             // quote registry writes
 	err       error
+
+	mu sync.Mutex
 }
 
 func (m *mockDB) GetPartnerByID(ctx context.Context, partnerID uuid.UUID) (*Partner, error) {
@@ -38,21 +54,53 @@ func (m *mockDB) GetAPIKeyByPublicKey(ctx context.Context, publicKey string) (*A
 	return m.apiKey, nil
 }
 
-func (m *mockDB) GetActiveAPIKey(ctx context.Context, partnerID uuid.UUID) (*APIKey, error) {
+func (m *mockDB) keys() []*APIKey {
+	if m.apiKey == nil {
+		return m.apiKeys
+	}
+	return append([]*APIKey{m.apiKey}, m.apiKeys...)
+}
+
+func (m *mockDB) GetAPIKey(ctx context.Context, partnerID, keyID uuid.UUID) (*APIKey, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
-	return m.apiKey, nil
+	for _, k := range m.keys() {
+		if k.ID == keyID && k.PartnerID == partnerID {
+			return k, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockDB) GetActiveAPIKeys(ctx context.Context, partnerID uuid.UUID) ([]*APIKey, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var active []*APIKey
+	for _, k := range m.keys() {
+		if k.PartnerID == partnerID && k.Active(time.Now()) {
+			active = append(active, k)
+		}
+	}
+	return active, nil
 }
 
-func (m *mockDB) CheckRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID) (bool, error) {
+func (m *mockDB) GetPartnerCertificate(ctx context.Context, fingerprint string) (*PartnerCertificate, error) {
 	if m.err != nil {
-		return false, m.err
+		return nil, m.err
 	}
-	return m.requestID[requestID.String()], nil
+	for _, c := range m.certs {
+		if c.Fingerprint == fingerprint {
+			return c, nil
+		}
+	}
+	return nil, sql.ErrNoRows
 }
 
 func (m *mockDB) StoreRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	if m.requestID == nil {
 		m.requestID = make(map[string]bool)
 	}
@@ -60,11 +108,72 @@ func (m *mockDB) StoreRequestID(ctx context.Context, requestID uuid.UUID, partne
 	return m.err
 }
 
-func (m *mockDB) GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, month string) (*UsageResponse, error) {
-	return &UsageResponse{}, m.err
+func (m *mockDB) auditedRequestID(requestID uuid.UUID) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requestID[requestID.String()]
+}
+
+// newReplayStore returns an in-memory KV for request ID claims
+func newReplayStore(t *testing.T) *kv.MemoryStore {
+	t.Helper()
+	store := kv.NewMemoryStore()
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func (m *mockDB) GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error) {
+	m.usageQ = &q
+	resp := &UsageResponse{
+		From:           q.From,
+		To:             q.To,
+		TxCount:        int64(len(m.usage)),
+		Totals:         []UsageTotal{{Asset: "XRP", VolumeIn: "1", VolumeOut: "0", Fees: "0", VolumeInUSD: "2"}},
+		TotalVolumeUSD: "2",
+		Details:        m.usage,
+	}
+	if len(m.usage) > q.Limit {
+		resp.Details = m.usage[:q.Limit]
+		resp.NextCursor = UsageCursor{Timestamp: m.usage[q.Limit-1].Timestamp, ID: int64(q.Limit)}.Encode()
+	}
+	return resp, m.err
+}
+
+func (m *mockDB) StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error {
+	m.usageQ = &q
+	for i := range m.usage {
+		if err := fn(&m.usage[i]); err != nil {
+			return err
+		}
+	}
+	return m.err
+}
+
+func (m *mockDB) ListInvoices(ctx context.Context, partnerID uuid.UUID, limit int) ([]*Invoice, error) {
+	if len(m.invoices) > limit {
+		return m.invoices[:limit], m.err
+	}
+	return m.invoices, m.err
+}
+
+func (m *mockDB) GetInvoice(ctx context.Context, partnerID uuid.UUID, month time.Time) (*Invoice, error) {
+	for _, inv := range m.invoices {
+		if inv.PeriodStart.Equal(month) {
+			return inv, m.err
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (m *mockDB) GetOrderbook(ctx context.Context, base, quote string, limit int) (*BookSnapshot, error) {
+	if m.book == nil {
+		return &BookSnapshot{}, m.err
+	}
+	return m.book, m.err
 }
 
 func (m *mockDB) StoreQuoteRegistry(ctx context.Context, registry *QuoteRegistry) error {
+	m.quotes++
 	return m.err
 }
 
@@ -112,7 +221,7 @@ func TestAuthMiddleware_ValidSignature(t *testing.T) {
 		requestID: make(map[string]bool),
 	}
 
-	auth := NewAuthMiddleware(db)
+	auth := NewAuthMiddleware(db, newReplayStore(t))
 
 	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -169,7 +278,7 @@ func TestAuthMiddleware_InvalidSignature(t *testing.T) {
 		requestID: make(map[string]bool),
 	}
 
-	auth := NewAuthMiddleware(db)
+	auth := NewAuthMiddleware(db, newReplayStore(t))
 
 	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -223,7 +332,7 @@ func TestAuthMiddleware_ExpiredTimestamp(t *testing.T) {
 		requestID: make(map[string]bool),
 	}
 
-	auth := NewAuthMiddleware(db)
+	auth := NewAuthMiddleware(db, newReplayStore(t))
 
 	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -274,28 +383,71 @@ func TestAuthMiddleware_ReplayAttack(t *testing.T) {
 			PartnerID: partnerID,
 			PublicKey: hex.EncodeToString(pubKey),
 		},
-		requestID: map[string]bool{
-			requestID.String(): true,
-		},
 	}
 
-	auth := NewAuthMiddleware(db)
+	auth := NewAuthMiddleware(db, newReplayStore(t))
+	auth.SetRequestIDAudit(true)
 
 	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	}))
 
-	req := httptest.NewRequest("POST", "/partner/v1/quote", bytes.NewReader(body))
-	req.Header.Set("X-Partner-Id", partnerID.String())
-	req.Header.Set("X-Request-Id", requestID.String())
-	req.Header.Set("X-Timestamp", timestamp)
-	req.Header.Set("X-Signature", signatureB64)
+	send := func() int {
+		req := httptest.NewRequest("POST", "/partner/v1/quote", bytes.NewReader(body))
+		req.Header.Set("X-Partner-Id", partnerID.String())
+		req.Header.Set("X-Request-Id", requestID.String())
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Signature", signatureB64)
 
-	rec := httptest.NewRecorder()
-	handler.ServeHTTP(rec, req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send(); code != http.StatusOK {
+		t.Fatalf("expected status 200 for first request, got %d", code)
+	}
+	if code := send(); code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for replay attack, got %d", code)
+	}
+
+	// The audit trail is written off the request path
+	deadline := time.Now().Add(time.Second)
+	for !db.auditedRequestID(requestID) {
+		if time.Now().After(deadline) {
+			t.Fatal("request id not written to the audit trail")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
 
+func TestAuthMiddleware_ReplayOnlyAfterAuthentication(t *testing.T) {
+	partnerID := uuid.New()
+	key, priv := newTestKey(t, partnerID)
+	_, wrongPriv := newTestKey(t, partnerID)
+
+	db := &mockDB{partner: &Partner{ID: partnerID, Status: "active"}, apiKeys: []*APIKey{key}}
+	handler := NewAuthMiddleware(db, newReplayStore(t)).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// A forged request must not consume the request ID of the genuine one
+	forged := signedRequest(t, wrongPriv, partnerID, "")
+	genuine := signedRequest(t, priv, partnerID, "")
+	genuine.Header.Set("X-Request-Id", forged.Header.Get("X-Request-Id"))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, forged)
 	if rec.Code != http.StatusUnauthorized {
-		t.Errorf("expected status 401 for replay attack, got %d", rec.Code)
+		t.Fatalf("expected status 401 for forged request, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, genuine)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200 for genuine request, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if db.auditedRequestID(uuid.MustParse(genuine.Header.Get("X-Request-Id"))) {
+		t.Error("request id audited without SetRequestIDAudit")
 	}
 }
 
@@ -333,7 +485,7 @@ func TestAuthMiddleware_SuspendedPartner(t *testing.T) {
 		requestID: make(map[string]bool),
 	}
 
-	auth := NewAuthMiddleware(db)
+	auth := NewAuthMiddleware(db, newReplayStore(t))
 
 	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -352,3 +504,264 @@ func TestAuthMiddleware_SuspendedPartner(t *testing.T) {
 		t.Errorf("expected status 403 for suspended partner, got %d", rec.Code)
 	}
 }
+
+func signedRequest(t *testing.T, privKey ed25519.PrivateKey, partnerID uuid.UUID, keyID string) *http.Request {
+	t.Helper()
+	return signedRequestTo(t, privKey, partnerID, keyID, "POST", "/partner/v1/quote")
+}
+
+func signedRequestTo(t *testing.T, privKey ed25519.PrivateKey, partnerID uuid.UUID, keyID, method, path string) *http.Request {
+	t.Helper()
+
+	timestamp := time.Now().Format(time.RFC3339)
+	body := []byte(`{"test":"data"}`)
+	bodyHash := sha256.Sum256(body)
+	canonical := fmt.Sprintf("%s\n%s\n\n%x\n%s", method, path, bodyHash, timestamp)
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("X-Partner-Id", partnerID.String())
+	req.Header.Set("X-Request-Id", uuid.New().String())
+	req.Header.Set("X-Timestamp", timestamp)
+	req.Header.Set("X-Signature", base64.StdEncoding.EncodeToString(ed25519.Sign(privKey, []byte(canonical))))
+	if keyID != "" {
+		req.Header.Set("X-Key-Id", keyID)
+	}
+	return req
+}
+
+func newTestKey(t *testing.T, partnerID uuid.UUID) (*APIKey, ed25519.PrivateKey) {
+	t.Helper()
+
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate keys: %v", err)
+	}
+	return &APIKey{ID: uuid.New(), PartnerID: partnerID, PublicKey: hex.EncodeToString(pubKey)}, privKey
+}
+
+func TestAuthMiddleware_KeyRotation(t *testing.T) {
+	partnerID := uuid.New()
+	oldKey, oldPriv := newTestKey(t, partnerID)
+	newKey, newPriv := newTestKey(t, partnerID)
+
+	// The old key is inside its overlap window
+	notAfter := time.Now().Add(time.Hour)
+	oldKey.NotAfter = &notAfter
+
+	db := &mockDB{
+		partner: &Partner{ID: partnerID, Status: "active"},
+		apiKeys: []*APIKey{newKey, oldKey},
+	}
+
+	var gotKeyID uuid.UUID
+	handler := NewAuthMiddleware(db, newReplayStore(t)).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKeyID, _ = r.Context().Value(ContextKeyAPIKeyID).(uuid.UUID)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name    string
+		priv    ed25519.PrivateKey
+		keyID   string
+		want    int
+		wantKey uuid.UUID
+	}{
+		{"new key by id", newPriv, newKey.ID.String(), http.StatusOK, newKey.ID},
+		{"old key by id during overlap", oldPriv, oldKey.ID.String(), http.StatusOK, oldKey.ID},
+		{"old key without id", oldPriv, "", http.StatusOK, oldKey.ID},
+		{"signed by the other key", oldPriv, newKey.ID.String(), http.StatusUnauthorized, uuid.Nil},
+		{"unknown key id", newPriv, uuid.New().String(), http.StatusUnauthorized, uuid.Nil},
+		{"malformed key id", newPriv, "key-1", http.StatusUnauthorized, uuid.Nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKeyID = uuid.Nil
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, signedRequest(t, tt.priv, partnerID, tt.keyID))
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if gotKeyID != tt.wantKey {
+				t.Errorf("context key id = %s, want %s", gotKeyID, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware_InactiveKeys(t *testing.T) {
+	partnerID := uuid.New()
+	expiredKey, expiredPriv := newTestKey(t, partnerID)
+	revokedKey, revokedPriv := newTestKey(t, partnerID)
+	otherKey, otherPriv := newTestKey(t, uuid.New())
+
+	notAfter := time.Now().Add(-time.Minute)
+	expiredKey.NotAfter = &notAfter
+	revokedAt := time.Now().Add(-time.Hour)
+	revokedKey.Revoked = true
+	revokedKey.RevokedAt = &revokedAt
+
+	db := &mockDB{
+		partner: &Partner{ID: partnerID, Status: "active"},
+		apiKeys: []*APIKey{expiredKey, revokedKey, otherKey},
+	}
+	handler := NewAuthMiddleware(db, newReplayStore(t)).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name  string
+		priv  ed25519.PrivateKey
+		keyID string
+	}{
+		{"expired overlap window", expiredPriv, expiredKey.ID.String()},
+		{"revoked", revokedPriv, revokedKey.ID.String()},
+		{"revoked without id", revokedPriv, ""},
+		{"another partner's key", otherPriv, otherKey.ID.String()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, signedRequest(t, tt.priv, partnerID, tt.keyID))
+
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("expected status 401, got %d", rec.Code)
+			}
+		})
+	}
+}
+
+// withClientCert marks req as arriving over TLS with a client certificate
+// the listener verified
+func TestAuthMiddleware_Scopes(t *testing.T) {
+	partnerID := uuid.New()
+	readKey, readPriv := newTestKey(t, partnerID)
+	quoteKey, quotePriv := newTestKey(t, partnerID)
+	legacyKey, legacyPriv := newTestKey(t, partnerID)
+	readKey.Scopes = []string{ScopePairs, ScopeUsage}
+	quoteKey.Scopes = []string{ScopeQuote}
+
+	db := &mockDB{
+		partner: &Partner{ID: partnerID, Status: "active"},
+		apiKeys: []*APIKey{readKey, quoteKey, legacyKey},
+	}
+	handler := NewAuthMiddleware(db, newReplayStore(t)).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name   string
+		priv   ed25519.PrivateKey
+		keyID  string
+		method string
+		path   string
+		want   int
+	}{
+		{"read key on usage", readPriv, readKey.ID.String(), "GET", "/partner/v1/usage", http.StatusOK},
+		{"read key on pairs", readPriv, "", "GET", "/partner/v1/pairs", http.StatusOK},
+		{"read key on quote", readPriv, readKey.ID.String(), "POST", "/partner/v1/quote", http.StatusForbidden},
+		{"read key on quote without key id", readPriv, "", "POST", "/partner/v1/quote", http.StatusForbidden},
+		{"quote key on quote", quotePriv, quoteKey.ID.String(), "POST", "/partner/v1/quote", http.StatusOK},
+		{"quote key on usage", quotePriv, quoteKey.ID.String(), "GET", "/partner/v1/usage", http.StatusForbidden},
+		{"quote key on health", quotePriv, quoteKey.ID.String(), "GET", "/partner/v1/health", http.StatusOK},
+		{"unscoped key on quote", legacyPriv, legacyKey.ID.String(), "POST", "/partner/v1/quote", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, signedRequestTo(t, tt.priv, partnerID, tt.keyID, tt.method, tt.path))
+
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if tt.want == http.StatusForbidden && !strings.Contains(rec.Body.String(), `"code":"insufficient_scope"`) {
+				t.Errorf("body = %s, want code insufficient_scope", rec.Body.String())
+			}
+		})
+	}
+}
+
+func withClientCert(req *http.Request, cert *x509.Certificate) *http.Request {
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return req
+}
+
+func TestAuthMiddleware_ClientCertificate(t *testing.T) {
+	proID, entID := uuid.New(), uuid.New()
+	proKey, proPriv := newTestKey(t, proID)
+
+	boundCert := &x509.Certificate{Raw: []byte("pro partner certificate")}
+	entCert := &x509.Certificate{Raw: []byte("enterprise partner certificate")}
+	revokedCert := &x509.Certificate{Raw: []byte("revoked certificate")}
+	unboundCert := &x509.Certificate{Raw: []byte("unbound certificate")}
+
+	partners := map[uuid.UUID]*Partner{
+		proID: {ID: proID, Plan: "pro", Status: "active"},
+		entID: {ID: entID, Plan: "enterprise", Status: "active"},
+	}
+	db := &mockDB{
+		apiKeys: []*APIKey{proKey},
+		certs: []*PartnerCertificate{
+			{ID: uuid.New(), PartnerID: proID, Fingerprint: CertificateFingerprint(boundCert)},
+			{ID: uuid.New(), PartnerID: entID, Fingerprint: CertificateFingerprint(entCert)},
+			{ID: uuid.New(), PartnerID: entID, Fingerprint: CertificateFingerprint(revokedCert), Revoked: true},
+		},
+	}
+
+	auth := NewAuthMiddleware(partnerLookup{db, partners}, newReplayStore(t))
+	auth.SetCertificateOnlyPlans("enterprise")
+
+	var gotPartner uuid.UUID
+	handler := auth.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPartner, _ = r.Context().Value(ContextKeyPartnerID).(uuid.UUID)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	certOnly := func(cert *x509.Certificate) *http.Request {
+		req := httptest.NewRequest("POST", "/partner/v1/quote", bytes.NewReader([]byte(`{}`)))
+		req.Header.Set("X-Request-Id", uuid.New().String())
+		req.Header.Set("X-Timestamp", time.Now().Format(time.RFC3339))
+		return withClientCert(req, cert)
+	}
+
+	tests := []struct {
+		name        string
+		req         *http.Request
+		want        int
+		wantPartner uuid.UUID
+	}{
+		{"signed with bound certificate", withClientCert(signedRequest(t, proPriv, proID, ""), boundCert), http.StatusOK, proID},
+		{"signed with unbound certificate", withClientCert(signedRequest(t, proPriv, proID, ""), unboundCert), http.StatusUnauthorized, uuid.Nil},
+		{"signed with another partner's certificate", withClientCert(signedRequest(t, proPriv, proID, ""), entCert), http.StatusUnauthorized, uuid.Nil},
+		{"certificate only on enterprise plan", certOnly(entCert), http.StatusOK, entID},
+		{"certificate only on pro plan", certOnly(boundCert), http.StatusUnauthorized, uuid.Nil},
+		{"revoked certificate", certOnly(revokedCert), http.StatusUnauthorized, uuid.Nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotPartner = uuid.Nil
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d: %s", tt.want, rec.Code, rec.Body.String())
+			}
+			if gotPartner != tt.wantPartner {
+				t.Errorf("context partner = %s, want %s", gotPartner, tt.wantPartner)
+			}
+		})
+	}
+}
+
+// partnerLookup serves several partners from one mockDB
+type partnerLookup struct {
+	*mockDB
+	partners map[uuid.UUID]*Partner
+}
+
+func (p partnerLookup) GetPartnerByID(ctx context.Context, partnerID uuid.UUID) (*Partner, error) {
+	if partner, ok := p.partners[partnerID]; ok {
+		return partner, nil
+	}
+	return nil, sql.ErrNoRows
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Invoice listing limits
+const (
+	DefaultInvoiceLimit = 12
+	MaxInvoiceLimit     = 120
+)
+
+// DefaultBillingCloseDelay is the least time after a month ends before the
+// billing loop closes it. It also waits for the indexer to pass the boundary.
+const DefaultBillingCloseDelay = time.Hour
+
+// BillingActor is the actor logged for months closed by the billing loop
+const BillingActor = "billing-loop"
+
+// invoiceUSDPlaces rounds invoice amounts to cents
+const invoiceUSDPlaces = 2
+
+// ErrMonthOpen is returned when closing a month that hasn't ended
+var ErrMonthOpen = errors.New("month has not ended")
+
+// invoiceStore is what serveInvoices reads, shared by the partner and admin
+// APIs. GetInvoice returns sql.ErrNoRows for a month that isn't closed.
+type invoiceStore interface {
+	ListInvoices(ctx context.Context, partnerID uuid.UUID, limit int) ([]*Invoice, error)
+	GetInvoice(ctx context.Context, partnerID uuid.UUID, month time.Time) (*Invoice, error)
+}
+
+// BillingStore is what closing a month needs. CloseInvoice writes the
+// partner's invoice for [from, to) unless one exists, and reports whether
+// it did; an existing invoice is returned unchanged. IndexedThrough is the
+// close time of the latest ledger the indexer has checkpointed.
+type BillingStore interface {
+	ListBillablePartners(ctx context.Context, from, to time.Time) ([]uuid.UUID, error)
+	CloseInvoice(ctx context.Context, partnerID uuid.UUID, from, to time.Time) (*Invoice, bool, error)
+	IndexedThrough(ctx context.Context) (time.Time, error)
+}
+
+// CloseResult is one partner's outcome of closing a month
+type CloseResult struct {
+	PartnerID uuid.UUID `json:"partner_id"`
+	InvoiceID uuid.UUID `json:"invoice_id"`
+	TotalUSD  string    `json:"total_usd"`
+	Created   bool      `json:"created"` // false when the month was already closed
+}
+
+type CloseMonthRequest struct {
+	Month string `json:"month"` // YYYY-MM
+}
+
+type CloseMonthResponse struct {
+	Month    string        `json:"month"`
+	Invoices []CloseResult `json:"invoices"`
+}
+
+// CloseMonth writes an invoice for every billable partner for the month
+// starting at month. It is idempotent: partners already invoiced keep their
+// invoice, so a run that failed part way can simply be repeated.
+func CloseMonth(ctx context.Context, store BillingStore, month, now time.Time) ([]CloseResult, error) {
+	from := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	if now.Before(to) {
+		return nil, ErrMonthOpen
+	}
+
+	partners, err := store.ListBillablePartners(ctx, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("list billable partners: %w", err)
+	}
+
+	results := make([]CloseResult, 0, len(partners))
+	for _, partnerID := range partners {
+		inv, created, err := store.CloseInvoice(ctx, partnerID, from, to)
+		if err != nil {
+			return results, fmt.Errorf("close %s for partner %s: %w", from.Format("2006-01"), partnerID, err)
+		}
+		results = append(results, CloseResult{PartnerID: partnerID, InvoiceID: inv.ID, TotalUSD: inv.TotalUSD, Created: created})
+	}
+	return results, nil
+}
+
+// StartBillingLoop closes the previous month once closeIndexedMonth allows,
+// checking hourly. Months missed while no loop was running must be closed
+// through the admin API.
+func StartBillingLoop(ctx context.Context, store BillingStore, delay time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	var closed time.Time
+	for {
+		now := time.Now().UTC()
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+		if !month.Equal(closed) {
+			done, err := closeIndexedMonth(ctx, store, month, delay, now)
+			if err != nil {
+				log.Printf("billing close of %s failed: %v", month.Format("2006-01"), err)
+			} else if done {
+				closed = month
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIndexedMonth closes month once it has been over for delay and the
+// indexer has checkpointed a ledger that closed after it, so no trade from
+// the month is still waiting to be recorded. It reports whether it closed.
+func closeIndexedMonth(ctx context.Context, store BillingStore, month time.Time, delay time.Duration, now time.Time) (bool, error) {
+	end := month.AddDate(0, 1, 0)
+	if now.Sub(end) < delay {
+		return false, nil
+	}
+
+	indexed, err := store.IndexedThrough(ctx)
+	if err != nil {
+		return false, fmt.Errorf("read indexer checkpoint: %w", err)
+	}
+	if indexed.Before(end) {
+		log.Printf("billing close of %s waiting for the indexer, at %s", month.Format("2006-01"), indexed.UTC().Format(time.RFC3339))
+		return false, nil
+	}
+
+	results, err := CloseMonth(ctx, store, month, now)
+	if err != nil {
+		return false, err
+	}
+	logClosedMonth(BillingActor, month, results)
+	return true, nil
+}
+
+func logClosedMonth(actor string, month time.Time, results []CloseResult) {
+	created := 0
+	for _, r := range results {
+		if r.Created {
+			created++
+		}
+	}
+	log.Printf("%s closed %s: %d invoice(s) written, %d already closed", actor, month.Format("2006-01"), created, len(results)-created)
+}
+
+// BuildInvoice prices a period's fees under terms. Each line is rounded to
+// cents and the subtotal is the sum of the lines, so the statement adds up.
+// The discount applies to the subtotal; if what remains is below the plan
+// minimum, an adjustment brings the total up to it.
+func BuildInvoice(partnerID uuid.UUID, from, to time.Time, terms BillingTerms, fees []AssetFees) *Invoice {
+	inv := &Invoice{
+		PartnerID:   partnerID,
+		PartnerName: terms.PartnerName,
+		Month:       from.Format("2006-01"),
+		PeriodStart: from,
+		PeriodEnd:   to,
+		Plan:        terms.Plan,
+		MinimumUSD:  terms.MinimumUSD.StringFixed(invoiceUSDPlaces),
+		DiscountBps: terms.DiscountBps,
+		Lines:       []InvoiceLine{},
+	}
+
+	subtotal := decimal.Zero
+	for _, f := range fees {
+		scale := assetScale(f.Asset)
+		feesUSD := f.FeesUSD.Round(invoiceUSDPlaces)
+		line := InvoiceLine{
+			Asset:         f.Asset,
+			TxCount:       f.TxCount,
+			Fees:          f.Fees.Shift(-scale).String(),
+			PricedFees:    f.PricedFees.Shift(-scale).String(),
+			FeesUSD:       feesUSD.StringFixed(invoiceUSDPlaces),
+			UnpricedCount: f.UnpricedCount,
+		}
+		if f.PricedFees.IsPositive() {
+			line.USDRate = f.FeesUSD.Div(f.PricedFees.Shift(-scale)).Round(8).String()
+		}
+		inv.Lines = append(inv.Lines, line)
+		inv.TxCount += f.TxCount
+		inv.UnpricedCount += f.UnpricedCount
+		subtotal = subtotal.Add(feesUSD)
+	}
+
+	discount := subtotal.Mul(decimal.NewFromInt(int64(terms.DiscountBps))).Div(decimal.NewFromInt(10000)).Round(invoiceUSDPlaces)
+	net := subtotal.Sub(discount)
+	adjustment := decimal.Zero
+	if minimum := terms.MinimumUSD.Round(invoiceUSDPlaces); net.LessThan(minimum) {
+		adjustment = minimum.Sub(net)
+	}
+
+	inv.SubtotalUSD = subtotal.StringFixed(invoiceUSDPlaces)
+	inv.DiscountUSD = discount.StringFixed(invoiceUSDPlaces)
+	inv.MinimumAdjustmentUSD = adjustment.StringFixed(invoiceUSDPlaces)
+	inv.TotalUSD = net.Add(adjustment).StringFixed(invoiceUSDPlaces)
+	return inv
+}
+
+// RenderStatement formats an invoice as a plain text statement
+func RenderStatement(inv *Invoice) string {
+	var b strings.Builder
+	rule := strings.Repeat("-", 96)
+
+	fmt.Fprintf(&b, "LUCENDEX ROUTING FEE STATEMENT\n\n")
+	fmt.Fprintf(&b, "Invoice   %s\n", inv.ID)
+	fmt.Fprintf(&b, "Partner   %s (%s)\n", inv.PartnerName, inv.PartnerID)
+	fmt.Fprintf(&b, "Period    %s to %s\n", inv.PeriodStart.Format("2006-01-02"), inv.PeriodEnd.AddDate(0, 0, -1).Format("2006-01-02"))
+	fmt.Fprintf(&b, "Plan      %s\n", inv.Plan)
+	fmt.Fprintf(&b, "Closed    %s\n\n", inv.ClosedAt.UTC().Format(time.RFC3339))
+
+	fmt.Fprintf(&b, "%-40s %8s %18s %14s %12s\n", "ASSET", "TRADES", "FEES", "USD RATE", "FEES USD")
+	b.WriteString(rule + "\n")
+	for _, l := range inv.Lines {
+		rate := l.USDRate
+		if rate == "" {
+			rate = "-"
+		}
+		fmt.Fprintf(&b, "%-40s %8d %18s %14s %12s\n", l.Asset, l.TxCount, l.Fees, rate, l.FeesUSD)
+	}
+	if len(inv.Lines) == 0 {
+		b.WriteString("No routed trades this period\n")
+	}
+	b.WriteString(rule + "\n")
+
+	total := func(label, amount string) {
+		fmt.Fprintf(&b, "%-83s %12s\n", label, amount)
+	}
+	total("Subtotal", inv.SubtotalUSD)
+	if inv.DiscountBps > 0 {
+		total(fmt.Sprintf("Discount (%s%%)", decimal.New(int64(inv.DiscountBps), -2).String()), "-"+inv.DiscountUSD)
+	}
+	if adj, _ := decimal.NewFromString(inv.MinimumAdjustmentUSD); adj.IsPositive() {
+		total("Plan minimum adjustment (minimum "+inv.MinimumUSD+" USD)", inv.MinimumAdjustmentUSD)
+	}
+	total("Total due (USD)", inv.TotalUSD)
+
+	if inv.UnpricedCount > 0 {
+		fmt.Fprintf(&b, "\n%d trade(s) had no USD reference price. Their fees are listed above but\n"+
+			"not included in the USD total; they are settled in the asset itself.\n", inv.UnpricedCount)
+	}
+	return b.String()
+}
+
+// InvoicesHandler handles GET /partner/v1/invoices
+func (h *Handlers) InvoicesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	partnerID := r.Context().Value(ContextKeyPartnerID).(uuid.UUID)
+	serveInvoices(w, r, h.db, partnerID)
+}
+
+// serveInvoices lists partnerID's closed invoices, newest first, or with
+// month=YYYY-MM returns that month's invoice, as a text statement with
+// format=text
+func serveInvoices(w http.ResponseWriter, r *http.Request, store invoiceStore, partnerID uuid.UUID) {
+	v := r.URL.Query()
+	format := v.Get("format")
+	if format != "" && format != "json" && format != "text" {
+		writeError(w, http.StatusBadRequest, "format must be json or text")
+		return
+	}
+
+	if m := v.Get("month"); m != "" {
+		month, err := time.Parse("2006-01", m)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid month format (use YYYY-MM)")
+			return
+		}
+		inv, err := store.GetInvoice(r.Context(), partnerID, month)
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, http.StatusNotFound, "no invoice for "+m)
+			return
+		}
+		if err != nil {
+			log.Printf("invoice %s for %s: %v", m, partnerID, err)
+			writeError(w, http.StatusInternalServerError, "failed to fetch invoice")
+			return
+		}
+		if format == "text" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(RenderStatement(inv)))
+			return
+		}
+		writeJSON(w, http.StatusOK, inv)
+		return
+	}
+
+	if format == "text" {
+		writeError(w, http.StatusBadRequest, "format=text needs month")
+		return
+	}
+	limit := DefaultInvoiceLimit
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > MaxInvoiceLimit {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxInvoiceLimit))
+			return
+		}
+		limit = n
+	}
+
+	invoices, err := store.ListInvoices(r.Context(), partnerID, limit)
+	if err != nil {
+		log.Printf("invoices for %s: %v", partnerID, err)
+		writeError(w, http.StatusInternalServerError, "failed to fetch invoices")
+		return
+	}
+	writeJSON(w, http.StatusOK, InvoicesResponse{Invoices: invoices})
+}
@@ -0,0 +1,291 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const invoiceColumns = `id, partner_id, partner_name, period_start, period_end, plan, minimum_usd::TEXT, discount_bps,
+	tx_count, unpriced_count, subtotal_usd::TEXT, discount_usd::TEXT, minimum_adjustment_usd::TEXT, total_usd::TEXT, closed_at`
+
+const invoiceLineColumns = `invoice_id, asset, tx_count, fees::TEXT, priced_fees::TEXT, COALESCE(usd_rate::TEXT, ''),
+	fees_usd::TEXT, unpriced_count`
+
+func scanInvoice(row rowScanner) (*Invoice, error) {
+	var inv Invoice
+	err := row.Scan(&inv.ID, &inv.PartnerID, &inv.PartnerName, &inv.PeriodStart, &inv.PeriodEnd, &inv.Plan,
+		&inv.MinimumUSD, &inv.DiscountBps, &inv.TxCount, &inv.UnpricedCount, &inv.SubtotalUSD, &inv.DiscountUSD,
+		&inv.MinimumAdjustmentUSD, &inv.TotalUSD, &inv.ClosedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.PeriodStart, inv.PeriodEnd = inv.PeriodStart.UTC(), inv.PeriodEnd.UTC()
+	inv.Month = inv.PeriodStart.Format("2006-01")
+	inv.Lines = []InvoiceLine{}
+	return &inv, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// attachInvoiceLines loads the lines of the partner's invoices starting on
+// or after since into invoices
+func attachInvoiceLines(ctx context.Context, q queryer, partnerID uuid.UUID, since time.Time, invoices []*Invoice) error {
+	byID := make(map[uuid.UUID]*Invoice, len(invoices))
+	for _, inv := range invoices {
+		byID[inv.ID] = inv
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT `+invoiceLineColumns+`
+		FROM invoice_line_items
+		WHERE invoice_id IN (SELECT id FROM invoices WHERE partner_id = $1 AND period_start >= $2)
+		ORDER BY asset
+	`, partnerID, since)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var invoiceID uuid.UUID
+		var l InvoiceLine
+		if err := rows.Scan(&invoiceID, &l.Asset, &l.TxCount, &l.Fees, &l.PricedFees, &l.USDRate, &l.FeesUSD, &l.UnpricedCount); err != nil {
+			return err
+		}
+		if inv, ok := byID[invoiceID]; ok {
+			inv.Lines = append(inv.Lines, l)
+		}
+	}
+	return rows.Err()
+}
+
+// ListInvoices returns the partner's latest invoices, newest first
+func (s *PostgresStore) ListInvoices(ctx context.Context, partnerID uuid.UUID, limit int) ([]*Invoice, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE partner_id = $1
+		ORDER BY period_start DESC
+		LIMIT $2
+	`, partnerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := []*Invoice{}
+	for rows.Next() {
+		inv, err := scanInvoice(rows)
+		if err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, inv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(invoices) == 0 {
+		return invoices, nil
+	}
+
+	oldest := invoices[len(invoices)-1].PeriodStart
+	if err := attachInvoiceLines(ctx, s.db, partnerID, oldest, invoices); err != nil {
+		return nil, err
+	}
+	return invoices, nil
+}
+
+// GetInvoice returns the partner's invoice for the month starting at month
+func (s *PostgresStore) GetInvoice(ctx context.Context, partnerID uuid.UUID, month time.Time) (*Invoice, error) {
+	return getInvoice(ctx, s.db, partnerID, month)
+}
+
+func getInvoice(ctx context.Context, q queryer, partnerID uuid.UUID, month time.Time) (*Invoice, error) {
+	inv, err := scanInvoice(q.QueryRowContext(ctx, `
+		SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE partner_id = $1 AND period_start = $2
+	`, partnerID, month))
+	if err != nil {
+		return nil, err
+	}
+	if err := attachInvoiceLines(ctx, q, partnerID, month, []*Invoice{inv}); err != nil {
+		return nil, err
+	}
+	return inv, nil
+}
+
+// ListBillablePartners returns the partners to invoice for [from, to):
+// those that existed before it ended and are active or had usage in it
+func (s *PostgresStore) ListBillablePartners(ctx context.Context, from, to time.Time) ([]uuid.UUID, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.id
+		FROM partners p
+		WHERE p.created_at < $2
+		  AND (p.status = 'active' OR EXISTS (
+		      SELECT 1 FROM usage_events ue
+		      WHERE ue.partner_id = p.id AND ue.ts >= $1 AND ue.ts < $2))
+		ORDER BY p.created_at, p.id
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// IndexedThrough returns the close time of the latest checkpointed ledger,
+// or the zero time before the indexer has written one
+func (s *PostgresStore) IndexedThrough(ctx context.Context) (time.Time, error) {
+	var closed sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT MAX(close_time_human)
+		FROM core.ledger_checkpoints
+	`).Scan(&closed)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return closed.Time, nil
+}
+
+// CloseInvoice writes the partner's invoice for [from, to) with its lines in
+// one transaction. If the month is already closed, by an earlier run or a
+// concurrent one, the existing invoice is returned and nothing is written.
+func (s *PostgresStore) CloseInvoice(ctx context.Context, partnerID uuid.UUID, from, to time.Time) (*Invoice, bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	existing, err := getInvoice(ctx, tx, partnerID, from)
+	if err == nil {
+		return existing, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+
+	var terms BillingTerms
+	var minimum string
+	err = tx.QueryRowContext(ctx, `
+		SELECT p.name, p.plan, COALESCE(b.minimum_usd, 0)::TEXT, COALESCE(b.discount_bps, 0)
+		FROM partners p
+		LEFT JOIN billing_plans b ON b.plan = p.plan
+		WHERE p.id = $1
+	`, partnerID).Scan(&terms.PartnerName, &terms.Plan, &minimum, &terms.DiscountBps)
+	if err != nil {
+		return nil, false, err
+	}
+	if terms.MinimumUSD, err = decimal.NewFromString(minimum); err != nil {
+		return nil, false, fmt.Errorf("plan minimum %q: %w", minimum, err)
+	}
+
+	fees, err := periodFees(ctx, tx, partnerID, from, to)
+	if err != nil {
+		return nil, false, err
+	}
+	inv := BuildInvoice(partnerID, from, to, terms, fees)
+
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO invoices
+			(partner_id, partner_name, period_start, period_end, plan, minimum_usd, discount_bps, tx_count,
+			 unpriced_count, subtotal_usd, discount_usd, minimum_adjustment_usd, total_usd)
+		VALUES ($1, $2, $3, $4, $5, $6::numeric, $7, $8, $9, $10::numeric, $11::numeric, $12::numeric, $13::numeric)
+		ON CONFLICT (partner_id, period_start) DO NOTHING
+		RETURNING id, closed_at
+	`, partnerID, inv.PartnerName, from, to, inv.Plan, inv.MinimumUSD, inv.DiscountBps, inv.TxCount,
+		inv.UnpricedCount, inv.SubtotalUSD, inv.DiscountUSD, inv.MinimumAdjustmentUSD, inv.TotalUSD).
+		Scan(&inv.ID, &inv.ClosedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Closed concurrently since we looked; theirs stands
+		tx.Rollback()
+		existing, err := s.GetInvoice(ctx, partnerID, from)
+		return existing, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, l := range inv.Lines {
+		var rate any
+		if l.USDRate != "" {
+			rate = l.USDRate
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO invoice_line_items
+				(invoice_id, asset, tx_count, fees, priced_fees, usd_rate, fees_usd, unpriced_count)
+			VALUES ($1, $2, $3, $4::numeric, $5::numeric, $6::numeric, $7::numeric, $8)
+		`, inv.ID, l.Asset, l.TxCount, l.Fees, l.PricedFees, rate, l.FeesUSD, l.UnpricedCount)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return inv, true, nil
+}
+
+// periodFees sums the partner's fees for [from, to) per fee asset. USD uses
+// the out price recorded when each trade was indexed. Usage is dated when it
+// was recorded, not by its ledger, so a row that arrives after its month was
+// closed lands in the month still open and is billed on the next invoice.
+func periodFees(ctx context.Context, q queryer, partnerID uuid.UUID, from, to time.Time) ([]AssetFees, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT out_asset, COUNT(*),
+		       SUM(fee_amount)::TEXT,
+		       COALESCE(SUM(fee_amount) FILTER (WHERE out_usd_price IS NOT NULL), 0)::TEXT,
+		       COALESCE(SUM(fee_amount * out_usd_price / CASE WHEN out_asset = 'XRP' THEN 1000000 ELSE 1 END)
+		                FILTER (WHERE out_usd_price IS NOT NULL), 0)::TEXT,
+		       COUNT(*) FILTER (WHERE out_usd_price IS NULL)
+		FROM usage_events
+		WHERE partner_id = $1 AND ts >= $2 AND ts < $3
+		GROUP BY out_asset
+		ORDER BY out_asset
+	`, partnerID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fees []AssetFees
+	for rows.Next() {
+		var f AssetFees
+		var total, priced, usd string
+		if err := rows.Scan(&f.Asset, &f.TxCount, &total, &priced, &usd, &f.UnpricedCount); err != nil {
+			return nil, err
+		}
+		if f.Fees, err = decimal.NewFromString(total); err != nil {
+			return nil, fmt.Errorf("fees for %s %q: %w", f.Asset, total, err)
+		}
+		if f.PricedFees, err = decimal.NewFromString(priced); err != nil {
+			return nil, fmt.Errorf("priced fees for %s %q: %w", f.Asset, priced, err)
+		}
+		if f.FeesUSD, err = decimal.NewFromString(usd); err != nil {
+			return nil, fmt.Errorf("USD fees for %s %q: %w", f.Asset, usd, err)
+		}
+		fees = append(fees, f)
+	}
+	return fees, rows.Err()
+}
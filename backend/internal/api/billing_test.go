@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+var testMonth = time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+
+func testFees() []AssetFees {
+	return []AssetFees{
+		// 0.24 XRP of fees, 0.2 of it priced at 2.5 USD
+		{Asset: "XRP", TxCount: 3, Fees: decimal.NewFromInt(240000), PricedFees: decimal.NewFromInt(200000),
+			FeesUSD: decimal.RequireFromString("0.5"), UnpricedCount: 1},
+		{Asset: "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B", TxCount: 2, Fees: decimal.RequireFromString("3.456"),
+			PricedFees: decimal.RequireFromString("3.456"), FeesUSD: decimal.RequireFromString("3.456")},
+	}
+}
+
+func TestBuildInvoice(t *testing.T) {
+	terms := BillingTerms{PartnerName: "Wallet", Plan: "pro", MinimumUSD: decimal.NewFromInt(10), DiscountBps: 1000}
+	inv := BuildInvoice(uuid.New(), testMonth, testMonth.AddDate(0, 1, 0), terms, testFees())
+
+	xrp := inv.Lines[0]
+	if xrp.Fees != "0.24" || xrp.PricedFees != "0.2" || xrp.USDRate != "2.5" || xrp.FeesUSD != "0.50" {
+		t.Errorf("XRP line = %+v", xrp)
+	}
+	if inv.Lines[1].FeesUSD != "3.46" {
+		t.Errorf("USD line fees = %s, want 3.46", inv.Lines[1].FeesUSD)
+	}
+	// 3.96 less 10% is 3.56, topped up to the 10.00 minimum
+	if inv.SubtotalUSD != "3.96" || inv.DiscountUSD != "0.40" || inv.MinimumAdjustmentUSD != "6.44" || inv.TotalUSD != "10.00" {
+		t.Errorf("totals = %s - %s + %s = %s", inv.SubtotalUSD, inv.DiscountUSD, inv.MinimumAdjustmentUSD, inv.TotalUSD)
+	}
+	if inv.TxCount != 5 || inv.UnpricedCount != 1 || inv.Month != "2025-11" {
+		t.Errorf("invoice = %+v", inv)
+	}
+
+	terms.MinimumUSD = decimal.NewFromInt(1)
+	if inv := BuildInvoice(uuid.New(), testMonth, testMonth.AddDate(0, 1, 0), terms, testFees()); inv.TotalUSD != "3.56" || inv.MinimumAdjustmentUSD != "0.00" {
+		t.Errorf("above minimum total = %s (adjustment %s), want 3.56", inv.TotalUSD, inv.MinimumAdjustmentUSD)
+	}
+}
+
+func TestRenderStatement(t *testing.T) {
+	terms := BillingTerms{PartnerName: "Wallet", Plan: "pro", MinimumUSD: decimal.NewFromInt(10), DiscountBps: 250}
+	inv := BuildInvoice(uuid.New(), testMonth, testMonth.AddDate(0, 1, 0), terms, testFees())
+	statement := RenderStatement(inv)
+
+	for _, want := range []string{
+		"Period    2025-11-01 to 2025-11-30",
+		"USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
+		"Discount (2.5%)",
+		"-0.10",
+		"Plan minimum adjustment (minimum 10.00 USD)",
+		"Total due (USD)",
+		"1 trade(s) had no USD reference price",
+	} {
+		if !strings.Contains(statement, want) {
+			t.Errorf("statement missing %q:\n%s", want, statement)
+		}
+	}
+}
+
+func TestCloseMonth(t *testing.T) {
+	store := newMockAdminStore()
+	for _, status := range []string{"active", "active", "suspended"} {
+		p := &Partner{ID: uuid.New(), Name: "p", Plan: "free", Status: status}
+		store.partners[p.ID] = p
+	}
+	ctx := context.Background()
+
+	if _, err := CloseMonth(ctx, store, testMonth, testMonth.AddDate(0, 0, 20)); !errors.Is(err, ErrMonthOpen) {
+		t.Errorf("closing an open month: err = %v, want ErrMonthOpen", err)
+	}
+
+	now := testMonth.AddDate(0, 1, 1)
+	first, err := CloseMonth(ctx, store, testMonth, now)
+	if err != nil {
+		t.Fatalf("CloseMonth() error = %v", err)
+	}
+	if len(first) != 2 || !first[0].Created || !first[1].Created {
+		t.Fatalf("first close = %+v, want two new invoices", first)
+	}
+
+	again, err := CloseMonth(ctx, store, testMonth, now)
+	if err != nil {
+		t.Fatalf("CloseMonth() again error = %v", err)
+	}
+	ids := map[uuid.UUID]bool{first[0].InvoiceID: true, first[1].InvoiceID: true}
+	for _, r := range again {
+		if r.Created || !ids[r.InvoiceID] {
+			t.Errorf("re-close = %+v, want the existing invoice unchanged", r)
+		}
+	}
+}
+
+func TestCloseIndexedMonth(t *testing.T) {
+	store := newMockAdminStore()
+	p := &Partner{ID: uuid.New(), Name: "p", Plan: "free", Status: "active"}
+	store.partners[p.ID] = p
+	ctx := context.Background()
+
+	end := testMonth.AddDate(0, 1, 0)
+	now := end.Add(2 * time.Hour)
+	store.indexed = end.Add(-time.Minute)
+	if done, err := closeIndexedMonth(ctx, store, testMonth, time.Hour, now); err != nil || done {
+		t.Errorf("indexer behind: closed = %t, %v; want to wait", done, err)
+	}
+	if len(store.invoices) != 0 {
+		t.Fatalf("invoices written while the indexer was behind: %d", len(store.invoices))
+	}
+
+	store.indexed = end.Add(time.Second)
+	if done, _ := closeIndexedMonth(ctx, store, testMonth, 3*time.Hour, now); done {
+		t.Error("closed before the delay had passed")
+	}
+	if done, err := closeIndexedMonth(ctx, store, testMonth, time.Hour, now); err != nil || !done {
+		t.Errorf("indexer past the boundary: closed = %t, %v", done, err)
+	}
+	if len(store.invoices) != 1 {
+		t.Errorf("invoices = %d, want 1", len(store.invoices))
+	}
+}
+
+func TestAdminCloseMonth(t *testing.T) {
+	store := newMockAdminStore()
+	p := &Partner{ID: uuid.New(), Name: "p", Plan: "free", Status: "active"}
+	store.partners[p.ID] = p
+	h := NewAdminHandlers(store, "secret").Handler()
+
+	w := adminRequest(t, h, http.MethodPost, "/admin/v1/billing/close", CloseMonthRequest{Month: "2025-11"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body.String())
+	}
+	var resp CloseMonthResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Invoices) != 1 || resp.Invoices[0].PartnerID != p.ID {
+		t.Errorf("response = %+v", resp)
+	}
+
+	next := time.Now().UTC().AddDate(0, 1, 0).Format("2006-01")
+	if w := adminRequest(t, h, http.MethodPost, "/admin/v1/billing/close", CloseMonthRequest{Month: next}); w.Code != http.StatusConflict {
+		t.Errorf("open month status = %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	w = adminRequest(t, h, http.MethodGet, "/admin/v1/partners/"+p.ID.String()+"/invoices?month=2025-11&format=text", nil)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Total due (USD)") {
+		t.Errorf("statement status = %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func invoicesRequest(t *testing.T, h *Handlers, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/partner/v1/invoices?"+query, nil)
+	req = req.WithContext(context.WithValue(req.Context(), ContextKeyPartnerID, uuid.New()))
+	rec := httptest.NewRecorder()
+	h.InvoicesHandler(rec, req)
+	return rec
+}
+
+func TestInvoicesHandler(t *testing.T) {
+	terms := BillingTerms{PartnerName: "Wallet", Plan: "pro"}
+	db := &mockDB{invoices: []*Invoice{
+		BuildInvoice(uuid.New(), testMonth, testMonth.AddDate(0, 1, 0), terms, testFees()),
+		BuildInvoice(uuid.New(), testMonth.AddDate(0, -1, 0), testMonth, terms, nil),
+	}}
+	h := newTestHandlers(t, db)
+
+	var list InvoicesResponse
+	json.NewDecoder(invoicesRequest(t, h, "limit=1").Body).Decode(&list)
+	if len(list.Invoices) != 1 || list.Invoices[0].Month != "2025-11" {
+		t.Errorf("list = %+v, want the newest invoice", list)
+	}
+
+	var inv Invoice
+	json.NewDecoder(invoicesRequest(t, h, "month=2025-10").Body).Decode(&inv)
+	if inv.Month != "2025-10" || inv.TotalUSD != "0.00" {
+		t.Errorf("invoice = %+v", inv)
+	}
+
+	rec := invoicesRequest(t, h, "month=2025-11&format=text")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") ||
+		!strings.Contains(rec.Body.String(), "LUCENDEX ROUTING FEE STATEMENT") {
+		t.Errorf("statement status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if rec := invoicesRequest(t, h, "month=2025-09"); rec.Code != http.StatusNotFound {
+		t.Errorf("unclosed month status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	for _, query := range []string{"month=2025-13", "format=text", "format=pdf", "limit=0"} {
+		if rec := invoicesRequest(t, h, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPostgresStore_CloseInvoice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	partnerID, invoiceID := uuid.New(), uuid.New()
+	from, to := testMonth, testMonth.AddDate(0, 1, 0)
+	closedAt := to.Add(time.Hour)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM invoices\s+WHERE partner_id = \$1 AND period_start = \$2`).
+		WithArgs(partnerID, from).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`LEFT JOIN billing_plans`).
+		WithArgs(partnerID).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "plan", "minimum_usd", "discount_bps"}).AddRow("Wallet", "pro", "0", 0))
+	mock.ExpectQuery(`GROUP BY out_asset`).
+		WithArgs(partnerID, from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"out_asset", "count", "fees", "priced", "usd", "unpriced"}).
+			AddRow("XRP", 2, "300000", "0", "0", 2))
+	mock.ExpectQuery(`INSERT INTO invoices .* ON CONFLICT \(partner_id, period_start\) DO NOTHING`).
+		WithArgs(partnerID, "Wallet", from, to, "pro", "0.00", 0, int64(2), int64(2), "0.00", "0.00", "0.00", "0.00").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "closed_at"}).AddRow(invoiceID, closedAt))
+	mock.ExpectExec(`INSERT INTO invoice_line_items`).
+		WithArgs(invoiceID, "XRP", int64(2), "0.3", "0", nil, "0.00", int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	store := NewPostgresStore(db)
+	inv, created, err := store.CloseInvoice(context.Background(), partnerID, from, to)
+	if err != nil {
+		t.Fatalf("CloseInvoice() error = %v", err)
+	}
+	if !created || inv.ID != invoiceID || inv.Lines[0].Fees != "0.3" {
+		t.Errorf("invoice = %+v (created %t)", inv, created)
+	}
+
+	// Closing again returns the stored invoice without writing
+	cols := []string{"id", "partner_id", "partner_name", "period_start", "period_end", "plan", "minimum_usd", "discount_bps",
+		"tx_count", "unpriced_count", "subtotal_usd", "discount_usd", "minimum_adjustment_usd", "total_usd", "closed_at"}
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM invoices\s+WHERE partner_id = \$1 AND period_start = \$2`).
+		WithArgs(partnerID, from).
+		WillReturnRows(sqlmock.NewRows(cols).
+			AddRow(invoiceID, partnerID, "Wallet", from, to, "pro", "0.00", 0, 2, 2, "0.00", "0.00", "0.00", "0.00", closedAt))
+	mock.ExpectQuery(`FROM invoice_line_items`).
+		WithArgs(partnerID, from).
+		WillReturnRows(sqlmock.NewRows([]string{"invoice_id", "asset", "tx_count", "fees", "priced_fees", "usd_rate", "fees_usd", "unpriced_count"}).
+			AddRow(invoiceID, "XRP", 2, "0.3", "0", "", "0.00", 2))
+	mock.ExpectRollback()
+
+	inv, created, err = store.CloseInvoice(context.Background(), partnerID, from, to)
+	if err != nil {
+		t.Fatalf("CloseInvoice() again error = %v", err)
+	}
+	if created || inv.ID != invoiceID || len(inv.Lines) != 1 || inv.Month != "2025-11" {
+		t.Errorf("re-close = %+v (created %t), want the stored invoice", inv, created)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 
+	"github.com/lucendex/backend/internal/address"
+	"github.com/lucendex/backend/internal/currency"
 	"github.com/lucendex/backend/internal/router"
 )
 
@@ -21,6 +25,7 @@ type Handlers struct {
 	db     DB
 	kv     KVStore
 	token  string
+	public PublicQuoteOptions
 }
 
 func NewHandlers(r *router.Router, db DB, kv KVStore, token string) *Handlers {
@@ -29,6 +34,7 @@ func NewHandlers(r *router.Router, db DB, kv KVStore, token string) *Handlers {
 		db:     db,
 		kv:     kv,
 		token:  token,
+		public: DefaultPublicQuoteOptions(),
 	}
 }
 
@@ -43,56 +49,14 @@ func (h *Handlers) QuoteHandler(w http.ResponseWriter, r *http.Request) {
 	partnerID := ctx.Value(ContextKeyPartnerID).(uuid.UUID)
 	partner := ctx.Value(ContextKeyPartner).(*Partner)
 
-	// Parse request
-	var req QuoteRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON")
-		return
-	}
-
-	// Validate input
-	if req.In == "" || req.Out == "" || req.Amount == "" {
-		writeError(w, http.StatusBadRequest, "missing required fields")
-		return
-	}
-
-	// Parse amount
-	amount, err := decimal.NewFromString(req.Amount)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid amount format")
-		return
-	}
-
-	if amount.LessThanOrEqual(decimal.Zero) {
-		writeError(w, http.StatusBadRequest, "amount must be positive")
+	routerReq, ok := parseQuoteRequest(w, r)
+	if !ok {
 		return
 	}
+	routerReq.PartnerID = partnerID.String()
 
-	// Convert to router types
-	inAsset, err := parseAsset(req.In)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-	outAsset, err := parseAsset(req.Out)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
-		return
-	}
-
-	routerReq := &router.QuoteRequest{
-		In:     inAsset,
-		Out:    outAsset,
-		Amount: amount,
-	}
-
-	// Get current ledger index
-	ledgerIndex := h.router.GetCurrentLedgerIndex()
-
-	// Generate quote
-	quote, err := h.router.GenerateQuote(ctx, routerReq, ledgerIndex)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+	quote, ok := h.generateQuote(ctx, w, routerReq)
+	if !ok {
 		return
 	}
 
@@ -110,7 +74,9 @@ func (h *Handlers) QuoteHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// PairsHandler handles GET /partner/v1/pairs
+// PairsHandler handles GET /partner/v1/pairs and GET /public/v1/pairs.
+// asset keeps the pairs trading it on either side; sort orders by
+// liquidity (default), volume or spread, and order overrides the direction.
 func (h *Handlers) PairsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -118,28 +84,83 @@ func (h *Handlers) PairsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
+	q := r.URL.Query()
+
+	var filter *router.Asset
+	if v := q.Get("asset"); v != "" {
+		asset, err := parseAsset(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "asset: "+err.Error())
+			return
+		}
+		filter = &asset
+	}
+
+	sortBy := q.Get("sort")
+	if sortBy == "" {
+		sortBy = PairsSortLiquidity
+	}
+	var descending bool
+	switch sortBy {
+	case PairsSortLiquidity, PairsSortVolume:
+		descending = true
+	case PairsSortSpread:
+	default:
+		writeError(w, http.StatusBadRequest, "sort must be liquidity, volume or spread")
+		return
+	}
+	switch q.Get("order") {
+	case "":
+	case "asc":
+		descending = false
+	case "desc":
+		descending = true
+	default:
+		writeError(w, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
 
 	// Get available pairs from router
 	pairs, err := h.router.GetAvailablePairs(ctx)
 	if err != nil {
+		log.Printf("pairs: %v", err)
 		writeError(w, http.StatusInternalServerError, "failed to fetch pairs")
 		return
 	}
 
+	// The router's slice is cached across requests; filter into a copy
+	selected := make([]router.TradingPairInfo, 0, len(pairs))
+	for _, p := range pairs {
+		if filter == nil || p.In == *filter || p.Out == *filter {
+			selected = append(selected, p)
+		}
+	}
+	sortPairs(selected, sortBy, descending)
+
 	// Convert to API response format
-	apiPairs := make([]TradingPair, len(pairs))
-	for i, p := range pairs {
+	apiPairs := make([]TradingPair, len(selected))
+	for i, p := range selected {
 		apiPairs[i] = TradingPair{
 			In:          p.In.String(),
 			Out:         p.Out.String(),
 			Liquidity:   p.Liquidity.String(),
-			AvgSpread:   p.AvgSpread.String(),
 			DailyVolume: p.DailyVolume.String(),
 		}
+		if p.BestBid.IsPositive() {
+			apiPairs[i].BestBid = p.BestBid.String()
+		}
+		if p.BestAsk.IsPositive() {
+			apiPairs[i].BestAsk = p.BestAsk.String()
+		}
+		if p.BestBid.IsPositive() && p.BestAsk.IsPositive() {
+			apiPairs[i].AvgSpread = p.AvgSpread.String()
+		}
 	}
 
 	resp := PairsResponse{
-		Pairs: apiPairs,
+		Pairs:          apiPairs,
+		ReferenceAsset: h.router.ReferenceAsset().String(),
+		LedgerIndex:    h.router.GetCurrentLedgerIndex(),
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -147,38 +168,38 @@ func (h *Handlers) PairsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// UsageHandler handles GET /partner/v1/usage
-func (h *Handlers) UsageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
-		return
-	}
-
-	ctx := r.Context()
-	partnerID := ctx.Value(ContextKeyPartnerID).(uuid.UUID)
-
-	// Parse query parameters
-	month := r.URL.Query().Get("month")
-	if month == "" {
-		month = time.Now().Format("2006-01")
-	}
+// Pair sort keys
+const (
+	PairsSortLiquidity = "liquidity"
+	PairsSortVolume    = "volume"
+	PairsSortSpread    = "spread"
+)
 
-	// Validate month format
-	if _, err := time.Parse("2006-01", month); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid month format (use YYYY-MM)")
-		return
+// sortPairs orders pairs by key. Pairs without a spread sort last either
+// way when ordering by spread.
+func sortPairs(pairs []router.TradingPairInfo, key string, descending bool) {
+	value := func(p router.TradingPairInfo) decimal.Decimal {
+		switch key {
+		case PairsSortVolume:
+			return p.DailyVolume
+		case PairsSortSpread:
+			return p.AvgSpread
+		}
+		return p.Liquidity
 	}
-
-	// Fetch usage data
-	usage, err := h.db.GetPartnerUsage(ctx, partnerID, month)
-	if err != nil {
-		writeError(w, http.StatusInternalServerError, "failed to fetch usage")
-		return
+	hasSpread := func(p router.TradingPairInfo) bool {
+		return p.BestBid.IsPositive() && p.BestAsk.IsPositive()
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(usage)
+	sort.SliceStable(pairs, func(i, j int) bool {
+		if key == PairsSortSpread && hasSpread(pairs[i]) != hasSpread(pairs[j]) {
+			return hasSpread(pairs[i])
+		}
+		if descending {
+			return value(pairs[i]).GreaterThan(value(pairs[j]))
+		}
+		return value(pairs[i]).LessThan(value(pairs[j]))
+	})
 }
 
 // HealthHandler handles GET /partner/v1/health
@@ -204,6 +225,70 @@ func (h *Handlers) HealthHandler(w http.ResponseWriter, r *http.Request) {
 
 // Helper functions
 
+// parseQuoteRequest decodes and validates a quote request body, writing the
+// error response itself when it returns false
+func parseQuoteRequest(w http.ResponseWriter, r *http.Request) (*router.QuoteRequest, bool) {
+	var req QuoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON")
+		return nil, false
+	}
+
+	// Validate input
+	if req.In == "" || req.Out == "" || req.Amount == "" {
+		writeError(w, http.StatusBadRequest, "missing required fields")
+		return nil, false
+	}
+
+	// Parse amount
+	amount, err := decimal.NewFromString(req.Amount)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid amount format")
+		return nil, false
+	}
+
+	if amount.LessThanOrEqual(decimal.Zero) {
+		writeError(w, http.StatusBadRequest, "amount must be positive")
+		return nil, false
+	}
+
+	// Convert to router types
+	inAsset, err := parseAsset(req.In)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	outAsset, err := parseAsset(req.Out)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+
+	return &router.QuoteRequest{In: inAsset, Out: outAsset, Amount: amount}, true
+}
+
+// generateQuote quotes req at the current ledger, writing the error
+// response itself when it returns false
+func (h *Handlers) generateQuote(ctx context.Context, w http.ResponseWriter, req *router.QuoteRequest) (*router.QuoteResponse, bool) {
+	ledgerIndex := h.router.GetCurrentLedgerIndex()
+
+	quote, err := h.router.GenerateQuote(ctx, req, ledgerIndex)
+	if err != nil {
+		var policyErr *router.PolicyError
+		if errors.As(err, &policyErr) {
+			writeErrorResponse(w, http.StatusForbidden, ErrorResponse{
+				Error:   err.Error(),
+				Code:    policyErr.Reason,
+				Details: policyErr.Asset.String(),
+			})
+			return nil, false
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
+	return quote, true
+}
+
 func (h *Handlers) storeQuoteRegistry(ctx context.Context, quote *router.QuoteResponse, partnerID uuid.UUID, routerBps int, expiresAt time.Time) error {
 	routeJSON, err := json.Marshal(quote.Route)
 	if err != nil {
@@ -234,6 +319,9 @@ func (h *Handlers) buildQuoteResponse(quote *router.QuoteResponse, expiresAt tim
 			AmountIn:  hop.AmountIn.String(),
 			AmountOut: hop.AmountOut.String(),
 		}
+		if hop.TransferFee.IsPositive() {
+			hops[i].TransferFee = hop.TransferFee.String()
+		}
 	}
 
 	return QuoteResponse{
@@ -245,9 +333,10 @@ func (h *Handlers) buildQuoteResponse(quote *router.QuoteResponse, expiresAt tim
 		AmountOut: quote.Out.String(),
 		Price:     quote.Price.String(),
 		Fees: FeesResponse{
-			RouterBps:   quote.Fees.RouterBps,
-			TradingFees: quote.Fees.TradingFees.String(),
-			EstOutFee:   quote.Fees.EstOutFee.String(),
+			RouterBps:    quote.Fees.RouterBps,
+			TradingFees:  quote.Fees.TradingFees.String(),
+			TransferFees: quote.Fees.TransferFees.String(),
+			EstOutFee:    quote.Fees.EstOutFee.String(),
 		},
 		LedgerIndex: quote.LedgerIndex,
 		TTL:         quote.TTLLedgers,
@@ -331,37 +420,35 @@ func (h *Handlers) LedgerUpdateHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func parseAsset(s string) (router.Asset, error) {
+	s = strings.TrimSpace(s)
 	if s == "" {
 		return router.Asset{}, fmt.Errorf("asset required")
 	}
-	parts := strings.Split(s, ".")
-	if len(parts) > 2 {
-		return router.Asset{}, fmt.Errorf("invalid asset format")
-	}
 
-	currency := strings.TrimSpace(parts[0])
-	if currency == "" {
-		return router.Asset{}, fmt.Errorf("currency code required")
+	asset, err := router.ParseAsset(s)
+	switch {
+	case errors.Is(err, currency.ErrInvalidCode):
+		return router.Asset{}, fmt.Errorf("invalid currency code")
+	case err != nil:
+		return router.Asset{}, fmt.Errorf("invalid asset format")
 	}
 
-	var issuer string
-	if len(parts) == 2 {
-		issuer = strings.TrimSpace(parts[1])
-		if issuer == "" {
-			return router.Asset{}, fmt.Errorf("issuer required")
+	// Issuers may be given as X-addresses; routing uses the classic form
+	if asset.Issuer != "" {
+		issuer, err := address.ParseAccount(asset.Issuer)
+		if err != nil {
+			return router.Asset{}, fmt.Errorf("invalid issuer address")
 		}
+		asset.Issuer = issuer
 	}
-
-	return router.Asset{
-		Currency: currency,
-		Issuer:   issuer,
-	}, nil
+	return asset, nil
 }
 
 // Additional DB interface methods needed
 type DBExtended interface {
 	DB
-	GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, month string) (*UsageResponse, error)
+	GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error)
+	StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error
 	StoreQuoteRegistry(ctx context.Context, registry *QuoteRegistry) error
 	GetIndexerLag(ctx context.Context) (int, error)
 }
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+)
+
+// Orderbook depth limits
+const (
+	DefaultOrderbookDepth = 20
+	MaxOrderbookDepth     = 100
+
+	// orderbookOfferLimit bounds the offers read per side; deeper offers
+	// only matter once the shown levels are exhausted
+	orderbookOfferLimit = 2000
+
+	// OrderbookPriceDigits is the significant digits levels are grouped by
+	OrderbookPriceDigits = 8
+
+	// AMMLevelStepBps is the price distance between virtual AMM levels
+	AMMLevelStepBps = 10
+)
+
+// OrderbookHandler handles GET /partner/v1/orderbook and GET
+// /public/v1/orderbook. Offers are grouped into price levels, best first,
+// with cumulative size; amm=true overlays the pair's AMM as virtual levels.
+func (h *Handlers) OrderbookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	if q.Get("base") == "" || q.Get("quote") == "" {
+		writeError(w, http.StatusBadRequest, "base and quote required")
+		return
+	}
+	base, err := parseAsset(q.Get("base"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "base: "+err.Error())
+		return
+	}
+	quote, err := parseAsset(q.Get("quote"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "quote: "+err.Error())
+		return
+	}
+	if base == quote {
+		writeError(w, http.StatusBadRequest, "base and quote must differ")
+		return
+	}
+
+	depth := DefaultOrderbookDepth
+	if v := q.Get("depth"); v != "" {
+		depth, err = strconv.Atoi(v)
+		if err != nil || depth < 1 || depth > MaxOrderbookDepth {
+			writeError(w, http.StatusBadRequest, "depth must be between 1 and "+strconv.Itoa(MaxOrderbookDepth))
+			return
+		}
+	}
+	withAMM, _ := strconv.ParseBool(q.Get("amm"))
+
+	// Read the quote ledger first so the book is at least as new as it
+	ledgerIndex := h.router.GetCurrentLedgerIndex()
+
+	book, err := h.db.GetOrderbook(r.Context(), base.String(), quote.String(), orderbookOfferLimit)
+	if err != nil {
+		log.Printf("orderbook %s/%s: %v", base, quote, err)
+		writeError(w, http.StatusInternalServerError, "failed to fetch orderbook")
+		return
+	}
+
+	var ammBids, ammAsks []BookOffer
+	if withAMM && book.Pool != nil {
+		ammBids, ammAsks = ammLevels(book.Pool, depth, AMMLevelStepBps)
+	}
+
+	resp := OrderbookResponse{
+		Base:            base.String(),
+		Quote:           quote.String(),
+		Bids:            aggregateLevels(book.Bids, ammBids, depth, false),
+		Asks:            aggregateLevels(book.Asks, ammAsks, depth, true),
+		LedgerIndex:     ledgerIndex,
+		BookLedgerIndex: book.LedgerIndex,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+type bookLevel struct {
+	price, size, amm decimal.Decimal
+	offers           int
+}
+
+// aggregateLevels groups offers and virtual AMM liquidity by price, rounded
+// away from the taker's favour, and returns the best depth levels with
+// cumulative size. Asks ascend and bids descend.
+func aggregateLevels(offers, amm []BookOffer, depth int, asks bool) []OrderbookLevel {
+	levels := make(map[string]*bookLevel)
+	add := func(o BookOffer, virtual bool) {
+		price := groupPrice(o.Price, asks)
+		key := price.String()
+		l, ok := levels[key]
+		if !ok {
+			l = &bookLevel{price: price}
+			levels[key] = l
+		}
+		l.size = l.size.Add(o.Size)
+		if virtual {
+			l.amm = l.amm.Add(o.Size)
+		} else {
+			l.offers++
+		}
+	}
+	for _, o := range offers {
+		add(o, false)
+	}
+	for _, o := range amm {
+		add(o, true)
+	}
+
+	sorted := make([]*bookLevel, 0, len(levels))
+	for _, l := range levels {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if asks {
+			return sorted[i].price.LessThan(sorted[j].price)
+		}
+		return sorted[i].price.GreaterThan(sorted[j].price)
+	})
+	if len(sorted) > depth {
+		sorted = sorted[:depth]
+	}
+
+	out := make([]OrderbookLevel, len(sorted))
+	cumulative := decimal.Zero
+	for i, l := range sorted {
+		cumulative = cumulative.Add(l.size)
+		out[i] = OrderbookLevel{
+			Price:      l.price.String(),
+			Size:       l.size.String(),
+			Cumulative: cumulative.String(),
+			Offers:     l.offers,
+		}
+		if l.amm.IsPositive() {
+			out[i].AMMSize = l.amm.String()
+		}
+	}
+	return out
+}
+
+// groupPrice rounds to OrderbookPriceDigits significant digits, up for asks
+// and down for bids, so a level never looks better than its offers
+func groupPrice(price decimal.Decimal, up bool) decimal.Decimal {
+	if !price.IsPositive() {
+		return price
+	}
+	lead := int32(price.NumDigits()) + price.Exponent() - 1
+	places := OrderbookPriceDigits - 1 - lead
+	if up {
+		return price.RoundCeil(places)
+	}
+	return price.RoundFloor(places)
+}
+
+// ammLevels slices a constant product pool into n virtual levels per side,
+// each moving the pool price stepBps further from spot. A level's price is
+// the worst in its band after the trading fee; its size is the base the
+// pool gives or takes across the band.
+func ammLevels(pool *BookPool, n, stepBps int) (bids, asks []BookOffer) {
+	x := pool.BaseReserve.InexactFloat64()
+	y := pool.QuoteReserve.InexactFloat64()
+	k := x * y
+	spot := y / x
+	fee := float64(pool.TradingFeeBps) / 10000
+	step := 1 + float64(stepBps)/10000
+
+	// The pool holds sqrt(k/p) base at pool price p
+	baseAt := func(p float64) float64 { return math.Sqrt(k / p) }
+
+	prevAsk, prevBid := spot, spot
+	for i := 0; i < n; i++ {
+		askPrice := prevAsk * step
+		asks = append(asks, BookOffer{
+			Price: decimal.NewFromFloat(askPrice / (1 - fee)),
+			Size:  decimal.NewFromFloat(baseAt(prevAsk) - baseAt(askPrice)),
+		})
+		prevAsk = askPrice
+
+		bidPrice := prevBid / step
+		bids = append(bids, BookOffer{
+			Price: decimal.NewFromFloat(bidPrice * (1 - fee)),
+			Size:  decimal.NewFromFloat(baseAt(bidPrice) - baseAt(prevBid)),
+		})
+		prevBid = bidPrice
+	}
+	return bids, asks
+}
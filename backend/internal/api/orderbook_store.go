@@ -0,0 +1,148 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/lucendex/backend/internal/xrpl"
+)
+
+// BookOffer is a resting offer in pair terms: price in quote per base and
+// size in base, XRP in whole units rather than drops
+type BookOffer struct {
+	Price decimal.Decimal
+	Size  decimal.Decimal
+}
+
+// BookPool is the pair's AMM, reserves in whole units
+type BookPool struct {
+	BaseReserve   decimal.Decimal
+	QuoteReserve  decimal.Decimal
+	TradingFeeBps int
+}
+
+// BookSnapshot is what the indexer has recorded for one pair. Asks and bids
+// are each ordered best first.
+type BookSnapshot struct {
+	Asks        []BookOffer
+	Bids        []BookOffer
+	Pool        *BookPool // nil without an AMM for the pair
+	LedgerIndex uint32    // newest ledger among the rows read
+}
+
+// GetOrderbook loads up to limit active, unexpired offers per side of the
+// base/quote book, plus the pair's AMM pool. The indexer records every offer
+// as an ask of the asset it gives, so bids are the offers of the reverse
+// book, inverted.
+func (s *PostgresStore) GetOrderbook(ctx context.Context, base, quote string, limit int) (*BookSnapshot, error) {
+	book := &BookSnapshot{}
+	var err error
+
+	book.Asks, err = s.bookSide(ctx, base, quote, limit, &book.LedgerIndex, func(price, amount decimal.Decimal) BookOffer {
+		return BookOffer{
+			Price: price.Shift(assetScale(base) - assetScale(quote)),
+			Size:  amount.Shift(-assetScale(base)),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A reverse offer gives quote for base at price base per quote
+	book.Bids, err = s.bookSide(ctx, quote, base, limit, &book.LedgerIndex, func(price, amount decimal.Decimal) BookOffer {
+		return BookOffer{
+			Price: decimal.NewFromInt(1).Div(price.Shift(assetScale(quote) - assetScale(base))),
+			Size:  amount.Mul(price).Shift(-assetScale(base)),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var asset1, reserve1, reserve2 string
+	var pool BookPool
+	var ledgerIndex uint32
+	err = s.db.QueryRowContext(ctx, `
+		SELECT asset1, asset1_reserve, asset2_reserve, trading_fee, ledger_index
+		FROM core.amm_pools
+		WHERE (asset1 = $1 AND asset2 = $2) OR (asset1 = $2 AND asset2 = $1)
+	`, base, quote).Scan(&asset1, &reserve1, &reserve2, &pool.TradingFeeBps, &ledgerIndex)
+	if err == sql.ErrNoRows {
+		return book, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if asset1 != base {
+		reserve1, reserve2 = reserve2, reserve1
+	}
+	if pool.BaseReserve, err = decimal.NewFromString(reserve1); err != nil {
+		return nil, fmt.Errorf("amm reserve %q: %w", reserve1, err)
+	}
+	if pool.QuoteReserve, err = decimal.NewFromString(reserve2); err != nil {
+		return nil, fmt.Errorf("amm reserve %q: %w", reserve2, err)
+	}
+	pool.BaseReserve = pool.BaseReserve.Shift(-assetScale(base))
+	pool.QuoteReserve = pool.QuoteReserve.Shift(-assetScale(quote))
+	if pool.BaseReserve.IsPositive() && pool.QuoteReserve.IsPositive() {
+		book.Pool = &pool
+	}
+	book.LedgerIndex = max(book.LedgerIndex, ledgerIndex)
+	return book, nil
+}
+
+// bookSide reads the offers giving gives for wants, cheapest first, and
+// converts each with toOffer. Offers without a positive price are skipped.
+func (s *PostgresStore) bookSide(ctx context.Context, gives, wants string, limit int, ledgerIndex *uint32, toOffer func(price, amount decimal.Decimal) BookOffer) ([]BookOffer, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT price, amount, ledger_index
+		FROM core.orderbook_state
+		WHERE base_asset = $1 AND quote_asset = $2 AND status = 'active'
+		  AND amount::NUMERIC > 0 AND price::NUMERIC > 0
+		  AND (expiration IS NULL OR expiration > $3)
+		ORDER BY price::NUMERIC ASC
+		LIMIT $4
+	`, gives, wants, time.Now().Unix()-xrpl.RippleEpoch, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []BookOffer
+	for rows.Next() {
+		var priceStr, amountStr string
+		var ledger uint32
+		if err := rows.Scan(&priceStr, &amountStr, &ledger); err != nil {
+			return nil, err
+		}
+		price, err := decimal.NewFromString(priceStr)
+		if err != nil {
+			return nil, fmt.Errorf("offer price %q: %w", priceStr, err)
+		}
+		amount, err := decimal.NewFromString(amountStr)
+		if err != nil {
+			return nil, fmt.Errorf("offer amount %q: %w", amountStr, err)
+		}
+		// Prices are stored rounded to 8 places, so a tiny one reads as 0
+		// and could not be inverted into a bid
+		if !price.IsPositive() || !amount.IsPositive() {
+			continue
+		}
+		offers = append(offers, toOffer(price, amount))
+		*ledgerIndex = max(*ledgerIndex, ledger)
+	}
+	return offers, rows.Err()
+}
+
+// assetScale is the decimal exponent between the indexer's stored amounts
+// and whole units: XRP is stored in drops
+func assetScale(asset string) int32 {
+	if asset == "XRP" {
+		return 6
+	}
+	return 0
+}
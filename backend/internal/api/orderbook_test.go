@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/shopspring/decimal"
+)
+
+func bookOffer(price, size string) BookOffer {
+	return BookOffer{Price: decimal.RequireFromString(price), Size: decimal.RequireFromString(size)}
+}
+
+func TestAggregateLevels(t *testing.T) {
+	asks := []BookOffer{
+		bookOffer("1.50000001", "10"),
+		bookOffer("1.50000002", "5"), // same level at 8 significant digits
+		bookOffer("1.49", "2"),
+		bookOffer("1.6", "1"),
+	}
+	levels := aggregateLevels(asks, nil, 2, true)
+
+	want := []OrderbookLevel{
+		{Price: "1.49", Size: "2", Cumulative: "2", Offers: 1},
+		{Price: "1.5000001", Size: "15", Cumulative: "17", Offers: 2},
+	}
+	if len(levels) != len(want) {
+		t.Fatalf("levels = %+v, want %+v", levels, want)
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("level %d = %+v, want %+v", i, levels[i], want[i])
+		}
+	}
+
+	// Bids descend and round down; virtual liquidity is counted apart
+	bids := aggregateLevels([]BookOffer{bookOffer("1.4", "3"), bookOffer("1.45", "1")}, []BookOffer{bookOffer("1.4", "7")}, 10, false)
+	if len(bids) != 2 || bids[0].Price != "1.45" || bids[1].Size != "10" || bids[1].AMMSize != "7" || bids[1].Offers != 1 || bids[1].Cumulative != "11" {
+		t.Errorf("bids = %+v", bids)
+	}
+}
+
+func TestAMMLevels(t *testing.T) {
+	pool := &BookPool{BaseReserve: decimal.NewFromInt(10000), QuoteReserve: decimal.NewFromInt(15000), TradingFeeBps: 30}
+	bids, asks := ammLevels(pool, 5, AMMLevelStepBps)
+
+	spot := decimal.NewFromFloat(1.5)
+	for i := range asks {
+		if !asks[i].Price.GreaterThan(spot) || !bids[i].Price.LessThan(spot) {
+			t.Errorf("level %d ask %s bid %s, want either side of spot", i, asks[i].Price, bids[i].Price)
+		}
+		if !asks[i].Size.IsPositive() || !bids[i].Size.IsPositive() {
+			t.Errorf("level %d sizes %s/%s, want positive", i, asks[i].Size, bids[i].Size)
+		}
+		if i > 0 && !asks[i].Price.GreaterThan(asks[i-1].Price) {
+			t.Errorf("ask %d not above ask %d", i, i-1)
+		}
+	}
+
+	// Buying the first ask band costs what the pool itself quotes
+	pf := 1 - 0.003
+	firstBand := asks[0].Size.InexactFloat64()
+	cost := 15000*10000/(10000-firstBand) - 15000
+	if got := cost / pf / firstBand; got > asks[0].Price.InexactFloat64() {
+		t.Errorf("average ask %f above level price %s", got, asks[0].Price)
+	}
+}
+
+func TestOrderbookHandler(t *testing.T) {
+	db := &mockDB{book: &BookSnapshot{
+		Asks:        []BookOffer{bookOffer("1.51", "100")},
+		Bids:        []BookOffer{bookOffer("1.49", "50")},
+		Pool:        &BookPool{BaseReserve: decimal.NewFromInt(10000), QuoteReserve: decimal.NewFromInt(15000)},
+		LedgerIndex: 41,
+	}}
+	h := newTestHandlers(t, db)
+	h.router.SetCurrentLedgerIndex(42)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.OrderbookHandler(rec, httptest.NewRequest(http.MethodGet, "/public/v1/orderbook?"+query, nil))
+		return rec
+	}
+
+	rec := get("base=XRP&quote=USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B&depth=3")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp OrderbookResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Asks) != 1 || len(resp.Bids) != 1 || resp.LedgerIndex != 42 || resp.BookLedgerIndex != 41 {
+		t.Errorf("book = %+v, want one level a side without the AMM", resp)
+	}
+
+	rec = get("base=XRP&quote=USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B&depth=3&amm=true")
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if len(resp.Asks) != 3 || len(resp.Bids) != 3 || resp.Asks[0].AMMSize == "" {
+		t.Errorf("book with amm = %+v, want three levels a side led by the pool", resp)
+	}
+
+	for _, query := range []string{"base=XRP", "base=XRP&quote=XRP", "base=XRP&quote=USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B&depth=0", "base=XRP&quote=USD.bad"} {
+		if rec := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestPostgresStore_GetOrderbook(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	usd := "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"
+	cols := []string{"price", "amount", "ledger_index"}
+
+	// Asks give XRP (drops) for USD at USD per drop
+	mock.ExpectQuery("FROM core.orderbook_state").
+		WithArgs("XRP", usd, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("0.0000015", "2000000", 100))
+	// Bids give USD for XRP at drops per USD
+	mock.ExpectQuery("FROM core.orderbook_state").
+		WithArgs(usd, "XRP", sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("800000", "3", 101))
+	mock.ExpectQuery("FROM core.amm_pools").
+		WithArgs("XRP", usd).
+		WillReturnRows(sqlmock.NewRows([]string{"asset1", "asset1_reserve", "asset2_reserve", "trading_fee", "ledger_index"}).
+			AddRow(usd, "15000", "10000000000", 30, 99))
+
+	book, err := NewPostgresStore(db).GetOrderbook(context.Background(), "XRP", usd, 10)
+	if err != nil {
+		t.Fatalf("GetOrderbook() error = %v", err)
+	}
+	if got := book.Asks[0]; !got.Price.Equal(decimal.RequireFromString("1.5")) || !got.Size.Equal(decimal.NewFromInt(2)) {
+		t.Errorf("ask = %s @ %s, want 2 @ 1.5", got.Size, got.Price)
+	}
+	if got := book.Bids[0]; !got.Price.Equal(decimal.RequireFromString("1.25")) || !got.Size.Equal(decimal.RequireFromString("2.4")) {
+		t.Errorf("bid = %s @ %s, want 2.4 @ 1.25", got.Size, got.Price)
+	}
+	if !book.Pool.BaseReserve.Equal(decimal.NewFromInt(10000)) || !book.Pool.QuoteReserve.Equal(decimal.NewFromInt(15000)) {
+		t.Errorf("pool = %+v, want reserves swapped into base/quote order", book.Pool)
+	}
+	if book.LedgerIndex != 101 {
+		t.Errorf("ledger index = %d, want 101", book.LedgerIndex)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresStore_GetOrderbookSkipsZeroPrice(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	usd := "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"
+	cols := []string{"price", "amount", "ledger_index"}
+
+	mock.ExpectQuery("FROM core.orderbook_state").
+		WithArgs("XRP", usd, sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(cols).AddRow("0.00000000", "2000000", 100))
+	// A zero price on the reverse book would divide by zero when inverted
+	mock.ExpectQuery("FROM core.orderbook_state").
+		WithArgs(usd, "XRP", sqlmock.AnyArg(), 10).
+		WillReturnRows(sqlmock.NewRows(cols).
+			AddRow("0.00000000", "5", 101).
+			AddRow("800000", "3", 101))
+	mock.ExpectQuery("FROM core.amm_pools").
+		WithArgs("XRP", usd).
+		WillReturnError(sql.ErrNoRows)
+
+	book, err := NewPostgresStore(db).GetOrderbook(context.Background(), "XRP", usd, 10)
+	if err != nil {
+		t.Fatalf("GetOrderbook() error = %v", err)
+	}
+	if len(book.Asks) != 0 {
+		t.Errorf("asks = %+v, want zero-price offer skipped", book.Asks)
+	}
+	if len(book.Bids) != 1 || !book.Bids[0].Price.Equal(decimal.RequireFromString("1.25")) {
+		t.Errorf("bids = %+v, want only the 1.25 bid", book.Bids)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
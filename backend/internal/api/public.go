@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/lucendex/backend/internal/router"
+)
+
+// Public quote defaults
+const (
+	PublicQuoteMaxAmount  = 10000 // in units of the input asset
+	PublicQuotePlaces     = 6     // decimal places kept on amounts and prices
+	PublicQuoteTTLLedgers = 5
+)
+
+// PublicQuoteOptions shapes quotes served without authentication
+type PublicQuoteOptions struct {
+	MaxAmount  decimal.Decimal
+	Places     int32
+	TTLLedgers uint16
+}
+
+func DefaultPublicQuoteOptions() PublicQuoteOptions {
+	return PublicQuoteOptions{
+		MaxAmount:  decimal.NewFromInt(PublicQuoteMaxAmount),
+		Places:     PublicQuotePlaces,
+		TTLLedgers: PublicQuoteTTLLedgers,
+	}
+}
+
+// SetPublicQuoteOptions replaces the limits applied by PublicQuoteHandler
+func (h *Handlers) SetPublicQuoteOptions(opts PublicQuoteOptions) {
+	h.public = opts
+}
+
+// PublicQuoteHandler handles POST /public/v1/quote. Public quotes are
+// indicative: the amount is capped, figures are truncated, and no quote
+// hash is returned or registered, so they can't be submitted or attributed
+// for routed fees. Partner policies don't apply; the global one does.
+func (h *Handlers) PublicQuoteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	routerReq, ok := parseQuoteRequest(w, r)
+	if !ok {
+		return
+	}
+	if routerReq.Amount.GreaterThan(h.public.MaxAmount) {
+		writeError(w, http.StatusBadRequest, "amount exceeds the public quote limit of "+h.public.MaxAmount.String()+", use the partner API")
+		return
+	}
+
+	quote, ok := h.generateQuote(r.Context(), w, routerReq)
+	if !ok {
+		return
+	}
+	truncateQuote(quote, h.public.Places)
+
+	expiresAt := time.Now().Add(time.Duration(h.public.TTLLedgers) * 4 * time.Second)
+	resp := h.buildQuoteResponse(quote, expiresAt)
+	resp.QuoteHash = ""
+	resp.TTL = h.public.TTLLedgers
+	resp.Indicative = true
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// truncateQuote drops precision past places, rounding toward zero so an
+// indicative quote never promises more than the router found
+func truncateQuote(q *router.QuoteResponse, places int32) {
+	q.Out = q.Out.Truncate(places)
+	q.Price = q.Price.Truncate(places)
+	q.Fees.TradingFees = q.Fees.TradingFees.Truncate(places)
+	q.Fees.TransferFees = q.Fees.TransferFees.Truncate(places)
+	q.Fees.EstOutFee = q.Fees.EstOutFee.Truncate(places)
+	q.Route.PriceImpact = q.Route.PriceImpact.Truncate(places)
+
+	hops := make([]router.Hop, len(q.Route.Hops))
+	for i, hop := range q.Route.Hops {
+		hop.AmountIn = hop.AmountIn.Truncate(places)
+		hop.AmountOut = hop.AmountOut.Truncate(places)
+		hop.TransferFee = hop.TransferFee.Truncate(places)
+		hops[i] = hop
+	}
+	q.Route.Hops = hops
+}
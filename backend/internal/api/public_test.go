@@ -0,0 +1,149 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+
+	"github.com/lucendex/backend/internal/router"
+)
+
+type nopRouterStore struct{}
+
+func (nopRouterStore) GetCircuitBreakerState(ctx context.Context, pair string) (interface{}, error) {
+	return nil, nil
+}
+func (nopRouterStore) SaveCircuitBreakerState(ctx context.Context, cb interface{}) error { return nil }
+func (nopRouterStore) LogAudit(ctx context.Context, log interface{}) error               { return nil }
+
+// newTestHandlers serves quotes from a single XRP/USD pool
+func newTestHandlers(t *testing.T, db DB) *Handlers {
+	t.Helper()
+	pools := []router.AMMPool{{
+		Asset1:        router.Asset{Currency: "XRP"},
+		Asset2:        router.Asset{Currency: "USD", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"},
+		Asset1Reserve: decimal.NewFromInt(10000),
+		Asset2Reserve: decimal.NewFromInt(15000),
+		TradingFeeBps: 30,
+	}}
+	store := newReplayStore(t)
+	qe := router.NewQuoteEngine(router.NewValidator(), router.NewPathfinder(pools, nil), router.NewCircuitBreaker(router.DefaultThreshold), store, 20)
+	return NewHandlers(router.NewRouter(qe, nopRouterStore{}, store), db, store, "")
+}
+
+func TestPublicQuoteHandler(t *testing.T) {
+	db := &mockDB{}
+	h := newTestHandlers(t, db)
+
+	quote := func(amount string) *httptest.ResponseRecorder {
+		body := `{"in":"XRP","out":"USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B","amount":"` + amount + `"}`
+		rec := httptest.NewRecorder()
+		h.PublicQuoteHandler(rec, httptest.NewRequest(http.MethodPost, "/public/v1/quote", strings.NewReader(body)))
+		return rec
+	}
+
+	rec := quote("100")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp QuoteResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Indicative || resp.QuoteHash != "" || resp.TTL != PublicQuoteTTLLedgers {
+		t.Errorf("quote = %+v, want an indicative quote without hash", resp)
+	}
+	for _, v := range []string{resp.AmountOut, resp.Price, resp.Route.Hops[0].AmountOut} {
+		if i := strings.IndexByte(v, '.'); i >= 0 && len(v)-i-1 > PublicQuotePlaces {
+			t.Errorf("%s has more than %d decimal places", v, PublicQuotePlaces)
+		}
+	}
+	if db.quotes != 0 {
+		t.Errorf("public quote registered %d times, want none", db.quotes)
+	}
+
+	if rec := quote("10001"); rec.Code != http.StatusBadRequest {
+		t.Errorf("oversized quote status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestQuoteHandler_RegistersForAttribution(t *testing.T) {
+	db := &mockDB{}
+	h := newTestHandlers(t, db)
+
+	req := httptest.NewRequest(http.MethodPost, "/partner/v1/quote", strings.NewReader(`{"in":"XRP","out":"USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B","amount":"100"}`))
+	ctx := context.WithValue(req.Context(), ContextKeyPartnerID, uuid.New())
+	ctx = context.WithValue(ctx, ContextKeyPartner, &Partner{RouterBps: 20})
+	rec := httptest.NewRecorder()
+	h.QuoteHandler(rec, req.WithContext(ctx))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp QuoteResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Indicative || resp.QuoteHash == "" || db.quotes != 1 {
+		t.Errorf("partner quote = %+v, registered %d times, want a hashed registered quote", resp, db.quotes)
+	}
+}
+
+func TestPairsHandler(t *testing.T) {
+	h := newTestHandlers(t, &mockDB{})
+	usd := "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"
+	eur := "EUR.rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq"
+	h.router.SetPairStats(router.NewPairStats(func(ctx context.Context, since time.Time) (*router.MarketSnapshot, error) {
+		return &router.MarketSnapshot{
+			Pools: []router.PoolReserves{
+				{Asset1: "XRP", Asset2: usd, Reserve1: "10000000000", Reserve2: "15000", TradingFeeBps: 30},
+				{Asset1: "XRP", Asset2: eur, Reserve1: "20000000000", Reserve2: "18000", TradingFeeBps: 100},
+			},
+			Volumes: []router.TradeVolume{{InAsset: "XRP", OutAsset: usd, AmountIn: "5000000", AmountOut: "7.5"}},
+		}, nil
+	}, router.Asset{Currency: "XRP"}))
+	h.router.SetCurrentLedgerIndex(7)
+
+	get := func(query string) (*httptest.ResponseRecorder, PairsResponse) {
+		rec := httptest.NewRecorder()
+		h.PairsHandler(rec, httptest.NewRequest(http.MethodGet, "/public/v1/pairs?"+query, nil))
+		var resp PairsResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec, resp
+	}
+
+	rec, resp := get("")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	if len(resp.Pairs) != 2 || resp.Pairs[0].Out != eur || resp.ReferenceAsset != "XRP" || resp.LedgerIndex != 7 {
+		t.Fatalf("pairs = %+v, want XRP/EUR first by liquidity", resp)
+	}
+	if resp.Pairs[0].AvgSpread == "" || resp.Pairs[0].BestBid == "" || resp.Pairs[0].Liquidity != "40000" {
+		t.Errorf("XRP/EUR = %+v", resp.Pairs[0])
+	}
+
+	_, resp = get("sort=spread")
+	if resp.Pairs[0].Out != usd {
+		t.Errorf("tightest spread = %s, want the 30 bps pool", resp.Pairs[0].Out)
+	}
+	_, resp = get("sort=volume")
+	if resp.Pairs[0].Out != usd || resp.Pairs[0].DailyVolume != "5" {
+		t.Errorf("highest volume = %+v, want XRP/USD with 5", resp.Pairs[0])
+	}
+	_, resp = get("asset=" + eur)
+	if len(resp.Pairs) != 1 || resp.Pairs[0].Out != eur {
+		t.Errorf("filtered pairs = %+v, want only XRP/EUR", resp.Pairs)
+	}
+
+	for _, query := range []string{"sort=price", "order=up", "asset=USD"} {
+		if rec, _ := get(query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
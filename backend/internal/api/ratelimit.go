@@ -1,11 +1,16 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lucendex/backend/internal/kv"
 )
 
 var (
@@ -19,13 +24,34 @@ const (
 	EnterprisePlanLimit = 10000
 )
 
+// Plan bursts (requests admitted back-to-back before pacing applies)
+const (
+	FreePlanBurst       = 20
+	ProPlanBurst        = 100
+	EnterprisePlanBurst = 1000
+)
+
+// Unauthenticated /public/v1 allowance per client IP
+const (
+	PublicIPLimit = 30
+	PublicIPBurst = 5
+)
+
+const rateLimitWindow = 60 * time.Second
+
+// PlanLimit is a partner plan's request allowance
+type PlanLimit struct {
+	PerMinute int64
+	Burst     int64 // 0 disables burst pacing
+}
+
 type RateLimiter struct {
 	kv KVStore
 	db DB
 }
 
 type KVStore interface {
-	IncrementRateLimit(partnerID string, ttl time.Duration) (int64, error)
+	RateLimit(key string, limit int64, window time.Duration, burst int64) (kv.RateLimitResult, error)
 }
 
 func NewRateLimiter(kv KVStore, db DB) *RateLimiter {
@@ -35,6 +61,8 @@ func NewRateLimiter(kv KVStore, db DB) *RateLimiter {
 	}
 }
 
+// Middleware enforces the partner's plan limit. Must run after
+// AuthMiddleware.
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -52,47 +80,126 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// Get limit based on plan
 		limit := rl.getLimitForPlan(partner.Plan)
-
-		// Check rate limit
-		count, err := rl.kv.IncrementRateLimit(partnerID.String(), 60*time.Second)
-		if err != nil {
-			writeError(w, http.StatusServiceUnavailable, "rate limiting unavailable")
+		if !enforceLimit(w, rl.kv, partnerID.String(), limit) {
 			return
 		}
 
-		// Set rate limit headers
-		w.Header().Set("X-RateLimit-Limit", fmt.Sprint(limit))
-		w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(max(0, limit-int(count))))
-		w.Header().Set("X-RateLimit-Reset", fmt.Sprint(time.Now().Add(60*time.Second).Unix()))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// enforceLimit applies limit to key over a sliding minute and paces bursts
+// with a token bucket refilling at the same rate. The store checks both in
+// one operation, so a request one of them rejects costs nothing. It writes
+// the rejection itself when it returns false.
+//
+// A full rate_limits namespace fails closed: a key that isn't tracked yet is
+// told to retry once a window has passed and idle limiters have expired.
+// Letting it through would hand a flood of client IPs an unlimited path.
+func enforceLimit(w http.ResponseWriter, store KVStore, key string, limit PlanLimit) bool {
+	res, err := store.RateLimit("limit:"+key, limit.PerMinute, rateLimitWindow, limit.Burst)
+	if errors.Is(err, kv.ErrNamespaceQuota) {
+		rejectRateLimited(w, kv.RateLimitResult{RetryAfter: rateLimitWindow})
+		return false
+	}
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, "rate limiting unavailable")
+		return false
+	}
+	setRateLimitHeaders(w, res)
+	if !res.Allowed {
+		rejectRateLimited(w, res)
+		return false
+	}
+	return true
+}
+
+// IPRateLimiter limits unauthenticated traffic by client address
+type IPRateLimiter struct {
+	kv    KVStore
+	limit PlanLimit
+
+	// trustForwardedFor takes the client address from the last
+	// X-Forwarded-For entry, as appended by our own proxy
+	trustForwardedFor bool
+}
+
+func NewIPRateLimiter(kv KVStore, limit PlanLimit) *IPRateLimiter {
+	return &IPRateLimiter{kv: kv, limit: limit}
+}
 
-		if count > int64(limit) {
-			w.Header().Set("Retry-After", "60")
-			writeError(w, http.StatusTooManyRequests, ErrRateLimitExceeded.Error())
+// SetTrustForwardedFor should only be enabled behind a proxy that appends
+// the peer address to X-Forwarded-For; otherwise clients pick their own key
+func (rl *IPRateLimiter) SetTrustForwardedFor(trust bool) {
+	rl.trustForwardedFor = trust
+}
+
+func (rl *IPRateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := rl.clientIP(r)
+		if ip == "" {
+			writeError(w, http.StatusBadRequest, "unknown client address")
+			return
+		}
+		if !enforceLimit(w, rl.kv, "ip:"+ip, rl.limit) {
 			return
 		}
-
 		next.ServeHTTP(w, r)
 	})
 }
 
-func (rl *RateLimiter) getLimitForPlan(plan string) int {
+// clientIP returns the key a request is limited under. IPv6 clients are
+// grouped by /64, the usual allocation of a single subscriber.
+func (rl *IPRateLimiter) clientIP(r *http.Request) string {
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if rl.trustForwardedFor {
+		if xff := r.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+			hops := strings.Split(xff[len(xff)-1], ",")
+			addr = strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return ""
+	}
+	ip = ip.Unmap()
+	if ip.Is4() {
+		return ip.String()
+	}
+	prefix, err := ip.WithZone("").Prefix(64)
+	if err != nil {
+		return ""
+	}
+	return prefix.String()
+}
+
+func setRateLimitHeaders(w http.ResponseWriter, res kv.RateLimitResult) {
+	w.Header().Set("X-RateLimit-Limit", fmt.Sprint(res.Limit))
+	w.Header().Set("X-RateLimit-Remaining", fmt.Sprint(res.Remaining))
+	w.Header().Set("X-RateLimit-Reset", fmt.Sprint(res.ResetAt.Unix()))
+}
+
+func rejectRateLimited(w http.ResponseWriter, res kv.RateLimitResult) {
+	// Round up so a client honouring Retry-After is never early
+	retry := (res.RetryAfter + time.Second - 1) / time.Second
+	w.Header().Set("Retry-After", fmt.Sprint(max(1, int64(retry))))
+	writeError(w, http.StatusTooManyRequests, ErrRateLimitExceeded.Error())
+}
+
+func (rl *RateLimiter) getLimitForPlan(plan string) PlanLimit {
 	switch plan {
 	case "free":
-		return FreePlanLimit
+		return PlanLimit{PerMinute: FreePlanLimit, Burst: FreePlanBurst}
 	case "pro":
-		return ProPlanLimit
+		return PlanLimit{PerMinute: ProPlanLimit, Burst: ProPlanBurst}
 	case "enterprise":
-		return EnterprisePlanLimit
+		return PlanLimit{PerMinute: EnterprisePlanLimit, Burst: EnterprisePlanBurst}
 	default:
-		return FreePlanLimit
-	}
-}
-
-func max(a, b int) int {
-	if a > b {
-		return a
+		return PlanLimit{PerMinute: FreePlanLimit, Burst: FreePlanBurst}
 	}
-	return b
 }
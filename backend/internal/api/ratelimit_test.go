@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/lucendex/backend/internal/kv"
+)
+
+func rateLimitedRequest(t *testing.T, handler http.Handler, partner *Partner) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/partner/v1/pairs", nil)
+	ctx := context.WithValue(req.Context(), ContextKeyPartnerID, partner.ID)
+	ctx = context.WithValue(ctx, ContextKeyPartner, partner)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+func TestRateLimiter_BurstThenRetryAfter(t *testing.T) {
+	store := kv.NewMemoryStore()
+	defer store.Close()
+
+	rl := NewRateLimiter(store, &mockDB{})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	partner := &Partner{ID: uuid.New(), Plan: "free"}
+
+	for i := 0; i < FreePlanBurst; i++ {
+		rec := rateLimitedRequest(t, handler, partner)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i, rec.Code)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != strconv.Itoa(FreePlanLimit-i-1) {
+			t.Errorf("request %d X-RateLimit-Remaining = %s, want %d", i, got, FreePlanLimit-i-1)
+		}
+	}
+
+	rec := rateLimitedRequest(t, handler, partner)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("request past burst status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+	if rec.Header().Get("X-RateLimit-Reset") == "" {
+		t.Error("missing X-RateLimit-Reset")
+	}
+
+	// Another partner has its own allowance
+	other := &Partner{ID: uuid.New(), Plan: "free"}
+	if rec := rateLimitedRequest(t, handler, other); rec.Code != http.StatusOK {
+		t.Errorf("other partner status = %d, want 200", rec.Code)
+	}
+}
+
+func TestRateLimiter_MissingPartnerContext(t *testing.T) {
+	store := kv.NewMemoryStore()
+	defer store.Close()
+
+	handler := NewRateLimiter(store, &mockDB{}).Middleware(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partner/v1/pairs", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+}
+
+func TestIPRateLimiter(t *testing.T) {
+	store := kv.NewMemoryStore()
+	defer store.Close()
+
+	rl := NewIPRateLimiter(store, PlanLimit{PerMinute: PublicIPLimit, Burst: 2})
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	request := func(remoteAddr, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/public/v1/pairs", nil)
+		req.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := request("198.51.100.7:4000", ""); code != http.StatusOK {
+			t.Fatalf("request %d status = %d, want 200", i, code)
+		}
+	}
+	if code := request("198.51.100.7:4001", ""); code != http.StatusTooManyRequests {
+		t.Errorf("same IP, new port status = %d, want 429", code)
+	}
+	if code := request("198.51.100.8:4000", ""); code != http.StatusOK {
+		t.Errorf("other IP status = %d, want 200", code)
+	}
+
+	// A whole IPv6 /64 shares one allowance
+	request("[2001:db8::1]:4000", "")
+	request("[2001:db8::2]:4000", "")
+	if code := request("[2001:db8::ffff]:4000", ""); code != http.StatusTooManyRequests {
+		t.Errorf("same /64 status = %d, want 429", code)
+	}
+
+	// X-Forwarded-For is ignored unless trusted, then its last hop counts
+	if code := request("198.51.100.7:4000", "203.0.113.9"); code != http.StatusTooManyRequests {
+		t.Errorf("untrusted X-Forwarded-For status = %d, want 429", code)
+	}
+	rl.SetTrustForwardedFor(true)
+	if code := request("10.0.0.1:80", "198.51.100.7, 203.0.113.9"); code != http.StatusOK {
+		t.Errorf("trusted X-Forwarded-For status = %d, want 200", code)
+	}
+	if code := request("10.0.0.1:80", "bogus"); code != http.StatusBadRequest {
+		t.Errorf("unparseable client address status = %d, want 400", code)
+	}
+}
+
+func TestIPRateLimiter_FullTableFailsClosed(t *testing.T) {
+	store := kv.NewMemoryStoreWithConfig(kv.DefaultMaxBytes, kv.DefaultMaxKeyLength, kv.DefaultMaxValueSize,
+		kv.WithNamespace(kv.NamespaceRateLimits, kv.NamespaceConfig{MaxKeys: 5, Eviction: kv.EvictNone}))
+	defer store.Close()
+
+	handler := NewIPRateLimiter(store, PlanLimit{PerMinute: PublicIPLimit, Burst: PublicIPBurst}).
+		Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+	request := func(ip int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/public/v1/pairs", nil)
+		req.RemoteAddr = "198.51.100." + strconv.Itoa(ip) + ":4000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for ip := 1; ip <= 20; ip++ {
+		rec := request(ip)
+		want := http.StatusOK
+		if ip > 5 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Fatalf("client %d status = %d, want %d", ip, rec.Code, want)
+		}
+		if ip > 5 && rec.Header().Get("Retry-After") != "60" {
+			t.Errorf("client %d Retry-After = %q, want 60", ip, rec.Header().Get("Retry-After"))
+		}
+	}
+	if n := store.Stats().NamespaceCounts[kv.NamespaceRateLimits]; n != 5 {
+		t.Errorf("rate limit keys = %d, want 5", n)
+	}
+	// Clients already tracked keep their allowance
+	if rec := request(1); rec.Code != http.StatusOK {
+		t.Errorf("tracked client status = %d, want 200", rec.Code)
+	}
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+// API key scopes. Each partner endpoint requires at most one.
+const (
+	ScopeQuote = "quote"
+	ScopePairs = "pairs"
+	ScopeUsage = "usage"
+)
+
+// Scopes lists every scope a key can be granted
+var Scopes = []string{ScopeQuote, ScopePairs, ScopeUsage}
+
+// ErrInvalidScope is returned when creating a key with an unknown scope
+var ErrInvalidScope = errors.New("scopes must be one or more of quote, pairs, usage")
+
+// ErrInsufficientScope is returned when an authentic key calls an endpoint
+// outside its scopes
+var ErrInsufficientScope = errors.New("api key is not allowed to call this endpoint")
+
+// DefaultRouteScopes maps partner API paths to the scope they require.
+// Paths not listed, like health, are open to every key.
+func DefaultRouteScopes() map[string]string {
+	return map[string]string{
+		"/partner/v1/quote":     ScopeQuote,
+		"/partner/v1/pairs":     ScopePairs,
+		"/partner/v1/orderbook": ScopePairs,
+		"/partner/v1/usage":     ScopeUsage,
+		"/partner/v1/invoices":  ScopeUsage,
+	}
+}
+
+// normalizeScopes validates scopes and returns them sorted without
+// duplicates. No scopes means an unrestricted key.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool, len(scopes))
+	var out []string
+	for _, s := range scopes {
+		s = strings.ToLower(strings.TrimSpace(s))
+		valid := false
+		for _, known := range Scopes {
+			valid = valid || s == known
+		}
+		if !valid {
+			return nil, ErrInvalidScope
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// scopesArg encodes scopes for string_to_array, NULL for an unrestricted key
+func scopesArg(scopes []string) any {
+	if len(scopes) == 0 {
+		return nil
+	}
+	return strings.Join(scopes, ",")
+}
+
+// parseScopes decodes the array_to_string form read back from Postgres
+func parseScopes(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
@@ -2,8 +2,12 @@ package api
 
 import (
 	"context"
+	"crypto/ed25519"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -30,105 +34,250 @@ func (s *PostgresStore) GetPartnerByID(ctx context.Context, partnerID uuid.UUID)
 	return &p, nil
 }
 
-func (s *PostgresStore) GetAPIKeyByPublicKey(ctx context.Context, publicKey string) (*APIKey, error) {
+// ErrInvalidPublicKey is returned when registering a key that isn't a hex
+// encoded Ed25519 public key
+var ErrInvalidPublicKey = errors.New("public key must be a hex encoded Ed25519 key")
+
+// ErrDuplicatePublicKey is returned when registering a key that is already
+// on file, for this partner or another
+var ErrDuplicatePublicKey = errors.New("public key already registered")
+
+const apiKeyColumns = `id, partner_id, public_key, COALESCE(label, ''), created_at, revoked, revoked_at, not_after, COALESCE(array_to_string(scopes, ','), '')`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
 	var k APIKey
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, partner_id, public_key, label, created_at, revoked, revoked_at
-		FROM api_keys
-		WHERE public_key = $1 AND revoked = false
-	`, publicKey).Scan(&k.ID, &k.PartnerID, &k.PublicKey, &k.Label, &k.CreatedAt, &k.Revoked, &k.RevokedAt)
+	var scopes string
+	err := row.Scan(&k.ID, &k.PartnerID, &k.PublicKey, &k.Label, &k.CreatedAt, &k.Revoked, &k.RevokedAt, &k.NotAfter, &scopes)
 	if err != nil {
 		return nil, err
 	}
+	k.Scopes = parseScopes(scopes)
 	return &k, nil
 }
 
-func (s *PostgresStore) GetActiveAPIKey(ctx context.Context, partnerID uuid.UUID) (*APIKey, error) {
-	var apiKey APIKey
-	err := s.db.QueryRowContext(ctx, `
-		SELECT id, partner_id, public_key, label, created_at, revoked, revoked_at
+func (s *PostgresStore) GetAPIKeyByPublicKey(ctx context.Context, publicKey string) (*APIKey, error) {
+	return scanAPIKey(s.db.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE public_key = $1 AND revoked = false
+	`, publicKey))
+}
+
+// GetAPIKey loads one of the partner's keys by the ID clients send in
+// X-Key-Id. Revoked and expired keys are returned too; callers check Active.
+func (s *PostgresStore) GetAPIKey(ctx context.Context, partnerID, keyID uuid.UUID) (*APIKey, error) {
+	return scanAPIKey(s.db.QueryRowContext(ctx, `
+		SELECT `+apiKeyColumns+`
+		FROM api_keys
+		WHERE id = $1 AND partner_id = $2
+	`, keyID, partnerID))
+}
+
+// GetActiveAPIKeys returns the partner's unrevoked keys whose overlap
+// window is still open, newest first
+func (s *PostgresStore) GetActiveAPIKeys(ctx context.Context, partnerID uuid.UUID) ([]*APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+apiKeyColumns+`
 		FROM api_keys
 		WHERE partner_id = $1 AND revoked = false
+		  AND (not_after IS NULL OR not_after > now())
 		ORDER BY created_at DESC
-		LIMIT 1
-	`, partnerID).Scan(&apiKey.ID, &apiKey.PartnerID, &apiKey.PublicKey, &apiKey.Label, &apiKey.CreatedAt, &apiKey.Revoked, &apiKey.RevokedAt)
+	`, partnerID)
 	if err != nil {
 		return nil, err
 	}
-	return &apiKey, nil
-}
+	defer rows.Close()
 
-func (s *PostgresStore) CheckRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID) (bool, error) {
-	var exists bool
-	err := s.db.QueryRowContext(ctx, `
-		SELECT EXISTS(SELECT 1 FROM request_ids WHERE request_id = $1 AND partner_id = $2)
-	`, requestID, partnerID).Scan(&exists)
-	return exists, err
+	var keys []*APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
 }
 
-func (s *PostgresStore) StoreRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID, expiresAt time.Time) error {
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO request_ids (request_id, partner_id, timestamp, expires_at)
-		VALUES ($1, $2, $3, $4)
-	`, requestID, partnerID, time.Now(), expiresAt)
-	return err
-}
+// CreateAPIKey registers a new public key for the partner. Existing keys
+// stay active, so clients can switch over at their own pace. A key without
+// scopes may call every partner endpoint.
+func (s *PostgresStore) CreateAPIKey(ctx context.Context, actor string, partnerID uuid.UUID, publicKey, label string, scopes []string) (*APIKey, error) {
+	if err := validatePublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
 
-func (s *PostgresStore) StoreQuoteRegistry(ctx context.Context, registry *QuoteRegistry) error {
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO quote_registry (quote_hash, partner_id, route, amount_in, amount_out, router_bps, expires_at, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	`, registry.QuoteHash, registry.PartnerID, registry.Route, registry.AmountIn, registry.AmountOut, registry.RouterBps, registry.ExpiresAt, registry.CreatedAt)
-	return err
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	key, err := scanAPIKey(tx.QueryRowContext(ctx, `
+		INSERT INTO api_keys (partner_id, public_key, label, scopes)
+		VALUES ($1, $2, $3, string_to_array($4, ','))
+		RETURNING `+apiKeyColumns+`
+	`, partnerID, strings.ToLower(publicKey), label, scopesArg(scopes)))
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicatePublicKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = insertAudit(ctx, tx, actor, AuditKeyCreate, partnerID, &key.ID, map[string]fieldChange{
+		"public_key": {To: key.PublicKey},
+		"label":      {To: key.Label},
+		"scopes":     {To: key.Scopes},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
-func (s *PostgresStore) GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, month string) (*UsageResponse, error) {
-	rows, err := s.db.QueryContext(ctx, `
-		SELECT quote_hash, pair, amount_in, amount_out, fee_amount, tx_hash, ledger_index, ts
-		FROM usage_events
-		WHERE partner_id = $1 AND date_trunc('month', ts) = $2::date
-		ORDER BY ts DESC
-	`, partnerID, month+"-01")
+// RotateAPIKey registers a new key and schedules the old one to stop
+// verifying after overlap, in one transaction. The new key inherits the old
+// key's scopes unless scopes are given. The old key should be revoked with
+// RevokeAPIKey once clients have moved over.
+func (s *PostgresStore) RotateAPIKey(ctx context.Context, actor string, partnerID, oldKeyID uuid.UUID, publicKey, label string, scopes []string, overlap time.Duration) (*APIKey, error) {
+	if err := validatePublicKey(publicKey); err != nil {
+		return nil, err
+	}
+	scopes, err := normalizeScopes(scopes)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var details []UsageDetail
-	var totalVolume, totalFees string
-	var txCount int64
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-	for rows.Next() {
-		var d UsageDetail
-		var quoteHash []byte
-		if err := rows.Scan(&quoteHash, &d.Pair, &d.AmountIn, &d.AmountOut, &d.FeeAmount, &d.TxHash, &d.LedgerIndex, &d.Timestamp); err != nil {
-			return nil, err
-		}
-		d.QuoteHash = string(quoteHash)
-		details = append(details, d)
-		txCount++
+	var notAfter time.Time
+	var oldScopes string
+	err = tx.QueryRowContext(ctx, `
+		UPDATE api_keys
+		SET not_after = LEAST(COALESCE(not_after, 'infinity'), now() + $3 * interval '1 second')
+		WHERE id = $1 AND partner_id = $2 AND revoked = false
+		RETURNING not_after, COALESCE(array_to_string(scopes, ','), '')
+	`, oldKeyID, partnerID, overlap.Seconds()).Scan(&notAfter, &oldScopes)
+	if err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = parseScopes(oldScopes)
 	}
 
-	if err := rows.Err(); err != nil {
+	key, err := scanAPIKey(tx.QueryRowContext(ctx, `
+		INSERT INTO api_keys (partner_id, public_key, label, scopes)
+		VALUES ($1, $2, $3, string_to_array($4, ','))
+		RETURNING `+apiKeyColumns+`
+	`, partnerID, strings.ToLower(publicKey), label, scopesArg(scopes)))
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicatePublicKey
+	}
+	if err != nil {
 		return nil, err
 	}
 
-	err = s.db.QueryRowContext(ctx, `
-		SELECT COALESCE(SUM(amount_out), 0), COALESCE(SUM(fee_amount), 0)
-		FROM usage_events
-		WHERE partner_id = $1 AND date_trunc('month', ts) = $2::date
-	`, partnerID, month+"-01").Scan(&totalVolume, &totalFees)
+	err = insertAudit(ctx, tx, actor, AuditKeyRotate, partnerID, &key.ID, map[string]fieldChange{
+		"public_key":         {To: key.PublicKey},
+		"label":              {To: key.Label},
+		"scopes":             {To: key.Scopes},
+		"replaces":           {To: oldKeyID},
+		"replaced_not_after": {To: notAfter},
+	})
 	if err != nil {
 		return nil, err
 	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// RevokeAPIKey stops a key from verifying immediately and records when.
+// Revoking an already revoked key keeps the original revoked_at and is not
+// audited again.
+func (s *PostgresStore) RevokeAPIKey(ctx context.Context, actor string, partnerID, keyID uuid.UUID) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var revoked bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT revoked
+		FROM api_keys
+		WHERE id = $1 AND partner_id = $2
+		FOR UPDATE
+	`, keyID, partnerID).Scan(&revoked)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return nil
+	}
 
-	return &UsageResponse{
-		Month:       month,
-		TxCount:     txCount,
-		TotalVolume: totalVolume,
-		TotalFees:   totalFees,
-		Details:     details,
-	}, nil
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE api_keys
+		SET revoked = true, revoked_at = COALESCE(revoked_at, now())
+		WHERE id = $1 AND partner_id = $2
+	`, keyID, partnerID); err != nil {
+		return err
+	}
+
+	err = insertAudit(ctx, tx, actor, AuditKeyRevoke, partnerID, &keyID, map[string]fieldChange{
+		"revoked": {From: false, To: true},
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// isUniqueViolation reports whether err is a Postgres unique_violation
+func isUniqueViolation(err error) bool {
+	var state interface{ SQLState() string }
+	return errors.As(err, &state) && state.SQLState() == "23505"
+}
+
+func validatePublicKey(publicKey string) error {
+	b, err := hex.DecodeString(publicKey)
+	if err != nil || len(b) != ed25519.PublicKeySize {
+		return ErrInvalidPublicKey
+	}
+	return nil
+}
+
+func (s *PostgresStore) StoreRequestID(ctx context.Context, requestID uuid.UUID, partnerID uuid.UUID, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO request_ids (request_id, partner_id, timestamp, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, requestID, partnerID, time.Now(), expiresAt)
+	return err
+}
+
+func (s *PostgresStore) StoreQuoteRegistry(ctx context.Context, registry *QuoteRegistry) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO quote_registry (quote_hash, partner_id, route, amount_in, amount_out, router_bps, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, registry.QuoteHash, registry.PartnerID, registry.Route, registry.AmountIn, registry.AmountOut, registry.RouterBps, registry.ExpiresAt, registry.CreatedAt)
+	return err
 }
 
 func (s *PostgresStore) GetIndexerLag(ctx context.Context) (int, error) {
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+var apiKeyRowColumns = []string{"id", "partner_id", "public_key", "label", "created_at", "revoked", "revoked_at", "not_after", "scopes"}
+
+func TestPostgresStore_RotateAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := NewPostgresStore(db)
+	ctx := context.Background()
+	partnerID, oldKeyID, newKeyID := uuid.New(), uuid.New(), uuid.New()
+	publicKey := strings.Repeat("AB", 32)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE api_keys").
+		WithArgs(oldKeyID, partnerID, float64(86400)).
+		WillReturnRows(sqlmock.NewRows([]string{"not_after", "scopes"}).AddRow(time.Now().Add(24*time.Hour), "pairs,usage"))
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs(partnerID, strings.ToLower(publicKey), "Q3 key", "pairs,usage").
+		WillReturnRows(sqlmock.NewRows(apiKeyRowColumns).
+			AddRow(newKeyID, partnerID, strings.ToLower(publicKey), "Q3 key", time.Now(), false, nil, nil, "pairs,usage"))
+	mock.ExpectExec("INSERT INTO partner_audit").
+		WithArgs("ops@lucendex", AuditKeyRotate, partnerID, &newKeyID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Without scopes the new key inherits the old key's
+	key, err := store.RotateAPIKey(ctx, "ops@lucendex", partnerID, oldKeyID, publicKey, "Q3 key", nil, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("RotateAPIKey() error = %v", err)
+	}
+	if key.ID != newKeyID || !key.Active(time.Now()) {
+		t.Errorf("RotateAPIKey() = %+v, want the new active key", key)
+	}
+	if key.HasScope(ScopeQuote) || !key.HasScope(ScopeUsage) {
+		t.Errorf("RotateAPIKey() scopes = %v, want [pairs usage]", key.Scopes)
+	}
+
+	// An unknown or revoked old key rolls the new key back
+	mock.ExpectBegin()
+	mock.ExpectQuery("UPDATE api_keys").
+		WithArgs(oldKeyID, partnerID, float64(86400)).
+		WillReturnRows(sqlmock.NewRows([]string{"not_after", "scopes"}))
+	mock.ExpectRollback()
+
+	if _, err := store.RotateAPIKey(ctx, "ops@lucendex", partnerID, oldKeyID, publicKey, "Q3 key", nil, 24*time.Hour); err != sql.ErrNoRows {
+		t.Errorf("RotateAPIKey(revoked) error = %v, want sql.ErrNoRows", err)
+	}
+
+	if _, err := store.RotateAPIKey(ctx, "ops@lucendex", partnerID, oldKeyID, "abcd", "", nil, time.Hour); err != ErrInvalidPublicKey {
+		t.Errorf("RotateAPIKey(short key) error = %v, want ErrInvalidPublicKey", err)
+	}
+	if _, err := store.RotateAPIKey(ctx, "ops@lucendex", partnerID, oldKeyID, publicKey, "", []string{"quote", "admin"}, time.Hour); err != ErrInvalidScope {
+		t.Errorf("RotateAPIKey(unknown scope) error = %v, want ErrInvalidScope", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPostgresStore_RevokeAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := NewPostgresStore(db)
+	ctx := context.Background()
+	partnerID, keyID := uuid.New(), uuid.New()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revoked FROM api_keys").
+		WithArgs(keyID, partnerID).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(false))
+	mock.ExpectExec("UPDATE api_keys SET revoked = true, revoked_at").
+		WithArgs(keyID, partnerID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO partner_audit").
+		WithArgs("ops@lucendex", AuditKeyRevoke, partnerID, &keyID, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Revoking again changes nothing and writes no audit record
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revoked FROM api_keys").
+		WithArgs(keyID, partnerID).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(true))
+	mock.ExpectRollback()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT revoked FROM api_keys").
+		WithArgs(keyID, uuid.Nil).
+		WillReturnRows(sqlmock.NewRows([]string{"revoked"}))
+	mock.ExpectRollback()
+
+	if err := store.RevokeAPIKey(ctx, "ops@lucendex", partnerID, keyID); err != nil {
+		t.Errorf("RevokeAPIKey() error = %v", err)
+	}
+	if err := store.RevokeAPIKey(ctx, "ops@lucendex", partnerID, keyID); err != nil {
+		t.Errorf("RevokeAPIKey(revoked) error = %v", err)
+	}
+	if err := store.RevokeAPIKey(ctx, "ops@lucendex", uuid.Nil, keyID); err != sql.ErrNoRows {
+		t.Errorf("RevokeAPIKey(other partner) error = %v, want sql.ErrNoRows", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+var partnerRowColumns = []string{"id", "name", "plan", "router_bps", "status", "created_at", "updated_at"}
+
+func TestPostgresStore_UpdatePartner(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := NewPostgresStore(db)
+	ctx := context.Background()
+	partnerID := uuid.New()
+	now := time.Now()
+	suspended, plan := "suspended", "pro"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM partners WHERE id = \\$1 FOR UPDATE").
+		WithArgs(partnerID).
+		WillReturnRows(sqlmock.NewRows(partnerRowColumns).AddRow(partnerID, "Wallet", "pro", 20, "active", now, now))
+	mock.ExpectQuery("UPDATE partners").
+		WithArgs(partnerID, "Wallet", "pro", 20, "suspended").
+		WillReturnRows(sqlmock.NewRows(partnerRowColumns).AddRow(partnerID, "Wallet", "pro", 20, "suspended", now, now))
+	mock.ExpectExec("INSERT INTO partner_audit").
+		WithArgs("ops@lucendex", AuditPartnerUpdate, partnerID, nil, []byte(`{"status":{"from":"active","to":"suspended"}}`)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	// Only changed fields are recorded; the unchanged plan is left out
+	p, err := store.UpdatePartner(ctx, "ops@lucendex", partnerID, PartnerUpdate{Status: &suspended, Plan: &plan})
+	if err != nil {
+		t.Fatalf("UpdatePartner() error = %v", err)
+	}
+	if p.Status != "suspended" {
+		t.Errorf("UpdatePartner() status = %s, want suspended", p.Status)
+	}
+
+	// A no-op update writes nothing
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM partners WHERE id = \\$1 FOR UPDATE").
+		WithArgs(partnerID).
+		WillReturnRows(sqlmock.NewRows(partnerRowColumns).AddRow(partnerID, "Wallet", "pro", 20, "suspended", now, now))
+	mock.ExpectRollback()
+
+	if _, err := store.UpdatePartner(ctx, "ops@lucendex", partnerID, PartnerUpdate{Status: &suspended}); err != nil {
+		t.Errorf("UpdatePartner(no-op) error = %v", err)
+	}
+
+	badBps := 20000
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM partners WHERE id = \\$1 FOR UPDATE").
+		WithArgs(partnerID).
+		WillReturnRows(sqlmock.NewRows(partnerRowColumns).AddRow(partnerID, "Wallet", "pro", 20, "suspended", now, now))
+	mock.ExpectRollback()
+
+	if _, err := store.UpdatePartner(ctx, "ops@lucendex", partnerID, PartnerUpdate{RouterBps: &badBps}); err != ErrInvalidRouterBps {
+		t.Errorf("UpdatePartner(20000 bps) error = %v, want ErrInvalidRouterBps", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
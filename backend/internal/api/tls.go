@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidFingerprint   = errors.New("fingerprint must be a hex encoded SHA-256")
+	ErrInvalidCertificate   = errors.New("certificate must be PEM encoded")
+	ErrDuplicateCertificate = errors.New("certificate already bound")
+)
+
+// CertificateFingerprint is the lowercase hex SHA-256 of the certificate's
+// DER encoding, the form stored in partner_certificates
+func CertificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// PEMFingerprint fingerprints the first certificate in a PEM block
+func PEMFingerprint(data []byte) (string, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", ErrInvalidCertificate
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	return CertificateFingerprint(cert), nil
+}
+
+// NormalizeFingerprint accepts a fingerprint in either case, with or
+// without colon separators, and returns the stored form
+func NormalizeFingerprint(fingerprint string) (string, error) {
+	fingerprint = strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	b, err := hex.DecodeString(fingerprint)
+	if err != nil || len(b) != sha256.Size {
+		return "", ErrInvalidFingerprint
+	}
+	return fingerprint, nil
+}
+
+// clientCertFingerprint returns the fingerprint of the client certificate
+// the TLS listener verified against the partner CA, or "" if there is none
+func clientCertFingerprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return CertificateFingerprint(r.TLS.VerifiedChains[0][0])
+}
+
+// TLSReloader serves the partner API certificate and the partner CA from
+// files and picks up replacements without a restart. A failed reload keeps
+// the previous material.
+type TLSReloader struct {
+	certFile, keyFile, caFile string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime time.Time
+}
+
+// NewTLSReloader loads the server key pair and, when caFile is set, the CA
+// that signs partner client certificates
+func NewTLSReloader(certFile, keyFile, caFile string) (*TLSReloader, error) {
+	tr := &TLSReloader{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := tr.Reload(); err != nil {
+		return nil, err
+	}
+	return tr, nil
+}
+
+// Reload reads the files again. Connections already established keep the
+// material they were handshaked with.
+func (tr *TLSReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(tr.certFile, tr.keyFile)
+	if err != nil {
+		return fmt.Errorf("load key pair: %w", err)
+	}
+
+	var pool *x509.CertPool
+	if tr.caFile != "" {
+		caPEM, err := os.ReadFile(tr.caFile)
+		if err != nil {
+			return fmt.Errorf("read client CA: %w", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return fmt.Errorf("no certificates in %s", tr.caFile)
+		}
+	}
+
+	modTime := tr.latestModTime()
+	tr.mu.Lock()
+	tr.cert, tr.pool, tr.modTime = &cert, pool, modTime
+	tr.mu.Unlock()
+	return nil
+}
+
+// TLSConfig returns a config that resolves the current material on every
+// handshake. Client certificates are verified when offered and optional
+// otherwise; AuthMiddleware decides whether a partner needs one.
+func (tr *TLSReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			tr.mu.RLock()
+			defer tr.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*tr.cert},
+			}
+			if tr.pool != nil {
+				cfg.ClientCAs = tr.pool
+				cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// Watch reloads whenever one of the files changes, checking every interval
+// until ctx is done
+func (tr *TLSReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tr.mu.RLock()
+			loaded := tr.modTime
+			tr.mu.RUnlock()
+			if !tr.latestModTime().After(loaded) {
+				continue
+			}
+			if err := tr.Reload(); err != nil {
+				log.Printf("tls reload failed, keeping previous certificates: %v", err)
+				continue
+			}
+			log.Printf("reloaded tls certificates")
+		}
+	}
+}
+
+func (tr *TLSReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, f := range []string{tr.certFile, tr.keyFile, tr.caFile} {
+		if f == "" {
+			continue
+		}
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeSelfSigned writes a fresh self-signed certificate and key and
+// returns the certificate PEM
+func writeSelfSigned(t *testing.T, certFile, keyFile, cn string) []byte {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func servedCommonName(t *testing.T, tr *TLSReloader) string {
+	t.Helper()
+	cfg, err := tr.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cfg.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestTLSReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key")
+	caFile := filepath.Join(dir, "ca.pem")
+
+	writeSelfSigned(t, certFile, keyFile, "api-v1")
+	caPEM := writeSelfSigned(t, caFile, filepath.Join(dir, "ca.key"), "partner-ca")
+
+	tr, err := NewTLSReloader(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewTLSReloader() error = %v", err)
+	}
+	cfg, _ := tr.TLSConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+	if cfg.ClientAuth != tls.VerifyClientCertIfGiven || cfg.ClientCAs == nil {
+		t.Errorf("client auth = %v, want optional verification against the partner CA", cfg.ClientAuth)
+	}
+	if got := servedCommonName(t, tr); got != "api-v1" {
+		t.Errorf("served %s, want api-v1", got)
+	}
+
+	writeSelfSigned(t, certFile, keyFile, "api-v2")
+	if err := tr.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := servedCommonName(t, tr); got != "api-v2" {
+		t.Errorf("served %s after reload, want api-v2", got)
+	}
+
+	// A broken replacement keeps the previous certificate
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	if err := tr.Reload(); err == nil {
+		t.Error("Reload(broken key) succeeded")
+	}
+	if got := servedCommonName(t, tr); got != "api-v2" {
+		t.Errorf("served %s after failed reload, want api-v2", got)
+	}
+
+	fingerprint, err := PEMFingerprint(caPEM)
+	if err != nil {
+		t.Fatalf("PEMFingerprint() error = %v", err)
+	}
+	if normalized, err := NormalizeFingerprint(fingerprint); err != nil || normalized != fingerprint {
+		t.Errorf("NormalizeFingerprint(%s) = %s, %v", fingerprint, normalized, err)
+	}
+}
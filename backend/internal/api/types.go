@@ -1,6 +1,7 @@
 package api
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,15 +15,19 @@ type QuoteRequest struct {
 	Amount string `json:"amount"`
 }
 
+// UsageQueryParams filters usage events to [From, To)
 type UsageQueryParams struct {
-	Month string `json:"month"` // YYYY-MM format
-	Limit int    `json:"limit"`
-	Offset int   `json:"offset"`
+	From   time.Time
+	To     time.Time
+	Pair   string // "IN-OUT" as recorded by the indexer
+	TxHash string
+	Limit  int
+	Cursor *UsageCursor // continue after this event
 }
 
 // Response types
 type QuoteResponse struct {
-	QuoteHash   string          `json:"quote_hash"`
+	QuoteHash   string          `json:"quote_hash,omitempty"` // absent on indicative quotes
 	Route       RouteResponse   `json:"route"`
 	AmountOut   string          `json:"amount_out"`
 	Price       string          `json:"price"`
@@ -30,6 +35,7 @@ type QuoteResponse struct {
 	LedgerIndex uint32          `json:"ledger_index"`
 	TTL         uint16          `json:"ttl_ledgers"`
 	ExpiresAt   string          `json:"expires_at"`
+	Indicative  bool            `json:"indicative,omitempty"` // public quote, not submittable
 }
 
 type RouteResponse struct {
@@ -38,48 +44,138 @@ type RouteResponse struct {
 }
 
 type HopResponse struct {
-	Type      string `json:"type"`
-	In        string `json:"in"`
-	Out       string `json:"out"`
-	AmountIn  string `json:"amount_in"`
-	AmountOut string `json:"amount_out"`
+	Type        string `json:"type"`
+	In          string `json:"in"`
+	Out         string `json:"out"`
+	AmountIn    string `json:"amount_in"`
+	AmountOut   string `json:"amount_out"`
+	TransferFee string `json:"transfer_fee,omitempty"`
 }
 
 type FeesResponse struct {
-	RouterBps   int    `json:"router_bps"`
-	TradingFees string `json:"trading_fees"`
-	EstOutFee   string `json:"est_out_fee"`
+	RouterBps    int    `json:"router_bps"`
+	TradingFees  string `json:"trading_fees"`
+	TransferFees string `json:"transfer_fees"`
+	EstOutFee    string `json:"est_out_fee"`
 }
 
 type PairsResponse struct {
-	Pairs []TradingPair `json:"pairs"`
+	Pairs          []TradingPair `json:"pairs"`
+	ReferenceAsset string        `json:"reference_asset"` // liquidity and volume are valued in it
+	LedgerIndex    uint32        `json:"ledger_index"`
 }
 
+// TradingPair prices are out per in. The spread and best prices are
+// omitted when that side of the market is empty.
 type TradingPair struct {
 	In          string `json:"in"`
 	Out         string `json:"out"`
 	Liquidity   string `json:"liquidity"`
-	AvgSpread   string `json:"avg_spread"`
+	AvgSpread   string `json:"avg_spread,omitempty"`
 	DailyVolume string `json:"daily_volume"`
+	BestBid     string `json:"best_bid,omitempty"`
+	BestAsk     string `json:"best_ask,omitempty"`
+}
+
+// OrderbookResponse is the aggregated book for one pair. Prices are quote
+// per base and sizes are in base.
+type OrderbookResponse struct {
+	Base            string           `json:"base"`
+	Quote           string           `json:"quote"`
+	Bids            []OrderbookLevel `json:"bids"`
+	Asks            []OrderbookLevel `json:"asks"`
+	LedgerIndex     uint32           `json:"ledger_index"`      // ledger quotes are generated at
+	BookLedgerIndex uint32           `json:"book_ledger_index"` // newest ledger reflected in the levels
 }
 
+type OrderbookLevel struct {
+	Price      string `json:"price"`
+	Size       string `json:"size"`
+	Cumulative string `json:"cumulative"`
+	Offers     int    `json:"offers"`
+	AMMSize    string `json:"amm_size,omitempty"` // virtual AMM liquidity included in size
+}
+
+// UsageResponse is one page of usage. Counts and totals cover every event
+// matching the filter, not just the page. Amounts are in the indexer's
+// units, XRP in drops; USD figures are only present with convert=usd.
 type UsageResponse struct {
-	Month       string        `json:"month"`
-	TxCount     int64         `json:"tx_count"`
-	TotalVolume string        `json:"total_volume"`
-	TotalFees   string        `json:"total_fees_usd"`
-	Details     []UsageDetail `json:"details"`
+	Month          string        `json:"month,omitempty"`
+	From           time.Time     `json:"from"`
+	To             time.Time     `json:"to"`
+	TxCount        int64         `json:"tx_count"`
+	Totals         []UsageTotal  `json:"totals"`
+	TotalVolumeUSD string        `json:"total_volume_usd,omitempty"`
+	TotalFeesUSD   string        `json:"total_fees_usd,omitempty"`
+	UnpricedCount  int64         `json:"unpriced_count,omitempty"` // events left out of the USD totals
+	Details        []UsageDetail `json:"details"`
+	NextCursor     string        `json:"next_cursor,omitempty"`
+}
+
+// UsageTotal sums one asset: volumes on the side it was traded, fees where
+// it was the out asset
+type UsageTotal struct {
+	Asset        string `json:"asset"`
+	VolumeIn     string `json:"volume_in"`
+	VolumeOut    string `json:"volume_out"`
+	Fees         string `json:"fees"`
+	VolumeInUSD  string `json:"volume_in_usd,omitempty"`
+	VolumeOutUSD string `json:"volume_out_usd,omitempty"`
+	FeesUSD      string `json:"fees_usd,omitempty"`
 }
 
 type UsageDetail struct {
 	QuoteHash   string    `json:"quote_hash"`
 	Pair        string    `json:"pair"`
+	InAsset     string    `json:"in_asset"`
+	OutAsset    string    `json:"out_asset"`
 	AmountIn    string    `json:"amount_in"`
 	AmountOut   string    `json:"amount_out"`
-	FeeAmount   string    `json:"fee_amount"`
+	FeeAmount   string    `json:"fee_amount"` // in out_asset
 	TxHash      string    `json:"tx_hash"`
 	LedgerIndex int64     `json:"ledger_index"`
 	Timestamp   time.Time `json:"timestamp"`
+	InUSDPrice  string    `json:"in_usd_price,omitempty"` // USD per whole unit when indexed
+	OutUSDPrice string    `json:"out_usd_price,omitempty"`
+}
+
+// Invoice is a closed month's routing fees for one partner. Plan terms and
+// the partner name are as they were when the month was closed. USD figures
+// are rounded to cents; fees without a reference price are listed on their
+// line but left out of the USD totals.
+type Invoice struct {
+	ID                   uuid.UUID     `json:"id"`
+	PartnerID            uuid.UUID     `json:"partner_id"`
+	PartnerName          string        `json:"partner_name"`
+	Month                string        `json:"month"`
+	PeriodStart          time.Time     `json:"period_start"`
+	PeriodEnd            time.Time     `json:"period_end"` // exclusive
+	Plan                 string        `json:"plan"`
+	MinimumUSD           string        `json:"minimum_usd"`
+	DiscountBps          int           `json:"discount_bps"`
+	TxCount              int64         `json:"tx_count"`
+	UnpricedCount        int64         `json:"unpriced_count"`
+	SubtotalUSD          string        `json:"subtotal_usd"`
+	DiscountUSD          string        `json:"discount_usd"`
+	MinimumAdjustmentUSD string        `json:"minimum_adjustment_usd"`
+	TotalUSD             string        `json:"total_usd"`
+	ClosedAt             time.Time     `json:"closed_at"`
+	Lines                []InvoiceLine `json:"lines"`
+}
+
+// InvoiceLine is one fee asset. Amounts are whole units, XRP in XRP.
+type InvoiceLine struct {
+	Asset         string `json:"asset"`
+	TxCount       int64  `json:"tx_count"`
+	Fees          string `json:"fees"`
+	PricedFees    string `json:"priced_fees"`
+	USDRate       string `json:"usd_rate,omitempty"` // effective USD per unit of priced_fees
+	FeesUSD       string `json:"fees_usd"`
+	UnpricedCount int64  `json:"unpriced_count"`
+}
+
+type InvoicesResponse struct {
+	Invoices []*Invoice `json:"invoices"`
 }
 
 type HealthResponse struct {
@@ -98,23 +194,71 @@ type ErrorResponse struct {
 
 // Database models
 type Partner struct {
-	ID        uuid.UUID `db:"id"`
-	Name      string    `db:"name"`
-	Plan      string    `db:"plan"`
-	RouterBps int       `db:"router_bps"`
-	Status    string    `db:"status"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID        uuid.UUID `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Plan      string    `db:"plan" json:"plan"`
+	RouterBps int       `db:"router_bps" json:"router_bps"`
+	Status    string    `db:"status" json:"status"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
 }
 
 type APIKey struct {
-	ID         uuid.UUID  `db:"id"`
-	PartnerID  uuid.UUID  `db:"partner_id"`
-	PublicKey  string     `db:"public_key"`
-	Label      string     `db:"label"`
-	CreatedAt  time.Time  `db:"created_at"`
-	Revoked    bool       `db:"revoked"`
-	RevokedAt  *time.Time `db:"revoked_at"`
+	ID         uuid.UUID  `db:"id" json:"id"`
+	PartnerID  uuid.UUID  `db:"partner_id" json:"partner_id"`
+	PublicKey  string     `db:"public_key" json:"public_key"`
+	Label      string     `db:"label" json:"label"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	Revoked    bool       `db:"revoked" json:"revoked"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	NotAfter   *time.Time `db:"not_after" json:"not_after,omitempty"`
+	Scopes     []string   `db:"scopes" json:"scopes,omitempty"`
+}
+
+// Active reports whether the key may sign requests at now. A rotated-out
+// key stays active until its not_after overlap window closes.
+func (k *APIKey) Active(now time.Time) bool {
+	if k.Revoked {
+		return false
+	}
+	return k.NotAfter == nil || now.Before(*k.NotAfter)
+}
+
+// HasScope reports whether the key may call endpoints requiring scope. A
+// key without scopes predates scoping and may call every endpoint.
+func (k *APIKey) HasScope(scope string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// PartnerCertificate binds a client certificate, by the SHA-256
+// fingerprint of its DER encoding, to a partner
+type PartnerCertificate struct {
+	ID          uuid.UUID  `db:"id" json:"id"`
+	PartnerID   uuid.UUID  `db:"partner_id" json:"partner_id"`
+	Fingerprint string     `db:"fingerprint" json:"fingerprint"`
+	Label       string     `db:"label" json:"label"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	Revoked     bool       `db:"revoked" json:"revoked"`
+	RevokedAt   *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
+
+// AuditEvent is one partner_audit row
+type AuditEvent struct {
+	ID        int64           `db:"id" json:"id"`
+	Actor     string          `db:"actor" json:"actor"`
+	Action    string          `db:"action" json:"action"`
+	PartnerID uuid.UUID       `db:"partner_id" json:"partner_id"`
+	APIKeyID  *uuid.UUID      `db:"api_key_id" json:"api_key_id,omitempty"`
+	Changes   json.RawMessage `db:"changes" json:"changes"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
 }
 
 type QuoteRegistry struct {
@@ -142,10 +286,33 @@ type UsageEvent struct {
 	Timestamp   time.Time       `db:"ts"`
 }
 
+// BillingTerms are a partner's plan terms, read from billing_plans
+type BillingTerms struct {
+	PartnerName string
+	Plan        string
+	MinimumUSD  decimal.Decimal
+	DiscountBps int
+}
+
+// AssetFees sums a period's routing fees in one asset, in the indexer's
+// units. FeesUSD covers PricedFees only.
+type AssetFees struct {
+	Asset         string
+	TxCount       int64
+	Fees          decimal.Decimal
+	PricedFees    decimal.Decimal
+	FeesUSD       decimal.Decimal
+	UnpricedCount int64
+}
+
 // Context keys
 type contextKey string
 
 const (
 	ContextKeyPartnerID contextKey = "partner_id"
 	ContextKeyPartner   contextKey = "partner"
+	ContextKeyAPIKeyID  contextKey = "api_key_id"
+	ContextKeyCertID    contextKey = "certificate_id"
+
+	ContextKeyAdminActor contextKey = "admin_actor"
 )
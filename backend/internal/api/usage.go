@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Usage query limits
+const (
+	DefaultUsageLimit = 100
+	MaxUsageLimit     = 1000
+	MaxUsageRange     = 366 * 24 * time.Hour
+
+	// usageFlushRows is how many CSV rows are written between flushes
+	usageFlushRows = 500
+
+	// usageWriteTimeout is how long an export may go without a flush. It
+	// replaces the server's write timeout, which would cut off a long one.
+	usageWriteTimeout = 30 * time.Second
+)
+
+// usageStore is what serveUsage reads, shared by the partner and admin APIs
+type usageStore interface {
+	GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error)
+	StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error
+}
+
+// UsageCursor is the position of the last event of a page
+type UsageCursor struct {
+	Timestamp time.Time
+	ID        int64
+}
+
+// Encode returns the opaque next_cursor form
+func (c UsageCursor) Encode() string {
+	raw := strconv.FormatInt(c.Timestamp.UnixNano(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeUsageCursor(s string) (*UsageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	c := &UsageCursor{Timestamp: time.Unix(0, nanos).UTC()}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return c, nil
+}
+
+// UsageHandler handles GET /partner/v1/usage
+func (h *Handlers) UsageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	partnerID := r.Context().Value(ContextKeyPartnerID).(uuid.UUID)
+	serveUsage(w, r, h.db, partnerID)
+}
+
+// serveUsage answers a usage query for partnerID: a page of events with
+// totals as JSON, or with format=csv every matching event streamed as CSV.
+//
+// The period is month=YYYY-MM (the current month by default) or from and
+// to, each a date or RFC 3339 time, to exclusive. pair and tx_hash narrow
+// it further; limit and cursor page through JSON results. convert=usd adds
+// USD totals at the reference prices recorded when each trade was indexed.
+func serveUsage(w http.ResponseWriter, r *http.Request, store usageStore, partnerID uuid.UUID) {
+	q, month, convertUSD, err := parseUsageQuery(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+	case "csv":
+		streamUsageCSV(w, r, store, partnerID, q)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+
+	usage, err := store.GetPartnerUsage(r.Context(), partnerID, q)
+	if err != nil {
+		log.Printf("usage for %s: %v", partnerID, err)
+		writeError(w, http.StatusInternalServerError, "failed to fetch usage")
+		return
+	}
+	usage.Month = month
+	if !convertUSD {
+		usage.TotalVolumeUSD, usage.TotalFeesUSD, usage.UnpricedCount = "", "", 0
+		for i := range usage.Totals {
+			usage.Totals[i].VolumeInUSD, usage.Totals[i].VolumeOutUSD, usage.Totals[i].FeesUSD = "", "", ""
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(usage)
+}
+
+func parseUsageQuery(r *http.Request) (q UsageQueryParams, month string, convertUSD bool, err error) {
+	v := r.URL.Query()
+
+	from, to := v.Get("from"), v.Get("to")
+	switch {
+	case from != "" || to != "":
+		if from == "" || to == "" || v.Get("month") != "" {
+			return q, "", false, errors.New("give month, or both from and to")
+		}
+		if q.From, err = parseUsageTime(from); err != nil {
+			return q, "", false, fmt.Errorf("from: %w", err)
+		}
+		if q.To, err = parseUsageTime(to); err != nil {
+			return q, "", false, fmt.Errorf("to: %w", err)
+		}
+		if !q.To.After(q.From) {
+			return q, "", false, errors.New("to must be after from")
+		}
+		if q.To.Sub(q.From) > MaxUsageRange {
+			return q, "", false, errors.New("range must not exceed 366 days")
+		}
+	default:
+		month = v.Get("month")
+		if month == "" {
+			month = time.Now().UTC().Format("2006-01")
+		}
+		start, err := time.Parse("2006-01", month)
+		if err != nil {
+			return q, "", false, errors.New("invalid month format (use YYYY-MM)")
+		}
+		q.From, q.To = start, start.AddDate(0, 1, 0)
+	}
+
+	if pair := v.Get("pair"); pair != "" {
+		// Asset strings have no hyphen, so the first one splits the pair
+		in, out, ok := strings.Cut(pair, "-")
+		if !ok {
+			return q, "", false, errors.New("pair must be IN-OUT, e.g. XRP-USD.rIssuer")
+		}
+		inAsset, err := parseAsset(in)
+		if err != nil {
+			return q, "", false, fmt.Errorf("pair: %w", err)
+		}
+		outAsset, err := parseAsset(out)
+		if err != nil {
+			return q, "", false, fmt.Errorf("pair: %w", err)
+		}
+		q.Pair = inAsset.String() + "-" + outAsset.String()
+	}
+
+	if txHash := v.Get("tx_hash"); txHash != "" {
+		if b, err := hex.DecodeString(txHash); err != nil || len(b) != 32 {
+			return q, "", false, errors.New("tx_hash must be 64 hex characters")
+		}
+		q.TxHash = strings.ToUpper(txHash)
+	}
+
+	q.Limit = DefaultUsageLimit
+	if s := v.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 1 || q.Limit > MaxUsageLimit {
+			return q, "", false, fmt.Errorf("limit must be between 1 and %d", MaxUsageLimit)
+		}
+	}
+	if s := v.Get("cursor"); s != "" {
+		if q.Cursor, err = decodeUsageCursor(s); err != nil {
+			return q, "", false, err
+		}
+	}
+
+	switch v.Get("convert") {
+	case "":
+	case "usd":
+		convertUSD = true
+	default:
+		return q, "", false, errors.New("convert must be usd")
+	}
+	return q, month, convertUSD, nil
+}
+
+// parseUsageTime accepts a date, meaning its start in UTC, or an RFC 3339
+// time
+func parseUsageTime(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("use YYYY-MM-DD or RFC 3339")
+	}
+	return t.UTC(), nil
+}
+
+var usageCSVHeader = []string{
+	"timestamp", "tx_hash", "ledger_index", "quote_hash", "pair",
+	"in_asset", "amount_in", "out_asset", "amount_out", "fee_amount",
+	"in_usd_price", "out_usd_price", "fee_usd",
+}
+
+// streamUsageCSV writes every matching event as it is read, so exports of
+// any size hold one row in memory. Amounts are whole units, unlike the JSON
+// API, so the file can be summed directly. Once rows have been sent an
+// error can only end the response early; it is logged.
+func streamUsageCSV(w http.ResponseWriter, r *http.Request, store usageStore, partnerID uuid.UUID, q UsageQueryParams) {
+	filename := fmt.Sprintf("usage-%s-%s.csv", q.From.Format("20060102"), q.To.Format("20060102"))
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// Keep long exports alive past the server's write timeout, including a
+	// slow query that has yet to return its first rows
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(usageWriteTimeout))
+	cw := csv.NewWriter(w)
+	cw.Write(usageCSVHeader)
+
+	rows := 0
+	err := store.StreamPartnerUsage(r.Context(), partnerID, q, func(d *UsageDetail) error {
+		cw.Write(usageCSVRecord(d))
+		if rows++; rows%usageFlushRows == 0 {
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			_ = rc.SetWriteDeadline(time.Now().Add(usageWriteTimeout))
+			_ = rc.Flush()
+		}
+		return nil
+	})
+	cw.Flush()
+	if err == nil {
+		err = cw.Error()
+	}
+	if err != nil {
+		log.Printf("usage export for %s stopped after %d rows: %v", partnerID, rows, err)
+	}
+}
+
+func usageCSVRecord(d *UsageDetail) []string {
+	feeUSD := ""
+	fee := wholeUnits(d.OutAsset, d.FeeAmount)
+	if price, err := decimal.NewFromString(d.OutUSDPrice); err == nil {
+		if f, err := decimal.NewFromString(fee); err == nil {
+			feeUSD = f.Mul(price).Round(6).String()
+		}
+	}
+	return []string{
+		d.Timestamp.UTC().Format(time.RFC3339),
+		d.TxHash,
+		strconv.FormatInt(d.LedgerIndex, 10),
+		d.QuoteHash,
+		d.Pair,
+		d.InAsset,
+		wholeUnits(d.InAsset, d.AmountIn),
+		d.OutAsset,
+		wholeUnits(d.OutAsset, d.AmountOut),
+		fee,
+		d.InUSDPrice,
+		d.OutUSDPrice,
+		feeUSD,
+	}
+}
+
+// wholeUnits converts an indexed amount, XRP in drops, to whole units
+func wholeUnits(asset, amount string) string {
+	v, err := decimal.NewFromString(amount)
+	if err != nil {
+		return amount
+	}
+	return v.Shift(-assetScale(asset)).String()
+}
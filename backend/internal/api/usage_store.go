@@ -0,0 +1,190 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+const usageColumns = `id, quote_hash, pair, in_asset, out_asset, amount_in::TEXT, amount_out::TEXT, fee_amount::TEXT,
+	tx_hash, ledger_index, ts, COALESCE(in_usd_price::TEXT, ''), COALESCE(out_usd_price::TEXT, '')`
+
+// usageWhere builds the filter shared by the page, totals and export
+// queries. The cursor only applies when withCursor is set.
+func usageWhere(partnerID uuid.UUID, q UsageQueryParams, withCursor bool) (string, []any) {
+	conds := []string{"partner_id = $1", "ts >= $2", "ts < $3"}
+	args := []any{partnerID, q.From, q.To}
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "$?", "$"+strconv.Itoa(len(args))))
+	}
+	if q.Pair != "" {
+		add("pair = $?", q.Pair)
+	}
+	if q.TxHash != "" {
+		add("tx_hash = $?", q.TxHash)
+	}
+	if withCursor && q.Cursor != nil {
+		args = append(args, q.Cursor.Timestamp, q.Cursor.ID)
+		conds = append(conds, fmt.Sprintf("(ts, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+// GetPartnerUsage returns one page of the partner's usage events, newest
+// first, with totals per asset over every event matching the filter
+func (s *PostgresStore) GetPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams) (*UsageResponse, error) {
+	where, args := usageWhere(partnerID, q, true)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+usageColumns+`
+		FROM usage_events
+		WHERE `+where+`
+		ORDER BY ts DESC, id DESC
+		LIMIT `+strconv.Itoa(q.Limit+1), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resp := &UsageResponse{From: q.From, To: q.To, Details: []UsageDetail{}}
+	var last UsageCursor
+	for rows.Next() {
+		d, cursor, err := scanUsageDetail(rows)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp.Details) == q.Limit {
+			resp.NextCursor = last.Encode()
+			break
+		}
+		resp.Details = append(resp.Details, *d)
+		last = cursor
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := s.usageTotals(ctx, partnerID, q, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// StreamPartnerUsage calls fn for every event matching the filter, newest
+// first, ignoring the limit and cursor
+func (s *PostgresStore) StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error {
+	where, args := usageWhere(partnerID, q, false)
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+usageColumns+`
+		FROM usage_events
+		WHERE `+where+`
+		ORDER BY ts DESC, id DESC
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d, _, err := scanUsageDetail(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func scanUsageDetail(rows *sql.Rows) (*UsageDetail, UsageCursor, error) {
+	var d UsageDetail
+	var cursor UsageCursor
+	var quoteHash []byte
+	err := rows.Scan(&cursor.ID, &quoteHash, &d.Pair, &d.InAsset, &d.OutAsset, &d.AmountIn, &d.AmountOut, &d.FeeAmount,
+		&d.TxHash, &d.LedgerIndex, &d.Timestamp, &d.InUSDPrice, &d.OutUSDPrice)
+	if err != nil {
+		return nil, cursor, err
+	}
+	d.QuoteHash = hex.EncodeToString(quoteHash)
+	cursor.Timestamp = d.Timestamp
+	return &d, cursor, nil
+}
+
+// usageTotals fills the count and per-asset totals. Volumes count an asset
+// on the side it was traded; fees are in the out asset. USD figures use the
+// prices recorded when each trade was indexed, rounded to 6 places. The USD
+// totals count each trade once, by its out side; trades without an out
+// price are counted in UnpricedCount and left out.
+func (s *PostgresStore) usageTotals(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, resp *UsageResponse) error {
+	where, args := usageWhere(partnerID, q, false)
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE out_usd_price IS NULL)
+		FROM usage_events
+		WHERE `+where, args...).Scan(&resp.TxCount, &resp.UnpricedCount)
+	if err != nil {
+		return err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH ev AS (
+			SELECT * FROM usage_events WHERE `+where+`
+		)
+		SELECT asset,
+		       SUM(volume_in)::TEXT, SUM(volume_out)::TEXT, SUM(fees)::TEXT,
+		       ROUND(COALESCE(SUM(volume_in_usd), 0), 6)::TEXT,
+		       ROUND(COALESCE(SUM(volume_out_usd), 0), 6)::TEXT,
+		       ROUND(COALESCE(SUM(fees_usd), 0), 6)::TEXT
+		FROM (
+			SELECT in_asset AS asset, amount_in AS volume_in, 0::NUMERIC AS volume_out, 0::NUMERIC AS fees,
+			       amount_in * in_usd_price / CASE WHEN in_asset = 'XRP' THEN 1000000 ELSE 1 END AS volume_in_usd,
+			       NULL::NUMERIC AS volume_out_usd, NULL::NUMERIC AS fees_usd
+			FROM ev
+			UNION ALL
+			SELECT out_asset, 0, amount_out, fee_amount,
+			       NULL,
+			       amount_out * out_usd_price / CASE WHEN out_asset = 'XRP' THEN 1000000 ELSE 1 END,
+			       fee_amount * out_usd_price / CASE WHEN out_asset = 'XRP' THEN 1000000 ELSE 1 END
+			FROM ev
+		) sides
+		GROUP BY asset
+		ORDER BY asset
+	`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	resp.Totals = []UsageTotal{}
+	volumeUSD, feesUSD := decimal.Zero, decimal.Zero
+	for rows.Next() {
+		var t UsageTotal
+		if err := rows.Scan(&t.Asset, &t.VolumeIn, &t.VolumeOut, &t.Fees, &t.VolumeInUSD, &t.VolumeOutUSD, &t.FeesUSD); err != nil {
+			return err
+		}
+		out, err := decimal.NewFromString(t.VolumeOutUSD)
+		if err != nil {
+			return fmt.Errorf("usage volume %q: %w", t.VolumeOutUSD, err)
+		}
+		fees, err := decimal.NewFromString(t.FeesUSD)
+		if err != nil {
+			return fmt.Errorf("usage fees %q: %w", t.FeesUSD, err)
+		}
+		volumeUSD, feesUSD = volumeUSD.Add(out), feesUSD.Add(fees)
+		resp.Totals = append(resp.Totals, t)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	resp.TotalVolumeUSD = volumeUSD.String()
+	resp.TotalFeesUSD = feesUSD.String()
+	return nil
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+)
+
+const testTxHash = "E3FE6EA3D48F0C2B639448020EA4F03D4F4F8FFDB243A852A0F59177921B4879"
+
+func usageRequest(t *testing.T, h *Handlers, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/partner/v1/usage?"+query, nil)
+	req = req.WithContext(context.WithValue(req.Context(), ContextKeyPartnerID, uuid.New()))
+	rec := httptest.NewRecorder()
+	h.UsageHandler(rec, req)
+	return rec
+}
+
+func TestUsageHandler_Query(t *testing.T) {
+	db := &mockDB{}
+	h := newTestHandlers(t, db)
+
+	rec := usageRequest(t, h, "month=2025-11&pair=XRP-USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B&tx_hash="+strings.ToLower(testTxHash)+"&limit=50")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	q := db.usageQ
+	if !q.From.Equal(time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)) || !q.To.Equal(time.Date(2025, 12, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("period = %v to %v, want November", q.From, q.To)
+	}
+	if q.Pair != "XRP-USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B" || q.TxHash != testTxHash || q.Limit != 50 {
+		t.Errorf("query = %+v", q)
+	}
+	var resp UsageResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Month != "2025-11" || resp.TotalVolumeUSD != "" || resp.Totals[0].VolumeInUSD != "" {
+		t.Errorf("usage = %+v, want no USD figures without convert", resp)
+	}
+
+	rec = usageRequest(t, h, "from=2025-11-15&to=2025-11-20T12:00:00Z&convert=usd")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !db.usageQ.To.Equal(time.Date(2025, 11, 20, 12, 0, 0, 0, time.UTC)) || db.usageQ.Limit != DefaultUsageLimit {
+		t.Errorf("query = %+v", db.usageQ)
+	}
+	resp = UsageResponse{}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.TotalVolumeUSD != "2" || resp.Totals[0].VolumeInUSD != "2" {
+		t.Errorf("usage = %+v, want USD figures with convert=usd", resp)
+	}
+
+	for _, query := range []string{
+		"month=2025-13",
+		"from=2025-11-01",
+		"month=2025-11&from=2025-11-01&to=2025-11-02",
+		"from=2025-11-02&to=2025-11-01",
+		"from=2024-01-01&to=2025-06-01",
+		"pair=XRP",
+		"pair=XRP-USD",
+		"tx_hash=abc",
+		"limit=0",
+		"cursor=bm9wZQ",
+		"convert=eur",
+		"format=xml",
+	} {
+		if rec := usageRequest(t, h, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestUsageHandler_Cursor(t *testing.T) {
+	ts := time.Date(2025, 11, 3, 10, 0, 0, 123, time.UTC)
+	db := &mockDB{usage: []UsageDetail{{Timestamp: ts}, {Timestamp: ts}, {Timestamp: ts}}}
+	h := newTestHandlers(t, db)
+
+	var resp UsageResponse
+	json.NewDecoder(usageRequest(t, h, "month=2025-11&limit=2").Body).Decode(&resp)
+	if len(resp.Details) != 2 || resp.NextCursor == "" {
+		t.Fatalf("page = %+v, want two events and a cursor", resp)
+	}
+
+	usageRequest(t, h, "month=2025-11&limit=2&cursor="+resp.NextCursor)
+	if c := db.usageQ.Cursor; c == nil || !c.Timestamp.Equal(ts) || c.ID != 2 {
+		t.Errorf("cursor = %+v, want the last event of the page", c)
+	}
+}
+
+func TestUsageHandler_CSV(t *testing.T) {
+	db := &mockDB{usage: []UsageDetail{{
+		Timestamp:   time.Date(2025, 11, 3, 10, 0, 0, 0, time.UTC),
+		TxHash:      testTxHash,
+		LedgerIndex: 100,
+		QuoteHash:   "ab",
+		Pair:        "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B-XRP",
+		InAsset:     "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
+		OutAsset:    "XRP",
+		AmountIn:    "25",
+		AmountOut:   "10000000",
+		FeeAmount:   "20000",
+		OutUSDPrice: "2.5",
+	}}}
+	h := newTestHandlers(t, db)
+
+	rec := usageRequest(t, h, "month=2025-11&format=csv&limit=1&cursor="+UsageCursor{ID: 1}.Encode())
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("status = %d, content type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "usage-20251101-20251201.csv") {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid csv: %v", err)
+	}
+	if len(records) != 2 || records[0][0] != "timestamp" {
+		t.Fatalf("records = %v, want header and one row", records)
+	}
+	row := records[1]
+	// XRP in whole units; the 0.02 XRP fee is worth 0.05 USD
+	if row[8] != "10" || row[9] != "0.02" || row[12] != "0.05" || row[10] != "" {
+		t.Errorf("row = %v", row)
+	}
+}
+
+// slowUsageStore takes delay before returning its first row, like a
+// filtered export query scanning a large table
+type slowUsageStore struct {
+	*mockDB
+	delay time.Duration
+}
+
+func (s *slowUsageStore) StreamPartnerUsage(ctx context.Context, partnerID uuid.UUID, q UsageQueryParams, fn func(*UsageDetail) error) error {
+	time.Sleep(s.delay)
+	return s.mockDB.StreamPartnerUsage(ctx, partnerID, q, fn)
+}
+
+func TestStreamUsageCSV_SlowQueryOutlivesWriteTimeout(t *testing.T) {
+	store := &slowUsageStore{mockDB: &mockDB{usage: []UsageDetail{{TxHash: testTxHash, InAsset: "XRP", OutAsset: "XRP"}}}, delay: 300 * time.Millisecond}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		streamUsageCSV(w, r, store, uuid.New(), UsageQueryParams{})
+	}))
+	srv.Config.WriteTimeout = 100 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+	records, err := csv.NewReader(resp.Body).ReadAll()
+	if err != nil || len(records) != 2 || records[1][1] != testTxHash {
+		t.Errorf("records = %v, %v; want header and one row", records, err)
+	}
+}
+
+func TestPostgresStore_GetPartnerUsage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	partnerID := uuid.New()
+	from := time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)
+	cursor := &UsageCursor{Timestamp: from.Add(48 * time.Hour), ID: 90}
+	q := UsageQueryParams{From: from, To: from.AddDate(0, 1, 0), Pair: "XRP-USD.rIssuer", Limit: 1, Cursor: cursor}
+
+	cols := []string{"id", "quote_hash", "pair", "in_asset", "out_asset", "amount_in", "amount_out", "fee_amount",
+		"tx_hash", "ledger_index", "ts", "in_usd_price", "out_usd_price"}
+	mock.ExpectQuery(`FROM usage_events\s+WHERE partner_id = \$1 AND ts >= \$2 AND ts < \$3 AND pair = \$4 AND \(ts, id\) < \(\$5, \$6\)\s+ORDER BY ts DESC, id DESC\s+LIMIT 2`).
+		WithArgs(partnerID, q.From, q.To, q.Pair, cursor.Timestamp, cursor.ID).
+		WillReturnRows(sqlmock.NewRows(cols).
+			AddRow(80, []byte{0xab}, q.Pair, "XRP", "USD.rIssuer", "1000000", "2.5", "0.005", testTxHash, 100, from.Add(time.Hour), "2.5", "1").
+			AddRow(70, []byte{0xcd}, q.Pair, "XRP", "USD.rIssuer", "2000000", "5", "0.01", testTxHash, 99, from, "", ""))
+	// Totals ignore the cursor
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).
+		WithArgs(partnerID, q.From, q.To, q.Pair).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "unpriced"}).AddRow(2, 1))
+	mock.ExpectQuery(`GROUP BY asset`).
+		WithArgs(partnerID, q.From, q.To, q.Pair).
+		WillReturnRows(sqlmock.NewRows([]string{"asset", "volume_in", "volume_out", "fees", "volume_in_usd", "volume_out_usd", "fees_usd"}).
+			AddRow("USD.rIssuer", "0", "7.5", "0.015", "0", "2.5", "0.005").
+			AddRow("XRP", "3000000", "0", "0", "2.5", "0", "0"))
+
+	usage, err := NewPostgresStore(db).GetPartnerUsage(context.Background(), partnerID, q)
+	if err != nil {
+		t.Fatalf("GetPartnerUsage() error = %v", err)
+	}
+	if len(usage.Details) != 1 || usage.Details[0].QuoteHash != "ab" || usage.Details[0].InUSDPrice != "2.5" {
+		t.Errorf("details = %+v", usage.Details)
+	}
+	next, err := decodeUsageCursor(usage.NextCursor)
+	if err != nil || next.ID != 80 || !next.Timestamp.Equal(from.Add(time.Hour)) {
+		t.Errorf("next cursor = %+v (%v), want event 80", next, err)
+	}
+	if usage.TxCount != 2 || usage.UnpricedCount != 1 || len(usage.Totals) != 2 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if usage.TotalVolumeUSD != "2.5" || usage.TotalFeesUSD != "0.005" {
+		t.Errorf("USD totals = %s / %s, want 2.5 / 0.005", usage.TotalVolumeUSD, usage.TotalFeesUSD)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package currency
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// Code is a canonical XRPL currency code. It is one of:
+//   - "XRP" for the native asset
+//   - a 3-character standard code (case-sensitive, e.g. "USD", "usd", "$$$")
+//   - 40 uppercase hex characters for non-standard 160-bit codes
+//
+// Hex codes using the standard layout are canonicalized to their 3-character
+// form, so every currency has exactly one Code.
+type Code string
+
+const XRP Code = "XRP"
+
+const (
+	codeBytes = 20
+	hexLength = codeBytes * 2
+)
+
+var (
+	ErrInvalidCode  = errors.New("invalid currency code")
+	ErrInvalidAsset = errors.New("invalid asset format")
+)
+
+// standardChars are the characters XRPL allows in 3-character codes
+const standardChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789?!@#$%^&*<>(){}[]|"
+
+// Parse validates s and returns its canonical Code
+func Parse(s string) (Code, error) {
+	switch len(s) {
+	case 3:
+		if s == string(XRP) {
+			return XRP, nil
+		}
+		if !isStandard(s) {
+			return "", ErrInvalidCode
+		}
+		return Code(s), nil
+	case hexLength:
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return "", ErrInvalidCode
+		}
+		return fromBytes(b)
+	}
+	return "", ErrInvalidCode
+}
+
+func (c Code) String() string {
+	return string(c)
+}
+
+func (c Code) IsXRP() bool {
+	return c == XRP
+}
+
+// IsHex reports whether c is a non-standard 160-bit code
+func (c Code) IsHex() bool {
+	return len(c) == hexLength
+}
+
+// Hex returns the 160-bit representation used on the ledger
+func (c Code) Hex() string {
+	switch {
+	case c.IsXRP():
+		return strings.Repeat("0", hexLength)
+	case c.IsHex():
+		return string(c)
+	}
+
+	var b [codeBytes]byte
+	copy(b[12:15], c)
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
+
+// Display returns a human-readable form. Hex codes that hold printable
+// ASCII (e.g. "534F4C4F00..." for "SOLO") are decoded; others, such as
+// LP token codes, are returned as hex.
+func (c Code) Display() string {
+	if !c.IsHex() {
+		return string(c)
+	}
+
+	b, err := hex.DecodeString(string(c))
+	if err != nil || b[0] <= 0x03 {
+		return string(c)
+	}
+
+	text := strings.TrimRight(string(b), "\x00")
+	for i := 0; i < len(text); i++ {
+		if text[i] < 0x20 || text[i] > 0x7E {
+			return string(c)
+		}
+	}
+	return text
+}
+
+// FormatAsset returns the canonical "XRP" or "CODE.ISSUER" form of an asset.
+// XRP must not have an issuer and every other currency must.
+func FormatAsset(currency, issuer string) (string, error) {
+	code, err := Parse(currency)
+	if err != nil {
+		return "", err
+	}
+	if code.IsXRP() {
+		if issuer != "" {
+			return "", ErrInvalidAsset
+		}
+		return string(XRP), nil
+	}
+	if issuer == "" {
+		return "", ErrInvalidAsset
+	}
+	return string(code) + "." + issuer, nil
+}
+
+// SplitAsset parses "XRP" or "CODE.ISSUER" into a canonical code and issuer.
+// Currency codes never contain '.', so the separator is unambiguous.
+func SplitAsset(asset string) (Code, string, error) {
+	currency, issuer, found := strings.Cut(asset, ".")
+	if found && issuer == "" {
+		return "", "", ErrInvalidAsset
+	}
+	if strings.Contains(issuer, ".") {
+		return "", "", ErrInvalidAsset
+	}
+
+	code, err := Parse(currency)
+	if err != nil {
+		return "", "", err
+	}
+	if code.IsXRP() != (issuer == "") {
+		return "", "", ErrInvalidAsset
+	}
+	return code, issuer, nil
+}
+
+func isStandard(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(standardChars, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func fromBytes(b []byte) (Code, error) {
+	if isZero(b) {
+		return XRP, nil
+	}
+
+	if b[0] == 0x00 {
+		// Standard layout: 12 zero bytes, 3 ASCII bytes, 5 zero bytes
+		if isZero(b[:12]) && isZero(b[15:]) {
+			code := string(b[12:15])
+			if code == string(XRP) || !isStandard(code) {
+				return "", ErrInvalidCode
+			}
+			return Code(code), nil
+		}
+	}
+
+	return Code(strings.ToUpper(hex.EncodeToString(b))), nil
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,95 @@
+package currency
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Code
+		wantErr bool
+	}{
+		{"XRP", XRP, false},
+		{"USD", "USD", false},
+		{"usd", "usd", false},
+		{"$$$", "$$$", false},
+		{"534f4c4f00000000000000000000000000000000", "534F4C4F00000000000000000000000000000000", false},
+		{"0000000000000000000000005553440000000000", "USD", false},
+		{"0000000000000000000000000000000000000000", XRP, false},
+		{"0000000000000000000000005852500000000000", "", true}, // "XRP" in hex is not allowed
+		{"03E7E7DE8F3C8D3C6F1B3F1B0C2A5DDEA2E0F2C1", "03E7E7DE8F3C8D3C6F1B3F1B0C2A5DDEA2E0F2C1", false},
+		{"", "", true},
+		{"US", "", true},
+		{"SOLO", "", true},
+		{"U.D", "", true},
+		{"ZZ4F4C4F00000000000000000000000000000000", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestCode_HexAndDisplay(t *testing.T) {
+	tests := []struct {
+		code        Code
+		wantHex     string
+		wantDisplay string
+	}{
+		{XRP, "0000000000000000000000000000000000000000", "XRP"},
+		{"USD", "0000000000000000000000005553440000000000", "USD"},
+		{"534F4C4F00000000000000000000000000000000", "534F4C4F00000000000000000000000000000000", "SOLO"},
+		{"03E7E7DE8F3C8D3C6F1B3F1B0C2A5DDEA2E0F2C1", "03E7E7DE8F3C8D3C6F1B3F1B0C2A5DDEA2E0F2C1", "03E7E7DE8F3C8D3C6F1B3F1B0C2A5DDEA2E0F2C1"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.code.Hex(); got != tt.wantHex {
+			t.Errorf("%s.Hex() = %q, want %q", tt.code, got, tt.wantHex)
+		}
+		if got := tt.code.Display(); got != tt.wantDisplay {
+			t.Errorf("%s.Display() = %q, want %q", tt.code, got, tt.wantDisplay)
+		}
+		if parsed, err := Parse(tt.code.Hex()); err != nil || parsed != tt.code {
+			t.Errorf("Parse(%s.Hex()) = %q, %v, want %q", tt.code, parsed, err, tt.code)
+		}
+	}
+}
+
+func TestSplitAsset(t *testing.T) {
+	tests := []struct {
+		input      string
+		wantCode   Code
+		wantIssuer string
+		wantErr    bool
+	}{
+		{"XRP", XRP, "", false},
+		{"USD.rIssuer", "USD", "rIssuer", false},
+		{"534f4c4f00000000000000000000000000000000.rIssuer", "534F4C4F00000000000000000000000000000000", "rIssuer", false},
+		{"XRP.rIssuer", "", "", true},
+		{"USD", "", "", true},
+		{"USD.", "", "", true},
+		{"USD.rIssuer.extra", "", "", true},
+	}
+
+	for _, tt := range tests {
+		code, issuer, err := SplitAsset(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SplitAsset(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if code != tt.wantCode || issuer != tt.wantIssuer {
+			t.Errorf("SplitAsset(%q) = %q, %q, want %q, %q", tt.input, code, issuer, tt.wantCode, tt.wantIssuer)
+		}
+	}
+
+	if asset, err := FormatAsset("534f4c4f00000000000000000000000000000000", "rIssuer"); err != nil ||
+		asset != "534F4C4F00000000000000000000000000000000.rIssuer" {
+		t.Errorf("FormatAsset() = %q, %v", asset, err)
+	}
+}
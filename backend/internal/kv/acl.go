@@ -0,0 +1,110 @@
+package kv
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Permission is a set of operations a service may perform on a namespace
+type Permission uint8
+
+const (
+	PermRead Permission = 1 << iota
+	PermWrite
+
+	PermNone      Permission = 0
+	PermReadWrite            = PermRead | PermWrite
+)
+
+// Service identities, taken from the client certificate CommonName
+const (
+	ServiceAPI     = "api"
+	ServiceRouter  = "router"
+	ServiceIndexer = "indexer"
+)
+
+// ACL maps service -> namespace -> permission. Anything not listed is denied.
+type ACL map[string]map[string]Permission
+
+// DefaultACL follows the namespace ownership in the security model: the
+// router owns quotes and breaker state, the API reads quotes and owns partner
+// rate limits, and the indexer publishes the ledger index. The API embeds a
+// router for quoting, so it may also write the ledger index it receives.
+// The API also claims request IDs for replay protection and caches the
+// asset policies its quote engine loads. Stats require read access to the
+// system namespace.
+func DefaultACL() ACL {
+	return ACL{
+		ServiceRouter: {
+			NamespaceQuotes:         PermReadWrite,
+			NamespaceCircuitBreaker: PermReadWrite,
+			NamespacePolicies:       PermReadWrite,
+			NamespaceSystem:         PermReadWrite,
+		},
+		ServiceAPI: {
+			NamespaceQuotes:     PermRead,
+			NamespaceRateLimits: PermReadWrite,
+			NamespacePolicies:   PermReadWrite,
+			NamespaceSystem:     PermReadWrite,
+			NamespaceRequestIDs: PermReadWrite,
+		},
+		ServiceIndexer: {
+			NamespaceCircuitBreaker: PermRead,
+			NamespaceSystem:         PermReadWrite,
+		},
+	}
+}
+
+// Allows reports whether service holds perm on namespace
+func (a ACL) Allows(service, namespace string, perm Permission) bool {
+	granted, ok := a[service][namespace]
+	return ok && granted&perm == perm
+}
+
+// ParseACL parses "router=quotes:rw,circuit_breaker:rw;api=quotes:r"
+func ParseACL(s string) (ACL, error) {
+	acl := make(ACL)
+	for _, svcPart := range strings.Split(s, ";") {
+		svcPart = strings.TrimSpace(svcPart)
+		if svcPart == "" {
+			continue
+		}
+
+		service, grants, ok := strings.Cut(svcPart, "=")
+		service = strings.TrimSpace(service)
+		if !ok || service == "" {
+			return nil, fmt.Errorf("invalid acl entry %q: want service=ns:perm,...", svcPart)
+		}
+
+		perms := make(map[string]Permission)
+		for _, grant := range strings.Split(grants, ",") {
+			ns, mode, ok := strings.Cut(strings.TrimSpace(grant), ":")
+			if !ok || ns == "" {
+				return nil, fmt.Errorf("invalid acl grant %q for %s", grant, service)
+			}
+			perm, err := parsePermission(mode)
+			if err != nil {
+				return nil, fmt.Errorf("invalid acl grant %q for %s: %w", grant, service, err)
+			}
+			perms[ns] = perm
+		}
+		acl[service] = perms
+	}
+
+	if len(acl) == 0 {
+		return nil, fmt.Errorf("acl is empty")
+	}
+	return acl, nil
+}
+
+func parsePermission(mode string) (Permission, error) {
+	switch mode {
+	case "r":
+		return PermRead, nil
+	case "w":
+		return PermWrite, nil
+	case "rw", "wr":
+		return PermReadWrite, nil
+	}
+	return PermNone, fmt.Errorf("unknown permission %q", mode)
+}
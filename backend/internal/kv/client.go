@@ -0,0 +1,408 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const DefaultRequestTimeout = 2 * time.Second
+
+// Client implements Store against a remote kv server. Requests are
+// multiplexed over one mTLS connection, re-dialled lazily after a failure.
+// Writes are never retried, so a dropped connection surfaces as an error
+// rather than a double increment.
+type Client struct {
+	addr      string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	writeMu sync.Mutex
+	conn    net.Conn
+	pending map[uint32]chan frame
+	streams map[uint32]*watcher
+	nextID  uint32
+	closed  bool
+}
+
+// Dial connects to addr and verifies the server accepts this service
+func Dial(addr string, tlsConfig *tls.Config) (*Client, error) {
+	c := &Client{
+		addr:      addr,
+		tlsConfig: tlsConfig,
+		timeout:   DefaultRequestTimeout,
+		pending:   make(map[uint32]chan frame),
+		streams:   make(map[uint32]*watcher),
+	}
+	if _, err := c.do(opPing, nil); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("kv dial %s: %w", addr, err)
+	}
+	return c, nil
+}
+
+// DialTLS loads a service certificate and dials addr, verifying the server
+// certificate against the host part of addr
+func DialTLS(addr, certFile, keyFile, caFile string) (*Client, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid kv address %q: %w", addr, err)
+	}
+	tlsConfig, err := ClientTLSConfig(certFile, keyFile, caFile, host)
+	if err != nil {
+		return nil, err
+	}
+	return Dial(addr, tlsConfig)
+}
+
+func (c *Client) Get(namespace, key string) ([]byte, bool) {
+	body := appendString(appendString(nil, namespace), key)
+	resp, err := c.do(opGet, body)
+	if err != nil || resp.code != statusOK {
+		return nil, false
+	}
+
+	r := &fieldReader{b: resp.body}
+	value := r.field()
+	if r.err != nil {
+		return nil, false
+	}
+	return append([]byte(nil), value...), true
+}
+
+func (c *Client) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	body := appendString(appendString(nil, namespace), key)
+	body = appendInt(appendField(body, value), int64(ttl))
+	_, err := c.call(opSetKey, body)
+	return err
+}
+
+func (c *Client) Delete(namespace, key string) error {
+	_, err := c.call(opDeleteKey, appendString(appendString(nil, namespace), key))
+	return err
+}
+
+func (c *Client) CompareAndSwap(namespace, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	return c.Txn(compareAndSwapTxn(namespace, key, old, value, ttl))
+}
+
+func (c *Client) SetNX(namespace, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.Txn(setNXTxn(namespace, key, value, ttl))
+}
+
+func (c *Client) Increment(namespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	body := appendInt(appendInt(appendString(appendString(nil, namespace), key), delta), int64(ttl))
+	resp, err := c.call(opIncrBy, body)
+	if err != nil {
+		return 0, err
+	}
+	r := &fieldReader{b: resp.body}
+	count := r.int()
+	return count, r.err
+}
+
+func (c *Client) Txn(txn Txn) (bool, error) {
+	if len(txn.If)+len(txn.Then)+len(txn.Else) > MaxTxnOps {
+		return false, ErrTxnTooLarge
+	}
+	resp, err := c.call(opTransaction, appendTxn(nil, txn))
+	if err != nil {
+		return false, err
+	}
+	r := &fieldReader{b: resp.body}
+	succeeded := r.int() == 1
+	return succeeded, r.err
+}
+
+func (c *Client) IncrementRateLimit(partnerID string, ttl time.Duration) (int64, error) {
+	resp, err := c.call(opIncr, appendInt(appendString(nil, partnerID), int64(ttl)))
+	if err != nil {
+		return 0, err
+	}
+	r := &fieldReader{b: resp.body}
+	count := r.int()
+	return count, r.err
+}
+
+func (c *Client) SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	return c.limit(opSlidingWindow, key, limit, window, 0)
+}
+
+func (c *Client) TokenBucket(key string, rate int64, per time.Duration, burst int64) (RateLimitResult, error) {
+	return c.limit(opTokenBucket, key, rate, per, burst)
+}
+
+func (c *Client) RateLimit(key string, limit int64, window time.Duration, burst int64) (RateLimitResult, error) {
+	return c.limit(opRateLimit, key, limit, window, burst)
+}
+
+func (c *Client) limit(op byte, key string, limit int64, period time.Duration, burst int64) (RateLimitResult, error) {
+	body := appendInt(appendInt(appendInt(appendString(nil, key), limit), int64(period)), burst)
+	resp, err := c.call(op, body)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	r := &fieldReader{b: resp.body}
+	res := r.rateLimit()
+	return res, r.err
+}
+
+func (c *Client) GetQuote(hash [32]byte) ([]byte, bool) {
+	return c.Get(NamespaceQuotes, hex.EncodeToString(hash[:]))
+}
+
+func (c *Client) SetQuote(hash [32]byte, route []byte, ttl time.Duration) error {
+	return c.Set(NamespaceQuotes, hex.EncodeToString(hash[:]), route, ttl)
+}
+
+func (c *Client) GetPolicy(key string) ([]byte, bool) {
+	return c.Get(NamespacePolicies, key)
+}
+
+func (c *Client) SetPolicy(key string, policy []byte, ttl time.Duration) error {
+	return c.Set(NamespacePolicies, key, policy, ttl)
+}
+
+func (c *Client) SetLedgerIndex(idx uint32) error {
+	return c.Set(NamespaceSystem, "ledger_index", []byte(strconv.FormatUint(uint64(idx), 10)), 0)
+}
+
+func (c *Client) GetLedgerIndex() (uint32, bool) {
+	value, ok := c.Get(NamespaceSystem, "ledger_index")
+	if !ok {
+		return 0, false
+	}
+	parsed, err := strconv.ParseUint(string(value), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(parsed), true
+}
+
+func (c *Client) Keys(namespace string) []string {
+	resp, err := c.call(opKeys, appendString(nil, namespace))
+	if err != nil {
+		return nil
+	}
+
+	r := &fieldReader{b: resp.body}
+	n := r.int()
+	if r.err != nil || n < 0 {
+		return nil
+	}
+	keys := make([]string, 0, n)
+	for i := int64(0); i < n && r.err == nil; i++ {
+		keys = append(keys, r.string())
+	}
+	if r.err != nil {
+		return nil
+	}
+	return keys
+}
+
+func (c *Client) Stats() Stats {
+	var stats Stats
+	resp, err := c.call(opStats, nil)
+	if err != nil {
+		return stats
+	}
+	r := &fieldReader{b: resp.body}
+	if data := r.field(); r.err == nil {
+		_ = json.Unmarshal(data, &stats)
+	}
+	return stats
+}
+
+// Watch subscribes to changes on the server. The channel is closed when ctx
+// is done or the connection is lost; a new Watch re-subscribes.
+func (c *Client) Watch(ctx context.Context, namespace, prefix string) (<-chan Event, error) {
+	w := newWatcher(namespace, prefix, DefaultWatchBuffer)
+	resp, id, err := c.roundTrip(opWatch, appendString(appendString(nil, namespace), prefix), w)
+	if err == nil {
+		err = responseError(resp)
+	}
+	if err != nil {
+		c.dropStream(id)
+		return nil, err
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.dropStream(id)
+			_, _ = c.call(opUnwatch, appendInt(nil, int64(id)))
+		case <-w.done:
+		}
+	}()
+	return w.ch, nil
+}
+
+func (c *Client) dropStream(id uint32) {
+	c.mu.Lock()
+	w, ok := c.streams[id]
+	delete(c.streams, id)
+	c.mu.Unlock()
+	if ok {
+		w.close()
+	}
+}
+
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// call is do with non-OK statuses mapped to errors
+func (c *Client) call(op byte, body []byte) (frame, error) {
+	resp, err := c.do(op, body)
+	if err != nil {
+		return resp, err
+	}
+	return resp, responseError(resp)
+}
+
+func responseError(resp frame) error {
+	switch resp.code {
+	case statusOK:
+		return nil
+	case statusNotFound:
+		return ErrKeyNotFound
+	case statusForbidden:
+		return ErrForbidden
+	}
+	r := &fieldReader{b: resp.body}
+	msg := r.string()
+	if r.err != nil {
+		return errMalformed
+	}
+	return resultError(msg)
+}
+
+func (c *Client) do(op byte, body []byte) (frame, error) {
+	resp, _, err := c.roundTrip(op, body, nil)
+	return resp, err
+}
+
+// roundTrip sends one request and waits for its response. A non-nil stream
+// is registered under the request id before sending, so events pushed right
+// after the response are not lost.
+func (c *Client) roundTrip(op byte, body []byte, stream *watcher) (frame, uint32, error) {
+	conn, id, ch, err := c.register(stream)
+	if err != nil {
+		return frame{}, 0, err
+	}
+
+	c.writeMu.Lock()
+	conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	err = writeFrame(conn, frame{id: id, code: op, body: body})
+	c.writeMu.Unlock()
+	if err != nil {
+		c.dropConn(conn)
+		return frame{}, id, err
+	}
+
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return frame{}, id, errPeerUnreachable
+		}
+		return resp, id, nil
+	case <-timer.C:
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return frame{}, id, context.DeadlineExceeded
+	}
+}
+
+// register ensures a live connection and reserves a request id on it
+func (c *Client) register(stream *watcher) (net.Conn, uint32, chan frame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, 0, nil, ErrClientClosed
+	}
+	if c.conn == nil {
+		dialer := &net.Dialer{Timeout: c.timeout}
+		conn, err := tls.DialWithDialer(dialer, "tcp", c.addr, c.tlsConfig)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("%w: %v", errNotDelivered, err)
+		}
+		c.conn = conn
+		go c.readLoop(conn)
+	}
+
+	c.nextID++
+	ch := make(chan frame, 1)
+	c.pending[c.nextID] = ch
+	if stream != nil {
+		c.streams[c.nextID] = stream
+	}
+	return c.conn, c.nextID, ch, nil
+}
+
+func (c *Client) readLoop(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		resp, err := readFrame(reader)
+		if err != nil {
+			c.dropConn(conn)
+			return
+		}
+
+		c.mu.Lock()
+		ch, ok := c.pending[resp.id]
+		delete(c.pending, resp.id)
+		stream := c.streams[resp.id]
+		c.mu.Unlock()
+
+		switch {
+		case ok:
+			ch <- resp
+		case stream != nil && resp.code == statusEvent:
+			r := &fieldReader{b: resp.body}
+			if ev := r.event(); r.err == nil {
+				stream.deliver(ev)
+			}
+		}
+	}
+}
+
+// dropConn fails every request waiting on conn so callers don't hang until
+// their timeout; the next request dials again
+func (c *Client) dropConn(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+	conn.Close()
+	c.conn = nil
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	for id, w := range c.streams {
+		w.close()
+		delete(c.streams, id)
+	}
+}
@@ -0,0 +1,520 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EncryptionKeySize = 32 // AES-256-GCM
+
+	envelopeVersion = 1
+	maxKeyIDLength  = 64
+)
+
+var (
+	ErrNoEncryptionKey  = errors.New("kv: no current encryption key")
+	ErrUnknownKeyID     = errors.New("kv: unknown encryption key id")
+	ErrDecrypt          = errors.New("kv: value failed authentication")
+	ErrEncryptedCounter = errors.New("kv: counters can't be kept in an encrypted namespace")
+	ErrHashedPrefix     = errors.New("kv: prefix watches aren't possible on a hashed namespace")
+)
+
+// KeyProvider supplies the secrets an EncryptedStore uses. Values are sealed
+// with the current key and record its id, so older keys must stay available
+// until every value written with them has been rewritten or has expired.
+type KeyProvider interface {
+	CurrentKey() (id string, key []byte, err error)
+	KeyByID(id string) ([]byte, error)
+
+	// HashKey keys the HMAC applied to the keys of hashed namespaces. Changing
+	// it orphans every hashed key, so it is not part of rotation.
+	HashKey() []byte
+}
+
+// KeyRing is an in-memory KeyProvider. Rotate adds a key and makes it
+// current; retired keys keep decrypting until removed with Retire.
+type KeyRing struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+	hashKey []byte
+}
+
+func NewKeyRing(hashKey []byte) *KeyRing {
+	return &KeyRing{keys: make(map[string][]byte), hashKey: append([]byte(nil), hashKey...)}
+}
+
+// ParseKeyRing builds a KeyRing from "id:base64key,..." where the last key
+// listed is current, and a base64 hash key
+func ParseKeyRing(keys, hashKey string) (*KeyRing, error) {
+	hk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(hashKey))
+	if err != nil || len(hk) < 16 {
+		return nil, fmt.Errorf("hash key must be at least 16 bytes of base64")
+	}
+	ring := NewKeyRing(hk)
+
+	for _, part := range strings.Split(keys, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid encryption key entry %q: want id:base64key", part)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key %q: %w", id, err)
+		}
+		if err := ring.Rotate(id, key); err != nil {
+			return nil, err
+		}
+	}
+	return ring, nil
+}
+
+// Rotate adds key under id and makes it the key new values are sealed with
+func (r *KeyRing) Rotate(id string, key []byte) error {
+	if id == "" || len(id) > maxKeyIDLength {
+		return fmt.Errorf("encryption key id must be 1-%d bytes", maxKeyIDLength)
+	}
+	if len(key) != EncryptionKeySize {
+		return fmt.Errorf("encryption key %q must be %d bytes", id, EncryptionKeySize)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = append([]byte(nil), key...)
+	r.current = id
+	return nil
+}
+
+// Retire forgets a key that is no longer current. Values still sealed with
+// it become unreadable.
+func (r *KeyRing) Retire(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id == r.current {
+		return fmt.Errorf("can't retire the current encryption key %q", id)
+	}
+	delete(r.keys, id)
+	return nil
+}
+
+func (r *KeyRing) CurrentKey() (string, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.current == "" {
+		return "", nil, ErrNoEncryptionKey
+	}
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) KeyByID(id string) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, ErrUnknownKeyID
+	}
+	return key, nil
+}
+
+func (r *KeyRing) HashKey() []byte {
+	return r.hashKey
+}
+
+// Protection selects what an EncryptedStore hides for one namespace
+type Protection struct {
+	EncryptValues bool // seal values with AES-256-GCM
+	HashKeys      bool // store HMAC-SHA256(key) instead of the key
+}
+
+// DefaultProtection hides partner IDs in rate-limit, request ID and policy
+// keys and the contents of cached quotes and policies. Rate-limit values are counters the
+// backend updates in place, so they can only be hashed.
+func DefaultProtection() map[string]Protection {
+	return map[string]Protection{
+		NamespaceRateLimits: {HashKeys: true},
+		NamespaceRequestIDs: {HashKeys: true},
+		NamespacePolicies:   {EncryptValues: true, HashKeys: true},
+		NamespaceQuotes:     {EncryptValues: true},
+	}
+}
+
+// EncryptedStore wraps a Store so the backend, whether in-process, durable,
+// replicated or remote, only ever holds ciphertext and hashed keys for the
+// protected namespaces. Each value is bound to its namespace and key, so
+// ciphertext can't be moved to another key, and carries its expiry so a
+// value read under a retired key can be re-sealed with the current one
+// without changing its TTL. Keys and Watch report hashed keys as stored.
+type EncryptedStore struct {
+	inner      Store
+	keys       KeyProvider
+	protection map[string]Protection
+
+	mu    sync.Mutex
+	aeads map[string]cipher.AEAD // by key material, so a reused id can't hit a stale cipher
+}
+
+func NewEncryptedStore(inner Store, keys KeyProvider, protection map[string]Protection) (*EncryptedStore, error) {
+	for ns, p := range protection {
+		if p.EncryptValues && ns == NamespaceRateLimits {
+			return nil, fmt.Errorf("%s: %w", ns, ErrEncryptedCounter)
+		}
+		if p.EncryptValues {
+			if _, _, err := keys.CurrentKey(); err != nil {
+				return nil, err
+			}
+		}
+		if p.HashKeys && len(keys.HashKey()) == 0 {
+			return nil, errors.New("kv: hashed namespaces need a hash key")
+		}
+	}
+	return &EncryptedStore{inner: inner, keys: keys, protection: protection, aeads: make(map[string]cipher.AEAD)}, nil
+}
+
+func (s *EncryptedStore) Get(namespace, key string) ([]byte, bool) {
+	storedKey := s.storedKey(namespace, key)
+	raw, ok := s.inner.Get(namespace, storedKey)
+	if !ok || !s.protection[namespace].EncryptValues {
+		return raw, ok
+	}
+
+	value, keyID, expiresAt, err := s.open(namespace, storedKey, raw)
+	if err != nil {
+		return nil, false
+	}
+	s.reseal(namespace, storedKey, raw, value, keyID, expiresAt)
+	return value, true
+}
+
+// reseal lazily moves a value sealed under an older key to the current one.
+// The swap only lands if nobody has written the key since it was read.
+func (s *EncryptedStore) reseal(namespace, storedKey string, raw, value []byte, keyID string, expiresAt time.Time) {
+	currentID, _, err := s.keys.CurrentKey()
+	if err != nil || keyID == currentID {
+		return
+	}
+	ttl := time.Duration(0)
+	if !expiresAt.IsZero() {
+		if ttl = time.Until(expiresAt); ttl <= 0 {
+			return
+		}
+	}
+	sealed, err := s.sealAt(namespace, storedKey, value, expiresAt)
+	if err != nil {
+		return
+	}
+	_, _ = s.inner.CompareAndSwap(namespace, storedKey, raw, sealed, ttl)
+}
+
+func (s *EncryptedStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	storedKey := s.storedKey(namespace, key)
+	sealed, err := s.seal(namespace, storedKey, value, ttl)
+	if err != nil {
+		return err
+	}
+	return s.inner.Set(namespace, storedKey, sealed, ttl)
+}
+
+func (s *EncryptedStore) Delete(namespace, key string) error {
+	return s.inner.Delete(namespace, s.storedKey(namespace, key))
+}
+
+// CompareAndSwap compares plaintext: the current ciphertext is read and
+// opened, and the swap is made against that exact ciphertext
+func (s *EncryptedStore) CompareAndSwap(namespace, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	if !s.protection[namespace].EncryptValues {
+		return s.inner.CompareAndSwap(namespace, s.storedKey(namespace, key), old, value, ttl)
+	}
+	return s.Txn(compareAndSwapTxn(namespace, key, old, value, ttl))
+}
+
+func (s *EncryptedStore) SetNX(namespace, key string, value []byte, ttl time.Duration) (bool, error) {
+	storedKey := s.storedKey(namespace, key)
+	sealed, err := s.seal(namespace, storedKey, value, ttl)
+	if err != nil {
+		return false, err
+	}
+	return s.inner.SetNX(namespace, storedKey, sealed, ttl)
+}
+
+func (s *EncryptedStore) Increment(namespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	if s.protection[namespace].EncryptValues {
+		return 0, ErrEncryptedCounter
+	}
+	return s.inner.Increment(namespace, s.storedKey(namespace, key), delta, ttl)
+}
+
+// Txn seals written values and hashes keys. Equality conditions on an
+// encrypted namespace are checked against the plaintext read just before
+// the transaction, and pinned to the ciphertext read, so the transaction
+// takes its Then branch only if the value is still unchanged.
+func (s *EncryptedStore) Txn(txn Txn) (bool, error) {
+	out := Txn{If: make([]Condition, len(txn.If))}
+	for i, c := range txn.If {
+		storedKey := s.storedKey(c.Namespace, c.Key)
+		out.If[i] = Condition{Namespace: c.Namespace, Key: storedKey, Op: c.Op, Value: c.Value}
+		if c.Op != CompareEqual || !s.protection[c.Namespace].EncryptValues {
+			continue
+		}
+
+		// An envelope is never empty, so nil can't match a sealed value
+		out.If[i].Value = nil
+		if raw, ok := s.inner.Get(c.Namespace, storedKey); ok {
+			if value, _, _, err := s.open(c.Namespace, storedKey, raw); err == nil && bytes.Equal(value, c.Value) {
+				out.If[i].Value = raw
+			}
+		}
+	}
+
+	var err error
+	if out.Then, err = s.sealOps(txn.Then); err != nil {
+		return false, err
+	}
+	if out.Else, err = s.sealOps(txn.Else); err != nil {
+		return false, err
+	}
+	return s.inner.Txn(out)
+}
+
+func (s *EncryptedStore) sealOps(ops []TxnOp) ([]TxnOp, error) {
+	out := make([]TxnOp, len(ops))
+	for i, op := range ops {
+		out[i] = op
+		out[i].Key = s.storedKey(op.Namespace, op.Key)
+		if op.Delete {
+			continue
+		}
+		sealed, err := s.seal(op.Namespace, out[i].Key, op.Value, op.TTL)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Value = sealed
+	}
+	return out, nil
+}
+
+func (s *EncryptedStore) IncrementRateLimit(partnerID string, ttl time.Duration) (int64, error) {
+	return s.inner.IncrementRateLimit(s.storedKey(NamespaceRateLimits, partnerID), ttl)
+}
+
+func (s *EncryptedStore) SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	return s.inner.SlidingWindow(s.storedKey(NamespaceRateLimits, key), limit, window)
+}
+
+func (s *EncryptedStore) TokenBucket(key string, rate int64, per time.Duration, burst int64) (RateLimitResult, error) {
+	return s.inner.TokenBucket(s.storedKey(NamespaceRateLimits, key), rate, per, burst)
+}
+
+func (s *EncryptedStore) RateLimit(key string, limit int64, window time.Duration, burst int64) (RateLimitResult, error) {
+	return s.inner.RateLimit(s.storedKey(NamespaceRateLimits, key), limit, window, burst)
+}
+
+func (s *EncryptedStore) GetQuote(hash [32]byte) ([]byte, bool) {
+	return s.Get(NamespaceQuotes, hex.EncodeToString(hash[:]))
+}
+
+func (s *EncryptedStore) SetQuote(hash [32]byte, route []byte, ttl time.Duration) error {
+	return s.Set(NamespaceQuotes, hex.EncodeToString(hash[:]), route, ttl)
+}
+
+func (s *EncryptedStore) GetPolicy(key string) ([]byte, bool) {
+	return s.Get(NamespacePolicies, key)
+}
+
+func (s *EncryptedStore) SetPolicy(key string, policy []byte, ttl time.Duration) error {
+	return s.Set(NamespacePolicies, key, policy, ttl)
+}
+
+func (s *EncryptedStore) SetLedgerIndex(idx uint32) error {
+	return s.Set(NamespaceSystem, "ledger_index", []byte(strconv.FormatUint(uint64(idx), 10)), 0)
+}
+
+func (s *EncryptedStore) GetLedgerIndex() (uint32, bool) {
+	value, ok := s.Get(NamespaceSystem, "ledger_index")
+	if !ok {
+		return 0, false
+	}
+	parsed, err := strconv.ParseUint(string(value), 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(parsed), true
+}
+
+func (s *EncryptedStore) Keys(namespace string) []string {
+	return s.inner.Keys(namespace)
+}
+
+// Watch opens values before delivering them. Keys in hashed namespaces can't
+// be matched by prefix, so only whole-namespace watches are allowed there.
+func (s *EncryptedStore) Watch(ctx context.Context, namespace, prefix string) (<-chan Event, error) {
+	p := s.protection[namespace]
+	if p.HashKeys && prefix != "" {
+		return nil, ErrHashedPrefix
+	}
+	events, err := s.inner.Watch(ctx, namespace, prefix)
+	if err != nil || !p.EncryptValues {
+		return events, err
+	}
+
+	out := make(chan Event, DefaultWatchBuffer)
+	go func() {
+		defer close(out)
+		for ev := range events {
+			if ev.Type == EventSet {
+				value, _, _, err := s.open(ev.Namespace, ev.Key, ev.Value)
+				if err != nil {
+					// Tell the watcher to re-read rather than pass ciphertext on
+					ev = Event{Type: EventOverflow}
+				} else {
+					ev.Value = value
+				}
+			}
+			select {
+			case out <- ev:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *EncryptedStore) Stats() Stats {
+	return s.inner.Stats()
+}
+
+func (s *EncryptedStore) Close() error {
+	return s.inner.Close()
+}
+
+// storedKey is the key as the backend sees it
+func (s *EncryptedStore) storedKey(namespace, key string) string {
+	if !s.protection[namespace].HashKeys {
+		return key
+	}
+	mac := hmac.New(sha256.New, s.keys.HashKey())
+	mac.Write([]byte(namespace))
+	mac.Write([]byte{0})
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *EncryptedStore) seal(namespace, storedKey string, value []byte, ttl time.Duration) ([]byte, error) {
+	if !s.protection[namespace].EncryptValues {
+		return value, nil
+	}
+	return s.sealAt(namespace, storedKey, value, expiryFrom(time.Now(), ttl))
+}
+
+// Envelope layout: version(1) idLen(1) id expiresAt(8) nonce ciphertext.
+// The header, namespace and stored key are authenticated as additional data.
+func (s *EncryptedStore) sealAt(namespace, storedKey string, value []byte, expiresAt time.Time) ([]byte, error) {
+	id, key, err := s.keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, 2+len(id)+8)
+	header = append(header, envelopeVersion, byte(len(id)))
+	header = append(header, id...)
+	expires := int64(0)
+	if !expiresAt.IsZero() {
+		expires = expiresAt.UnixNano()
+	}
+	header = binary.BigEndian.AppendUint64(header, uint64(expires))
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, value, additionalData(namespace, storedKey, header)), nil
+}
+
+func (s *EncryptedStore) open(namespace, storedKey string, raw []byte) ([]byte, string, time.Time, error) {
+	if len(raw) < 2 || raw[0] != envelopeVersion {
+		return nil, "", time.Time{}, ErrDecrypt
+	}
+	headerLen := 2 + int(raw[1]) + 8
+	if len(raw) < headerLen {
+		return nil, "", time.Time{}, ErrDecrypt
+	}
+	header := raw[:headerLen]
+	id := string(raw[2 : 2+int(raw[1])])
+	expiresAt := unixNanoTime(int64(binary.BigEndian.Uint64(raw[headerLen-8 : headerLen])))
+
+	key, err := s.keys.KeyByID(id)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+	aead, err := s.aead(key)
+	if err != nil {
+		return nil, "", time.Time{}, err
+	}
+
+	rest := raw[headerLen:]
+	if len(rest) < aead.NonceSize() {
+		return nil, "", time.Time{}, ErrDecrypt
+	}
+	nonce, ciphertext := rest[:aead.NonceSize()], rest[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, ciphertext, additionalData(namespace, storedKey, header))
+	if err != nil {
+		return nil, "", time.Time{}, ErrDecrypt
+	}
+	return value, id, expiresAt, nil
+}
+
+func additionalData(namespace, storedKey string, header []byte) []byte {
+	ad := make([]byte, 0, len(namespace)+len(storedKey)+len(header)+2)
+	ad = append(ad, namespace...)
+	ad = append(ad, 0)
+	ad = append(ad, storedKey...)
+	ad = append(ad, 0)
+	return append(ad, header...)
+}
+
+func (s *EncryptedStore) aead(key []byte) (cipher.AEAD, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if a, ok := s.aeads[string(key)]; ok {
+		return a, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	a, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.aeads[string(key)] = a
+	return a, nil
+}
@@ -0,0 +1,219 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, EncryptionKeySize)
+}
+
+func newTestEncryptedStore(t *testing.T) (*EncryptedStore, *MemoryStore, *KeyRing) {
+	t.Helper()
+
+	inner := NewMemoryStore()
+	t.Cleanup(func() { inner.Close() })
+
+	ring := NewKeyRing([]byte("0123456789abcdef"))
+	if err := ring.Rotate("k1", testKey(1)); err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewEncryptedStore(inner, ring, DefaultProtection())
+	if err != nil {
+		t.Fatalf("NewEncryptedStore() error = %v", err)
+	}
+	return store, inner, ring
+}
+
+func TestEncryptedStore_HidesKeysAndValues(t *testing.T) {
+	store, inner, _ := newTestEncryptedStore(t)
+	partnerID := "3f2b8c1e-7a4d-4e8f-9b6a-1c2d3e4f5a6b"
+
+	if err := store.SetPolicy("partner:"+partnerID, []byte(`[{"asset":"USD"}]`), time.Minute); err != nil {
+		t.Fatalf("SetPolicy() error = %v", err)
+	}
+	if _, err := store.IncrementRateLimit(partnerID, time.Minute); err != nil {
+		t.Fatalf("IncrementRateLimit() error = %v", err)
+	}
+	if _, err := store.SlidingWindow("window:"+partnerID, 10, time.Minute); err != nil {
+		t.Fatalf("SlidingWindow() error = %v", err)
+	}
+
+	for _, ns := range []string{NamespacePolicies, NamespaceRateLimits} {
+		for _, k := range inner.Keys(ns) {
+			if strings.Contains(k, partnerID) {
+				t.Errorf("%s key %q exposes the partner id", ns, k)
+			}
+		}
+	}
+	for _, se := range inner.snapshot() {
+		if bytes.Contains(se.Value, []byte("USD")) {
+			t.Errorf("%s value is stored in plaintext", se.Namespace)
+		}
+	}
+
+	if v, ok := store.GetPolicy("partner:" + partnerID); !ok || string(v) != `[{"asset":"USD"}]` {
+		t.Errorf("GetPolicy() = %q, %v", v, ok)
+	}
+	if n, _ := store.IncrementRateLimit(partnerID, time.Minute); n != 2 {
+		t.Errorf("IncrementRateLimit() = %d, want 2", n)
+	}
+}
+
+func TestEncryptedStore_RejectsMovedCiphertext(t *testing.T) {
+	store, inner, _ := newTestEncryptedStore(t)
+
+	_ = store.SetQuote([32]byte{1}, []byte("route-1"), time.Minute)
+	_ = store.SetQuote([32]byte{2}, []byte("route-2"), time.Minute)
+
+	// Copy quote 1's ciphertext over quote 2
+	raw, _ := inner.GetQuote([32]byte{1})
+	_ = inner.SetQuote([32]byte{2}, raw, time.Minute)
+
+	if _, ok := store.GetQuote([32]byte{2}); ok {
+		t.Error("ciphertext moved to another key must not decrypt")
+	}
+	if v, ok := store.GetQuote([32]byte{1}); !ok || string(v) != "route-1" {
+		t.Errorf("GetQuote() = %q, %v", v, ok)
+	}
+}
+
+func TestEncryptedStore_RotationResealsLazily(t *testing.T) {
+	store, inner, ring := newTestEncryptedStore(t)
+
+	_ = store.Set(NamespaceQuotes, "q", []byte("route"), time.Hour)
+	before, _ := inner.Get(NamespaceQuotes, "q")
+
+	if err := ring.Rotate("k2", testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := store.Get(NamespaceQuotes, "q"); !ok || string(v) != "route" {
+		t.Fatalf("Get() after rotation = %q, %v", v, ok)
+	}
+
+	after, _ := inner.Get(NamespaceQuotes, "q")
+	if bytes.Equal(before, after) || !bytes.Contains(after[:8], []byte("k2")) {
+		t.Fatal("value should be re-sealed with the current key on read")
+	}
+
+	// The retired key is no longer needed for this value
+	if err := ring.Retire("k1"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := store.Get(NamespaceQuotes, "q"); !ok || string(v) != "route" {
+		t.Errorf("Get() after retiring k1 = %q, %v", v, ok)
+	}
+	if err := ring.Retire("k2"); err == nil {
+		t.Error("Retire() of the current key should fail")
+	}
+
+	// The re-sealed value keeps its original expiry
+	_, _, expiresAt, err := store.open(NamespaceQuotes, "q", after)
+	if err != nil || time.Until(expiresAt) < 59*time.Minute {
+		t.Errorf("re-sealed expiry = %v, %v", expiresAt, err)
+	}
+}
+
+func TestEncryptedStore_AtomicOps(t *testing.T) {
+	store, _, _ := newTestEncryptedStore(t)
+
+	if ok, err := store.SetNX(NamespacePolicies, "global", []byte("v1"), 0); err != nil || !ok {
+		t.Fatalf("SetNX() = %v, %v", ok, err)
+	}
+	if ok, _ := store.CompareAndSwap(NamespacePolicies, "global", []byte("v0"), []byte("v2"), 0); ok {
+		t.Error("CompareAndSwap() with the wrong plaintext should fail")
+	}
+	if ok, err := store.CompareAndSwap(NamespacePolicies, "global", []byte("v1"), []byte("v2"), 0); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() = %v, %v", ok, err)
+	}
+
+	txn := Txn{
+		If:   []Condition{KeyEquals(NamespacePolicies, "global", []byte("v2"))},
+		Then: []TxnOp{SetOp(NamespaceQuotes, "q", []byte("route"), time.Minute)},
+	}
+	if ok, err := store.Txn(txn); err != nil || !ok {
+		t.Fatalf("Txn() = %v, %v", ok, err)
+	}
+	if v, _ := store.Get(NamespaceQuotes, "q"); string(v) != "route" {
+		t.Errorf("quote = %q", v)
+	}
+
+	if _, err := store.Increment(NamespaceQuotes, "n", 1, 0); err != ErrEncryptedCounter {
+		t.Errorf("Increment() on an encrypted namespace error = %v, want ErrEncryptedCounter", err)
+	}
+	if n, err := store.Increment(NamespaceRateLimits, "n", 2, 0); err != nil || n != 2 {
+		t.Errorf("Increment() on a hashed namespace = %d, %v", n, err)
+	}
+}
+
+func TestEncryptedStore_Watch(t *testing.T) {
+	store, _, _ := newTestEncryptedStore(t)
+
+	if _, err := store.Watch(context.Background(), NamespacePolicies, "partner:"); err != ErrHashedPrefix {
+		t.Errorf("prefix Watch() on a hashed namespace error = %v, want ErrHashedPrefix", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := store.Watch(ctx, NamespaceQuotes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = store.Set(NamespaceQuotes, "q", []byte("route"), 0)
+	if ev := nextEvent(t, events); ev.Type != EventSet || string(ev.Value) != "route" {
+		t.Errorf("event = %v %q, want decrypted set", ev.Type, ev.Value)
+	}
+}
+
+func TestNewEncryptedStore_InvalidConfig(t *testing.T) {
+	inner := NewMemoryStore()
+	defer inner.Close()
+
+	ring := NewKeyRing(nil)
+	if _, err := NewEncryptedStore(inner, ring, map[string]Protection{NamespaceQuotes: {EncryptValues: true}}); err != ErrNoEncryptionKey {
+		t.Errorf("no current key error = %v, want ErrNoEncryptionKey", err)
+	}
+	if _, err := NewEncryptedStore(inner, ring, map[string]Protection{NamespaceRateLimits: {HashKeys: true}}); err == nil {
+		t.Error("hashed namespace without a hash key should fail")
+	}
+	_ = ring.Rotate("k1", testKey(1))
+	if _, err := NewEncryptedStore(inner, ring, map[string]Protection{NamespaceRateLimits: {EncryptValues: true}}); err == nil {
+		t.Error("encrypted rate limits should be rejected")
+	}
+	if err := ring.Rotate("k2", []byte("short")); err == nil {
+		t.Error("Rotate() with a short key should fail")
+	}
+}
+
+func TestParseKeyRing(t *testing.T) {
+	hashKey := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef"))
+	k1 := base64.StdEncoding.EncodeToString(testKey(1))
+	k2 := base64.StdEncoding.EncodeToString(testKey(2))
+
+	ring, err := ParseKeyRing("k1:"+k1+", k2:"+k2, hashKey)
+	if err != nil {
+		t.Fatalf("ParseKeyRing() error = %v", err)
+	}
+	if id, _, _ := ring.CurrentKey(); id != "k2" {
+		t.Errorf("current key = %q, want the last listed", id)
+	}
+	if _, err := ring.KeyByID("k1"); err != nil {
+		t.Errorf("KeyByID(k1) error = %v", err)
+	}
+
+	for _, tt := range []struct{ keys, hashKey string }{
+		{"k1:" + k1, "c2hvcnQ="},
+		{"k1", hashKey},
+		{"k1:not-base64", hashKey},
+		{"k1:" + hashKey, hashKey},
+	} {
+		if _, err := ParseKeyRing(tt.keys, tt.hashKey); err == nil {
+			t.Errorf("ParseKeyRing(%q, %q) should fail", tt.keys, tt.hashKey)
+		}
+	}
+}
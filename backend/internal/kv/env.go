@@ -0,0 +1,199 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultRaftAddr is where a Raft member serves its peers unless
+// KV_RAFT_ADDR says otherwise. Members on other hosts need it set to an
+// internal interface.
+const DefaultRaftAddr = "127.0.0.1:7000"
+
+// Backend is what services need from KV. It is satisfied by the local,
+// durable, Raft-replicated, remote and encrypted stores.
+type Backend interface {
+	Store
+	GetPolicy(key string) ([]byte, bool)
+	SetPolicy(key string, policy []byte, ttl time.Duration) error
+	SetLedgerIndex(idx uint32) error
+	GetLedgerIndex() (uint32, bool)
+}
+
+// FromEnv opens the store a service is configured for: a client for a
+// shared kv server when KV_ADDR is set, a Raft-replicated store when
+// KV_RAFT_ID is set, and LocalFromEnv otherwise. With KV_ENCRYPTION_KEYS
+// ("id:base64key,...", the last one current, with KV_HASH_KEY) quote and
+// policy values are encrypted and partner IDs in keys hashed before they
+// reach the store; every service sharing it must use the same keys.
+func FromEnv() (Backend, error) {
+	var store Backend
+	var err error
+	switch {
+	case os.Getenv("KV_ADDR") != "":
+		store, err = remoteFromEnv(os.Getenv("KV_ADDR"))
+	case os.Getenv("KV_RAFT_ID") != "":
+		store, err = raftFromEnv(os.Getenv("KV_RAFT_ID"))
+	default:
+		store, err = LocalFromEnv()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := os.Getenv("KV_ENCRYPTION_KEYS")
+	if keys == "" {
+		return store, nil
+	}
+	ring, err := ParseKeyRing(keys, os.Getenv("KV_HASH_KEY"))
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("invalid kv encryption keys: %w", err)
+	}
+	encrypted, err := NewEncryptedStore(store, ring, DefaultProtection())
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to enable kv encryption: %w", err)
+	}
+	id, _, _ := ring.CurrentKey()
+	log.Printf("kv encryption enabled (current key %s)", id)
+	return encrypted, nil
+}
+
+// LocalFromEnv opens an in-process store. With KV_DATA_DIR it persists rate
+// limits, breaker state and the ledger index there so they survive
+// restarts; quotes are short-lived and stay memory-only unless listed in
+// KV_DURABLE_NAMESPACES.
+func LocalFromEnv() (Backend, error) {
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := os.Getenv("KV_DATA_DIR")
+	if dir == "" {
+		return NewMemoryStoreWithConfig(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, opts...), nil
+	}
+
+	namespaces := os.Getenv("KV_DURABLE_NAMESPACES")
+	if namespaces == "" {
+		namespaces = strings.Join([]string{NamespaceRateLimits, NamespaceCircuitBreaker, NamespaceSystem}, ",")
+	}
+	durable, err := NewDurableMemoryStore(DurabilityConfig{
+		Dir:        dir,
+		Namespaces: strings.Split(namespaces, ","),
+		Options:    opts,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open durable kv: %w", err)
+	}
+	log.Printf("durable kv in %s (namespaces: %s)", dir, namespaces)
+	return durable, nil
+}
+
+// OptionsFromEnv applies KV_NAMESPACES, e.g. "quotes=lru,67108864,10000",
+// on top of the default namespace budgets and eviction policies
+func OptionsFromEnv() ([]Option, error) {
+	spec := os.Getenv("KV_NAMESPACES")
+	if spec == "" {
+		return nil, nil
+	}
+	configs, err := ParseNamespaceConfigs(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid KV_NAMESPACES: %w", err)
+	}
+	return []Option{WithNamespaces(configs)}, nil
+}
+
+// remoteFromEnv connects to a shared kv server so state is visible to the
+// other services. The client certificate's CommonName selects the ACL.
+func remoteFromEnv(addr string) (Backend, error) {
+	client, err := DialTLS(addr, os.Getenv("KV_TLS_CERT"), os.Getenv("KV_TLS_KEY"), os.Getenv("KV_TLS_CA"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to kv server: %w", err)
+	}
+	log.Printf("using kv server at %s", addr)
+	return client, nil
+}
+
+// raftBackend stops serving peers when the store is closed
+type raftBackend struct {
+	*RaftStore
+	srv *http.Server
+}
+
+func (b *raftBackend) Close() error {
+	b.srv.Close()
+	return b.RaftStore.Close()
+}
+
+// raftFromEnv joins the Raft group in KV_RAFT_PEERS as id, keeping its
+// state in KV_RAFT_DIR (default KV_DATA_DIR/raft), and serves its peers on
+// KV_RAFT_ADDR over mTLS. KV_RAFT_TLS_CERT must name the member
+// ID as its CommonName and be usable as both server and client certificate;
+// peers are trusted if signed by KV_RAFT_TLS_CA.
+func raftFromEnv(id string) (Backend, error) {
+	peers, err := ParsePeers(os.Getenv("KV_RAFT_PEERS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KV_RAFT_PEERS: %w", err)
+	}
+	certFile, keyFile, caFile := os.Getenv("KV_RAFT_TLS_CERT"), os.Getenv("KV_RAFT_TLS_KEY"), os.Getenv("KV_RAFT_TLS_CA")
+	if certFile == "" || keyFile == "" || caFile == "" {
+		return nil, errors.New("KV_RAFT_TLS_CERT, KV_RAFT_TLS_KEY and KV_RAFT_TLS_CA are required")
+	}
+	serverTLS, err := ServerTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, fmt.Errorf("raft kv server TLS: %w", err)
+	}
+	clientTLS, err := ClientTLSConfig(certFile, keyFile, caFile, "")
+	if err != nil {
+		return nil, fmt.Errorf("raft kv client TLS: %w", err)
+	}
+	ids := make([]string, 0, len(peers))
+	for peer := range peers {
+		ids = append(ids, peer)
+	}
+	opts, err := OptionsFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	dir := os.Getenv("KV_RAFT_DIR")
+	if dir == "" && os.Getenv("KV_DATA_DIR") != "" {
+		dir = filepath.Join(os.Getenv("KV_DATA_DIR"), "raft")
+	}
+	if dir == "" {
+		return nil, errors.New("KV_RAFT_DIR or KV_DATA_DIR is required to keep raft state")
+	}
+
+	addr := os.Getenv("KV_RAFT_ADDR")
+	if addr == "" {
+		addr = DefaultRaftAddr
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("raft kv listen on %s: %w", addr, err)
+	}
+
+	transport := NewTLSHTTPTransport(peers, clientTLS)
+	raftStore, err := NewRaftStore(RaftConfig{ID: id, Peers: ids, Transport: transport, Dir: dir, Options: opts})
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to start raft kv: %w", err)
+	}
+
+	srv := &http.Server{Handler: transport.Handler(), TLSConfig: serverTLS, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		log.Printf("raft kv %s listening on %s (%d members)", id, addr, len(ids))
+		if err := srv.ServeTLS(ln, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("raft kv listener failed: %v", err)
+		}
+	}()
+	return &raftBackend{RaftStore: raftStore, srv: srv}, nil
+}
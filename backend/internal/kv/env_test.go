@@ -0,0 +1,44 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestFromEnv(t *testing.T) {
+	for _, key := range []string{"KV_ADDR", "KV_RAFT_ID", "KV_NAMESPACES", "KV_ENCRYPTION_KEYS"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("KV_DATA_DIR", t.TempDir())
+
+	store, err := FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+	if _, ok := store.(*MemoryStore); !ok {
+		t.Errorf("store = %T, want a durable *MemoryStore", store)
+	}
+	store.Close()
+
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	t.Setenv("KV_ENCRYPTION_KEYS", "k1:"+key)
+	t.Setenv("KV_HASH_KEY", key)
+	store, err = FromEnv()
+	if err != nil {
+		t.Fatalf("FromEnv() with keys error = %v", err)
+	}
+	if _, ok := store.(*EncryptedStore); !ok {
+		t.Errorf("store = %T, want *EncryptedStore", store)
+	}
+	if err := store.SetPolicy("p1", []byte("deny"), time.Minute); err != nil {
+		t.Errorf("SetPolicy() error = %v", err)
+	}
+	store.Close()
+
+	t.Setenv("KV_NAMESPACES", "quotes=bogus")
+	if _, err := FromEnv(); err == nil {
+		t.Error("FromEnv() with invalid KV_NAMESPACES: want error")
+	}
+}
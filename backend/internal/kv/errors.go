@@ -10,5 +10,22 @@ var (
 	ErrMemoryLimit      = errors.New("memory limit exceeded")
 	ErrKeyNotFound      = errors.New("key not found")
 	ErrNamespaceQuota   = errors.New("namespace quota exceeded")
+	ErrNamespaceFull    = errors.New("namespace memory budget exceeded")
 	ErrInvalidNamespace = errors.New("invalid namespace")
+	ErrInvalidRateLimit = errors.New("rate limit, period and burst must be positive")
+	ErrNotCounter       = errors.New("value is not an integer counter")
+	ErrCounterOverflow  = errors.New("counter overflow")
+	ErrTxnTooLarge      = errors.New("transaction has too many operations")
+
+	ErrNotLeader      = errors.New("raft: node is not the leader")
+	ErrNoLeader       = errors.New("raft: no leader elected")
+	ErrLeadershipLost = errors.New("raft: leadership lost before entry committed")
+	ErrOutcomeUnknown = errors.New("raft: entry outcome unknown")
+	ErrRaftStopped    = errors.New("raft: node stopped")
+	ErrUnknownPeer    = errors.New("raft: unknown peer")
+
+	ErrForbidden     = errors.New("kv: namespace access denied")
+	ErrFrameTooLarge = errors.New("kv: frame exceeds maximum size")
+	ErrClientClosed  = errors.New("kv: client closed")
+	ErrStoreClosed   = errors.New("kv: store closed")
 )
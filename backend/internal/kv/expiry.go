@@ -0,0 +1,175 @@
+package kv
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const DefaultExpiryTick = 100 * time.Millisecond
+
+// Hierarchical timing wheel: level k has wheelSlots slots each spanning
+// wheelSlots^k ticks, so four levels of 64 cover 64^4 ticks (about 19 days
+// at 100ms). Entries further out park in the top level and are re-placed
+// when their slot comes round.
+const (
+	wheelBits   = 6
+	wheelSlots  = 1 << wheelBits
+	wheelMask   = wheelSlots - 1
+	wheelLevels = 4
+	wheelSpan   = int64(1) << (wheelBits * wheelLevels)
+)
+
+var (
+	kvExpirations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "lucendex_kv_expirations_total",
+			Help: "KV entries reclaimed after their TTL",
+		},
+		[]string{"namespace"},
+	)
+
+	kvExpiryLag = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "lucendex_kv_expiry_lag_seconds",
+			Help:    "Delay between a KV entry's deadline and its reclamation",
+			Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 5, 30},
+		},
+	)
+)
+
+// timingWheel tracks the deadlines of one shard's entries. It is guarded by
+// the shard lock; entries link into their slot through intrusive pointers so
+// rescheduling and removal are O(1).
+type timingWheel struct {
+	tick    int64 // nanoseconds per tick
+	current int64 // last tick processed
+	slots   [wheelLevels][wheelSlots]*entry
+}
+
+func newTimingWheel(tick time.Duration, now time.Time) timingWheel {
+	return timingWheel{tick: int64(tick), current: now.UnixNano() / int64(tick)}
+}
+
+// schedule places e by its deadline; e must not already be scheduled
+func (w *timingWheel) schedule(e *entry) {
+	d := e.expiresAt.UnixNano() / w.tick
+	if d <= w.current {
+		d = w.current + 1
+	}
+	delta := d - w.current
+	if delta >= wheelSpan {
+		d = w.current + wheelSpan - 1
+		delta = wheelSpan - 1
+	}
+
+	level := 0
+	for delta >= int64(1)<<(wheelBits*(level+1)) {
+		level++
+	}
+
+	slot := &w.slots[level][(d>>(wheelBits*level))&wheelMask]
+	e.timerSlot = slot
+	e.timerPrev = nil
+	e.timerNext = *slot
+	if *slot != nil {
+		(*slot).timerPrev = e
+	}
+	*slot = e
+}
+
+// remove unschedules e; a no-op for entries without a deadline
+func (w *timingWheel) remove(e *entry) {
+	if e.timerSlot == nil {
+		return
+	}
+	if e.timerPrev != nil {
+		e.timerPrev.timerNext = e.timerNext
+	} else {
+		*e.timerSlot = e.timerNext
+	}
+	if e.timerNext != nil {
+		e.timerNext.timerPrev = e.timerPrev
+	}
+	e.timerSlot, e.timerPrev, e.timerNext = nil, nil, nil
+}
+
+// advance processes every tick up to now, calling fire for each entry whose
+// deadline has passed. fire must not reschedule the entry.
+func (w *timingWheel) advance(now time.Time, fire func(*entry)) {
+	target := now.UnixNano() / w.tick
+	for w.current < target {
+		w.current++
+
+		// Cascade top-down so an entry can fall through several levels in
+		// one tick
+		for level := wheelLevels - 1; level > 0; level-- {
+			if w.current&(int64(1)<<(wheelBits*level)-1) != 0 {
+				continue
+			}
+			slot := &w.slots[level][(w.current>>(wheelBits*level))&wheelMask]
+			for e := *slot; e != nil; e = *slot {
+				w.remove(e)
+				w.schedule(e)
+			}
+		}
+
+		slot := &w.slots[0][w.current&wheelMask]
+		for e := *slot; e != nil; e = *slot {
+			w.remove(e)
+			if e.expired(now) {
+				fire(e)
+			} else {
+				// Due later within the current tick
+				w.schedule(e)
+			}
+		}
+	}
+
+	// The next slot holds deadlines inside the tick now falls in; reap the
+	// ones already past so callers see no expired entries at all
+	next := &w.slots[0][(w.current+1)&wheelMask]
+	for e := *next; e != nil; {
+		following := e.timerNext
+		if e.expired(now) {
+			w.remove(e)
+			fire(e)
+		}
+		e = following
+	}
+}
+
+// expire advances every shard's wheel to now
+func (s *MemoryStore) expire(now time.Time) {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		sh.wheel.advance(now, func(e *entry) {
+			s.expireEntryLocked(sh, s.makeKey(e.namespace, e.key), e, now)
+		})
+		sh.mu.Unlock()
+	}
+}
+
+// expireEntryLocked removes an entry whose TTL has passed and records it
+func (s *MemoryStore) expireEntryLocked(sh *shard, fullKey string, e *entry, now time.Time) {
+	s.deleteEntryLocked(sh, fullKey, e)
+	s.publish(EventExpire, e.namespace, e.key, nil, time.Time{})
+	s.expirations.Add(1)
+	kvExpirations.WithLabelValues(e.namespace).Inc()
+	kvExpiryLag.Observe(now.Sub(e.expiresAt).Seconds())
+}
+
+func (s *MemoryStore) expiryLoop() {
+	ticker := time.NewTicker(DefaultExpiryTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.expire(now)
+		}
+	}
+}
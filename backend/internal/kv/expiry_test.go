@@ -0,0 +1,120 @@
+package kv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_ExpiresWithoutAccess(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		if err := store.Set("test", string(rune('a'+i)), []byte("value"), 50*time.Millisecond); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := store.Set("test", "forever", []byte("value"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for store.Stats().TotalKeys != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("TotalKeys = %d after TTL, want 1", store.Stats().TotalKeys)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stats := store.Stats()
+	if stats.Expirations != 20 {
+		t.Errorf("Expirations = %d, want 20", stats.Expirations)
+	}
+	if stats.CurrentBytes <= 0 {
+		t.Errorf("CurrentBytes = %d, want the remaining entry", stats.CurrentBytes)
+	}
+}
+
+func TestMemoryStore_ExpiryFreesQuota(t *testing.T) {
+	store := NewMemoryStoreWithConfig(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize,
+		WithNamespace(NamespaceQuotes, NamespaceConfig{MaxKeys: 10, Eviction: EvictNone}))
+	defer store.Close()
+
+	for i := 0; i < 10; i++ {
+		if err := store.Set(NamespaceQuotes, string(rune('a'+i)), []byte("v"), 50*time.Millisecond); err != nil {
+			t.Fatalf("Set(%d) error = %v", i, err)
+		}
+	}
+	if err := store.Set(NamespaceQuotes, "over", []byte("v"), 0); err != ErrNamespaceQuota {
+		t.Fatalf("Set() at quota error = %v, want ErrNamespaceQuota", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	store.cleanup()
+
+	if err := store.Set(NamespaceQuotes, "over", []byte("v"), 0); err != nil {
+		t.Errorf("Set() after expiry error = %v", err)
+	}
+}
+
+func TestMemoryStore_OverwriteReschedules(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	_ = store.Set("test", "k", []byte("short"), 50*time.Millisecond)
+	_ = store.Set("test", "k", []byte("long"), time.Hour)
+
+	time.Sleep(60 * time.Millisecond)
+	store.cleanup()
+
+	if v, ok := store.Get("test", "k"); !ok || string(v) != "long" {
+		t.Errorf("Get() = %q, %v; overwrite must drop the earlier deadline", v, ok)
+	}
+
+	_ = store.Set("test", "k", []byte("persistent"), 0)
+	if store.Stats().Expirations != 0 {
+		t.Errorf("Expirations = %d, want 0", store.Stats().Expirations)
+	}
+}
+
+func TestTimingWheel_Cascades(t *testing.T) {
+	tick := time.Millisecond
+	start := time.Unix(0, 0)
+	w := newTimingWheel(tick, start)
+
+	// One deadline per level, plus one beyond the wheel's span
+	offsets := []int64{3, 70, 5000, 300000, wheelSpan + 12345}
+	entries := make(map[*entry]int64)
+	for _, off := range offsets {
+		e := &entry{expiresAt: start.Add(time.Duration(off) * tick)}
+		w.schedule(e)
+		entries[e] = off
+	}
+
+	cancelled := &entry{expiresAt: start.Add(5000 * tick)}
+	w.schedule(cancelled)
+	w.remove(cancelled)
+
+	fired := make(map[*entry]int64)
+	step := int64(997)
+	for now := int64(0); now <= wheelSpan+20000; now += step {
+		at := now
+		w.advance(start.Add(time.Duration(at)*tick+tick/2), func(e *entry) {
+			fired[e] = at
+		})
+	}
+
+	if _, ok := fired[cancelled]; ok {
+		t.Error("removed entry fired")
+	}
+	for e, off := range entries {
+		at, ok := fired[e]
+		if !ok {
+			t.Errorf("deadline +%d never fired", off)
+			continue
+		}
+		if at < off || at-off > step {
+			t.Errorf("deadline +%d fired at %d", off, at)
+		}
+	}
+}
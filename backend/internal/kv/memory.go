@@ -1,9 +1,10 @@
 package kv
 
 import (
-	"container/list"
 	"encoding/hex"
-	"fmt"
+	"hash/maphash"
+	"math"
+	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
@@ -11,37 +12,92 @@ import (
 )
 
 const (
-	DefaultMaxBytes        = 512 * 1024 * 1024
-	DefaultMaxKeyLength    = 256
-	DefaultMaxValueSize    = 1024 * 1024
-	DefaultCleanupInterval = 60 * time.Second
+	DefaultMaxBytes     = 512 * 1024 * 1024
+	DefaultMaxKeyLength = 256
+	DefaultMaxValueSize = 1024 * 1024
+	DefaultShards       = 64
 
 	NamespaceQuotes         = "quotes"
 	NamespaceRateLimits     = "rate_limits"
 	NamespaceCircuitBreaker = "circuit_breaker"
 	NamespaceSystem         = "system"
+	NamespacePolicies       = "policies"
+	NamespaceRequestIDs     = "request_ids"
 )
 
-var namespaceQuotas = map[string]int64{
-	NamespaceQuotes:         10000,
-	NamespaceRateLimits:     100000,
-	NamespaceCircuitBreaker: 1000,
-	NamespaceSystem:         128,
-}
+const (
+	entryOverhead = 64
+
+	// evictionSamples is how many evictable entries a full shard inspects
+	// to pick a victim. At most evictionScanLimit entries are looked at per
+	// shard, so a shard holding mostly other namespaces fails fast instead
+	// of scanning everything on each write.
+	evictionSamples   = 5
+	evictionScanLimit = 64
+
+	// lfuDecayTicks halves an entry's access count for every this many
+	// shard clock ticks it goes unread, so formerly hot keys can age out
+	lfuDecayTicks = 1 << 16
+)
 
 type entry struct {
-	namespace string
-	key       string
-	value     []byte
-	expiresAt time.Time
-	size      int
-	lruNode   *list.Element
+	namespace  string
+	key        string
+	value      []byte
+	expiresAt  time.Time
+	size       int
+	lastAccess atomic.Uint64 // shard clock tick of the last read or write
+	hits       atomic.Uint32 // accesses, for LFU eviction
+
+	// timing wheel links, set while the entry has a scheduled deadline
+	timerSlot *(*entry)
+	timerPrev *entry
+	timerNext *entry
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && now.After(e.expiresAt)
+}
+
+// frequency is the entry's access count decayed by how long it has been idle
+func (e *entry) frequency(clock uint64) uint32 {
+	idle := (clock - e.lastAccess.Load()) / lfuDecayTicks
+	if idle >= 32 {
+		return 0
+	}
+	return e.hits.Load() >> idle
+}
+
+// shard owns a slice of the key space with its own lock and byte budget.
+// Reads only take the read lock: recency is recorded by stamping the
+// entry with the shard clock instead of reordering a list.
+type shard struct {
+	mu       sync.RWMutex
+	data     map[string]*entry
+	bytes    int64
+	maxBytes int64
+	clock    atomic.Uint64
+	wheel    timingWheel
+}
+
+func (sh *shard) touch(e *entry) {
+	e.lastAccess.Store(sh.clock.Add(1))
+	if e.hits.Load() < math.MaxUint32 {
+		e.hits.Add(1)
+	}
 }
 
+// MemoryStore is an in-memory Store split into hash-addressed shards so
+// operations on different keys rarely contend. Each shard evicts its own
+// entries when its share of maxBytes is exhausted, choosing only from
+// namespaces whose policy allows it, and namespaces may have byte budgets
+// of their own. Per-namespace key and byte counts are kept as atomic
+// counters, so quota checks and Stats don't scan the store. TTLs are tracked
+// in a timing wheel per shard, reclaiming entries within DefaultExpiryTick
+// of their deadline.
 type MemoryStore struct {
-	mu           sync.RWMutex
-	data         map[string]*entry
-	lru          *list.List
+	shards       []*shard
+	seed         maphash.Seed
 	maxBytes     int64
 	currentBytes int64
 	maxKeyLength int
@@ -49,103 +105,145 @@ type MemoryStore struct {
 	evictions    int64
 	hits         int64
 	misses       int64
+	expirations  atomic.Int64
 	stopCh       chan struct{}
 	stopped      atomic.Bool
+
+	evictCursor      atomic.Uint32 // first shard tried by the next evictNamespaceLocked
+	namespaces       sync.Map      // namespace -> *namespaceUsage
+	namespaceConfigs map[string]NamespaceConfig
+	defaultNamespace NamespaceConfig
+	watchers         watchers
+
+	wal     *wal            // nil unless opened with NewDurableMemoryStore
+	durable map[string]bool // namespaces written to the WAL
 }
 
 func NewMemoryStore() *MemoryStore {
 	return NewMemoryStoreWithConfig(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize)
 }
 
-func NewMemoryStoreWithConfig(maxBytes int64, maxKeyLength, maxValueSize int) *MemoryStore {
+// NewMemoryStoreWithConfig creates a store with the given limits. Namespaces
+// start from DefaultNamespaceConfigs; opts may override them.
+func NewMemoryStoreWithConfig(maxBytes int64, maxKeyLength, maxValueSize int, opts ...Option) *MemoryStore {
+	return newMemoryStore(maxBytes, maxKeyLength, maxValueSize, shardCount(maxBytes, maxKeyLength, maxValueSize), opts...)
+}
+
+func newMemoryStore(maxBytes int64, maxKeyLength, maxValueSize, shards int, opts ...Option) *MemoryStore {
 	s := &MemoryStore{
-		data:         make(map[string]*entry),
-		lru:          list.New(),
-		maxBytes:     maxBytes,
-		maxKeyLength: maxKeyLength,
-		maxValueSize: maxValueSize,
-		stopCh:       make(chan struct{}),
-	}
-	go s.cleanupLoop()
+		shards:           make([]*shard, shards),
+		seed:             maphash.MakeSeed(),
+		maxBytes:         maxBytes,
+		maxKeyLength:     maxKeyLength,
+		maxValueSize:     maxValueSize,
+		stopCh:           make(chan struct{}),
+		namespaceConfigs: DefaultNamespaceConfigs(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	now := time.Now()
+	for i := range s.shards {
+		s.shards[i] = &shard{
+			data:     make(map[string]*entry),
+			maxBytes: maxBytes / int64(shards),
+			wheel:    newTimingWheel(DefaultExpiryTick, now),
+		}
+	}
+	go s.expiryLoop()
 	return s
 }
 
+// shardCount is the largest power of two up to DefaultShards that leaves
+// every shard room for a few maximum-size entries. Small stores end up with
+// a single shard.
+func shardCount(maxBytes int64, maxKeyLength, maxValueSize int) int {
+	minShardBytes := int64(4 * (maxKeyLength + maxValueSize + entryOverhead))
+	n := DefaultShards
+	for n > 1 && maxBytes/int64(n) < minShardBytes {
+		n /= 2
+	}
+	return n
+}
+
+func (s *MemoryStore) shardFor(fullKey string) *shard {
+	return s.shards[s.shardIndex(fullKey)]
+}
+
+func (s *MemoryStore) shardIndex(fullKey string) int {
+	return int(maphash.String(s.seed, fullKey) & uint64(len(s.shards)-1))
+}
+
 func (s *MemoryStore) Get(namespace, key string) ([]byte, bool) {
 	if namespace == "" || key == "" {
 		atomic.AddInt64(&s.misses, 1)
 		return nil, false
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	fullKey := s.makeKey(namespace, key)
-	e, ok := s.data[fullKey]
+	sh := s.shardFor(fullKey)
+
+	sh.mu.RLock()
+	e, ok := sh.data[fullKey]
 	if !ok {
+		sh.mu.RUnlock()
 		atomic.AddInt64(&s.misses, 1)
 		return nil, false
 	}
-
-	if !e.expiresAt.IsZero() && time.Now().After(e.expiresAt) {
-		s.deleteEntryLocked(fullKey, e)
+	if now := time.Now(); e.expired(now) {
+		sh.mu.RUnlock()
+		s.dropExpired(sh, fullKey, e, now)
 		atomic.AddInt64(&s.misses, 1)
 		return nil, false
 	}
 
-	s.lru.MoveToFront(e.lruNode)
-	atomic.AddInt64(&s.hits, 1)
-
+	sh.touch(e)
 	result := make([]byte, len(e.value))
 	copy(result, e.value)
+	sh.mu.RUnlock()
+
+	atomic.AddInt64(&s.hits, 1)
 	return result, true
 }
 
-func (s *MemoryStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
-	if err := s.validate(namespace, key, value); err != nil {
-		return err
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// dropExpired removes e if it is still the entry stored under fullKey
+func (s *MemoryStore) dropExpired(sh *shard, fullKey string, e *entry, now time.Time) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	if err := s.checkNamespaceQuota(namespace); err != nil {
-		return err
+	if current, ok := sh.data[fullKey]; ok && current == e {
+		s.expireEntryLocked(sh, fullKey, e, now)
 	}
+}
 
-	fullKey := s.makeKey(namespace, key)
-	entrySize := len(fullKey) + len(value) + 64
+func (s *MemoryStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	return s.setAt(namespace, key, value, expiryFrom(time.Now(), ttl))
+}
 
-	if existing, ok := s.data[fullKey]; ok {
-		atomic.AddInt64(&s.currentBytes, -int64(existing.size))
-		s.lru.Remove(existing.lruNode)
+// setAt stores a value with an absolute expiry. Replicated stores use it so
+// every replica expires the entry at the same instant.
+func (s *MemoryStore) setAt(namespace, key string, value []byte, expiresAt time.Time) error {
+	if err := s.validate(namespace, key, value); err != nil {
+		return err
 	}
 
-	for s.currentBytes+int64(entrySize) > s.maxBytes {
-		if !s.evictOldest() {
-			return ErrMemoryLimit
-		}
+	quota := s.namespaceConfig(namespace).MaxKeys
+	if quota > 0 && s.usage(namespace).keys.Load() >= quota {
+		// Reclaim entries that expired since the last tick before refusing
+		s.expire(time.Now())
 	}
 
-	var expiresAt time.Time
-	if ttl > 0 {
-		expiresAt = time.Now().Add(ttl)
-	}
+	fullKey := s.makeKey(namespace, key)
+	sh := s.shardFor(fullKey)
 
-	valueCopy := make([]byte, len(value))
-	copy(valueCopy, value)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	e := &entry{
-		namespace: namespace,
-		key:       key,
-		value:     valueCopy,
-		expiresAt: expiresAt,
-		size:      entrySize,
+	if err := s.setLockedAt(sh, namespace, key, value, expiresAt, quota); err != nil {
+		return err
 	}
-	e.lruNode = s.lru.PushFront(fullKey)
-	s.data[fullKey] = e
-	atomic.AddInt64(&s.currentBytes, int64(entrySize))
-
-	return nil
+	s.publish(EventSet, namespace, key, value, expiresAt)
+	return s.logLocked(recordSet, namespace, key, value, expiresAt)
 }
 
 func (s *MemoryStore) Delete(namespace, key string) error {
@@ -153,53 +251,32 @@ func (s *MemoryStore) Delete(namespace, key string) error {
 		return ErrKeyEmpty
 	}
 
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	fullKey := s.makeKey(namespace, key)
-	e, ok := s.data[fullKey]
+	sh := s.shardFor(fullKey)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	e, ok := sh.data[fullKey]
 	if !ok {
 		return ErrKeyNotFound
 	}
 
-	s.deleteEntryLocked(fullKey, e)
-	return nil
+	s.deleteEntryLocked(sh, fullKey, e)
+	s.publish(EventDelete, namespace, key, nil, time.Time{})
+	return s.logLocked(recordDelete, namespace, key, nil, time.Time{})
 }
 
+// IncrementRateLimit counts a request against partnerID's fixed window. The
+// window starts with the first request and lasts ttl; later requests in it
+// keep its expiry.
 func (s *MemoryStore) IncrementRateLimit(partnerID string, ttl time.Duration) (int64, error) {
-	if partnerID == "" {
-		return 0, ErrKeyEmpty
-	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	fullKey := s.makeKey(NamespaceRateLimits, partnerID)
-	e, ok := s.data[fullKey]
-
-	if !ok || (!e.expiresAt.IsZero() && time.Now().After(e.expiresAt)) {
-		if ok {
-			s.deleteEntryLocked(fullKey, e)
-		}
-
-		if err := s.setLocked(NamespaceRateLimits, partnerID, []byte("1"), ttl); err != nil {
-			return 0, err
-		}
-		return 1, nil
-	}
-
-	count, err := strconv.ParseInt(string(e.value), 10, 64)
-	if err != nil {
-		return 0, fmt.Errorf("invalid counter value: %w", err)
-	}
-	count++
-
-	newValue := []byte(strconv.FormatInt(count, 10))
-	if err := s.setLocked(NamespaceRateLimits, partnerID, newValue, ttl); err != nil {
-		return 0, err
-	}
+	return s.incrementAt(partnerID, ttl, time.Now())
+}
 
-	return count, nil
+// incrementAt is IncrementRateLimit evaluated at a fixed instant
+func (s *MemoryStore) incrementAt(partnerID string, ttl time.Duration, now time.Time) (int64, error) {
+	return s.incrementByAt(NamespaceRateLimits, partnerID, 1, ttl, now)
 }
 
 func (s *MemoryStore) GetQuote(hash [32]byte) ([]byte, bool) {
@@ -212,6 +289,14 @@ func (s *MemoryStore) SetQuote(hash [32]byte, route []byte, ttl time.Duration) e
 	return s.Set(NamespaceQuotes, key, route, ttl)
 }
 
+func (s *MemoryStore) GetPolicy(key string) ([]byte, bool) {
+	return s.Get(NamespacePolicies, key)
+}
+
+func (s *MemoryStore) SetPolicy(key string, policy []byte, ttl time.Duration) error {
+	return s.Set(NamespacePolicies, key, policy, ttl)
+}
+
 func (s *MemoryStore) SetLedgerIndex(idx uint32) error {
 	value := []byte(strconv.FormatUint(uint64(idx), 10))
 	return s.Set(NamespaceSystem, "ledger_index", value, 0)
@@ -231,37 +316,38 @@ func (s *MemoryStore) GetLedgerIndex() (uint32, bool) {
 }
 
 func (s *MemoryStore) Keys(namespace string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	now := time.Now()
 	var keys []string
 
-	for _, e := range s.data {
-		if e.namespace == namespace {
-			if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for _, e := range sh.data {
+			if e.namespace == namespace && !e.expired(now) {
 				keys = append(keys, e.key)
 			}
 		}
+		sh.mu.RUnlock()
 	}
 
 	return keys
 }
 
+// Stats reports counters maintained on write, so it costs the same however
+// large the store is
 func (s *MemoryStore) Stats() Stats {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	namespaceCounts := make(map[string]int64)
+	namespaceBytes := make(map[string]int64)
 	totalKeys := int64(0)
 
-	now := time.Now()
-	for _, e := range s.data {
-		if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
-			namespaceCounts[e.namespace]++
-			totalKeys++
+	s.namespaces.Range(func(ns, u any) bool {
+		usage := u.(*namespaceUsage)
+		if n := usage.keys.Load(); n > 0 {
+			namespaceCounts[ns.(string)] = n
+			namespaceBytes[ns.(string)] = usage.bytes.Load()
+			totalKeys += n
 		}
-	}
+		return true
+	})
 
 	return Stats{
 		TotalKeys:       totalKeys,
@@ -270,7 +356,9 @@ func (s *MemoryStore) Stats() Stats {
 		Evictions:       atomic.LoadInt64(&s.evictions),
 		Hits:            atomic.LoadInt64(&s.hits),
 		Misses:          atomic.LoadInt64(&s.misses),
+		Expirations:     s.expirations.Load(),
 		NamespaceCounts: namespaceCounts,
+		NamespaceBytes:  namespaceBytes,
 	}
 }
 
@@ -279,9 +367,28 @@ func (s *MemoryStore) Close() error {
 		return nil
 	}
 	close(s.stopCh)
+
+	if s.wal != nil {
+		s.lockAll()
+		defer s.unlockAll()
+		return s.wal.close()
+	}
 	return nil
 }
 
+// lockAll takes every shard lock in index order, freezing the whole store
+func (s *MemoryStore) lockAll() {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+	}
+}
+
+func (s *MemoryStore) unlockAll() {
+	for _, sh := range s.shards {
+		sh.mu.Unlock()
+	}
+}
+
 func (s *MemoryStore) validate(namespace, key string, value []byte) error {
 	if namespace == "" {
 		return ErrNamespaceEmpty
@@ -298,46 +405,88 @@ func (s *MemoryStore) validate(namespace, key string, value []byte) error {
 	return nil
 }
 
-func (s *MemoryStore) checkNamespaceQuota(namespace string) error {
-	quota, ok := namespaceQuotas[namespace]
-	if !ok {
-		return nil
+// reserveKey claims one slot of a namespace quota; quota <= 0 is unlimited
+func (s *MemoryStore) reserveKey(namespace string, quota int64) bool {
+	counter := &s.usage(namespace).keys
+	if quota <= 0 {
+		counter.Add(1)
+		return true
 	}
-
-	count := int64(0)
-	now := time.Now()
-	for _, e := range s.data {
-		if e.namespace == namespace {
-			if e.expiresAt.IsZero() || now.Before(e.expiresAt) {
-				count++
-			}
+	for {
+		n := counter.Load()
+		if n >= quota {
+			return false
+		}
+		if counter.CompareAndSwap(n, n+1) {
+			return true
 		}
 	}
+}
 
-	if count >= quota {
-		return ErrNamespaceQuota
+// reserveBytes claims delta bytes of a namespace budget across all shards;
+// limit <= 0 is unlimited and a shrinking entry always fits
+func (s *MemoryStore) reserveBytes(namespace string, delta, limit int64) bool {
+	counter := &s.usage(namespace).bytes
+	if limit <= 0 || delta <= 0 {
+		counter.Add(delta)
+		return true
+	}
+	for {
+		n := counter.Load()
+		if n+delta > limit {
+			return false
+		}
+		if counter.CompareAndSwap(n, n+delta) {
+			return true
+		}
 	}
-	return nil
 }
 
-func (s *MemoryStore) setLocked(namespace, key string, value []byte, ttl time.Duration) error {
+// setLockedAt inserts or replaces an entry in sh, which must be locked.
+// New keys are counted against quota (<= 0 for none). When the quota or the
+// namespace byte budget, both shared by all shards, is reached, room is made
+// by evicting the namespace's entries from any shard; when the shard is full, by evicting
+// anything evictable in it.
+func (s *MemoryStore) setLockedAt(sh *shard, namespace, key string, value []byte, expiresAt time.Time, quota int64) error {
 	fullKey := s.makeKey(namespace, key)
-	entrySize := len(fullKey) + len(value) + 64
-
-	if existing, ok := s.data[fullKey]; ok {
-		atomic.AddInt64(&s.currentBytes, -int64(existing.size))
-		s.lru.Remove(existing.lruNode)
+	entrySize := int64(len(fullKey) + len(value) + entryOverhead)
+	if entrySize > sh.maxBytes {
+		return ErrMemoryLimit
+	}
+	cfg := s.namespaceConfig(namespace)
+	if cfg.MaxBytes > 0 && entrySize > cfg.MaxBytes {
+		return ErrNamespaceFull
 	}
 
-	for s.currentBytes+int64(entrySize) > s.maxBytes {
-		if !s.evictOldest() {
-			return ErrMemoryLimit
+	existing, exists := sh.data[fullKey]
+	for !exists && !s.reserveKey(namespace, quota) {
+		if cfg.Eviction == EvictNone || !s.evictNamespaceLocked(sh, fullKey, namespace) {
+			return ErrNamespaceQuota
+		}
+	}
+	release := func() {
+		if !exists {
+			s.usage(namespace).keys.Add(-1)
 		}
 	}
 
-	var expiresAt time.Time
-	if ttl > 0 {
-		expiresAt = time.Now().Add(ttl)
+	freed := int64(0)
+	if exists {
+		freed = int64(existing.size)
+	}
+	delta := entrySize - freed
+	for !s.reserveBytes(namespace, delta, cfg.MaxBytes) {
+		if cfg.Eviction == EvictNone || !s.evictNamespaceLocked(sh, fullKey, namespace) {
+			release()
+			return ErrNamespaceFull
+		}
+	}
+	for sh.bytes-freed+entrySize > sh.maxBytes {
+		if !s.evictLocked(sh, fullKey, "") {
+			s.usage(namespace).bytes.Add(-delta)
+			release()
+			return ErrMemoryLimit
+		}
 	}
 
 	valueCopy := make([]byte, len(value))
@@ -348,72 +497,222 @@ func (s *MemoryStore) setLocked(namespace, key string, value []byte, ttl time.Du
 		key:       key,
 		value:     valueCopy,
 		expiresAt: expiresAt,
-		size:      entrySize,
+		size:      int(entrySize),
+	}
+	if exists {
+		sh.wheel.remove(existing)
+		e.hits.Store(existing.hits.Load())
 	}
-	e.lruNode = s.lru.PushFront(fullKey)
-	s.data[fullKey] = e
-	atomic.AddInt64(&s.currentBytes, int64(entrySize))
+	sh.touch(e)
+	sh.data[fullKey] = e
+	if !expiresAt.IsZero() {
+		sh.wheel.schedule(e)
+	}
+
+	sh.bytes += delta
+	atomic.AddInt64(&s.currentBytes, delta)
 
 	return nil
 }
 
-func (s *MemoryStore) evictOldest() bool {
-	elem := s.lru.Back()
-	if elem == nil {
+// evictLocked removes one entry from sh, restricted to namespace unless it
+// is empty. Expired entries go first; otherwise a few entries from
+// evictable namespaces are sampled, each policy nominates its victim from
+// the sample, and the least recently used nominee is evicted. keep is the
+// key being written and is never chosen. Map iteration order is
+// randomised, so the sample is too.
+func (s *MemoryStore) evictLocked(sh *shard, keep, namespace string) bool {
+	now := time.Now()
+	clock := sh.clock.Load()
+	var lru, lfu *entry
+	lruKey, lfuKey := "", ""
+	sampled, scanned := 0, 0
+
+	for fullKey, e := range sh.data {
+		if scanned++; scanned > evictionScanLimit {
+			break
+		}
+		if fullKey == keep || (namespace != "" && e.namespace != namespace) {
+			continue
+		}
+		if e.expired(now) {
+			s.expireEntryLocked(sh, fullKey, e, now)
+			return true
+		}
+
+		switch s.namespaceConfig(e.namespace).Eviction {
+		case EvictLRU:
+			if lru == nil || e.lastAccess.Load() < lru.lastAccess.Load() {
+				lru, lruKey = e, fullKey
+			}
+		case EvictLFU:
+			if lfu == nil || lessFrequent(e, lfu, clock) {
+				lfu, lfuKey = e, fullKey
+			}
+		default:
+			continue
+		}
+		if sampled++; sampled >= evictionSamples {
+			break
+		}
+	}
+
+	victim, victimKey := lru, lruKey
+	if lfu != nil && (victim == nil || lfu.lastAccess.Load() < victim.lastAccess.Load()) {
+		victim, victimKey = lfu, lfuKey
+	}
+	if victim == nil {
 		return false
 	}
+	s.deleteEntryLocked(sh, victimKey, victim)
+	atomic.AddInt64(&s.evictions, 1)
+	s.publish(EventEvict, victim.namespace, victim.key, nil, time.Time{})
+	return true
+}
 
-	fullKey := elem.Value.(string)
-	if e, ok := s.data[fullKey]; ok {
-		s.deleteEntryLocked(fullKey, e)
-		atomic.AddInt64(&s.evictions, 1)
+// evictNamespaceLocked evicts one of namespace's entries to make room in its
+// budget, which all shards share. sh, which must be locked, is tried first,
+// then the others in turn from a rotating start. Shards another writer holds
+// are skipped rather than waited for, so two writers can't deadlock.
+func (s *MemoryStore) evictNamespaceLocked(sh *shard, keep, namespace string) bool {
+	if s.evictLocked(sh, keep, namespace) {
 		return true
 	}
+	n := uint32(len(s.shards))
+	start := s.evictCursor.Add(1)
+	for i := uint32(0); i < n; i++ {
+		other := s.shards[(start+i)%n]
+		if other == sh || !other.mu.TryLock() {
+			continue
+		}
+		evicted := s.evictLocked(other, keep, namespace)
+		other.mu.Unlock()
+		if evicted {
+			return true
+		}
+	}
 	return false
 }
 
-func (s *MemoryStore) deleteEntryLocked(fullKey string, e *entry) {
-	if e.lruNode != nil {
-		s.lru.Remove(e.lruNode)
+func lessFrequent(a, b *entry, clock uint64) bool {
+	fa, fb := a.frequency(clock), b.frequency(clock)
+	if fa != fb {
+		return fa < fb
 	}
-	delete(s.data, fullKey)
+	return a.lastAccess.Load() < b.lastAccess.Load()
+}
+
+func (s *MemoryStore) deleteEntryLocked(sh *shard, fullKey string, e *entry) {
+	sh.wheel.remove(e)
+	delete(sh.data, fullKey)
+	sh.bytes -= int64(e.size)
 	atomic.AddInt64(&s.currentBytes, -int64(e.size))
+	usage := s.usage(e.namespace)
+	usage.keys.Add(-1)
+	usage.bytes.Add(-int64(e.size))
 }
 
-func (s *MemoryStore) makeKey(namespace, key string) string {
-	return namespace + ":" + key
+// SnapshotEntry is one live entry in a point-in-time copy of the store
+type SnapshotEntry struct {
+	Namespace string `json:"ns"`
+	Key       string `json:"key"`
+	Value     []byte `json:"value"`
+	ExpiresAt int64  `json:"expires_at,omitempty"` // unix nanoseconds, 0 = no expiry
 }
 
-func (s *MemoryStore) cleanupLoop() {
-	ticker := time.NewTicker(DefaultCleanupInterval)
-	defer ticker.Stop()
+// snapshot copies every unexpired entry, least recently used first
+func (s *MemoryStore) snapshot() []SnapshotEntry {
+	s.lockAll()
+	defer s.unlockAll()
+	return s.snapshotLocked(nil)
+}
 
-	for {
-		select {
-		case <-s.stopCh:
-			return
-		case <-ticker.C:
-			s.cleanup()
+// snapshotLocked copies unexpired entries in the given namespaces (all if
+// nil). Every shard must be locked. Recency is only ordered within a shard,
+// so entries are sorted by shard and then least recently used first.
+func (s *MemoryStore) snapshotLocked(namespaces map[string]bool) []SnapshotEntry {
+	now := time.Now()
+	var entries []SnapshotEntry
+
+	for _, sh := range s.shards {
+		live := make([]*entry, 0, len(sh.data))
+		for _, e := range sh.data {
+			if e.expired(now) || (namespaces != nil && !namespaces[e.namespace]) {
+				continue
+			}
+			live = append(live, e)
+		}
+		sort.Slice(live, func(i, j int) bool {
+			return live[i].lastAccess.Load() < live[j].lastAccess.Load()
+		})
+
+		for _, e := range live {
+			se := SnapshotEntry{Namespace: e.namespace, Key: e.key, Value: append([]byte(nil), e.value...)}
+			if !e.expiresAt.IsZero() {
+				se.ExpiresAt = e.expiresAt.UnixNano()
+			}
+			entries = append(entries, se)
 		}
 	}
+	return entries
 }
 
-func (s *MemoryStore) cleanup() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// restore replaces the store contents with a snapshot. Watchers get an
+// EventOverflow rather than one event per key.
+func (s *MemoryStore) restore(entries []SnapshotEntry) error {
+	s.lockAll()
+	defer s.unlockAll()
+	defer s.publishOverflow()
 
 	now := time.Now()
-	toDelete := make([]string, 0)
+	for _, sh := range s.shards {
+		sh.data = make(map[string]*entry)
+		sh.bytes = 0
+		sh.wheel = newTimingWheel(DefaultExpiryTick, now)
+	}
+	atomic.StoreInt64(&s.currentBytes, 0)
+	s.namespaces.Range(func(_, u any) bool {
+		usage := u.(*namespaceUsage)
+		usage.keys.Store(0)
+		usage.bytes.Store(0)
+		return true
+	})
 
-	for fullKey, e := range s.data {
-		if !e.expiresAt.IsZero() && now.After(e.expiresAt) {
-			toDelete = append(toDelete, fullKey)
+	for _, se := range entries {
+		if err := s.setLocked(se.Namespace, se.Key, se.Value, unixNanoTime(se.ExpiresAt)); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	for _, fullKey := range toDelete {
-		if e, ok := s.data[fullKey]; ok {
-			s.deleteEntryLocked(fullKey, e)
-		}
+// setLocked is setLockedAt for callers already holding every shard lock
+func (s *MemoryStore) setLocked(namespace, key string, value []byte, expiresAt time.Time) error {
+	sh := s.shardFor(s.makeKey(namespace, key))
+	return s.setLockedAt(sh, namespace, key, value, expiresAt, 0)
+}
+
+// deleteLocked removes a key if present; every shard must be locked
+func (s *MemoryStore) deleteLocked(namespace, key string) {
+	fullKey := s.makeKey(namespace, key)
+	sh := s.shardFor(fullKey)
+	if e, ok := sh.data[fullKey]; ok {
+		s.deleteEntryLocked(sh, fullKey, e)
 	}
 }
+
+func expiryFrom(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return now.Add(ttl)
+}
+
+func (s *MemoryStore) makeKey(namespace, key string) string {
+	return namespace + ":" + key
+}
+
+// cleanup reclaims every entry whose deadline has passed
+func (s *MemoryStore) cleanup() {
+	s.expire(time.Now())
+}
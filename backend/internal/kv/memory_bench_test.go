@@ -1,6 +1,8 @@
 package kv
 
 import (
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -227,3 +229,54 @@ func BenchmarkMemoryStore_Keys(b *testing.B) {
 		_ = store.Keys(namespace)
 	}
 }
+
+// BenchmarkMemoryStore_ShardScaling compares a single shard against the
+// default sharding on a spread of keys. Run with -cpu=1,2,4,8 to see
+// throughput scale with cores.
+func BenchmarkMemoryStore_ShardScaling(b *testing.B) {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = "key-" + strconv.Itoa(i)
+	}
+	value := []byte("value")
+
+	for _, shards := range []int{1, DefaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			store := newMemoryStore(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, shards)
+			defer store.Close()
+
+			for _, key := range keys {
+				_ = store.Set("bench", key, value, 0)
+			}
+
+			var seq atomic.Uint64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(seq.Add(1)) * 7919
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%4 == 0 {
+						_ = store.Set("bench", key, value, 0)
+					} else {
+						_, _ = store.Get("bench", key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkMemoryStore_ParallelIncrement(b *testing.B) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	var seq atomic.Uint64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		partnerID := "partner-" + strconv.FormatUint(seq.Add(1), 10)
+		for pb.Next() {
+			_, _ = store.IncrementRateLimit(partnerID, time.Minute)
+		}
+	})
+}
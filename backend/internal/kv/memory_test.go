@@ -205,6 +205,24 @@ func TestMemoryStore_IncrementRateLimit(t *testing.T) {
 	}
 }
 
+func TestMemoryStore_IncrementRateLimitKeepsExpiry(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	for i, at := range []time.Duration{0, 30 * time.Second, 59 * time.Second} {
+		n, err := store.incrementAt("partner-1", time.Minute, now.Add(at))
+		if err != nil || n != int64(i+1) {
+			t.Fatalf("incrementAt(+%v) = %d, %v; want %d", at, n, err, i+1)
+		}
+	}
+
+	// The window opened by the first request has ended
+	if n, _ := store.incrementAt("partner-1", time.Minute, now.Add(61*time.Second)); n != 1 {
+		t.Errorf("incrementAt(+61s) = %d, want 1", n)
+	}
+}
+
 func TestMemoryStore_QuoteOperations(t *testing.T) {
 	store := NewMemoryStore()
 	defer store.Close()
@@ -431,7 +449,7 @@ func TestMemoryStore_NamespaceQuota(t *testing.T) {
 	defer store.Close()
 
 	namespace := NamespaceCircuitBreaker
-	quota := namespaceQuotas[namespace]
+	quota := DefaultNamespaceConfigs()[namespace].MaxKeys
 
 	for i := int64(0); i < quota; i++ {
 		key := string(rune('a' + int(i%26))) + string(rune('A' + int(i/26)))
@@ -0,0 +1,155 @@
+package kv
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// EvictionPolicy decides which of a namespace's entries make way when the
+// store or the namespace runs out of memory
+type EvictionPolicy uint8
+
+const (
+	EvictLRU  EvictionPolicy = iota // least recently used
+	EvictLFU                        // least frequently used, decaying with idleness
+	EvictNone                       // never evicted; writes fail when full
+)
+
+func (p EvictionPolicy) String() string {
+	switch p {
+	case EvictLRU:
+		return "lru"
+	case EvictLFU:
+		return "lfu"
+	case EvictNone:
+		return "none"
+	}
+	return "unknown"
+}
+
+func parseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch s {
+	case "lru":
+		return EvictLRU, nil
+	case "lfu":
+		return EvictLFU, nil
+	case "none", "noevict":
+		return EvictNone, nil
+	}
+	return EvictLRU, fmt.Errorf("unknown eviction policy %q", s)
+}
+
+// NamespaceConfig limits one namespace. Zero limits mean unlimited within
+// the store-wide budget.
+type NamespaceConfig struct {
+	MaxKeys  int64
+	MaxBytes int64 // shared by all shards; each evicts only its own entries
+	Eviction EvictionPolicy
+}
+
+// DefaultNamespaceConfigs lets the quote cache absorb memory pressure while
+// counters, breaker state, policies, request IDs and system keys are never
+// evicted, so a flood of quotes can't reset a partner's rate limit or reopen
+// a replay window
+func DefaultNamespaceConfigs() map[string]NamespaceConfig {
+	return map[string]NamespaceConfig{
+		NamespaceQuotes:         {MaxKeys: 10000, Eviction: EvictLRU},
+		NamespaceRateLimits:     {MaxKeys: 1000000, Eviction: EvictNone}, // partners and public client IPs
+		NamespaceCircuitBreaker: {MaxKeys: 1000, Eviction: EvictNone},
+		NamespaceSystem:         {MaxKeys: 128, Eviction: EvictNone},
+		NamespacePolicies:       {MaxKeys: 10000, Eviction: EvictNone},
+		NamespaceRequestIDs:     {MaxKeys: 1000000, Eviction: EvictNone},
+	}
+}
+
+// Option adjusts a MemoryStore at construction
+type Option func(*MemoryStore)
+
+// WithNamespace replaces the configuration of one namespace
+func WithNamespace(namespace string, cfg NamespaceConfig) Option {
+	return func(s *MemoryStore) {
+		s.namespaceConfigs[namespace] = cfg
+	}
+}
+
+// WithNamespaces replaces the configuration of each listed namespace
+func WithNamespaces(configs map[string]NamespaceConfig) Option {
+	return func(s *MemoryStore) {
+		for ns, cfg := range configs {
+			s.namespaceConfigs[ns] = cfg
+		}
+	}
+}
+
+// WithDefaultNamespace configures namespaces that aren't listed explicitly.
+// By default they are unlimited and evicted LRU.
+func WithDefaultNamespace(cfg NamespaceConfig) Option {
+	return func(s *MemoryStore) {
+		s.defaultNamespace = cfg
+	}
+}
+
+// ParseNamespaceConfigs parses "quotes=lru,67108864,10000;rate_limits=none",
+// each entry being namespace=policy[,max_bytes[,max_keys]]
+func ParseNamespaceConfigs(s string) (map[string]NamespaceConfig, error) {
+	configs := make(map[string]NamespaceConfig)
+	for _, part := range strings.Split(s, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		ns, spec, ok := strings.Cut(part, "=")
+		ns = strings.TrimSpace(ns)
+		if !ok || ns == "" {
+			return nil, fmt.Errorf("invalid namespace entry %q: want ns=policy[,max_bytes[,max_keys]]", part)
+		}
+
+		fields := strings.Split(spec, ",")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("invalid namespace entry %q: too many fields", part)
+		}
+		var cfg NamespaceConfig
+		var err error
+		if cfg.Eviction, err = parseEvictionPolicy(strings.TrimSpace(fields[0])); err != nil {
+			return nil, fmt.Errorf("invalid namespace entry %q: %w", part, err)
+		}
+		limits := []*int64{&cfg.MaxBytes, &cfg.MaxKeys}
+		for i, field := range fields[1:] {
+			n, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid namespace entry %q: bad limit %q", part, field)
+			}
+			*limits[i] = n
+		}
+		configs[ns] = cfg
+	}
+
+	if len(configs) == 0 {
+		return nil, fmt.Errorf("namespace config is empty")
+	}
+	return configs, nil
+}
+
+// namespaceUsage counts one namespace's live keys and bytes store-wide
+type namespaceUsage struct {
+	keys  atomic.Int64
+	bytes atomic.Int64
+}
+
+func (s *MemoryStore) namespaceConfig(namespace string) NamespaceConfig {
+	if cfg, ok := s.namespaceConfigs[namespace]; ok {
+		return cfg
+	}
+	return s.defaultNamespace
+}
+
+func (s *MemoryStore) usage(namespace string) *namespaceUsage {
+	if u, ok := s.namespaces.Load(namespace); ok {
+		return u.(*namespaceUsage)
+	}
+	u, _ := s.namespaces.LoadOrStore(namespace, new(namespaceUsage))
+	return u.(*namespaceUsage)
+}
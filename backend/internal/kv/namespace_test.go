@@ -0,0 +1,216 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMemoryStore_CacheFloodKeepsRateLimits(t *testing.T) {
+	store := newMemoryStore(16*1024, DefaultMaxKeyLength, 1024, 1)
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		if _, err := store.IncrementRateLimit(fmt.Sprint("partner-", i), 0); err != nil {
+			t.Fatalf("IncrementRateLimit() error = %v", err)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if err := store.Set(NamespaceQuotes, fmt.Sprint("q", i), make([]byte, 200), 0); err != nil {
+			t.Fatalf("Set(quote %d) error = %v", i, err)
+		}
+	}
+
+	stats := store.Stats()
+	if stats.Evictions == 0 {
+		t.Fatal("expected quote evictions")
+	}
+	if n := stats.NamespaceCounts[NamespaceRateLimits]; n != 20 {
+		t.Errorf("rate limit keys = %d, want 20", n)
+	}
+	for i := 0; i < 20; i++ {
+		if _, ok := store.Get(NamespaceRateLimits, fmt.Sprint("partner-", i)); !ok {
+			t.Errorf("rate limit for partner-%d was evicted", i)
+		}
+	}
+}
+
+func TestMemoryStore_NoEvictRejectsWhenFull(t *testing.T) {
+	store := newMemoryStore(4*1024, DefaultMaxKeyLength, 1024, 1)
+	defer store.Close()
+
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		err = store.Set(NamespaceSystem, fmt.Sprint("k", i), make([]byte, 200), 0)
+	}
+	if err != ErrMemoryLimit {
+		t.Fatalf("Set() on a full store of no-evict keys error = %v, want ErrMemoryLimit", err)
+	}
+	if store.Stats().Evictions != 0 {
+		t.Error("no-evict namespace must not be evicted")
+	}
+}
+
+func TestMemoryStore_NamespaceByteBudget(t *testing.T) {
+	store := newMemoryStore(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, 1,
+		WithNamespace("cache", NamespaceConfig{MaxBytes: 1000, Eviction: EvictLRU}),
+		WithNamespace("fixed", NamespaceConfig{MaxBytes: 1000, Eviction: EvictNone}))
+	defer store.Close()
+
+	_ = store.Set("other", "k", make([]byte, 500), 0)
+	for i := 0; i < 20; i++ {
+		if err := store.Set("cache", fmt.Sprint("k", i), make([]byte, 200), 0); err != nil {
+			t.Fatalf("Set(cache %d) error = %v", i, err)
+		}
+	}
+
+	stats := store.Stats()
+	if b := stats.NamespaceBytes["cache"]; b > 1000 {
+		t.Errorf("cache bytes = %d, want <= 1000", b)
+	}
+	if _, ok := store.Get("cache", "k19"); !ok {
+		t.Error("newest cache entry should be kept")
+	}
+	if _, ok := store.Get("other", "k"); !ok {
+		t.Error("other namespace must not pay for the cache budget")
+	}
+
+	var err error
+	for i := 0; i < 20 && err == nil; i++ {
+		err = store.Set("fixed", fmt.Sprint("k", i), make([]byte, 200), 0)
+	}
+	if err != ErrNamespaceFull {
+		t.Errorf("Set() beyond a no-evict budget error = %v, want ErrNamespaceFull", err)
+	}
+	if err := store.Set("fixed", "huge", make([]byte, 2000), 0); err != ErrNamespaceFull {
+		t.Errorf("Set() larger than the budget error = %v, want ErrNamespaceFull", err)
+	}
+}
+
+func TestMemoryStore_KeyQuotaEvicts(t *testing.T) {
+	store := newMemoryStore(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, DefaultShards,
+		WithNamespace("cache", NamespaceConfig{MaxKeys: 10, Eviction: EvictLRU}),
+		WithNamespace("fixed", NamespaceConfig{MaxKeys: 10, Eviction: EvictNone}))
+	defer store.Close()
+
+	for i := 0; i < 50; i++ {
+		if err := store.Set("cache", fmt.Sprint("k", i), []byte("v"), 0); err != nil {
+			t.Fatalf("Set(cache %d) error = %v", i, err)
+		}
+	}
+	if n := store.Stats().NamespaceCounts["cache"]; n != 10 {
+		t.Errorf("cache keys = %d, want 10", n)
+	}
+	if _, ok := store.Get("cache", "k49"); !ok {
+		t.Error("newest cache entry should be kept")
+	}
+
+	var err error
+	for i := 0; i < 11 && err == nil; i++ {
+		err = store.Set("fixed", fmt.Sprint("k", i), []byte("v"), 0)
+	}
+	if err != ErrNamespaceQuota {
+		t.Errorf("Set() past a no-evict quota error = %v, want ErrNamespaceQuota", err)
+	}
+}
+
+func TestMemoryStore_NamespaceByteBudgetEvictsAcrossShards(t *testing.T) {
+	store := newMemoryStore(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, DefaultShards,
+		WithNamespace("cache", NamespaceConfig{MaxBytes: 2000, Eviction: EvictLRU}))
+	defer store.Close()
+
+	// Most writes land in a shard with no other cache entry to evict
+	for i := 0; i < 200; i++ {
+		if err := store.Set("cache", fmt.Sprint("k", i), make([]byte, 200), 0); err != nil {
+			t.Fatalf("Set(cache %d) error = %v", i, err)
+		}
+	}
+
+	stats := store.Stats()
+	if b := stats.NamespaceBytes["cache"]; b > 2000 {
+		t.Errorf("cache bytes = %d, want <= 2000", b)
+	}
+	if stats.Evictions == 0 {
+		t.Error("expected evictions from other shards")
+	}
+	if _, ok := store.Get("cache", "k199"); !ok {
+		t.Error("newest cache entry should be kept")
+	}
+}
+
+func TestMemoryStore_NamespaceByteBudgetAcrossShards(t *testing.T) {
+	store := newMemoryStore(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, DefaultShards,
+		WithNamespace("fixed", NamespaceConfig{MaxBytes: 4096, Eviction: EvictNone}))
+	defer store.Close()
+
+	// The budget belongs to the namespace, not to each shard's share of it
+	var wg sync.WaitGroup
+	var stored atomic.Int64
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				if err := store.Set("fixed", fmt.Sprint("k", w, "-", i), make([]byte, 200), 0); err == nil {
+					stored.Add(1)
+				} else if err != ErrNamespaceFull {
+					t.Errorf("Set() error = %v, want nil or ErrNamespaceFull", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	entrySize := int64(len(store.makeKey("fixed", "k0-0")) + 200 + entryOverhead)
+	if want := 4096 / entrySize; stored.Load() != want {
+		t.Errorf("stored %d entries, want %d", stored.Load(), want)
+	}
+	if b := store.Stats().NamespaceBytes["fixed"]; b > 4096 {
+		t.Errorf("fixed bytes = %d, want <= 4096", b)
+	}
+}
+
+func TestMemoryStore_LFUKeepsHotKeys(t *testing.T) {
+	store := newMemoryStore(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize, 1,
+		WithNamespace("lfu", NamespaceConfig{MaxBytes: 1200, Eviction: EvictLFU}))
+	defer store.Close()
+
+	_ = store.Set("lfu", "hot", make([]byte, 200), 0)
+	for i := 0; i < 50; i++ {
+		store.Get("lfu", "hot")
+	}
+
+	// Each new key is written after hot was last read, so LRU would evict hot
+	for i := 0; i < 30; i++ {
+		if err := store.Set("lfu", fmt.Sprint("cold", i), make([]byte, 200), 0); err != nil {
+			t.Fatalf("Set(%d) error = %v", i, err)
+		}
+	}
+	if _, ok := store.Get("lfu", "hot"); !ok {
+		t.Error("frequently read key should survive LFU eviction")
+	}
+}
+
+func TestParseNamespaceConfigs(t *testing.T) {
+	configs, err := ParseNamespaceConfigs("quotes=lru,67108864,5000; rate_limits=none ;cache=lfu,1024")
+	if err != nil {
+		t.Fatalf("ParseNamespaceConfigs() error = %v", err)
+	}
+	want := map[string]NamespaceConfig{
+		NamespaceQuotes:     {MaxBytes: 67108864, MaxKeys: 5000, Eviction: EvictLRU},
+		NamespaceRateLimits: {Eviction: EvictNone},
+		"cache":             {MaxBytes: 1024, Eviction: EvictLFU},
+	}
+	for ns, cfg := range want {
+		if configs[ns] != cfg {
+			t.Errorf("%s = %+v, want %+v", ns, configs[ns], cfg)
+		}
+	}
+
+	for _, bad := range []string{"", "quotes", "quotes=fifo", "quotes=lru,-1", "quotes=lru,1,2,3", "=lru"} {
+		if _, err := ParseNamespaceConfigs(bad); err == nil {
+			t.Errorf("ParseNamespaceConfigs(%q) should fail", bad)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+// Wire format. Every frame is
+//
+//	length(4) id(4) code(1) body
+//
+// where length counts id, code and body. Requests carry an op code and
+// responses a status code; the id pairs a response with its request so one
+// connection can carry many requests at once. Body fields are uvarint
+// length-prefixed byte strings and varint integers.
+const (
+	frameHeaderSize = 9
+	maxFrameSize    = 4 * 1024 * 1024
+)
+
+const (
+	opGet byte = iota + 1
+	opSetKey
+	opDeleteKey
+	opIncr
+	opKeys
+	opStats
+	opPing
+	opSlidingWindow
+	opTokenBucket
+	opWatch
+	opUnwatch
+	opIncrBy
+	opTransaction
+	opRateLimit
+)
+
+const (
+	statusOK byte = iota
+	statusNotFound
+	statusError
+	statusForbidden
+	statusEvent // pushed on a watch's request id after its statusOK
+)
+
+var errMalformed = errors.New("kv: malformed frame")
+
+type frame struct {
+	id   uint32
+	code byte
+	body []byte
+}
+
+func writeFrame(w io.Writer, f frame) error {
+	size := 5 + len(f.body)
+	if size > maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	buf := make([]byte, frameHeaderSize, frameHeaderSize+len(f.body))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(size))
+	binary.BigEndian.PutUint32(buf[4:8], f.id)
+	buf[8] = f.code
+	_, err := w.Write(append(buf, f.body...))
+	return err
+}
+
+func readFrame(r io.Reader) (frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size < 5 {
+		return frame{}, errMalformed
+	}
+	if size > maxFrameSize {
+		return frame{}, ErrFrameTooLarge
+	}
+
+	f := frame{
+		id:   binary.BigEndian.Uint32(header[4:8]),
+		code: header[8],
+		body: make([]byte, size-5),
+	}
+	if _, err := io.ReadFull(r, f.body); err != nil {
+		return frame{}, err
+	}
+	return f, nil
+}
+
+func appendField(b, field []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(field)))
+	return append(b, field...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return appendField(b, []byte(s))
+}
+
+func appendInt(b []byte, v int64) []byte {
+	return binary.AppendVarint(b, v)
+}
+
+func appendRateLimit(b []byte, res RateLimitResult) []byte {
+	allowed := int64(0)
+	if res.Allowed {
+		allowed = 1
+	}
+	b = appendInt(b, allowed)
+	b = appendInt(b, res.Limit)
+	b = appendInt(b, res.Remaining)
+	b = appendInt(b, res.ResetAt.UnixNano())
+	return appendInt(b, int64(res.RetryAfter))
+}
+
+func appendEvent(b []byte, ev Event) []byte {
+	b = appendInt(b, int64(ev.Type))
+	b = appendString(appendString(b, ev.Namespace), ev.Key)
+	b = appendField(b, ev.Value)
+	expiresAt := int64(0)
+	if !ev.ExpiresAt.IsZero() {
+		expiresAt = ev.ExpiresAt.UnixNano()
+	}
+	return appendInt(b, expiresAt)
+}
+
+func appendTxn(b []byte, txn Txn) []byte {
+	b = appendInt(b, int64(len(txn.If)))
+	for _, c := range txn.If {
+		b = appendInt(b, int64(c.Op))
+		b = appendField(appendString(appendString(b, c.Namespace), c.Key), c.Value)
+	}
+	for _, ops := range [][]TxnOp{txn.Then, txn.Else} {
+		b = appendInt(b, int64(len(ops)))
+		for _, op := range ops {
+			del := int64(0)
+			if op.Delete {
+				del = 1
+			}
+			b = appendInt(b, del)
+			b = appendField(appendString(appendString(b, op.Namespace), op.Key), op.Value)
+			b = appendInt(b, int64(op.TTL))
+		}
+	}
+	return b
+}
+
+// fieldReader decodes a body; the first error sticks and later reads are no-ops
+type fieldReader struct {
+	b   []byte
+	err error
+}
+
+func (r *fieldReader) field() []byte {
+	if r.err != nil {
+		return nil
+	}
+	n, k := binary.Uvarint(r.b)
+	if k <= 0 || uint64(len(r.b)-k) < n {
+		r.err = errMalformed
+		return nil
+	}
+	v := r.b[k : k+int(n)]
+	r.b = r.b[k+int(n):]
+	return v
+}
+
+func (r *fieldReader) string() string {
+	return string(r.field())
+}
+
+func (r *fieldReader) rateLimit() RateLimitResult {
+	allowed, limit, remaining, resetAt, retryAfter := r.int(), r.int(), r.int(), r.int(), r.int()
+	return RateLimitResult{
+		Allowed:    allowed == 1,
+		Limit:      limit,
+		Remaining:  remaining,
+		ResetAt:    time.Unix(0, resetAt),
+		RetryAfter: time.Duration(retryAfter),
+	}
+}
+
+func (r *fieldReader) event() Event {
+	ev := Event{Type: EventType(r.int())}
+	ev.Namespace, ev.Key = r.string(), r.string()
+	if value := r.field(); len(value) > 0 {
+		ev.Value = append([]byte(nil), value...)
+	}
+	ev.ExpiresAt = unixNanoTime(r.int())
+	return ev
+}
+
+func (r *fieldReader) txn() Txn {
+	var txn Txn
+	n := r.count()
+	for i := 0; i < n; i++ {
+		c := Condition{Op: CompareOp(r.int())}
+		c.Namespace, c.Key, c.Value = r.string(), r.string(), r.field()
+		txn.If = append(txn.If, c)
+	}
+	for _, ops := range []*[]TxnOp{&txn.Then, &txn.Else} {
+		n := r.count()
+		for i := 0; i < n; i++ {
+			op := TxnOp{Delete: r.int() == 1}
+			op.Namespace, op.Key, op.Value = r.string(), r.string(), r.field()
+			op.TTL = time.Duration(r.int())
+			*ops = append(*ops, op)
+		}
+	}
+	return txn
+}
+
+// count reads a list length, rejecting ones beyond MaxTxnOps
+func (r *fieldReader) count() int {
+	n := r.int()
+	if r.err == nil && (n < 0 || n > MaxTxnOps) {
+		r.err = errMalformed
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (r *fieldReader) int() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, k := binary.Varint(r.b)
+	if k <= 0 {
+		r.err = errMalformed
+		return 0
+	}
+	r.b = r.b[k:]
+	return v
+}
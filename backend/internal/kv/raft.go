@@ -0,0 +1,946 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultElectionTimeout   = 300 * time.Millisecond
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	DefaultApplyTimeout      = 2 * time.Second
+	DefaultMaxLogEntries     = 10000
+	DefaultMaxAppendEntries  = 512
+)
+
+const (
+	roleFollower = iota
+	roleCandidate
+	roleLeader
+)
+
+const (
+	opNoop      = "noop"
+	opSet       = "set"
+	opDelete    = "delete"
+	opIncrement = "incr"
+	opWindow    = "window"
+	opBucket    = "bucket"
+	opLimit     = "limit"
+	opAdd       = "add"
+	opTxn       = "txn"
+)
+
+// RaftConfig configures one member of a replicated store
+type RaftConfig struct {
+	ID        string
+	Peers     []string // IDs of every member, including ID
+	Transport Transport
+
+	// Dir keeps the term, vote, log and snapshot across restarts. Without it
+	// a restarted member may vote twice in a term or lose acknowledged
+	// entries; leave it empty only in tests.
+	Dir string
+
+	ElectionTimeout   time.Duration // randomized between 1x and 2x
+	HeartbeatInterval time.Duration
+	ApplyTimeout      time.Duration // bound on a write's round trip through the log
+	MaxLogEntries     int           // applied entries kept before compacting into a snapshot
+	MaxAppendEntries  int           // entries per AppendEntries request
+
+	// State machine limits, as for NewMemoryStoreWithConfig
+	MaxBytes     int64
+	MaxKeyLength int
+	MaxValueSize int
+	Options      []Option
+}
+
+// Command is a replicated write. Now is stamped by the leader so TTLs and
+// counter windows resolve identically on every replica.
+type Command struct {
+	Op        string        `json:"op"`
+	Namespace string        `json:"ns,omitempty"`
+	Key       string        `json:"key,omitempty"`
+	Value     []byte        `json:"value,omitempty"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+	Now       int64         `json:"now,omitempty"`
+	Delta     int64         `json:"delta,omitempty"`
+	Txn       *Txn          `json:"txn,omitempty"`
+
+	// Rate limiter parameters for window, bucket and limit commands
+	Limit  int64         `json:"limit,omitempty"`
+	Period time.Duration `json:"period,omitempty"`
+	Burst  int64         `json:"burst,omitempty"`
+}
+
+// CommandResult is the outcome of applying a Command
+type CommandResult struct {
+	Count     int64            `json:"count,omitempty"`
+	Succeeded bool             `json:"succeeded,omitempty"`
+	RateLimit *RateLimitResult `json:"rate_limit,omitempty"`
+	Err       string           `json:"err,omitempty"`
+}
+
+type LogEntry struct {
+	Index   uint64  `json:"index"`
+	Term    uint64  `json:"term"`
+	Command Command `json:"command"`
+}
+
+type VoteRequest struct {
+	Term         uint64 `json:"term"`
+	CandidateID  string `json:"candidate_id"`
+	LastLogIndex uint64 `json:"last_log_index"`
+	LastLogTerm  uint64 `json:"last_log_term"`
+}
+
+type VoteResponse struct {
+	Term        uint64 `json:"term"`
+	VoteGranted bool   `json:"vote_granted"`
+}
+
+type AppendEntriesRequest struct {
+	Term         uint64     `json:"term"`
+	LeaderID     string     `json:"leader_id"`
+	PrevLogIndex uint64     `json:"prev_log_index"`
+	PrevLogTerm  uint64     `json:"prev_log_term"`
+	Entries      []LogEntry `json:"entries,omitempty"`
+	LeaderCommit uint64     `json:"leader_commit"`
+}
+
+type AppendEntriesResponse struct {
+	Term          uint64 `json:"term"`
+	Success       bool   `json:"success"`
+	MatchIndex    uint64 `json:"match_index"`
+	ConflictIndex uint64 `json:"conflict_index,omitempty"`
+}
+
+type InstallSnapshotRequest struct {
+	Term      uint64          `json:"term"`
+	LeaderID  string          `json:"leader_id"`
+	LastIndex uint64          `json:"last_index"`
+	LastTerm  uint64          `json:"last_term"`
+	Entries   []SnapshotEntry `json:"entries"`
+}
+
+type InstallSnapshotResponse struct {
+	Term uint64 `json:"term"`
+}
+
+type raftSnapshot struct {
+	index   uint64
+	term    uint64
+	entries []SnapshotEntry
+}
+
+type applyResult struct {
+	count     int64
+	succeeded bool
+	rateLimit *RateLimitResult
+	err       error
+}
+
+type waiter struct {
+	term uint64
+	ch   chan applyResult
+}
+
+// raftNode replicates Commands to a MemoryStore state machine.
+// log[0] is a sentinel holding the index and term of the latest snapshot.
+type raftNode struct {
+	mu        sync.Mutex
+	applyMu   sync.Mutex // serializes state machine changes
+	id        string
+	peers     []string
+	transport Transport
+	fsm       *MemoryStore
+	cfg       RaftConfig
+	storage   *raftStorage
+	saved     raftState // last state written to storage
+
+	role        int
+	term        uint64
+	votedFor    string
+	leaderID    string
+	log         []LogEntry
+	snapshot    *raftSnapshot
+	commitIndex uint64
+	lastApplied uint64
+
+	nextIndex     map[string]uint64
+	matchIndex    map[string]uint64
+	replicating   map[string]bool
+	lastBroadcast time.Time
+	deadline      time.Time
+	waiters       map[uint64]*waiter
+
+	applyCh     chan struct{}
+	replicateCh chan struct{}
+	stopCh      chan struct{}
+	stopOnce    sync.Once
+	wg          sync.WaitGroup
+}
+
+func newRaftNode(cfg RaftConfig, fsm *MemoryStore) *raftNode {
+	n := &raftNode{
+		id:          cfg.ID,
+		transport:   cfg.Transport,
+		fsm:         fsm,
+		cfg:         cfg,
+		log:         []LogEntry{{}},
+		nextIndex:   make(map[string]uint64),
+		matchIndex:  make(map[string]uint64),
+		replicating: make(map[string]bool),
+		waiters:     make(map[uint64]*waiter),
+		applyCh:     make(chan struct{}, 1),
+		replicateCh: make(chan struct{}, 1),
+		stopCh:      make(chan struct{}),
+	}
+	for _, p := range cfg.Peers {
+		if p != cfg.ID {
+			n.peers = append(n.peers, p)
+		}
+	}
+	n.resetDeadlineLocked()
+	return n
+}
+
+// recover loads state left by a previous process. The state machine must
+// already hold the snapshot.
+func (n *raftNode) recover(storage *raftStorage, rec *raftRecovery) {
+	n.storage = storage
+	n.term, n.votedFor = rec.state.Term, rec.state.VotedFor
+	n.saved = rec.state
+	if rec.snapshot != nil {
+		n.snapshot = rec.snapshot
+		n.log = []LogEntry{{Index: rec.snapshot.index, Term: rec.snapshot.term}}
+		n.commitIndex = rec.snapshot.index
+		n.lastApplied = rec.snapshot.index
+	}
+	n.log = append(n.log, rec.log...)
+}
+
+func (n *raftNode) start() {
+	n.wg.Add(2)
+	go n.run()
+	go n.applyLoop()
+}
+
+func (n *raftNode) stop() {
+	n.stopOnce.Do(func() {
+		close(n.stopCh)
+	})
+	n.wg.Wait()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for idx, w := range n.waiters {
+		w.ch <- applyResult{err: ErrRaftStopped}
+		delete(n.waiters, idx)
+	}
+	_ = n.storage.close()
+}
+
+// persistLocked makes the term, vote and the given new entries durable. It
+// must succeed before the node answers an RPC or counts its own log toward
+// a commit; on failure the node stops, since going on could break a
+// promise made to the rest of the group.
+func (n *raftNode) persistLocked(entries []LogEntry) bool {
+	state := raftState{Term: n.term, VotedFor: n.votedFor}
+	var changed *raftState
+	if state != n.saved {
+		changed = &state
+	}
+	if changed == nil && len(entries) == 0 {
+		return true
+	}
+	if err := n.storage.save(changed, entries); err != nil {
+		n.failLocked(err)
+		return false
+	}
+	n.saved = state
+	return true
+}
+
+// compactLocked persists the snapshot and the log after it
+func (n *raftNode) compactLocked() bool {
+	state := raftState{Term: n.term, VotedFor: n.votedFor}
+	if err := n.storage.compact(state, n.snapshot, n.log[1:]); err != nil {
+		n.failLocked(err)
+		return false
+	}
+	n.saved = state
+	return true
+}
+
+func (n *raftNode) failLocked(err error) {
+	log.Printf("raft %s: failed to persist state, stopping: %v", n.id, err)
+	n.role = roleFollower
+	n.leaderID = ""
+	n.stopOnce.Do(func() {
+		close(n.stopCh)
+	})
+}
+
+// Leader returns the current leader ID, or "" while an election is running
+func (n *raftNode) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+func (n *raftNode) isLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == roleLeader
+}
+
+// propose commits cmd through the leader and returns the applied result.
+// Followers forward to the leader; the call retries while no leader is known.
+func (n *raftNode) propose(ctx context.Context, cmd Command) (applyResult, error) {
+	for {
+		res, err := n.proposeOnce(ctx, cmd)
+		if !retryable(err) {
+			return res, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return applyResult{}, err
+		case <-n.stopCh:
+			return applyResult{}, ErrRaftStopped
+		case <-time.After(n.cfg.HeartbeatInterval / 2):
+		}
+	}
+}
+
+func (n *raftNode) proposeOnce(ctx context.Context, cmd Command) (applyResult, error) {
+	n.mu.Lock()
+	if n.role != roleLeader {
+		leader := n.leaderID
+		n.mu.Unlock()
+		if leader == "" {
+			return applyResult{}, ErrNoLeader
+		}
+		res, err := n.transport.Forward(ctx, leader, cmd)
+		if err != nil {
+			return applyResult{}, err
+		}
+		return applyResult{count: res.Count, succeeded: res.Succeeded, rateLimit: res.RateLimit}, resultError(res.Err)
+	}
+
+	w := n.appendLocked(cmd)
+	index := n.lastIndexLocked()
+	n.mu.Unlock()
+
+	n.signal(n.replicateCh)
+
+	select {
+	case res := <-w.ch:
+		return res, res.err
+	case <-n.stopCh:
+		return applyResult{}, ErrRaftStopped
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, index)
+		n.mu.Unlock()
+		return applyResult{}, ctx.Err()
+	}
+}
+
+// appendLocked appends cmd to the leader's log and registers a waiter
+func (n *raftNode) appendLocked(cmd Command) *waiter {
+	cmd.Now = time.Now().UnixNano()
+	entry := LogEntry{Index: n.lastIndexLocked() + 1, Term: n.term, Command: cmd}
+	n.log = append(n.log, entry)
+
+	w := &waiter{term: n.term, ch: make(chan applyResult, 1)}
+	if !n.persistLocked([]LogEntry{entry}) {
+		w.ch <- applyResult{err: ErrRaftStopped}
+		return w
+	}
+	n.matchIndex[n.id] = entry.Index
+	n.waiters[entry.Index] = w
+
+	if len(n.peers) == 0 {
+		n.advanceCommitLocked()
+	}
+	return w
+}
+
+// HandleForward applies a write forwarded by a follower
+func (n *raftNode) HandleForward(ctx context.Context, cmd Command) (*CommandResult, error) {
+	n.mu.Lock()
+	if n.role != roleLeader {
+		n.mu.Unlock()
+		return &CommandResult{Err: ErrNotLeader.Error()}, nil
+	}
+	w := n.appendLocked(cmd)
+	index := n.lastIndexLocked()
+	n.mu.Unlock()
+
+	n.signal(n.replicateCh)
+
+	select {
+	case res := <-w.ch:
+		return &CommandResult{Count: res.count, Succeeded: res.succeeded, RateLimit: res.rateLimit, Err: errorString(res.err)}, nil
+	case <-n.stopCh:
+		return &CommandResult{Err: ErrRaftStopped.Error()}, nil
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.waiters, index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (n *raftNode) HandleRequestVote(req *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term > n.term {
+		n.becomeFollowerLocked(req.Term, "")
+	}
+	resp := &VoteResponse{Term: n.term}
+	if req.Term < n.term {
+		return resp
+	}
+
+	upToDate := req.LastLogTerm > n.lastTermLocked() ||
+		(req.LastLogTerm == n.lastTermLocked() && req.LastLogIndex >= n.lastIndexLocked())
+
+	if (n.votedFor == "" || n.votedFor == req.CandidateID) && upToDate {
+		n.votedFor = req.CandidateID
+		n.resetDeadlineLocked()
+		resp.VoteGranted = true
+	}
+	if !n.persistLocked(nil) {
+		resp.VoteGranted = false
+	}
+	return resp
+}
+
+func (n *raftNode) HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &AppendEntriesResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != roleFollower {
+		n.becomeFollowerLocked(req.Term, req.LeaderID)
+	}
+	n.leaderID = req.LeaderID
+	n.resetDeadlineLocked()
+
+	prev := req.PrevLogIndex
+	entries := req.Entries
+	if base := n.log[0].Index; prev < base {
+		// Entries up to the snapshot are committed and therefore match
+		skip := int(base - prev)
+		if skip > len(entries) {
+			skip = len(entries)
+		}
+		entries = entries[skip:]
+		prev = base
+	} else {
+		if prev > n.lastIndexLocked() {
+			return &AppendEntriesResponse{Term: n.term, ConflictIndex: n.lastIndexLocked() + 1}
+		}
+		if n.termAtLocked(prev) != req.PrevLogTerm {
+			conflictTerm := n.termAtLocked(prev)
+			idx := prev
+			for idx > base+1 && n.termAtLocked(idx-1) == conflictTerm {
+				idx--
+			}
+			return &AppendEntriesResponse{Term: n.term, ConflictIndex: idx}
+		}
+	}
+
+	var appended []LogEntry
+	for i, e := range entries {
+		if e.Index <= n.lastIndexLocked() {
+			if n.termAtLocked(e.Index) == e.Term {
+				continue
+			}
+			n.log = n.log[:e.Index-n.log[0].Index]
+		}
+		appended = entries[i:]
+		n.log = append(n.log, appended...)
+		break
+	}
+	if !n.persistLocked(appended) {
+		return &AppendEntriesResponse{Term: n.term}
+	}
+
+	match := prev + uint64(len(entries))
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = min(req.LeaderCommit, match)
+		n.signal(n.applyCh)
+	}
+	return &AppendEntriesResponse{Term: n.term, Success: true, MatchIndex: match}
+}
+
+func (n *raftNode) HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse {
+	n.mu.Lock()
+	if req.Term < n.term {
+		defer n.mu.Unlock()
+		return &InstallSnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != roleFollower {
+		n.becomeFollowerLocked(req.Term, req.LeaderID)
+	}
+	n.leaderID = req.LeaderID
+	n.resetDeadlineLocked()
+	term := n.term
+	if req.LastIndex <= n.lastApplied {
+		n.mu.Unlock()
+		return &InstallSnapshotResponse{Term: term}
+	}
+	n.mu.Unlock()
+
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+
+	if err := n.fsm.restore(req.Entries); err != nil {
+		return &InstallSnapshotResponse{Term: term}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Keep any log suffix that extends past the snapshot
+	var suffix []LogEntry
+	if req.LastIndex < n.lastIndexLocked() && n.termAtLocked(req.LastIndex) == req.LastTerm {
+		suffix = append(suffix, n.log[req.LastIndex-n.log[0].Index+1:]...)
+	}
+	n.log = append([]LogEntry{{Index: req.LastIndex, Term: req.LastTerm}}, suffix...)
+	n.snapshot = &raftSnapshot{index: req.LastIndex, term: req.LastTerm, entries: req.Entries}
+	n.commitIndex = max(n.commitIndex, req.LastIndex)
+	n.lastApplied = req.LastIndex
+	if !n.compactLocked() {
+		return &InstallSnapshotResponse{Term: n.term}
+	}
+
+	// Outcomes of our own proposals covered by the snapshot are unknown
+	for idx, w := range n.waiters {
+		if idx <= req.LastIndex {
+			w.ch <- applyResult{err: ErrOutcomeUnknown}
+			delete(n.waiters, idx)
+		}
+	}
+	return &InstallSnapshotResponse{Term: n.term}
+}
+
+func (n *raftNode) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.replicateCh:
+			n.mu.Lock()
+			if n.role == roleLeader {
+				n.broadcastLocked()
+			}
+			n.mu.Unlock()
+		case now := <-ticker.C:
+			n.mu.Lock()
+			switch {
+			case n.role == roleLeader:
+				if now.Sub(n.lastBroadcast) >= n.cfg.HeartbeatInterval {
+					n.broadcastLocked()
+				}
+			case now.After(n.deadline):
+				n.startElectionLocked()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *raftNode) startElectionLocked() {
+	n.role = roleCandidate
+	n.term++
+	n.votedFor = n.id
+	n.leaderID = ""
+	n.resetDeadlineLocked()
+	if !n.persistLocked(nil) {
+		return
+	}
+
+	if len(n.peers) == 0 {
+		n.becomeLeaderLocked()
+		return
+	}
+
+	req := &VoteRequest{
+		Term:         n.term,
+		CandidateID:  n.id,
+		LastLogIndex: n.lastIndexLocked(),
+		LastLogTerm:  n.lastTermLocked(),
+	}
+	votes := 1
+
+	for _, peer := range n.peers {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+			defer cancel()
+
+			resp, err := n.transport.RequestVote(ctx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mu.Lock()
+			defer n.mu.Unlock()
+
+			if resp.Term > n.term {
+				n.becomeFollowerLocked(resp.Term, "")
+				return
+			}
+			if n.role != roleCandidate || n.term != req.Term || !resp.VoteGranted {
+				return
+			}
+			votes++
+			if votes > (len(n.peers)+1)/2 {
+				n.becomeLeaderLocked()
+			}
+		}(peer)
+	}
+}
+
+func (n *raftNode) becomeLeaderLocked() {
+	n.role = roleLeader
+	n.leaderID = n.id
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndexLocked() + 1
+		n.matchIndex[peer] = 0
+	}
+
+	// A no-op from the new term lets entries from earlier terms commit
+	noop := LogEntry{
+		Index:   n.lastIndexLocked() + 1,
+		Term:    n.term,
+		Command: Command{Op: opNoop, Now: time.Now().UnixNano()},
+	}
+	n.log = append(n.log, noop)
+	if !n.persistLocked([]LogEntry{noop}) {
+		return
+	}
+	n.matchIndex[n.id] = n.lastIndexLocked()
+	if len(n.peers) == 0 {
+		n.advanceCommitLocked()
+	}
+	n.broadcastLocked()
+}
+
+func (n *raftNode) becomeFollowerLocked(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persistLocked(nil)
+	}
+	n.role = roleFollower
+	n.leaderID = leader
+	n.resetDeadlineLocked()
+}
+
+func (n *raftNode) broadcastLocked() {
+	n.lastBroadcast = time.Now()
+	for _, peer := range n.peers {
+		if n.replicating[peer] {
+			continue
+		}
+		n.replicating[peer] = true
+		go n.replicateTo(peer)
+	}
+}
+
+// replicateTo sends AppendEntries (or a snapshot) until peer is caught up
+func (n *raftNode) replicateTo(peer string) {
+	for {
+		n.mu.Lock()
+		if n.role != roleLeader {
+			n.replicating[peer] = false
+			n.mu.Unlock()
+			return
+		}
+
+		term := n.term
+		next := n.nextIndex[peer]
+		if next <= n.log[0].Index && n.snapshot != nil {
+			req := &InstallSnapshotRequest{
+				Term:      term,
+				LeaderID:  n.id,
+				LastIndex: n.snapshot.index,
+				LastTerm:  n.snapshot.term,
+				Entries:   n.snapshot.entries,
+			}
+			n.mu.Unlock()
+
+			if !n.sendSnapshot(peer, req) {
+				return
+			}
+			continue
+		}
+		if next <= n.log[0].Index {
+			next = n.log[0].Index + 1
+		}
+
+		prev := next - 1
+		end := min(n.lastIndexLocked(), prev+uint64(n.cfg.MaxAppendEntries))
+		req := &AppendEntriesRequest{
+			Term:         term,
+			LeaderID:     n.id,
+			PrevLogIndex: prev,
+			PrevLogTerm:  n.termAtLocked(prev),
+			Entries:      append([]LogEntry(nil), n.log[prev-n.log[0].Index+1:end-n.log[0].Index+1]...),
+			LeaderCommit: n.commitIndex,
+		}
+		n.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ElectionTimeout)
+		resp, err := n.transport.AppendEntries(ctx, peer, req)
+		cancel()
+
+		n.mu.Lock()
+		if err != nil || n.role != roleLeader || n.term != term {
+			n.replicating[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		if resp.Term > n.term {
+			n.becomeFollowerLocked(resp.Term, "")
+			n.replicating[peer] = false
+			n.mu.Unlock()
+			return
+		}
+
+		if resp.Success {
+			n.matchIndex[peer] = max(n.matchIndex[peer], resp.MatchIndex)
+			n.nextIndex[peer] = n.matchIndex[peer] + 1
+			n.advanceCommitLocked()
+		} else {
+			n.nextIndex[peer] = max(1, min(resp.ConflictIndex, n.nextIndex[peer]-1))
+		}
+
+		if resp.Success && n.nextIndex[peer] > n.lastIndexLocked() && req.LeaderCommit == n.commitIndex {
+			n.replicating[peer] = false
+			n.mu.Unlock()
+			return
+		}
+		n.mu.Unlock()
+	}
+}
+
+func (n *raftNode) sendSnapshot(peer string, req *InstallSnapshotRequest) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), n.cfg.ApplyTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err != nil || n.role != roleLeader || n.term != req.Term {
+		n.replicating[peer] = false
+		return false
+	}
+	if resp.Term > n.term {
+		n.becomeFollowerLocked(resp.Term, "")
+		n.replicating[peer] = false
+		return false
+	}
+	n.matchIndex[peer] = max(n.matchIndex[peer], req.LastIndex)
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	return true
+}
+
+// advanceCommitLocked commits the highest current-term index held by a majority
+func (n *raftNode) advanceCommitLocked() {
+	matches := make([]uint64, 0, len(n.peers)+1)
+	matches = append(matches, n.lastIndexLocked())
+	for _, peer := range n.peers {
+		matches = append(matches, n.matchIndex[peer])
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i] > matches[j] })
+
+	quorum := matches[len(matches)/2]
+	if quorum > n.commitIndex && n.termAtLocked(quorum) == n.term {
+		n.commitIndex = quorum
+		n.signal(n.applyCh)
+		n.signal(n.replicateCh)
+	}
+}
+
+func (n *raftNode) applyLoop() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.applyCh:
+		}
+
+		n.applyMu.Lock()
+		n.applyCommitted()
+		n.applyMu.Unlock()
+	}
+}
+
+func (n *raftNode) applyCommitted() {
+	n.mu.Lock()
+	if n.lastApplied >= n.commitIndex {
+		n.mu.Unlock()
+		return
+	}
+	base := n.log[0].Index
+	entries := append([]LogEntry(nil), n.log[n.lastApplied-base+1:n.commitIndex-base+1]...)
+	n.mu.Unlock()
+
+	for _, e := range entries {
+		res := n.applyCommand(e.Command)
+
+		n.mu.Lock()
+		n.lastApplied = e.Index
+		if w, ok := n.waiters[e.Index]; ok {
+			if w.term == e.Term {
+				w.ch <- res
+			} else {
+				w.ch <- applyResult{err: ErrLeadershipLost}
+			}
+			delete(n.waiters, e.Index)
+		}
+		n.mu.Unlock()
+	}
+
+	n.maybeCompact()
+}
+
+func (n *raftNode) applyCommand(cmd Command) applyResult {
+	now := time.Unix(0, cmd.Now)
+	switch cmd.Op {
+	case opSet:
+		return applyResult{err: n.fsm.setAt(cmd.Namespace, cmd.Key, cmd.Value, expiryFrom(now, cmd.TTL))}
+	case opDelete:
+		return applyResult{err: n.fsm.Delete(cmd.Namespace, cmd.Key)}
+	case opIncrement:
+		count, err := n.fsm.incrementAt(cmd.Key, cmd.TTL, now)
+		return applyResult{count: count, err: err}
+	case opWindow:
+		res, err := n.fsm.slidingWindowAt(cmd.Key, cmd.Limit, cmd.Period, now)
+		return applyResult{rateLimit: &res, err: err}
+	case opBucket:
+		res, err := n.fsm.tokenBucketAt(cmd.Key, cmd.Limit, cmd.Period, cmd.Burst, now)
+		return applyResult{rateLimit: &res, err: err}
+	case opLimit:
+		res, err := n.fsm.rateLimitAt(cmd.Key, cmd.Limit, cmd.Period, cmd.Burst, now)
+		return applyResult{rateLimit: &res, err: err}
+	case opAdd:
+		count, err := n.fsm.incrementByAt(cmd.Namespace, cmd.Key, cmd.Delta, cmd.TTL, now)
+		return applyResult{count: count, err: err}
+	case opTxn:
+		if cmd.Txn == nil {
+			return applyResult{}
+		}
+		succeeded, err := n.fsm.txnAt(*cmd.Txn, now)
+		return applyResult{succeeded: succeeded, err: err}
+	}
+	return applyResult{}
+}
+
+// maybeCompact folds applied entries into a snapshot once the log is long.
+// Called with applyMu held so the state machine matches lastApplied.
+func (n *raftNode) maybeCompact() {
+	n.mu.Lock()
+	applied := n.lastApplied
+	base := n.log[0].Index
+	n.mu.Unlock()
+
+	if applied-base <= uint64(n.cfg.MaxLogEntries) {
+		return
+	}
+
+	entries := n.fsm.snapshot()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	term := n.termAtLocked(applied)
+	n.log = append([]LogEntry{{Index: applied, Term: term}}, n.log[applied-n.log[0].Index+1:]...)
+	n.snapshot = &raftSnapshot{index: applied, term: term, entries: entries}
+	n.compactLocked()
+}
+
+func (n *raftNode) lastIndexLocked() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *raftNode) lastTermLocked() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+func (n *raftNode) termAtLocked(index uint64) uint64 {
+	base := n.log[0].Index
+	if index < base || index > n.lastIndexLocked() {
+		return 0
+	}
+	return n.log[index-base].Term
+}
+
+func (n *raftNode) resetDeadlineLocked() {
+	timeout := n.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(n.cfg.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+func (n *raftNode) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// retryable reports whether a proposal certainly did not commit
+func retryable(err error) bool {
+	return errors.Is(err, ErrNoLeader) || errors.Is(err, ErrNotLeader) ||
+		errors.Is(err, ErrLeadershipLost) || errors.Is(err, errNotDelivered)
+}
+
+// Errors crossing a transport or the network protocol are sent as strings;
+// map known ones back
+var resultErrors = []error{
+	ErrKeyTooLong, ErrKeyEmpty, ErrNamespaceEmpty, ErrValueTooLarge, ErrMemoryLimit,
+	ErrKeyNotFound, ErrNamespaceQuota, ErrNamespaceFull, ErrInvalidNamespace, ErrInvalidRateLimit,
+	ErrNotCounter, ErrCounterOverflow, ErrTxnTooLarge,
+	ErrNotLeader, ErrNoLeader, ErrLeadershipLost, ErrOutcomeUnknown, ErrRaftStopped,
+	ErrForbidden, ErrFrameTooLarge, ErrStoreClosed,
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func resultError(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, err := range resultErrors {
+		if err.Error() == s {
+			return err
+		}
+	}
+	return errors.New(s)
+}
@@ -0,0 +1,233 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	raftLogFile       = "raft.log"
+	raftSnapshotFile  = "raft-snapshot"
+	raftSnapshotMagic = "LXKVRSN1"
+)
+
+// raftState is the vote state a member must not forget across restarts
+type raftState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for,omitempty"`
+}
+
+// raftStorage keeps a member's term, vote, log and latest snapshot in dir.
+// The log file holds WAL-framed state and entry records; an entry replaces
+// any it overlaps, so a truncated suffix needs no record of its own. Every
+// save is synced before it returns. A nil *raftStorage keeps nothing.
+type raftStorage struct {
+	dir  string
+	file *os.File
+	buf  *bufio.Writer
+}
+
+// raftRecovery is the state openRaftStorage found on disk
+type raftRecovery struct {
+	state    raftState
+	snapshot *raftSnapshot
+	log      []LogEntry // entries after the snapshot
+}
+
+func openRaftStorage(dir string) (*raftStorage, *raftRecovery, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create raft dir: %w", err)
+	}
+
+	rec := &raftRecovery{}
+	header, entries, err := readSnapshotFile(dir, raftSnapshotFile, raftSnapshotMagic, 2)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header != nil {
+		rec.snapshot = &raftSnapshot{index: header[0], term: header[1], entries: entries}
+	}
+	if err := rec.replay(filepath.Join(dir, raftLogFile)); err != nil {
+		return nil, nil, err
+	}
+
+	s := &raftStorage{dir: dir}
+	if err := s.open(); err != nil {
+		return nil, nil, err
+	}
+	return s, rec, nil
+}
+
+func (r *raftRecovery) replay(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open raft log: %w", err)
+	}
+	defer f.Close()
+
+	var base uint64
+	if r.snapshot != nil {
+		base = r.snapshot.index
+	}
+
+	br := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readRecord(br)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errTornRecord) {
+			// Nothing past a torn tail was acknowledged
+			return f.Truncate(offset)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read raft log: %w", err)
+		}
+		offset += n
+
+		switch rec.op {
+		case recordRaftState:
+			var state raftState
+			if err := json.Unmarshal(rec.value, &state); err != nil {
+				return fmt.Errorf("failed to read raft state: %w", err)
+			}
+			r.state = state
+		case recordRaftEntry:
+			var e LogEntry
+			if err := json.Unmarshal(rec.value, &e); err != nil {
+				return fmt.Errorf("failed to read raft entry: %w", err)
+			}
+			// Entries the snapshot covers remain if it was installed just
+			// before a crash, ahead of the log rewrite
+			if e.Index <= base {
+				continue
+			}
+			for len(r.log) > 0 && r.log[len(r.log)-1].Index >= e.Index {
+				r.log = r.log[:len(r.log)-1]
+			}
+			if want := base + uint64(len(r.log)) + 1; e.Index != want {
+				return fmt.Errorf("raft log has entry %d, want %d", e.Index, want)
+			}
+			r.log = append(r.log, e)
+		default:
+			return fmt.Errorf("unexpected record %d in raft log", rec.op)
+		}
+	}
+}
+
+func (s *raftStorage) open() error {
+	f, err := os.OpenFile(filepath.Join(s.dir, raftLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open raft log: %w", err)
+	}
+	s.file = f
+	s.buf = bufio.NewWriter(f)
+	return nil
+}
+
+// save appends state, if not nil, and entries, then syncs
+func (s *raftStorage) save(state *raftState, entries []LogEntry) error {
+	if s == nil {
+		return nil
+	}
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if err := writeRaftRecords(s.buf, state, entries); err != nil {
+		return err
+	}
+	if err := s.buf.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// compact writes snap and replaces the log with state and the entries
+// after the snapshot. The snapshot goes first: replay skips entries it
+// covers, so a crash between the two steps loses nothing.
+func (s *raftStorage) compact(state raftState, snap *raftSnapshot, entries []LogEntry) error {
+	if s == nil {
+		return nil
+	}
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if err := writeSnapshotFile(s.dir, raftSnapshotFile, raftSnapshotMagic, []uint64{snap.index, snap.term}, snap.entries); err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.dir, raftLogFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create raft log: %w", err)
+	}
+	buf := bufio.NewWriter(f)
+	if err := writeRaftRecords(buf, &state, entries); err != nil {
+		f.Close()
+		return err
+	}
+	if err := buf.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write raft log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync raft log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close raft log: %w", err)
+	}
+
+	s.file.Close()
+	s.file = nil
+	if err := os.Rename(tmp, filepath.Join(s.dir, raftLogFile)); err != nil {
+		return fmt.Errorf("failed to install raft log: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+func (s *raftStorage) close() error {
+	if s == nil || s.file == nil {
+		return nil
+	}
+	err := s.buf.Flush()
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	return err
+}
+
+func writeRaftRecords(w *bufio.Writer, state *raftState, entries []LogEntry) error {
+	if state != nil {
+		value, err := json.Marshal(state)
+		if err != nil {
+			return fmt.Errorf("failed to encode raft state: %w", err)
+		}
+		if _, err := w.Write(encodeRecord(walRecord{op: recordRaftState, value: value})); err != nil {
+			return err
+		}
+	}
+	for _, e := range entries {
+		value, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("failed to encode raft entry: %w", err)
+		}
+		if _, err := w.Write(encodeRecord(walRecord{op: recordRaftEntry, value: value})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRaftStorage_Recovery(t *testing.T) {
+	dir := t.TempDir()
+
+	s, rec, err := openRaftStorage(dir)
+	if err != nil {
+		t.Fatalf("openRaftStorage() error = %v", err)
+	}
+	if rec.state.Term != 0 || rec.snapshot != nil || len(rec.log) != 0 {
+		t.Fatalf("fresh recovery = %+v", rec)
+	}
+
+	entries := []LogEntry{{Index: 1, Term: 1}, {Index: 2, Term: 1}, {Index: 3, Term: 1}}
+	if err := s.save(&raftState{Term: 1, VotedFor: "n1"}, entries); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	// A new leader overwrites the uncommitted tail
+	if err := s.save(&raftState{Term: 2}, []LogEntry{{Index: 2, Term: 2}}); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	s.close()
+
+	// A torn append is dropped on recovery
+	f, err := os.OpenFile(filepath.Join(dir, raftLogFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 9, 1, 2})
+	f.Close()
+
+	s, rec, err = openRaftStorage(dir)
+	if err != nil {
+		t.Fatalf("openRaftStorage() error = %v", err)
+	}
+	if rec.state != (raftState{Term: 2}) {
+		t.Errorf("state = %+v, want term 2 without a vote", rec.state)
+	}
+	if len(rec.log) != 2 || rec.log[1].Term != 2 {
+		t.Errorf("log = %+v, want entries 1@1 and 2@2", rec.log)
+	}
+
+	snap := &raftSnapshot{index: 2, term: 2, entries: []SnapshotEntry{{Namespace: "test", Key: "k", Value: []byte("v")}}}
+	if err := s.compact(raftState{Term: 3, VotedFor: "n2"}, snap, []LogEntry{{Index: 3, Term: 3}}); err != nil {
+		t.Fatalf("compact() error = %v", err)
+	}
+	s.close()
+
+	s, rec, err = openRaftStorage(dir)
+	if err != nil {
+		t.Fatalf("openRaftStorage() error = %v", err)
+	}
+	defer s.close()
+	if rec.state != (raftState{Term: 3, VotedFor: "n2"}) {
+		t.Errorf("state after compact = %+v", rec.state)
+	}
+	if rec.snapshot == nil || rec.snapshot.index != 2 || rec.snapshot.term != 2 || len(rec.snapshot.entries) != 1 {
+		t.Errorf("snapshot after compact = %+v", rec.snapshot)
+	}
+	if len(rec.log) != 1 || rec.log[0].Index != 3 {
+		t.Errorf("log after compact = %+v, want entry 3", rec.log)
+	}
+}
@@ -0,0 +1,235 @@
+package kv
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RaftStore is a Store replicated across a Raft group. Writes, including
+// IncrementRateLimit, are committed through the leader's log (followers
+// forward them), so counters are linearizable cluster-wide. Reads are served
+// from the local replica and may briefly lag the leader.
+type RaftStore struct {
+	node *raftNode
+	fsm  *MemoryStore
+}
+
+func NewRaftStore(cfg RaftConfig) (*RaftStore, error) {
+	if cfg.ID == "" {
+		return nil, errors.New("raft: node ID is required")
+	}
+	if cfg.Transport == nil {
+		return nil, errors.New("raft: transport is required")
+	}
+
+	found := false
+	for _, p := range cfg.Peers {
+		if p == cfg.ID {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("raft: peers must include node %q", cfg.ID)
+	}
+
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = DefaultElectionTimeout
+	}
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if cfg.HeartbeatInterval >= cfg.ElectionTimeout {
+		return nil, errors.New("raft: heartbeat interval must be shorter than election timeout")
+	}
+	if cfg.ApplyTimeout <= 0 {
+		cfg.ApplyTimeout = DefaultApplyTimeout
+	}
+	if cfg.MaxLogEntries <= 0 {
+		cfg.MaxLogEntries = DefaultMaxLogEntries
+	}
+	if cfg.MaxAppendEntries <= 0 {
+		cfg.MaxAppendEntries = DefaultMaxAppendEntries
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.MaxKeyLength <= 0 {
+		cfg.MaxKeyLength = DefaultMaxKeyLength
+	}
+	if cfg.MaxValueSize <= 0 {
+		cfg.MaxValueSize = DefaultMaxValueSize
+	}
+
+	fsm := NewMemoryStoreWithConfig(cfg.MaxBytes, cfg.MaxKeyLength, cfg.MaxValueSize, cfg.Options...)
+	node := newRaftNode(cfg, fsm)
+	if cfg.Dir != "" {
+		storage, rec, err := openRaftStorage(cfg.Dir)
+		if err != nil {
+			fsm.Close()
+			return nil, fmt.Errorf("raft: %w", err)
+		}
+		if rec.snapshot != nil {
+			if err := fsm.restore(rec.snapshot.entries); err != nil {
+				storage.close()
+				fsm.Close()
+				return nil, fmt.Errorf("raft: failed to restore snapshot: %w", err)
+			}
+		}
+		node.recover(storage, rec)
+	}
+	cfg.Transport.SetHandler(node)
+	node.start()
+
+	return &RaftStore{node: node, fsm: fsm}, nil
+}
+
+// ID returns this member's ID
+func (s *RaftStore) ID() string {
+	return s.node.id
+}
+
+// Leader returns the ID of the current leader, or "" if none is known
+func (s *RaftStore) Leader() string {
+	return s.node.Leader()
+}
+
+func (s *RaftStore) IsLeader() bool {
+	return s.node.isLeader()
+}
+
+func (s *RaftStore) Get(namespace, key string) ([]byte, bool) {
+	return s.fsm.Get(namespace, key)
+}
+
+func (s *RaftStore) Set(namespace, key string, value []byte, ttl time.Duration) error {
+	// Reject locally what the state machine would reject after a round trip
+	if err := s.fsm.validate(namespace, key, value); err != nil {
+		return err
+	}
+	_, err := s.propose(Command{Op: opSet, Namespace: namespace, Key: key, Value: value, TTL: ttl})
+	return err
+}
+
+func (s *RaftStore) Delete(namespace, key string) error {
+	_, err := s.propose(Command{Op: opDelete, Namespace: namespace, Key: key})
+	return err
+}
+
+func (s *RaftStore) CompareAndSwap(namespace, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	return s.Txn(compareAndSwapTxn(namespace, key, old, value, ttl))
+}
+
+func (s *RaftStore) SetNX(namespace, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.Txn(setNXTxn(namespace, key, value, ttl))
+}
+
+func (s *RaftStore) Increment(namespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	if err := s.fsm.validate(namespace, key, nil); err != nil {
+		return 0, err
+	}
+	res, err := s.propose(Command{Op: opAdd, Namespace: namespace, Key: key, Delta: delta, TTL: ttl})
+	return res.count, err
+}
+
+// Txn is committed as a single log entry, so every replica evaluates the
+// conditions against the same state
+func (s *RaftStore) Txn(txn Txn) (bool, error) {
+	if err := s.fsm.validateTxn(txn); err != nil {
+		return false, err
+	}
+	res, err := s.propose(Command{Op: opTxn, Txn: &txn})
+	return res.succeeded, err
+}
+
+func (s *RaftStore) IncrementRateLimit(partnerID string, ttl time.Duration) (int64, error) {
+	res, err := s.propose(Command{Op: opIncrement, Key: partnerID, TTL: ttl})
+	return res.count, err
+}
+
+func (s *RaftStore) SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	if err := s.fsm.validateLimiter(key, limit, window, 1); err != nil {
+		return RateLimitResult{}, err
+	}
+	return s.proposeLimiter(Command{Op: opWindow, Key: key, Limit: limit, Period: window})
+}
+
+func (s *RaftStore) TokenBucket(key string, rate int64, per time.Duration, burst int64) (RateLimitResult, error) {
+	if err := s.fsm.validateLimiter(key, rate, per, burst); err != nil {
+		return RateLimitResult{}, err
+	}
+	return s.proposeLimiter(Command{Op: opBucket, Key: key, Limit: rate, Period: per, Burst: burst})
+}
+
+func (s *RaftStore) RateLimit(key string, limit int64, window time.Duration, burst int64) (RateLimitResult, error) {
+	if err := s.fsm.validateLimiter(key, limit, window, 1); err != nil {
+		return RateLimitResult{}, err
+	}
+	if burst < 0 {
+		return RateLimitResult{}, ErrInvalidRateLimit
+	}
+	return s.proposeLimiter(Command{Op: opLimit, Key: key, Limit: limit, Period: window, Burst: burst})
+}
+
+// Watch observes this member's copy of the data, which every write reaches
+// once committed
+func (s *RaftStore) Watch(ctx context.Context, namespace, prefix string) (<-chan Event, error) {
+	return s.fsm.Watch(ctx, namespace, prefix)
+}
+
+func (s *RaftStore) GetQuote(hash [32]byte) ([]byte, bool) {
+	return s.Get(NamespaceQuotes, hex.EncodeToString(hash[:]))
+}
+
+func (s *RaftStore) SetQuote(hash [32]byte, route []byte, ttl time.Duration) error {
+	return s.Set(NamespaceQuotes, hex.EncodeToString(hash[:]), route, ttl)
+}
+
+func (s *RaftStore) GetPolicy(key string) ([]byte, bool) {
+	return s.Get(NamespacePolicies, key)
+}
+
+func (s *RaftStore) SetPolicy(key string, policy []byte, ttl time.Duration) error {
+	return s.Set(NamespacePolicies, key, policy, ttl)
+}
+
+func (s *RaftStore) SetLedgerIndex(idx uint32) error {
+	return s.Set(NamespaceSystem, "ledger_index", []byte(strconv.FormatUint(uint64(idx), 10)), 0)
+}
+
+func (s *RaftStore) GetLedgerIndex() (uint32, bool) {
+	return s.fsm.GetLedgerIndex()
+}
+
+func (s *RaftStore) Keys(namespace string) []string {
+	return s.fsm.Keys(namespace)
+}
+
+func (s *RaftStore) Stats() Stats {
+	return s.fsm.Stats()
+}
+
+func (s *RaftStore) Close() error {
+	s.node.stop()
+	return s.fsm.Close()
+}
+
+func (s *RaftStore) propose(cmd Command) (applyResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.node.cfg.ApplyTimeout)
+	defer cancel()
+	return s.node.propose(ctx, cmd)
+}
+
+func (s *RaftStore) proposeLimiter(cmd Command) (RateLimitResult, error) {
+	res, err := s.propose(cmd)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	if res.rateLimit == nil {
+		return RateLimitResult{}, ErrOutcomeUnknown
+	}
+	return *res.rateLimit, nil
+}
@@ -0,0 +1,512 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testCluster struct {
+	network *InmemNetwork
+	stores  map[string]*RaftStore
+}
+
+func newTestCluster(t *testing.T, size int, tweak func(*RaftConfig)) *testCluster {
+	t.Helper()
+
+	ids := make([]string, size)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("n%d", i+1)
+	}
+
+	c := &testCluster{network: NewInmemNetwork(), stores: make(map[string]*RaftStore)}
+	for _, id := range ids {
+		cfg := RaftConfig{
+			ID:                id,
+			Peers:             ids,
+			Transport:         c.network.Transport(id),
+			ElectionTimeout:   100 * time.Millisecond,
+			HeartbeatInterval: 20 * time.Millisecond,
+			ApplyTimeout:      3 * time.Second,
+		}
+		if tweak != nil {
+			tweak(&cfg)
+		}
+
+		s, err := NewRaftStore(cfg)
+		if err != nil {
+			t.Fatalf("NewRaftStore(%s) error = %v", id, err)
+		}
+		c.stores[id] = s
+	}
+
+	t.Cleanup(func() {
+		for _, s := range c.stores {
+			s.Close()
+		}
+	})
+	return c
+}
+
+// waitLeader returns the single leader among connected nodes
+func (c *testCluster) waitLeader(t *testing.T, exclude string) *RaftStore {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []*RaftStore
+		for id, s := range c.stores {
+			if id != exclude && s.IsLeader() {
+				leaders = append(leaders, s)
+			}
+		}
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no leader elected")
+	return nil
+}
+
+func (c *testCluster) follower(leader *RaftStore) *RaftStore {
+	for id, s := range c.stores {
+		if id != leader.ID() {
+			return s
+		}
+	}
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestRaftStore_ElectsSingleLeader(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	leader := c.waitLeader(t, "")
+
+	waitFor(t, "followers to learn leader", func() bool {
+		for _, s := range c.stores {
+			if s.Leader() != leader.ID() {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestRaftStore_FollowerWriteReplicates(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	leader := c.waitLeader(t, "")
+	follower := c.follower(leader)
+
+	if err := follower.Set("test", "key1", []byte("value1"), 0); err != nil {
+		t.Fatalf("Set() on follower error = %v", err)
+	}
+
+	for id, s := range c.stores {
+		waitFor(t, "replication to "+id, func() bool {
+			v, ok := s.Get("test", "key1")
+			return ok && bytes.Equal(v, []byte("value1"))
+		})
+	}
+
+	if err := follower.Delete("test", "key1"); err != nil {
+		t.Fatalf("Delete() on follower error = %v", err)
+	}
+	if err := follower.Delete("test", "key1"); err != ErrKeyNotFound {
+		t.Errorf("second Delete() error = %v, want ErrKeyNotFound", err)
+	}
+	for id, s := range c.stores {
+		waitFor(t, "delete on "+id, func() bool {
+			_, ok := s.Get("test", "key1")
+			return !ok
+		})
+	}
+}
+
+func TestRaftStore_ValidationErrors(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *RaftConfig) { cfg.MaxValueSize = 8 })
+	leader := c.waitLeader(t, "")
+
+	if err := c.follower(leader).Set("test", "key", make([]byte, 9), 0); err != ErrValueTooLarge {
+		t.Errorf("Set() error = %v, want ErrValueTooLarge", err)
+	}
+	if err := leader.Set("", "key", nil, 0); err != ErrNamespaceEmpty {
+		t.Errorf("Set() error = %v, want ErrNamespaceEmpty", err)
+	}
+}
+
+func TestRaftStore_LinearizableIncrement(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	c.waitLeader(t, "")
+
+	const perNode = 50
+
+	var mu sync.Mutex
+	var counts []int64
+	var wg sync.WaitGroup
+
+	for _, s := range c.stores {
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func(s *RaftStore) {
+				defer wg.Done()
+				for i := 0; i < perNode; i++ {
+					n, err := s.IncrementRateLimit("partner-1", time.Minute)
+					if err != nil {
+						t.Errorf("IncrementRateLimit() error = %v", err)
+						return
+					}
+					mu.Lock()
+					counts = append(counts, n)
+					mu.Unlock()
+				}
+			}(s)
+		}
+	}
+	wg.Wait()
+
+	total := int64(len(c.stores) * 2 * perNode)
+	if int64(len(counts)) != total {
+		t.Fatalf("got %d results, want %d", len(counts), total)
+	}
+
+	// Every caller must observe a distinct value: 1..total with no gaps
+	sort.Slice(counts, func(i, j int) bool { return counts[i] < counts[j] })
+	for i, n := range counts {
+		if n != int64(i+1) {
+			t.Fatalf("counts[%d] = %d, want %d", i, n, i+1)
+		}
+	}
+
+	for id, s := range c.stores {
+		waitFor(t, "counter on "+id, func() bool {
+			v, ok := s.Get(NamespaceRateLimits, "partner-1")
+			return ok && string(v) == fmt.Sprint(total)
+		})
+	}
+}
+
+func TestRaftStore_TTLReplicated(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	leader := c.waitLeader(t, "")
+
+	if err := leader.Set("test", "short", []byte("v"), 300*time.Millisecond); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	for id, s := range c.stores {
+		waitFor(t, "replication to "+id, func() bool {
+			_, ok := s.Get("test", "short")
+			return ok
+		})
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	for id, s := range c.stores {
+		if _, ok := s.Get("test", "short"); ok {
+			t.Errorf("%s: key should have expired", id)
+		}
+	}
+}
+
+func TestRaftStore_LeaderFailover(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	oldLeader := c.waitLeader(t, "")
+
+	if err := oldLeader.Set("test", "before", []byte("1"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	c.network.Disconnect(oldLeader.ID())
+	newLeader := c.waitLeader(t, oldLeader.ID())
+	if newLeader.ID() == oldLeader.ID() {
+		t.Fatal("partitioned leader kept leadership")
+	}
+
+	follower := c.follower(newLeader)
+	if follower.ID() == oldLeader.ID() {
+		for id, s := range c.stores {
+			if id != oldLeader.ID() && id != newLeader.ID() {
+				follower = s
+			}
+		}
+	}
+	if err := follower.Set("test", "after", []byte("2"), 0); err != nil {
+		t.Fatalf("Set() after failover error = %v", err)
+	}
+
+	c.network.Reconnect(oldLeader.ID())
+	waitFor(t, "old leader to catch up", func() bool {
+		a, okA := oldLeader.Get("test", "after")
+		b, okB := oldLeader.Get("test", "before")
+		return okA && okB && string(a) == "2" && string(b) == "1"
+	})
+}
+
+func TestRaftStore_SnapshotCatchUp(t *testing.T) {
+	c := newTestCluster(t, 3, func(cfg *RaftConfig) { cfg.MaxLogEntries = 10 })
+	leader := c.waitLeader(t, "")
+	lagging := c.follower(leader)
+
+	c.network.Disconnect(lagging.ID())
+	for i := 0; i < 50; i++ {
+		if err := leader.Set("test", fmt.Sprintf("key%d", i), []byte{byte(i)}, 0); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	leader.node.mu.Lock()
+	compacted := leader.node.snapshot != nil
+	leader.node.mu.Unlock()
+	if !compacted {
+		t.Fatal("leader log was not compacted")
+	}
+
+	c.network.Reconnect(lagging.ID())
+	waitFor(t, "lagging follower to install snapshot", func() bool {
+		return len(lagging.Keys("test")) == 50
+	})
+
+	v, ok := lagging.Get("test", "key49")
+	if !ok || !bytes.Equal(v, []byte{49}) {
+		t.Errorf("key49 = %v, %v", v, ok)
+	}
+}
+
+func TestRaftStore_HTTPTransport(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	peers := make(map[string]string)
+	transports := make(map[string]*HTTPTransport)
+	servers := make(map[string]*httptest.Server)
+
+	for _, id := range ids {
+		tr := NewHTTPTransport(peers, nil)
+		transports[id] = tr
+		servers[id] = httptest.NewServer(tr.Handler())
+		peers[id] = servers[id].URL
+	}
+	defer func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}()
+
+	stores := make(map[string]*RaftStore)
+	for _, id := range ids {
+		s, err := NewRaftStore(RaftConfig{
+			ID:                id,
+			Peers:             ids,
+			Transport:         transports[id],
+			ElectionTimeout:   150 * time.Millisecond,
+			HeartbeatInterval: 30 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewRaftStore() error = %v", err)
+		}
+		defer s.Close()
+		stores[id] = s
+	}
+
+	for i, id := range ids {
+		n, err := stores[id].IncrementRateLimit("p", time.Minute)
+		if err != nil {
+			t.Fatalf("IncrementRateLimit() on %s error = %v", id, err)
+		}
+		if n != int64(i+1) {
+			t.Errorf("IncrementRateLimit() on %s = %d, want %d", id, n, i+1)
+		}
+	}
+
+	for id, s := range stores {
+		waitFor(t, "counter on "+id, func() bool {
+			v, ok := s.Get(NamespaceRateLimits, "p")
+			return ok && string(v) == "3"
+		})
+	}
+}
+
+func TestParsePeers(t *testing.T) {
+	peers, err := ParsePeers("n1=http://10.0.0.1:7000/, n2=http://10.0.0.2:7000")
+	if err != nil {
+		t.Fatalf("ParsePeers() error = %v", err)
+	}
+	if peers["n1"] != "http://10.0.0.1:7000" || peers["n2"] != "http://10.0.0.2:7000" {
+		t.Errorf("ParsePeers() = %v", peers)
+	}
+
+	for _, bad := range []string{"", "n1", "=http://x", "n1="} {
+		if _, err := ParsePeers(bad); err == nil {
+			t.Errorf("ParsePeers(%q) expected error", bad)
+		}
+	}
+}
+
+func TestNewRaftStore_InvalidConfig(t *testing.T) {
+	network := NewInmemNetwork()
+	tests := []struct {
+		name string
+		cfg  RaftConfig
+	}{
+		{"missing ID", RaftConfig{Peers: []string{"a"}, Transport: network.Transport("a")}},
+		{"missing transport", RaftConfig{ID: "a", Peers: []string{"a"}}},
+		{"self not in peers", RaftConfig{ID: "a", Peers: []string{"b"}, Transport: network.Transport("a")}},
+		{"heartbeat too slow", RaftConfig{ID: "a", Peers: []string{"a"}, Transport: network.Transport("a"),
+			ElectionTimeout: time.Second, HeartbeatInterval: time.Second}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRaftStore(tt.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestRaftStore_SingleNode(t *testing.T) {
+	network := NewInmemNetwork()
+	s, err := NewRaftStore(RaftConfig{
+		ID:                "solo",
+		Peers:             []string{"solo"},
+		Transport:         network.Transport("solo"),
+		ElectionTimeout:   50 * time.Millisecond,
+		HeartbeatInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewRaftStore() error = %v", err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := s.node.propose(ctx, Command{Op: opSet, Namespace: "test", Key: "k", Value: []byte("v")}); err != nil {
+		t.Fatalf("propose() error = %v", err)
+	}
+	if v, ok := s.Get("test", "k"); !ok || string(v) != "v" {
+		t.Errorf("Get() = %q, %v", v, ok)
+	}
+}
+
+func TestHTTPTransport_RequiresPeerCertificate(t *testing.T) {
+	pki := newTestPKI(t)
+	store, err := NewRaftStore(RaftConfig{ID: "a", Peers: []string{"a"}, Transport: NewInmemNetwork().Transport("a")})
+	if err != nil {
+		t.Fatalf("NewRaftStore() error = %v", err)
+	}
+	defer store.Close()
+
+	tr := NewTLSHTTPTransport(map[string]string{"a": "", "b": ""}, nil)
+	tr.SetHandler(store.node)
+	srv := httptest.NewUnstartedServer(tr.Handler())
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "a", true)},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS13,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	call := func(clientTLS *tls.Config) int {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		resp, err := client.Post(srv.URL+"/raft/vote", "application/json", strings.NewReader(`{"term":0}`))
+		if err != nil {
+			t.Fatalf("POST /raft/vote error = %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := call(pki.clientConfig(t, "b")); got != http.StatusOK {
+		t.Errorf("member status = %d, want %d", got, http.StatusOK)
+	}
+	if got := call(pki.clientConfig(t, "api")); got != http.StatusForbidden {
+		t.Errorf("non-member status = %d, want %d", got, http.StatusForbidden)
+	}
+	if got := call(&tls.Config{RootCAs: pki.pool, ServerName: "127.0.0.1"}); got != http.StatusForbidden {
+		t.Errorf("no certificate status = %d, want %d", got, http.StatusForbidden)
+	}
+}
+
+func TestHTTPTransport_LimitsBody(t *testing.T) {
+	tr := NewHTTPTransport(map[string]string{}, nil)
+	srv := httptest.NewServer(tr.Handler())
+	defer srv.Close()
+
+	body := `{"candidate_id":"` + strings.Repeat("x", maxRPCBytes) + `"}`
+	resp, err := http.Post(srv.URL+"/raft/vote", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /raft/vote error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("oversized body status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestRaftStore_RecoversFromDir(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *RaftStore {
+		s, err := NewRaftStore(RaftConfig{
+			ID:                "solo",
+			Peers:             []string{"solo"},
+			Transport:         NewInmemNetwork().Transport("solo"),
+			ElectionTimeout:   50 * time.Millisecond,
+			HeartbeatInterval: 10 * time.Millisecond,
+			MaxLogEntries:     5,
+			Dir:               dir,
+		})
+		if err != nil {
+			t.Fatalf("NewRaftStore() error = %v", err)
+		}
+		return s
+	}
+
+	s := open()
+	for i := 0; i < 12; i++ {
+		if err := s.Set("test", fmt.Sprintf("k%d", i), []byte{byte(i)}, 0); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	s.node.mu.Lock()
+	term := s.node.term
+	s.node.mu.Unlock()
+	s.Close()
+
+	s = open()
+	defer s.Close()
+
+	s.node.mu.Lock()
+	recovered := s.node.term
+	s.node.mu.Unlock()
+	if recovered < term {
+		t.Errorf("term after restart = %d, want at least %d", recovered, term)
+	}
+	waitFor(t, "recovered keys", func() bool {
+		for i := 0; i < 12; i++ {
+			if v, ok := s.Get("test", fmt.Sprintf("k%d", i)); !ok || !bytes.Equal(v, []byte{byte(i)}) {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,324 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RPCHandler receives Raft RPCs addressed to the local node
+type RPCHandler interface {
+	HandleRequestVote(req *VoteRequest) *VoteResponse
+	HandleAppendEntries(req *AppendEntriesRequest) *AppendEntriesResponse
+	HandleInstallSnapshot(req *InstallSnapshotRequest) *InstallSnapshotResponse
+	HandleForward(ctx context.Context, cmd Command) (*CommandResult, error)
+}
+
+// Transport carries Raft RPCs between members
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req *AppendEntriesRequest) (*AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error)
+	Forward(ctx context.Context, peer string, cmd Command) (*CommandResult, error)
+	SetHandler(h RPCHandler)
+}
+
+var (
+	errPeerUnreachable = errors.New("raft: peer unreachable")
+	// errNotDelivered marks failures where the peer never saw the request,
+	// so a forwarded write can safely be retried
+	errNotDelivered = fmt.Errorf("%w: request not delivered", errPeerUnreachable)
+)
+
+// InmemNetwork connects in-process nodes, for tests and single-binary clusters.
+// Disconnect simulates a partition of one node from all others.
+type InmemNetwork struct {
+	mu           sync.RWMutex
+	handlers     map[string]RPCHandler
+	disconnected map[string]bool
+}
+
+func NewInmemNetwork() *InmemNetwork {
+	return &InmemNetwork{
+		handlers:     make(map[string]RPCHandler),
+		disconnected: make(map[string]bool),
+	}
+}
+
+// Transport returns the transport for node id
+func (n *InmemNetwork) Transport(id string) Transport {
+	return &inmemTransport{network: n, id: id}
+}
+
+func (n *InmemNetwork) Disconnect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.disconnected[id] = true
+}
+
+func (n *InmemNetwork) Reconnect(id string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.disconnected, id)
+}
+
+func (n *InmemNetwork) route(from, to string) (RPCHandler, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.disconnected[from] || n.disconnected[to] {
+		return nil, errNotDelivered
+	}
+	h, ok := n.handlers[to]
+	if !ok {
+		return nil, ErrUnknownPeer
+	}
+	return h, nil
+}
+
+type inmemTransport struct {
+	network *InmemNetwork
+	id      string
+}
+
+func (t *inmemTransport) SetHandler(h RPCHandler) {
+	t.network.mu.Lock()
+	defer t.network.mu.Unlock()
+	t.network.handlers[t.id] = h
+}
+
+func (t *inmemTransport) RequestVote(ctx context.Context, peer string, req *VoteRequest) (*VoteResponse, error) {
+	h, err := t.network.route(t.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandleRequestVote(req), nil
+}
+
+func (t *inmemTransport) AppendEntries(ctx context.Context, peer string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	h, err := t.network.route(t.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	resp := h.HandleAppendEntries(req)
+
+	// A partition may have started while the request was in flight
+	if _, err := t.network.route(t.id, peer); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *inmemTransport) InstallSnapshot(ctx context.Context, peer string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	h, err := t.network.route(t.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandleInstallSnapshot(req), nil
+}
+
+func (t *inmemTransport) Forward(ctx context.Context, peer string, cmd Command) (*CommandResult, error) {
+	h, err := t.network.route(t.id, peer)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandleForward(ctx, cmd)
+}
+
+// Request body limits for the RPC endpoints. Snapshots carry the whole
+// state machine; everything else is bounded by MaxAppendEntries values.
+const (
+	maxRPCBytes      = 64 * 1024 * 1024
+	maxSnapshotBytes = 2 * DefaultMaxBytes
+)
+
+// HTTPTransport carries Raft RPCs as JSON over HTTP. Use NewTLSHTTPTransport
+// outside tests: the plain transport does not authenticate peers.
+type HTTPTransport struct {
+	peers       map[string]string // ID -> base URL
+	client      *http.Client
+	handler     RPCHandler
+	requirePeer bool // only serve clients presenting a member's certificate
+}
+
+func NewHTTPTransport(peers map[string]string, client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+	return &HTTPTransport{peers: peers, client: client}
+}
+
+// NewTLSHTTPTransport dials peers (https URLs) with clientTLS, as from
+// ClientTLSConfig with an empty server name so each peer's host is
+// verified. Its Handler, served with ServerTLSConfig, only accepts clients
+// whose certificate CommonName is a member ID.
+func NewTLSHTTPTransport(peers map[string]string, clientTLS *tls.Config) *HTTPTransport {
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: clientTLS, ForceAttemptHTTP2: true},
+	}
+	t := NewHTTPTransport(peers, client)
+	t.requirePeer = true
+	return t
+}
+
+// ParsePeers parses "id1=https://host1:7000,id2=https://host2:7000"
+func ParsePeers(s string) (map[string]string, error) {
+	peers := make(map[string]string)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, url, ok := strings.Cut(part, "=")
+		if !ok || id == "" || url == "" {
+			return nil, fmt.Errorf("invalid peer %q: want id=url", part)
+		}
+		peers[id] = strings.TrimRight(url, "/")
+	}
+	if len(peers) == 0 {
+		return nil, errors.New("no peers configured")
+	}
+	return peers, nil
+}
+
+func (t *HTTPTransport) SetHandler(h RPCHandler) {
+	t.handler = h
+}
+
+// Handler serves the RPC endpoints for the local node
+func (t *HTTPTransport) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/raft/vote", func(w http.ResponseWriter, r *http.Request) {
+		var req VoteRequest
+		if !decodeRPC(w, r, &req, maxRPCBytes) {
+			return
+		}
+		writeRPC(w, t.handler.HandleRequestVote(&req))
+	})
+	mux.HandleFunc("/raft/append", func(w http.ResponseWriter, r *http.Request) {
+		var req AppendEntriesRequest
+		if !decodeRPC(w, r, &req, maxRPCBytes) {
+			return
+		}
+		writeRPC(w, t.handler.HandleAppendEntries(&req))
+	})
+	mux.HandleFunc("/raft/snapshot", func(w http.ResponseWriter, r *http.Request) {
+		var req InstallSnapshotRequest
+		if !decodeRPC(w, r, &req, maxSnapshotBytes) {
+			return
+		}
+		writeRPC(w, t.handler.HandleInstallSnapshot(&req))
+	})
+	mux.HandleFunc("/raft/forward", func(w http.ResponseWriter, r *http.Request) {
+		var cmd Command
+		if !decodeRPC(w, r, &cmd, maxRPCBytes) {
+			return
+		}
+		res, err := t.handler.HandleForward(r.Context(), cmd)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		writeRPC(w, res)
+	})
+	if !t.requirePeer {
+		return mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !t.isPeer(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// isPeer reports whether r came over TLS with a verified certificate naming
+// another member
+func (t *HTTPTransport) isPeer(r *http.Request) bool {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+	_, ok := t.peers[r.TLS.VerifiedChains[0][0].Subject.CommonName]
+	return ok
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, peer string, req *VoteRequest) (*VoteResponse, error) {
+	var resp VoteResponse
+	return &resp, t.call(ctx, peer, "/raft/vote", req, &resp)
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, peer string, req *AppendEntriesRequest) (*AppendEntriesResponse, error) {
+	var resp AppendEntriesResponse
+	return &resp, t.call(ctx, peer, "/raft/append", req, &resp)
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, peer string, req *InstallSnapshotRequest) (*InstallSnapshotResponse, error) {
+	var resp InstallSnapshotResponse
+	return &resp, t.call(ctx, peer, "/raft/snapshot", req, &resp)
+}
+
+func (t *HTTPTransport) Forward(ctx context.Context, peer string, cmd Command) (*CommandResult, error) {
+	var resp CommandResult
+	return &resp, t.call(ctx, peer, "/raft/forward", cmd, &resp)
+}
+
+func (t *HTTPTransport) call(ctx context.Context, peer, path string, in, out interface{}) error {
+	base, ok := t.peers[peer]
+	if !ok {
+		return ErrUnknownPeer
+	}
+
+	body, err := json.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rpc: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build rpc: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return fmt.Errorf("%w: %v", errNotDelivered, err)
+		}
+		return fmt.Errorf("%w: %v", errPeerUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %d", errPeerUnreachable, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func decodeRPC(w http.ResponseWriter, r *http.Request, v interface{}, limit int64) bool {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit)).Decode(v); err != nil {
+		http.Error(w, "invalid rpc body", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeRPC(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
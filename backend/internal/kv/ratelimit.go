@@ -0,0 +1,306 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// RateLimitResult is the outcome of one request against a limiter
+type RateLimitResult struct {
+	Allowed    bool          `json:"allowed"`
+	Limit      int64         `json:"limit"`
+	Remaining  int64         `json:"remaining"`
+	ResetAt    time.Time     `json:"reset_at"`              // when the full limit is available again
+	RetryAfter time.Duration `json:"retry_after,omitempty"` // until the next request is admitted; zero if allowed
+}
+
+// Limiter state is stored in the rate_limits namespace as a tagged binary
+// value, so it survives through the WAL and Raft like any other entry
+const (
+	stateWindow = 'w'
+	stateBucket = 'b'
+	stateSize   = 25
+)
+
+// SlidingWindow admits at most limit requests per window for key, using a
+// sliding window counter: the previous fixed window's count is weighted by
+// how much of it still overlaps the sliding window. Unlike a fixed window,
+// a steady sender can't double its rate across a window boundary.
+func (s *MemoryStore) SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error) {
+	return s.slidingWindowAt(key, limit, window, time.Now())
+}
+
+// TokenBucket admits requests for key from a bucket of burst tokens that
+// refills at rate tokens per period
+func (s *MemoryStore) TokenBucket(key string, rate int64, per time.Duration, burst int64) (RateLimitResult, error) {
+	return s.tokenBucketAt(key, rate, per, burst, time.Now())
+}
+
+// RateLimit admits a request for key only if a sliding window of limit per
+// window admits it and, when burst is positive, so does a token bucket of
+// burst tokens refilling at the same rate. Both are kept in one entry, so
+// neither is charged for a request the other denies. The result reports the
+// window; a denial waits for whichever limiter takes longer.
+func (s *MemoryStore) RateLimit(key string, limit int64, window time.Duration, burst int64) (RateLimitResult, error) {
+	return s.rateLimitAt(key, limit, window, burst, time.Now())
+}
+
+func (s *MemoryStore) slidingWindowAt(key string, limit int64, window time.Duration, now time.Time) (RateLimitResult, error) {
+	if err := s.validateLimiter(key, limit, window, 1); err != nil {
+		return RateLimitResult{}, err
+	}
+	return s.updateLimiter(key, now, func(value []byte) ([]byte, time.Time, RateLimitResult, error) {
+		st, err := decodeWindow(value)
+		if err != nil {
+			return nil, time.Time{}, RateLimitResult{}, err
+		}
+		res := st.take(limit, int64(window), now.UnixNano())
+		return st.encode(), time.Unix(0, st.start+2*int64(window)), res, nil
+	})
+}
+
+func (s *MemoryStore) tokenBucketAt(key string, rate int64, per time.Duration, burst int64, now time.Time) (RateLimitResult, error) {
+	if err := s.validateLimiter(key, rate, per, burst); err != nil {
+		return RateLimitResult{}, err
+	}
+	return s.updateLimiter(key, now, func(value []byte) ([]byte, time.Time, RateLimitResult, error) {
+		st, err := decodeBucket(value, burst, now.UnixNano())
+		if err != nil {
+			return nil, time.Time{}, RateLimitResult{}, err
+		}
+		res := st.take(rate, int64(per), burst, now.UnixNano())
+		// Once full again the state is indistinguishable from a new bucket
+		return st.encode(), res.ResetAt, res, nil
+	})
+}
+
+func (s *MemoryStore) rateLimitAt(key string, limit int64, window time.Duration, burst int64, now time.Time) (RateLimitResult, error) {
+	if err := s.validateLimiter(key, limit, window, 1); err != nil {
+		return RateLimitResult{}, err
+	}
+	if burst < 0 {
+		return RateLimitResult{}, ErrInvalidRateLimit
+	}
+	return s.updateLimiter(key, now, func(value []byte) ([]byte, time.Time, RateLimitResult, error) {
+		ws, bs, err := decodeLimit(value, burst, now.UnixNano())
+		if err != nil {
+			return nil, time.Time{}, RateLimitResult{}, err
+		}
+		res := ws.take(limit, int64(window), now.UnixNano())
+		expiresAt := time.Unix(0, ws.start+2*int64(window))
+		if burst == 0 {
+			return ws.encode(), expiresAt, res, nil
+		}
+
+		bucket := bs.take(limit, int64(window), burst, now.UnixNano())
+		if !bucket.Allowed {
+			if res.Allowed {
+				// The window's count is not kept, so neither is its charge
+				res.Allowed = false
+				res.Remaining++
+			}
+			res.RetryAfter = max(res.RetryAfter, bucket.RetryAfter)
+		}
+		if bucket.ResetAt.After(expiresAt) {
+			expiresAt = bucket.ResetAt
+		}
+		return append(ws.encode(), bs.encode()...), expiresAt, res, nil
+	})
+}
+
+func (s *MemoryStore) validateLimiter(key string, limit int64, period time.Duration, burst int64) error {
+	if err := s.validate(NamespaceRateLimits, key, nil); err != nil {
+		return err
+	}
+	if limit <= 0 || period <= 0 || burst <= 0 {
+		return ErrInvalidRateLimit
+	}
+	return nil
+}
+
+// updateLimiter applies step to key's current state under its shard lock and
+// stores the result. Denied requests leave the state untouched. New keys
+// count against the namespace's MaxKeys, which bounds the limiters an
+// attacker can create by varying the key.
+func (s *MemoryStore) updateLimiter(key string, now time.Time, step func(value []byte) ([]byte, time.Time, RateLimitResult, error)) (RateLimitResult, error) {
+	fullKey := s.makeKey(NamespaceRateLimits, key)
+	sh := s.shardFor(fullKey)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	var current []byte
+	if e, ok := sh.data[fullKey]; ok {
+		if e.expired(now) {
+			s.expireEntryLocked(sh, fullKey, e, now)
+		} else {
+			current = e.value
+		}
+	}
+
+	value, expiresAt, res, err := step(current)
+	if err != nil || !res.Allowed {
+		return res, err
+	}
+	if !expiresAt.After(now) {
+		expiresAt = now.Add(time.Nanosecond)
+	}
+
+	if err := s.setLockedAt(sh, NamespaceRateLimits, key, value, expiresAt, s.namespaceConfig(NamespaceRateLimits).MaxKeys); err != nil {
+		return RateLimitResult{}, err
+	}
+	s.publish(EventSet, NamespaceRateLimits, key, value, expiresAt)
+	return res, s.logLocked(recordSet, NamespaceRateLimits, key, value, expiresAt)
+}
+
+// windowState counts requests in the fixed window starting at start and the
+// one before it
+type windowState struct {
+	start int64
+	prev  int64
+	curr  int64
+}
+
+func decodeWindow(value []byte) (windowState, error) {
+	if value == nil {
+		return windowState{}, nil
+	}
+	if len(value) != stateSize || value[0] != stateWindow {
+		return windowState{}, fmt.Errorf("invalid sliding window state")
+	}
+	return windowState{
+		start: int64(binary.BigEndian.Uint64(value[1:9])),
+		prev:  int64(binary.BigEndian.Uint64(value[9:17])),
+		curr:  int64(binary.BigEndian.Uint64(value[17:25])),
+	}, nil
+}
+
+// decodeLimit splits RateLimit state into its window and, when the limit
+// has a burst, its bucket
+func decodeLimit(value []byte, burst, now int64) (windowState, bucketState, error) {
+	if value == nil {
+		ws, _ := decodeWindow(nil)
+		bs, _ := decodeBucket(nil, burst, now)
+		return ws, bs, nil
+	}
+	if len(value) != stateSize && len(value) != 2*stateSize {
+		return windowState{}, bucketState{}, fmt.Errorf("invalid rate limit state")
+	}
+	ws, err := decodeWindow(value[:stateSize])
+	if err != nil {
+		return windowState{}, bucketState{}, err
+	}
+	var bucket []byte
+	if len(value) == 2*stateSize {
+		bucket = value[stateSize:]
+	}
+	bs, err := decodeBucket(bucket, burst, now)
+	return ws, bs, err
+}
+
+func (st *windowState) encode() []byte {
+	b := make([]byte, stateSize)
+	b[0] = stateWindow
+	binary.BigEndian.PutUint64(b[1:9], uint64(st.start))
+	binary.BigEndian.PutUint64(b[9:17], uint64(st.prev))
+	binary.BigEndian.PutUint64(b[17:25], uint64(st.curr))
+	return b
+}
+
+func (st *windowState) take(limit, window, now int64) RateLimitResult {
+	start := now - now%window
+	switch start {
+	case st.start:
+	case st.start + window:
+		st.prev, st.curr = st.curr, 0
+	default:
+		st.prev, st.curr = 0, 0
+	}
+	st.start = start
+
+	elapsed := now - start
+	weight := float64(window-elapsed) / float64(window)
+	estimate := float64(st.prev)*weight + float64(st.curr)
+
+	res := RateLimitResult{Limit: limit}
+	if estimate+1 <= float64(limit) {
+		res.Allowed = true
+		st.curr++
+		estimate++
+	} else {
+		res.RetryAfter = time.Duration(st.retryAfter(limit, window, elapsed))
+	}
+	res.Remaining = max(0, limit-int64(math.Ceil(estimate)))
+
+	// The estimate reaches zero once both counted windows have slid out
+	switch {
+	case st.curr > 0:
+		res.ResetAt = time.Unix(0, start+2*window)
+	case st.prev > 0:
+		res.ResetAt = time.Unix(0, start+window)
+	default:
+		res.ResetAt = time.Unix(0, now)
+	}
+	return res
+}
+
+// retryAfter is how long until the weighted estimate leaves room for one more
+// request
+func (st *windowState) retryAfter(limit, window, elapsed int64) int64 {
+	room := float64(limit - 1)
+	if float64(st.curr) <= room && st.prev > 0 {
+		// The previous window's weight decays enough within this window
+		return window - elapsed - int64((room-float64(st.curr))*float64(window)/float64(st.prev))
+	}
+	// Wait for the next window, where this one's count becomes the decaying part
+	next := float64(window) * (1 - room/float64(st.curr))
+	return window - elapsed + int64(math.Ceil(next))
+}
+
+// bucketState holds the tokens left at last
+type bucketState struct {
+	last   int64
+	tokens float64
+}
+
+func decodeBucket(value []byte, burst, now int64) (bucketState, error) {
+	if value == nil {
+		return bucketState{last: now, tokens: float64(burst)}, nil
+	}
+	if len(value) != stateSize || value[0] != stateBucket {
+		return bucketState{}, fmt.Errorf("invalid token bucket state")
+	}
+	return bucketState{
+		last:   int64(binary.BigEndian.Uint64(value[1:9])),
+		tokens: math.Float64frombits(binary.BigEndian.Uint64(value[9:17])),
+	}, nil
+}
+
+func (st *bucketState) encode() []byte {
+	b := make([]byte, stateSize)
+	b[0] = stateBucket
+	binary.BigEndian.PutUint64(b[1:9], uint64(st.last))
+	binary.BigEndian.PutUint64(b[9:17], math.Float64bits(st.tokens))
+	return b
+}
+
+func (st *bucketState) take(rate, per, burst, now int64) RateLimitResult {
+	perToken := float64(per) / float64(rate)
+	if now > st.last {
+		st.tokens = math.Min(float64(burst), st.tokens+float64(now-st.last)/perToken)
+		st.last = now
+	}
+
+	res := RateLimitResult{Limit: burst}
+	if st.tokens >= 1 {
+		res.Allowed = true
+		st.tokens--
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - st.tokens) * perToken))
+	}
+	res.Remaining = int64(st.tokens)
+	res.ResetAt = time.Unix(0, now+int64(math.Ceil((float64(burst)-st.tokens)*perToken)))
+	return res
+}
@@ -0,0 +1,240 @@
+package kv
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow_NoBoundaryBurst(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	window := time.Minute
+	start := time.Unix(1_700_000_040, 0).Truncate(window)
+
+	// Fill the limit at the very end of one window
+	end := start.Add(window - time.Second)
+	for i := 0; i < 10; i++ {
+		res, err := store.slidingWindowAt("p", 10, window, end)
+		if err != nil || !res.Allowed {
+			t.Fatalf("request %d = %+v, %v", i, res, err)
+		}
+		if res.Remaining != int64(9-i) {
+			t.Errorf("request %d Remaining = %d, want %d", i, res.Remaining, 9-i)
+		}
+	}
+
+	// A fixed window would hand out 10 more two seconds later
+	res, err := store.slidingWindowAt("p", 10, window, start.Add(window+time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed {
+		t.Fatal("request just after the boundary should be limited")
+	}
+
+	// prev=10 weighted (59/60) leaves no room; one slot frees once the
+	// weight drops to 9/10, i.e. 6s into the new window
+	if want := 5 * time.Second; res.RetryAfter != want {
+		t.Errorf("RetryAfter = %v, want %v", res.RetryAfter, want)
+	}
+	if want := start.Add(2 * window); !res.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %v, want %v", res.ResetAt, want)
+	}
+
+	retry := start.Add(window + time.Second + res.RetryAfter)
+	if res, _ := store.slidingWindowAt("p", 10, window, retry.Add(-time.Millisecond)); res.Allowed {
+		t.Error("request before RetryAfter should be limited")
+	}
+	if res, _ := store.slidingWindowAt("p", 10, window, retry); !res.Allowed {
+		t.Errorf("request at RetryAfter = %+v, want allowed", res)
+	}
+}
+
+func TestSlidingWindow_RetryInNextWindow(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	window := time.Minute
+	start := time.Unix(1_700_000_040, 0).Truncate(window)
+
+	for i := 0; i < 4; i++ {
+		_, _ = store.slidingWindowAt("p", 4, window, start.Add(30*time.Second))
+	}
+	res, _ := store.slidingWindowAt("p", 4, window, start.Add(30*time.Second))
+	if res.Allowed {
+		t.Fatal("fifth request should be limited")
+	}
+
+	// Next window: 4*(1-e/60) <= 3 once e >= 15s
+	if want := 45 * time.Second; res.RetryAfter != want {
+		t.Errorf("RetryAfter = %v, want %v", res.RetryAfter, want)
+	}
+}
+
+func TestTokenBucket_BurstAndRefill(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	for i := 0; i < 5; i++ {
+		res, err := store.tokenBucketAt("p", 60, time.Minute, 5, now)
+		if err != nil || !res.Allowed {
+			t.Fatalf("burst request %d = %+v, %v", i, res, err)
+		}
+	}
+
+	res, _ := store.tokenBucketAt("p", 60, time.Minute, 5, now)
+	if res.Allowed || res.Remaining != 0 {
+		t.Fatalf("request past burst = %+v, want denied", res)
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("RetryAfter = %v, want 1s", res.RetryAfter)
+	}
+	if want := now.Add(5 * time.Second); !res.ResetAt.Equal(want) {
+		t.Errorf("ResetAt = %v, want %v", res.ResetAt, want)
+	}
+
+	res, _ = store.tokenBucketAt("p", 60, time.Minute, 5, now.Add(2500*time.Millisecond))
+	if !res.Allowed || res.Remaining != 1 {
+		t.Errorf("after 2.5s = %+v, want allowed with 1 remaining", res)
+	}
+
+	// Tokens never exceed the burst
+	res, _ = store.tokenBucketAt("p", 60, time.Minute, 5, now.Add(time.Hour))
+	if !res.Allowed || res.Remaining != 4 {
+		t.Errorf("after idle = %+v, want allowed with 4 remaining", res)
+	}
+}
+
+func TestRateLimit_ChargesOnlyWhenBothAdmit(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Unix(1_700_000_000, 0)
+	for i := 0; i < 2; i++ {
+		if res, err := store.rateLimitAt("p", 60, time.Minute, 2, now); err != nil || !res.Allowed {
+			t.Fatalf("burst request %d = %+v, %v", i, res, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		res, _ := store.rateLimitAt("p", 60, time.Minute, 2, now)
+		if res.Allowed || res.Remaining != 58 || res.RetryAfter != time.Second {
+			t.Fatalf("request past burst = %+v, want denied with 58 remaining", res)
+		}
+	}
+	// Bucket denials left the window alone
+	if res, _ := store.rateLimitAt("p", 60, time.Minute, 2, now.Add(time.Second)); !res.Allowed || res.Remaining != 57 {
+		t.Errorf("after refill = %+v, want allowed with 57 remaining", res)
+	}
+
+	for i := 0; i < 2; i++ {
+		if res, _ := store.rateLimitAt("w", 2, time.Minute, 5, now); !res.Allowed {
+			t.Fatalf("request %d = %+v, want allowed", i, res)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if res, _ := store.rateLimitAt("w", 2, time.Minute, 5, now); res.Allowed {
+			t.Fatalf("request past window = %+v, want denied", res)
+		}
+	}
+	// Window denials left the bucket alone
+	fullKey := store.makeKey(NamespaceRateLimits, "w")
+	_, bucket, err := decodeLimit(store.shardFor(fullKey).data[fullKey].value, 5, now.UnixNano())
+	if err != nil || bucket.tokens != 3 {
+		t.Errorf("bucket tokens = %v, %v; want 3", bucket.tokens, err)
+	}
+}
+
+func TestRateLimit_KeyQuota(t *testing.T) {
+	store := NewMemoryStoreWithConfig(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize,
+		WithNamespace(NamespaceRateLimits, NamespaceConfig{MaxKeys: 5, Eviction: EvictNone}))
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		_, err := store.RateLimit(fmt.Sprint("ip:", i), 10, time.Minute, 2)
+		if i < 5 && err != nil {
+			t.Fatalf("RateLimit(%d) error = %v", i, err)
+		}
+		if i >= 5 && err != ErrNamespaceQuota {
+			t.Fatalf("RateLimit(%d) error = %v, want ErrNamespaceQuota", i, err)
+		}
+	}
+	if n := store.Stats().NamespaceCounts[NamespaceRateLimits]; n != 5 {
+		t.Errorf("rate limit keys = %d, want 5", n)
+	}
+}
+
+func TestRateLimit_InvalidParameters(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	if _, err := store.SlidingWindow("p", 0, time.Minute); err != ErrInvalidRateLimit {
+		t.Errorf("SlidingWindow(limit 0) error = %v, want ErrInvalidRateLimit", err)
+	}
+	if _, err := store.TokenBucket("p", 10, time.Minute, 0); err != ErrInvalidRateLimit {
+		t.Errorf("TokenBucket(burst 0) error = %v, want ErrInvalidRateLimit", err)
+	}
+	if _, err := store.SlidingWindow("", 10, time.Minute); err != ErrKeyEmpty {
+		t.Errorf("SlidingWindow(empty key) error = %v, want ErrKeyEmpty", err)
+	}
+
+	_ = store.Set(NamespaceRateLimits, "bucket", []byte("garbage"), 0)
+	if _, err := store.TokenBucket("bucket", 10, time.Minute, 5); err == nil {
+		t.Error("TokenBucket over foreign state should fail")
+	}
+}
+
+func TestRaftStore_RateLimitReplicates(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	leader := c.waitLeader(t, "")
+	follower := c.follower(leader)
+
+	for i := 0; i < 3; i++ {
+		res, err := follower.SlidingWindow("p", 3, time.Minute)
+		if err != nil || !res.Allowed {
+			t.Fatalf("SlidingWindow(%d) = %+v, %v", i, res, err)
+		}
+	}
+	res, err := leader.SlidingWindow("p", 3, time.Minute)
+	if err != nil || res.Allowed || res.RetryAfter <= 0 {
+		t.Errorf("SlidingWindow over limit = %+v, %v", res, err)
+	}
+
+	if res, err := follower.TokenBucket("b", 1, time.Hour, 1); err != nil || !res.Allowed {
+		t.Fatalf("TokenBucket() = %+v, %v", res, err)
+	}
+	if res, _ := leader.TokenBucket("b", 1, time.Hour, 1); res.Allowed {
+		t.Error("second TokenBucket() should be denied on every member")
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	api := dialAs(t, addr, pki, ServiceAPI)
+	router := dialAs(t, addr, pki, ServiceRouter)
+
+	res, err := api.TokenBucket("p", 10, time.Minute, 2)
+	if err != nil || !res.Allowed || res.Limit != 2 || res.Remaining != 1 || res.ResetAt.IsZero() {
+		t.Errorf("TokenBucket() = %+v, %v", res, err)
+	}
+	if res, err := api.SlidingWindow("p2", 1, time.Minute); err != nil || !res.Allowed {
+		t.Errorf("SlidingWindow() = %+v, %v", res, err)
+	}
+	if res, err := api.RateLimit("p3", 10, time.Minute, 1); err != nil || !res.Allowed || res.Limit != 10 {
+		t.Errorf("RateLimit() = %+v, %v", res, err)
+	}
+	if res, err := api.RateLimit("p3", 10, time.Minute, 1); err != nil || res.Allowed || res.Remaining != 9 {
+		t.Errorf("RateLimit() past burst = %+v, %v", res, err)
+	}
+	if _, err := router.SlidingWindow("p", 1, time.Minute); err != ErrForbidden {
+		t.Errorf("router SlidingWindow() error = %v, want ErrForbidden", err)
+	}
+	if _, err := api.SlidingWindow("p", -1, time.Minute); err != ErrInvalidRateLimit {
+		t.Errorf("SlidingWindow(-1) error = %v, want ErrInvalidRateLimit", err)
+	}
+}
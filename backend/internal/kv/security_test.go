@@ -289,9 +289,6 @@ func TestSecurity_MemoryAccounting(t *testing.T) {
 }
 
 func TestSecurity_NamespaceQuotaEnforcement(t *testing.T) {
-	store := NewMemoryStore()
-	defer store.Close()
-
 	tests := []struct {
 		namespace string
 		testQuota int64
@@ -302,11 +299,9 @@ func TestSecurity_NamespaceQuotaEnforcement(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.namespace, func(t *testing.T) {
-			origQuota := namespaceQuotas[tt.namespace]
-			namespaceQuotas[tt.namespace] = tt.testQuota
-			defer func() {
-				namespaceQuotas[tt.namespace] = origQuota
-			}()
+			store := NewMemoryStoreWithConfig(DefaultMaxBytes, DefaultMaxKeyLength, DefaultMaxValueSize,
+				WithNamespace(tt.namespace, NamespaceConfig{MaxKeys: tt.testQuota, Eviction: EvictNone}))
+			defer store.Close()
 
 			for i := int64(0); i < tt.testQuota; i++ {
 				key := string(rune('a'+(i%26))) + string(rune('A'+(i/26)))
@@ -390,9 +385,10 @@ func TestSecurity_AtomicOperationsConsistency(t *testing.T) {
 	wg.Wait()
 
 	fullKey := store.makeKey(NamespaceRateLimits, partnerID)
-	store.mu.RLock()
-	e, ok := store.data[fullKey]
-	store.mu.RUnlock()
+	sh := store.shardFor(fullKey)
+	sh.mu.RLock()
+	e, ok := sh.data[fullKey]
+	sh.mu.RUnlock()
 
 	if !ok {
 		t.Fatal("Counter not found after increments")
@@ -0,0 +1,456 @@
+package kv
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHandshakeTimeout = 5 * time.Second
+	DefaultMaxInflight      = 64
+)
+
+// Server exposes a Store over the framed protocol. Clients authenticate with
+// a certificate from the shared CA; its CommonName is the service identity
+// checked against the ACL.
+type Server struct {
+	store       Store
+	acl         ACL
+	tlsConfig   *tls.Config
+	maxInflight int
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+func NewServer(store Store, acl ACL, tlsConfig *tls.Config) *Server {
+	return &Server{
+		store:       store,
+		acl:         acl,
+		tlsConfig:   tlsConfig,
+		maxInflight: DefaultMaxInflight,
+		listeners:   make(map[net.Listener]struct{}),
+		conns:       make(map[net.Conn]struct{}),
+	}
+}
+
+// Serve accepts connections on ln until Close is called
+func (s *Server) Serve(ln net.Listener) error {
+	if s.tlsConfig == nil || s.tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		return errors.New("kv server requires mTLS (RequireAndVerifyClientCert)")
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners[ln] = struct{}{}
+	s.mu.Unlock()
+
+	tlsLn := tls.NewListener(ln, s.tlsConfig)
+	for {
+		conn, err := tlsLn.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		s.wg.Add(1)
+		go s.handleConn(conn.(*tls.Conn))
+	}
+}
+
+// Close stops all listeners and drops open connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+func (s *Server) handleConn(conn *tls.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(DefaultHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+	service := state.PeerCertificates[0].Subject.CommonName
+	if _, ok := s.acl[service]; !ok {
+		log.Printf("kv: rejecting unknown service %q from %s", service, conn.RemoteAddr())
+		return
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	var writeMu sync.Mutex
+	var inflight sync.WaitGroup
+	sem := make(chan struct{}, s.maxInflight)
+
+	send := func(f frame) {
+		writeMu.Lock()
+		err := writeFrame(conn, f)
+		writeMu.Unlock()
+		if err != nil {
+			conn.Close()
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watches := make(map[uint32]context.CancelFunc)
+
+	for {
+		req, err := readFrame(reader)
+		if err != nil {
+			break
+		}
+
+		// Watches outlive their request, so they don't hold an inflight slot
+		switch req.code {
+		case opWatch:
+			resp, events := s.openWatch(ctx, service, req, watches)
+			send(resp)
+			if events != nil {
+				inflight.Add(1)
+				go func(id uint32) {
+					defer inflight.Done()
+					for ev := range events {
+						send(frame{id: id, code: statusEvent, body: appendEvent(nil, ev)})
+					}
+				}(req.id)
+			}
+			continue
+		case opUnwatch:
+			r := &fieldReader{b: req.body}
+			if id := uint32(r.int()); r.err == nil && watches[id] != nil {
+				watches[id]()
+				delete(watches, id)
+			}
+			send(frame{id: req.id, code: statusOK})
+			continue
+		}
+
+		sem <- struct{}{}
+		inflight.Add(1)
+		go func(req frame) {
+			defer func() {
+				<-sem
+				inflight.Done()
+			}()
+
+			send(s.handle(service, req))
+		}(req)
+	}
+	cancel()
+	inflight.Wait()
+}
+
+// openWatch subscribes a connection to a namespace. It returns the response
+// to send and, on success, the events to forward after it.
+func (s *Server) openWatch(ctx context.Context, service string, req frame, watches map[uint32]context.CancelFunc) (frame, <-chan Event) {
+	r := &fieldReader{b: req.body}
+	ns, prefix := r.string(), r.string()
+	if r.err != nil {
+		return frame{id: req.id, code: statusError, body: appendString(nil, r.err.Error())}, nil
+	}
+	if !s.acl.Allows(service, ns, PermRead) {
+		return frame{id: req.id, code: statusForbidden, body: appendString(nil, ErrForbidden.Error())}, nil
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	events, err := s.store.Watch(watchCtx, ns, prefix)
+	if err != nil {
+		cancel()
+		return frame{id: req.id, code: statusError, body: appendString(nil, err.Error())}, nil
+	}
+	watches[req.id] = cancel
+	return frame{id: req.id, code: statusOK}, events
+}
+
+func (s *Server) handle(service string, req frame) frame {
+	r := &fieldReader{b: req.body}
+	resp := frame{id: req.id, code: statusOK}
+
+	deny := func() frame {
+		resp.code = statusForbidden
+		resp.body = appendString(nil, ErrForbidden.Error())
+		return resp
+	}
+	fail := func(err error) frame {
+		resp.code = statusError
+		resp.body = appendString(nil, err.Error())
+		return resp
+	}
+
+	switch req.code {
+	case opPing:
+		return resp
+
+	case opGet:
+		ns, key := r.string(), r.string()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, ns, PermRead) {
+			return deny()
+		}
+		value, ok := s.store.Get(ns, key)
+		if !ok {
+			resp.code = statusNotFound
+			return resp
+		}
+		resp.body = appendField(nil, value)
+
+	case opSetKey:
+		ns, key, value, ttl := r.string(), r.string(), r.field(), r.int()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, ns, PermWrite) {
+			return deny()
+		}
+		if err := s.store.Set(ns, key, value, time.Duration(ttl)); err != nil {
+			return fail(err)
+		}
+
+	case opDeleteKey:
+		ns, key := r.string(), r.string()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, ns, PermWrite) {
+			return deny()
+		}
+		if err := s.store.Delete(ns, key); err != nil {
+			return fail(err)
+		}
+
+	case opIncr:
+		key, ttl := r.string(), r.int()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, NamespaceRateLimits, PermWrite) {
+			return deny()
+		}
+		count, err := s.store.IncrementRateLimit(key, time.Duration(ttl))
+		if err != nil {
+			return fail(err)
+		}
+		resp.body = appendInt(nil, count)
+
+	case opSlidingWindow, opTokenBucket, opRateLimit:
+		key, limit, period, burst := r.string(), r.int(), r.int(), r.int()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, NamespaceRateLimits, PermWrite) {
+			return deny()
+		}
+		var res RateLimitResult
+		var err error
+		switch req.code {
+		case opSlidingWindow:
+			res, err = s.store.SlidingWindow(key, limit, time.Duration(period))
+		case opTokenBucket:
+			res, err = s.store.TokenBucket(key, limit, time.Duration(period), burst)
+		default:
+			res, err = s.store.RateLimit(key, limit, time.Duration(period), burst)
+		}
+		if err != nil {
+			return fail(err)
+		}
+		resp.body = appendRateLimit(nil, res)
+
+	case opIncrBy:
+		ns, key, delta, ttl := r.string(), r.string(), r.int(), r.int()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, ns, PermReadWrite) {
+			return deny()
+		}
+		count, err := s.store.Increment(ns, key, delta, time.Duration(ttl))
+		if err != nil {
+			return fail(err)
+		}
+		resp.body = appendInt(nil, count)
+
+	case opTransaction:
+		txn := r.txn()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.allowsTxn(service, txn) {
+			return deny()
+		}
+		succeeded, err := s.store.Txn(txn)
+		if err != nil {
+			return fail(err)
+		}
+		flag := int64(0)
+		if succeeded {
+			flag = 1
+		}
+		resp.body = appendInt(nil, flag)
+
+	case opKeys:
+		ns := r.string()
+		if r.err != nil {
+			return fail(r.err)
+		}
+		if !s.acl.Allows(service, ns, PermRead) {
+			return deny()
+		}
+		keys := s.store.Keys(ns)
+		resp.body = appendInt(nil, int64(len(keys)))
+		for _, k := range keys {
+			resp.body = appendString(resp.body, k)
+		}
+
+	case opStats:
+		if !s.acl.Allows(service, NamespaceSystem, PermRead) {
+			return deny()
+		}
+		data, err := json.Marshal(s.statsFor(service))
+		if err != nil {
+			return fail(err)
+		}
+		resp.body = appendField(nil, data)
+
+	default:
+		return fail(fmt.Errorf("kv: unknown op %d", req.code))
+	}
+	return resp
+}
+
+// allowsTxn requires read access to every namespace a transaction tests and
+// write access to every namespace either branch may write
+func (s *Server) allowsTxn(service string, txn Txn) bool {
+	for _, c := range txn.If {
+		if !s.acl.Allows(service, c.Namespace, PermRead) {
+			return false
+		}
+	}
+	for _, ops := range [][]TxnOp{txn.Then, txn.Else} {
+		for _, op := range ops {
+			if !s.acl.Allows(service, op.Namespace, PermWrite) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// statsFor reports store-wide totals, with per-namespace figures only for
+// namespaces service may read
+func (s *Server) statsFor(service string) Stats {
+	stats := s.store.Stats()
+	counts := make(map[string]int64, len(stats.NamespaceCounts))
+	for ns, n := range stats.NamespaceCounts {
+		if s.acl.Allows(service, ns, PermRead) {
+			counts[ns] = n
+		}
+	}
+	bytes := make(map[string]int64, len(stats.NamespaceBytes))
+	for ns, n := range stats.NamespaceBytes {
+		if s.acl.Allows(service, ns, PermRead) {
+			bytes[ns] = n
+		}
+	}
+	stats.NamespaceCounts, stats.NamespaceBytes = counts, bytes
+	return stats
+}
+
+// ServerTLSConfig loads a server certificate and requires client certificates
+// signed by caFile
+func ServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, pool, err := loadTLSMaterial(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+// ClientTLSConfig loads a service's client certificate and trusts servers
+// signed by caFile. serverName must match the server certificate.
+func ClientTLSConfig(certFile, keyFile, caFile, serverName string) (*tls.Config, error) {
+	cert, pool, err := loadTLSMaterial(certFile, keyFile, caFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		MinVersion:   tls.VersionTLS13,
+	}, nil
+}
+
+func loadTLSMaterial(certFile, keyFile, caFile string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return tls.Certificate{}, nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return tls.Certificate{}, nil, errors.New("no certificates found in CA file")
+	}
+	return cert, pool, nil
+}
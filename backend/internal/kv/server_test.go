@@ -0,0 +1,343 @@
+package kv
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testPKI struct {
+	ca     *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	pool   *x509.CertPool
+	serial int64
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{ca: ca, caKey: key, pool: pool, serial: 1}
+}
+
+func (p *testPKI) issue(t *testing.T, cn string, server bool) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.serial++
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(p.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if server {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		tmpl.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (p *testPKI) clientConfig(t *testing.T, cn string) *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{p.issue(t, cn, false)},
+		RootCAs:      p.pool,
+		ServerName:   "127.0.0.1",
+		MinVersion:   tls.VersionTLS13,
+	}
+}
+
+func startTestServer(t *testing.T, store Store) (string, *testPKI) {
+	t.Helper()
+
+	pki := newTestPKI(t)
+	srv := NewServer(store, DefaultACL(), &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, "kv", true)},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS13,
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+
+	return ln.Addr().String(), pki
+}
+
+func dialAs(t *testing.T, addr string, pki *testPKI, service string) *Client {
+	t.Helper()
+
+	c, err := Dial(addr, pki.clientConfig(t, service))
+	if err != nil {
+		t.Fatalf("Dial(%s) error = %v", service, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_RoundTrip(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	router := dialAs(t, addr, pki, ServiceRouter)
+	api := dialAs(t, addr, pki, ServiceAPI)
+
+	hash := [32]byte{1, 2, 3}
+	if err := router.SetQuote(hash, []byte("route"), time.Minute); err != nil {
+		t.Fatalf("SetQuote() error = %v", err)
+	}
+	got, ok := api.GetQuote(hash)
+	if !ok || !bytes.Equal(got, []byte("route")) {
+		t.Errorf("GetQuote() = %q, %v", got, ok)
+	}
+
+	if err := router.SetLedgerIndex(89123456); err != nil {
+		t.Fatalf("SetLedgerIndex() error = %v", err)
+	}
+	if idx, ok := api.GetLedgerIndex(); !ok || idx != 89123456 {
+		t.Errorf("GetLedgerIndex() = %d, %v", idx, ok)
+	}
+
+	if keys := router.Keys(NamespaceQuotes); len(keys) != 1 {
+		t.Errorf("Keys() = %v, want 1 key", keys)
+	}
+	if stats := api.Stats(); stats.TotalKeys != 2 {
+		t.Errorf("Stats().TotalKeys = %d, want 2", stats.TotalKeys)
+	}
+
+	if err := router.Delete(NamespaceQuotes, "missing"); err != ErrKeyNotFound {
+		t.Errorf("Delete(missing) error = %v, want ErrKeyNotFound", err)
+	}
+	if _, ok := api.Get(NamespaceQuotes, "missing"); ok {
+		t.Error("Get(missing) should miss")
+	}
+	if err := router.Set(NamespaceQuotes, "big", make([]byte, DefaultMaxValueSize+1), 0); err != ErrValueTooLarge {
+		t.Errorf("Set(oversized) error = %v, want ErrValueTooLarge", err)
+	}
+}
+
+func TestClient_NamespaceACL(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	api := dialAs(t, addr, pki, ServiceAPI)
+	indexer := dialAs(t, addr, pki, ServiceIndexer)
+
+	// quotes: router-write, API-read
+	if err := api.SetQuote([32]byte{9}, []byte("forged"), time.Minute); err != ErrForbidden {
+		t.Errorf("api SetQuote() error = %v, want ErrForbidden", err)
+	}
+	if _, ok := indexer.GetQuote([32]byte{9}); ok {
+		t.Error("indexer must not read quotes")
+	}
+	if _, err := indexer.IncrementRateLimit("partner-1", time.Minute); err != ErrForbidden {
+		t.Errorf("indexer IncrementRateLimit() error = %v, want ErrForbidden", err)
+	}
+	if err := indexer.Set(NamespaceCircuitBreaker, "XRP/USD", []byte("open"), 0); err != ErrForbidden {
+		t.Errorf("indexer circuit breaker write error = %v, want ErrForbidden", err)
+	}
+
+	if n, err := api.IncrementRateLimit("partner-1", time.Minute); err != nil || n != 1 {
+		t.Errorf("api IncrementRateLimit() = %d, %v", n, err)
+	}
+	if err := indexer.SetLedgerIndex(100); err != nil {
+		t.Errorf("indexer SetLedgerIndex() error = %v", err)
+	}
+	if err := api.SetPolicy("partner:p1", []byte("[]"), time.Minute); err != nil {
+		t.Errorf("api SetPolicy() error = %v", err)
+	}
+
+	// Stats are scoped to what the caller may read
+	stats := indexer.Stats()
+	if stats.TotalKeys == 0 {
+		t.Error("indexer Stats() returned nothing")
+	}
+	if _, ok := stats.NamespaceCounts[NamespaceRateLimits]; ok {
+		t.Error("indexer Stats() must not report rate limit namespace")
+	}
+	srv := NewServer(store, ACL{"audit": {NamespaceQuotes: PermRead}}, nil)
+	if resp := srv.handle("audit", frame{code: opStats}); resp.code != statusForbidden {
+		t.Errorf("stats without system read: status = %d, want forbidden", resp.code)
+	}
+}
+
+func TestClient_RejectsUnauthenticated(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	noCert := &tls.Config{RootCAs: pki.pool, ServerName: "127.0.0.1", MinVersion: tls.VersionTLS13}
+	if _, err := Dial(addr, noCert); err == nil {
+		t.Error("Dial without client certificate should fail")
+	}
+
+	if _, err := Dial(addr, pki.clientConfig(t, "partner-portal")); err == nil {
+		t.Error("Dial with a service missing from the ACL should fail")
+	}
+
+	other := newTestPKI(t)
+	foreign := &tls.Config{
+		Certificates: []tls.Certificate{other.issue(t, ServiceRouter, false)},
+		RootCAs:      pki.pool,
+		ServerName:   "127.0.0.1",
+		MinVersion:   tls.VersionTLS13,
+	}
+	if _, err := Dial(addr, foreign); err == nil {
+		t.Error("Dial with a certificate from another CA should fail")
+	}
+}
+
+func TestClient_ConcurrentIncrements(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	api := dialAs(t, addr, pki, ServiceAPI)
+
+	const workers, perWorker = 10, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				if _, err := api.IncrementRateLimit("partner-1", time.Minute); err != nil {
+					t.Errorf("IncrementRateLimit() error = %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, _ := store.Get(NamespaceRateLimits, "partner-1")
+	if string(v) != "500" {
+		t.Errorf("counter = %s, want 500", v)
+	}
+}
+
+func TestClient_Reconnects(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	router := dialAs(t, addr, pki, ServiceRouter)
+
+	router.mu.Lock()
+	router.conn.Close()
+	router.mu.Unlock()
+
+	// The in-flight failure may surface once; the next call re-dials
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := router.Set(NamespaceQuotes, "k", []byte("v"), 0)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Set() after reconnect error = %v", err)
+		}
+	}
+}
+
+func TestParseACL(t *testing.T) {
+	acl, err := ParseACL("router=quotes:rw,circuit_breaker:rw; api=quotes:r,rate_limits:rw")
+	if err != nil {
+		t.Fatalf("ParseACL() error = %v", err)
+	}
+
+	tests := []struct {
+		service, ns string
+		perm        Permission
+		want        bool
+	}{
+		{ServiceRouter, NamespaceQuotes, PermWrite, true},
+		{ServiceAPI, NamespaceQuotes, PermRead, true},
+		{ServiceAPI, NamespaceQuotes, PermWrite, false},
+		{ServiceAPI, NamespaceRateLimits, PermReadWrite, true},
+		{ServiceIndexer, NamespaceSystem, PermRead, false},
+		{ServiceRouter, NamespaceSystem, PermRead, false},
+	}
+	for _, tt := range tests {
+		if got := acl.Allows(tt.service, tt.ns, tt.perm); got != tt.want {
+			t.Errorf("Allows(%s, %s, %d) = %v, want %v", tt.service, tt.ns, tt.perm, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "router", "router=quotes", "router=quotes:x", "=quotes:r"} {
+		if _, err := ParseACL(bad); err == nil {
+			t.Errorf("ParseACL(%q) expected error", bad)
+		}
+	}
+}
+
+func TestFrame_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	body := appendInt(appendField(appendString(nil, "ns"), []byte{0, 1, 2}), -42)
+	if err := writeFrame(&buf, frame{id: 7, code: opSetKey, body: body}); err != nil {
+		t.Fatalf("writeFrame() error = %v", err)
+	}
+
+	f, err := readFrame(&buf)
+	if err != nil {
+		t.Fatalf("readFrame() error = %v", err)
+	}
+	r := &fieldReader{b: f.body}
+	ns, value, n := r.string(), r.field(), r.int()
+	if f.id != 7 || f.code != opSetKey || ns != "ns" || !bytes.Equal(value, []byte{0, 1, 2}) || n != -42 || r.err != nil {
+		t.Errorf("decoded id=%d code=%d ns=%q value=%v n=%d err=%v", f.id, f.code, ns, value, n, r.err)
+	}
+
+	r.field()
+	if r.err != errMalformed {
+		t.Errorf("reading past end error = %v, want errMalformed", r.err)
+	}
+
+	if err := writeFrame(&buf, frame{body: make([]byte, maxFrameSize)}); err != ErrFrameTooLarge {
+		t.Errorf("writeFrame(oversized) error = %v, want ErrFrameTooLarge", err)
+	}
+}
@@ -1,15 +1,26 @@
 package kv
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type Store interface {
 	Get(namespace, key string) ([]byte, bool)
 	Set(namespace, key string, value []byte, ttl time.Duration) error
 	Delete(namespace, key string) error
+	CompareAndSwap(namespace, key string, old, value []byte, ttl time.Duration) (bool, error)
+	SetNX(namespace, key string, value []byte, ttl time.Duration) (bool, error)
+	Increment(namespace, key string, delta int64, ttl time.Duration) (int64, error)
+	Txn(txn Txn) (bool, error)
 	IncrementRateLimit(partnerID string, ttl time.Duration) (int64, error)
+	SlidingWindow(key string, limit int64, window time.Duration) (RateLimitResult, error)
+	TokenBucket(key string, rate int64, per time.Duration, burst int64) (RateLimitResult, error)
+	RateLimit(key string, limit int64, window time.Duration, burst int64) (RateLimitResult, error)
 	GetQuote(hash [32]byte) ([]byte, bool)
 	SetQuote(hash [32]byte, route []byte, ttl time.Duration) error
 	Keys(namespace string) []string
+	Watch(ctx context.Context, namespace, prefix string) (<-chan Event, error)
 	Stats() Stats
 	Close() error
 }
@@ -21,5 +32,7 @@ type Stats struct {
 	Evictions       int64
 	Hits            int64
 	Misses          int64
+	Expirations     int64
 	NamespaceCounts map[string]int64
+	NamespaceBytes  map[string]int64
 }
@@ -0,0 +1,349 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// MaxTxnOps bounds the conditions plus operations in one transaction
+const MaxTxnOps = 64
+
+// CompareOp is the test a Condition applies to a key
+type CompareOp uint8
+
+const (
+	CompareExists CompareOp = iota + 1
+	CompareAbsent
+	CompareEqual
+)
+
+// Condition guards a transaction. Expired keys count as absent.
+type Condition struct {
+	Namespace string    `json:"ns"`
+	Key       string    `json:"key"`
+	Op        CompareOp `json:"op"`
+	Value     []byte    `json:"value,omitempty"` // for CompareEqual
+}
+
+func KeyExists(namespace, key string) Condition {
+	return Condition{Namespace: namespace, Key: key, Op: CompareExists}
+}
+
+func KeyAbsent(namespace, key string) Condition {
+	return Condition{Namespace: namespace, Key: key, Op: CompareAbsent}
+}
+
+func KeyEquals(namespace, key string, value []byte) Condition {
+	return Condition{Namespace: namespace, Key: key, Op: CompareEqual, Value: value}
+}
+
+// TxnOp is a write inside a transaction. Deleting a missing key is a no-op.
+type TxnOp struct {
+	Delete    bool          `json:"delete,omitempty"`
+	Namespace string        `json:"ns"`
+	Key       string        `json:"key"`
+	Value     []byte        `json:"value,omitempty"`
+	TTL       time.Duration `json:"ttl,omitempty"`
+}
+
+func SetOp(namespace, key string, value []byte, ttl time.Duration) TxnOp {
+	return TxnOp{Namespace: namespace, Key: key, Value: value, TTL: ttl}
+}
+
+func DeleteOp(namespace, key string) TxnOp {
+	return TxnOp{Delete: true, Namespace: namespace, Key: key}
+}
+
+// Txn applies Then if every condition in If holds and Else otherwise, as
+// one atomic step: no other write to the keys involved can interleave, and
+// a failed write rolls back the ones before it.
+type Txn struct {
+	If   []Condition `json:"if,omitempty"`
+	Then []TxnOp     `json:"then,omitempty"`
+	Else []TxnOp     `json:"else,omitempty"`
+}
+
+// Txn runs txn and reports whether its conditions held
+func (s *MemoryStore) Txn(txn Txn) (bool, error) {
+	return s.txnAt(txn, time.Now())
+}
+
+// CompareAndSwap replaces key's value with value only if it currently holds
+// old. Use SetNX to create a key that must not exist yet.
+func (s *MemoryStore) CompareAndSwap(namespace, key string, old, value []byte, ttl time.Duration) (bool, error) {
+	return s.Txn(compareAndSwapTxn(namespace, key, old, value, ttl))
+}
+
+// SetNX stores value only if key is absent or expired
+func (s *MemoryStore) SetNX(namespace, key string, value []byte, ttl time.Duration) (bool, error) {
+	return s.Txn(setNXTxn(namespace, key, value, ttl))
+}
+
+// Increment adds delta to the integer counter at key and returns the new
+// value. A missing key starts from zero and expires after ttl (0 for
+// never); incrementing an existing counter keeps its expiry.
+func (s *MemoryStore) Increment(namespace, key string, delta int64, ttl time.Duration) (int64, error) {
+	return s.incrementByAt(namespace, key, delta, ttl, time.Now())
+}
+
+func compareAndSwapTxn(namespace, key string, old, value []byte, ttl time.Duration) Txn {
+	return Txn{If: []Condition{KeyEquals(namespace, key, old)}, Then: []TxnOp{SetOp(namespace, key, value, ttl)}}
+}
+
+func setNXTxn(namespace, key string, value []byte, ttl time.Duration) Txn {
+	return Txn{If: []Condition{KeyAbsent(namespace, key)}, Then: []TxnOp{SetOp(namespace, key, value, ttl)}}
+}
+
+func (s *MemoryStore) incrementByAt(namespace, key string, delta int64, ttl time.Duration, now time.Time) (int64, error) {
+	if err := s.validate(namespace, key, nil); err != nil {
+		return 0, err
+	}
+
+	fullKey := s.makeKey(namespace, key)
+	sh := s.shardFor(fullKey)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	count := int64(0)
+	expiresAt := expiryFrom(now, ttl)
+	if e, ok := sh.data[fullKey]; ok {
+		if e.expired(now) {
+			s.expireEntryLocked(sh, fullKey, e, now)
+		} else {
+			current, err := strconv.ParseInt(string(e.value), 10, 64)
+			if err != nil {
+				return 0, ErrNotCounter
+			}
+			count, expiresAt = current, e.expiresAt
+		}
+	}
+	if (delta > 0 && count > math.MaxInt64-delta) || (delta < 0 && count < math.MinInt64-delta) {
+		return 0, ErrCounterOverflow
+	}
+	count += delta
+
+	value := []byte(strconv.FormatInt(count, 10))
+	if err := s.setLockedAt(sh, namespace, key, value, expiresAt, s.namespaceConfig(namespace).MaxKeys); err != nil {
+		return 0, err
+	}
+	s.publish(EventSet, namespace, key, value, expiresAt)
+	return count, s.logLocked(recordIncrement, namespace, key, value, expiresAt)
+}
+
+func (s *MemoryStore) validateTxn(txn Txn) error {
+	if len(txn.If)+len(txn.Then)+len(txn.Else) > MaxTxnOps {
+		return ErrTxnTooLarge
+	}
+	for _, c := range txn.If {
+		if err := s.validate(c.Namespace, c.Key, c.Value); err != nil {
+			return err
+		}
+		if c.Op < CompareExists || c.Op > CompareEqual {
+			return fmt.Errorf("kv: unknown compare op %d", c.Op)
+		}
+	}
+	for _, ops := range [][]TxnOp{txn.Then, txn.Else} {
+		for _, op := range ops {
+			if err := s.validate(op.Namespace, op.Key, op.Value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// txnUndo is a key's state before a transaction first wrote it
+type txnUndo struct {
+	namespace string
+	key       string
+	existed   bool
+	value     []byte
+	expiresAt time.Time
+}
+
+// txnAt is Txn evaluated at a fixed instant, so replicas agree on expiry
+func (s *MemoryStore) txnAt(txn Txn, now time.Time) (bool, error) {
+	if err := s.validateTxn(txn); err != nil {
+		return false, err
+	}
+
+	locked := s.lockTxn(txn)
+	defer func() {
+		for i := len(locked) - 1; i >= 0; i-- {
+			locked[i].mu.Unlock()
+		}
+	}()
+
+	succeeded := true
+	for _, c := range txn.If {
+		if !s.checkLocked(c, now) {
+			succeeded = false
+			break
+		}
+	}
+	ops := txn.Then
+	if !succeeded {
+		ops = txn.Else
+	}
+
+	var undo []txnUndo
+	seen := make(map[string]bool, len(ops))
+	for _, op := range ops {
+		fullKey := s.makeKey(op.Namespace, op.Key)
+		sh := s.shardFor(fullKey)
+
+		e, exists := sh.data[fullKey]
+		if exists && e.expired(now) {
+			s.expireEntryLocked(sh, fullKey, e, now)
+			exists = false
+		}
+		if !seen[fullKey] {
+			seen[fullKey] = true
+			u := txnUndo{namespace: op.Namespace, key: op.Key, existed: exists}
+			if exists {
+				u.value, u.expiresAt = e.value, e.expiresAt
+			}
+			undo = append(undo, u)
+		}
+
+		if op.Delete {
+			if exists {
+				s.deleteEntryLocked(sh, fullKey, e)
+			}
+			continue
+		}
+		if err := s.setLockedAt(sh, op.Namespace, op.Key, op.Value, expiryFrom(now, op.TTL), s.namespaceConfig(op.Namespace).MaxKeys); err != nil {
+			s.rollbackLocked(undo)
+			return false, err
+		}
+	}
+
+	var records []walRecord
+	for _, op := range ops {
+		rec := walRecord{op: recordSet, namespace: op.Namespace, key: op.Key, value: op.Value}
+		expiresAt := expiryFrom(now, op.TTL)
+		if op.Delete {
+			rec.op, rec.value, expiresAt = recordDelete, nil, time.Time{}
+			s.publish(EventDelete, op.Namespace, op.Key, nil, expiresAt)
+		} else {
+			s.publish(EventSet, op.Namespace, op.Key, op.Value, expiresAt)
+		}
+		if !expiresAt.IsZero() {
+			rec.expiresAt = expiresAt.UnixNano()
+		}
+		if s.durable[op.Namespace] {
+			records = append(records, rec)
+		}
+	}
+	return succeeded, s.logTxnLocked(records)
+}
+
+// lockTxn takes the locks of every shard txn touches in index order, the
+// same order as lockAll, and returns them
+func (s *MemoryStore) lockTxn(txn Txn) []*shard {
+	indexes := make(map[int]bool)
+	add := func(namespace, key string) {
+		indexes[s.shardIndex(s.makeKey(namespace, key))] = true
+	}
+	for _, c := range txn.If {
+		add(c.Namespace, c.Key)
+	}
+	for _, ops := range [][]TxnOp{txn.Then, txn.Else} {
+		for _, op := range ops {
+			add(op.Namespace, op.Key)
+		}
+	}
+
+	order := make([]int, 0, len(indexes))
+	for i := range indexes {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+
+	locked := make([]*shard, len(order))
+	for i, idx := range order {
+		locked[i] = s.shards[idx]
+		locked[i].mu.Lock()
+	}
+	return locked
+}
+
+func (s *MemoryStore) checkLocked(c Condition, now time.Time) bool {
+	fullKey := s.makeKey(c.Namespace, c.Key)
+	sh := s.shardFor(fullKey)
+
+	e, exists := sh.data[fullKey]
+	if exists && e.expired(now) {
+		s.expireEntryLocked(sh, fullKey, e, now)
+		exists = false
+	}
+	switch c.Op {
+	case CompareExists:
+		return exists
+	case CompareAbsent:
+		return !exists
+	case CompareEqual:
+		return exists && bytes.Equal(e.value, c.Value)
+	}
+	return false
+}
+
+// rollbackLocked restores the keys a failed transaction wrote. Restoring
+// never grows a namespace beyond where it started, so quota can't refuse it.
+func (s *MemoryStore) rollbackLocked(undo []txnUndo) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		fullKey := s.makeKey(u.namespace, u.key)
+		sh := s.shardFor(fullKey)
+		if e, ok := sh.data[fullKey]; ok {
+			s.deleteEntryLocked(sh, fullKey, e)
+		}
+		if u.existed {
+			_ = s.setLockedAt(sh, u.namespace, u.key, u.value, u.expiresAt, 0)
+		}
+	}
+}
+
+// logTxnLocked writes a transaction's durable mutations as one WAL record,
+// so recovery applies all of them or none
+func (s *MemoryStore) logTxnLocked(records []walRecord) error {
+	switch {
+	case s.wal == nil || len(records) == 0:
+		return nil
+	case len(records) == 1:
+		rec := records[0]
+		return s.logLocked(rec.op, rec.namespace, rec.key, rec.value, unixNanoTime(rec.expiresAt))
+	}
+
+	var batch []byte
+	for _, rec := range records {
+		batch = append(batch, encodeRecord(rec)...)
+	}
+	if err := s.wal.append(walRecord{op: recordTxn, value: batch}); err != nil {
+		return fmt.Errorf("wal append failed: %w", err)
+	}
+	return nil
+}
+
+// decodeTxnRecord splits a recordTxn back into its mutations
+func decodeTxnRecord(rec walRecord) ([]walRecord, error) {
+	r := bufio.NewReader(bytes.NewReader(rec.value))
+	var records []walRecord
+	for {
+		sub, _, err := readRecord(r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil || sub.op == recordTxn {
+			return nil, errTornRecord
+		}
+		records = append(records, sub)
+	}
+}
@@ -0,0 +1,261 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore_CompareAndSwap(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	if ok, err := store.CompareAndSwap(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"), []byte("open"), 0); err != nil || ok {
+		t.Errorf("CompareAndSwap(missing) = %v, %v; want false", ok, err)
+	}
+	_ = store.Set(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"), 0)
+
+	if ok, _ := store.CompareAndSwap(NamespaceCircuitBreaker, "XRP/USD", []byte("half-open"), []byte("open"), 0); ok {
+		t.Error("CompareAndSwap() with stale value should fail")
+	}
+	if ok, err := store.CompareAndSwap(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"), []byte("open"), 0); err != nil || !ok {
+		t.Fatalf("CompareAndSwap() = %v, %v; want true", ok, err)
+	}
+	if v, _ := store.Get(NamespaceCircuitBreaker, "XRP/USD"); string(v) != "open" {
+		t.Errorf("value = %q, want open", v)
+	}
+}
+
+func TestMemoryStore_CompareAndSwapConcurrent(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	_ = store.Set("test", "n", []byte("0"), 0)
+
+	const workers, perWorker = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; {
+				old, _ := store.Get("test", "n")
+				n, _ := strconv.Atoi(string(old))
+				if ok, _ := store.CompareAndSwap("test", "n", old, []byte(strconv.Itoa(n+1)), 0); ok {
+					i++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := store.Get("test", "n"); string(v) != strconv.Itoa(workers*perWorker) {
+		t.Errorf("counter = %s, want %d", v, workers*perWorker)
+	}
+}
+
+func TestMemoryStore_SetNX(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	if ok, err := store.SetNX(NamespaceQuotes, "q1", []byte("used"), 20*time.Millisecond); err != nil || !ok {
+		t.Fatalf("SetNX() = %v, %v; want true", ok, err)
+	}
+	if ok, _ := store.SetNX(NamespaceQuotes, "q1", []byte("again"), time.Minute); ok {
+		t.Error("SetNX() on an existing key should fail")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if ok, _ := store.SetNX(NamespaceQuotes, "q1", []byte("again"), time.Minute); !ok {
+		t.Error("SetNX() should succeed once the key expired")
+	}
+}
+
+func TestMemoryStore_Increment(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	now := time.Now()
+	if n, err := store.incrementByAt("test", "c", 5, time.Minute, now); err != nil || n != 5 {
+		t.Fatalf("Increment() = %d, %v; want 5", n, err)
+	}
+	if n, _ := store.incrementByAt("test", "c", -7, time.Hour, now.Add(time.Second)); n != -2 {
+		t.Errorf("Increment(-7) = %d, want -2", n)
+	}
+
+	// The TTL set on creation is kept
+	if n, _ := store.incrementByAt("test", "c", 1, time.Hour, now.Add(2*time.Minute)); n != 1 {
+		t.Errorf("Increment() after expiry = %d, want 1", n)
+	}
+
+	_ = store.Set("test", "s", []byte("text"), 0)
+	if _, err := store.Increment("test", "s", 1, 0); err != ErrNotCounter {
+		t.Errorf("Increment(non-integer) error = %v, want ErrNotCounter", err)
+	}
+	_ = store.Set("test", "max", []byte("9223372036854775807"), 0)
+	if _, err := store.Increment("test", "max", 1, 0); err != ErrCounterOverflow {
+		t.Errorf("Increment(overflow) error = %v, want ErrCounterOverflow", err)
+	}
+}
+
+func TestMemoryStore_Txn(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	_ = store.Set("locks", "pair:XRP/USD", []byte("router-1"), 0)
+
+	// Release a lock and hand its work to another key in one step
+	txn := Txn{
+		If:   []Condition{KeyEquals("locks", "pair:XRP/USD", []byte("router-1")), KeyAbsent("jobs", "XRP/USD")},
+		Then: []TxnOp{DeleteOp("locks", "pair:XRP/USD"), SetOp("jobs", "XRP/USD", []byte("queued"), 0)},
+		Else: []TxnOp{SetOp("jobs", "conflicts", []byte("1"), 0)},
+	}
+	if ok, err := store.Txn(txn); err != nil || !ok {
+		t.Fatalf("Txn() = %v, %v; want true", ok, err)
+	}
+	if _, ok := store.Get("locks", "pair:XRP/USD"); ok {
+		t.Error("lock should be released")
+	}
+	if v, _ := store.Get("jobs", "XRP/USD"); string(v) != "queued" {
+		t.Errorf("job = %q", v)
+	}
+
+	// Conditions no longer hold, so the Else branch runs
+	if ok, err := store.Txn(txn); err != nil || ok {
+		t.Fatalf("Txn() = %v, %v; want false", ok, err)
+	}
+	if _, ok := store.Get("jobs", "conflicts"); !ok {
+		t.Error("else branch should have run")
+	}
+
+	big := Txn{}
+	for i := 0; i <= MaxTxnOps; i++ {
+		big.Then = append(big.Then, SetOp("test", fmt.Sprint(i), nil, 0))
+	}
+	if _, err := store.Txn(big); err != ErrTxnTooLarge {
+		t.Errorf("Txn(too large) error = %v, want ErrTxnTooLarge", err)
+	}
+}
+
+func TestMemoryStore_TxnRollsBack(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	quota := DefaultNamespaceConfigs()[NamespaceSystem].MaxKeys
+	for i := int64(0); i < quota-1; i++ {
+		_ = store.Set(NamespaceSystem, fmt.Sprint("k", i), []byte("v"), 0)
+	}
+
+	// The second new key exceeds the namespace quota
+	txn := Txn{Then: []TxnOp{
+		SetOp(NamespaceSystem, "k0", []byte("changed"), 0),
+		SetOp(NamespaceSystem, "new-1", []byte("v"), 0),
+		SetOp(NamespaceSystem, "new-2", []byte("v"), 0),
+	}}
+	if _, err := store.Txn(txn); err != ErrNamespaceQuota {
+		t.Fatalf("Txn() error = %v, want ErrNamespaceQuota", err)
+	}
+	if v, _ := store.Get(NamespaceSystem, "k0"); string(v) != "v" {
+		t.Errorf("k0 = %q, want the original value", v)
+	}
+	if _, ok := store.Get(NamespaceSystem, "new-1"); ok {
+		t.Error("new-1 should be rolled back")
+	}
+	if n := store.Stats().NamespaceCounts[NamespaceSystem]; n != quota-1 {
+		t.Errorf("namespace count = %d, want %d", n, quota-1)
+	}
+}
+
+func TestDurableStore_RecoversTxn(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openDurable(t, dir, false)
+	_ = s.Set(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"), 0)
+	txn := Txn{
+		If: []Condition{KeyEquals(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"))},
+		Then: []TxnOp{
+			SetOp(NamespaceCircuitBreaker, "XRP/USD", []byte("open"), 0),
+			SetOp(NamespaceSystem, "breaker_trips", []byte("1"), 0),
+		},
+	}
+	if ok, err := s.Txn(txn); err != nil || !ok {
+		t.Fatalf("Txn() = %v, %v", ok, err)
+	}
+	if _, err := s.Increment(NamespaceSystem, "breaker_trips", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = openDurable(t, dir, false)
+	defer s.Close()
+
+	if v, _ := s.Get(NamespaceCircuitBreaker, "XRP/USD"); string(v) != "open" {
+		t.Errorf("breaker = %q, want open", v)
+	}
+	if v, _ := s.Get(NamespaceSystem, "breaker_trips"); string(v) != "2" {
+		t.Errorf("trips = %q, want 2", v)
+	}
+}
+
+func TestRaftStore_TxnReplicates(t *testing.T) {
+	c := newTestCluster(t, 3, nil)
+	leader := c.waitLeader(t, "")
+	follower := c.follower(leader)
+
+	if ok, err := follower.SetNX(NamespaceQuotes, "q1", []byte("used"), time.Minute); err != nil || !ok {
+		t.Fatalf("SetNX() = %v, %v", ok, err)
+	}
+	if ok, err := leader.SetNX(NamespaceQuotes, "q1", []byte("used"), time.Minute); err != nil || ok {
+		t.Errorf("second SetNX() = %v, %v; want false on every member", ok, err)
+	}
+	if n, err := follower.Increment("test", "c", 3, 0); err != nil || n != 3 {
+		t.Errorf("Increment() = %d, %v", n, err)
+	}
+	if ok, err := leader.CompareAndSwap("test", "c", []byte("3"), []byte("10"), 0); err != nil || !ok {
+		t.Errorf("CompareAndSwap() = %v, %v", ok, err)
+	}
+
+	waitFor(t, "txn to replicate", func() bool {
+		v, _ := follower.Get("test", "c")
+		return string(v) == "10"
+	})
+}
+
+func TestClient_Txn(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	router := dialAs(t, addr, pki, ServiceRouter)
+	api := dialAs(t, addr, pki, ServiceAPI)
+
+	if ok, err := router.SetNX(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"), 0); err != nil || !ok {
+		t.Fatalf("SetNX() = %v, %v", ok, err)
+	}
+	if ok, err := router.CompareAndSwap(NamespaceCircuitBreaker, "XRP/USD", []byte("closed"), []byte("open"), 0); err != nil || !ok {
+		t.Errorf("CompareAndSwap() = %v, %v", ok, err)
+	}
+	if n, err := router.Increment(NamespaceSystem, "trips", 2, 0); err != nil || n != 2 {
+		t.Errorf("Increment() = %d, %v", n, err)
+	}
+	if _, err := router.Increment(NamespaceCircuitBreaker, "XRP/USD", 1, 0); err != ErrNotCounter {
+		t.Errorf("Increment(non-integer) error = %v, want ErrNotCounter", err)
+	}
+
+	// The API may read quotes but not write them
+	txn := Txn{If: []Condition{KeyAbsent(NamespaceQuotes, "q")}, Then: []TxnOp{SetOp(NamespaceQuotes, "q", nil, 0)}}
+	if _, err := api.Txn(txn); err != ErrForbidden {
+		t.Errorf("api Txn(quotes) error = %v, want ErrForbidden", err)
+	}
+
+	events, _ := store.Watch(context.Background(), NamespaceQuotes, "")
+	if ok, err := router.Txn(txn); err != nil || !ok {
+		t.Fatalf("router Txn() = %v, %v", ok, err)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventSet || ev.Key != "q" {
+		t.Errorf("event = %v %s", ev.Type, ev.Key)
+	}
+}
@@ -0,0 +1,608 @@
+package kv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSnapshotInterval = 5 * time.Minute
+	DefaultSyncInterval     = time.Second
+
+	walSegmentPrefix = "wal-"
+	walSegmentSuffix = ".log"
+	snapshotFile     = "snapshot"
+	snapshotMagic    = "LXKVSNP1"
+
+	walMaxRecord = 16 * 1024 * 1024
+)
+
+const (
+	recordSet byte = iota + 1
+	recordDelete
+	recordIncrement
+	recordTxn // value holds the transaction's encoded records
+
+	// Raft storage records, see raft_storage.go
+	recordRaftState // value holds a JSON raftState
+	recordRaftEntry // value holds a JSON LogEntry
+)
+
+var (
+	ErrCorruptSnapshot = errors.New("corrupt kv snapshot")
+	errTornRecord      = errors.New("torn wal record")
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// DurabilityConfig enables the write-ahead log for selected namespaces.
+// Writes to other namespaces stay memory-only and are lost on restart.
+type DurabilityConfig struct {
+	Dir        string
+	Namespaces []string
+
+	// SyncEveryWrite fsyncs before each write returns. Otherwise the WAL is
+	// flushed and synced every SyncInterval, bounding loss on a crash.
+	SyncEveryWrite   bool
+	SyncInterval     time.Duration
+	SnapshotInterval time.Duration
+
+	MaxBytes     int64
+	MaxKeyLength int
+	MaxValueSize int
+	Options      []Option // as for NewMemoryStoreWithConfig
+}
+
+// walRecord is one logged mutation. Increments log the resulting counter so
+// replay is idempotent.
+type walRecord struct {
+	op        byte
+	namespace string
+	key       string
+	value     []byte
+	expiresAt int64 // unix nanoseconds, 0 = no expiry
+}
+
+// wal appends records to numbered segment files in dir. A snapshot records
+// the segment it was cut at; recovery loads it and replays later segments.
+type wal struct {
+	mu      sync.Mutex
+	snapMu  sync.Mutex // one snapshot at a time
+	dir     string
+	seq     uint64
+	file    *os.File
+	buf     *bufio.Writer
+	syncAll bool
+	dirty   bool
+}
+
+// NewDurableMemoryStore opens (or creates) a MemoryStore backed by a WAL in
+// cfg.Dir, recovering any state left by a previous process. Expired entries
+// are dropped during recovery.
+func NewDurableMemoryStore(cfg DurabilityConfig) (*MemoryStore, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("durability dir is required")
+	}
+	if cfg.SyncInterval <= 0 {
+		cfg.SyncInterval = DefaultSyncInterval
+	}
+	if cfg.SnapshotInterval <= 0 {
+		cfg.SnapshotInterval = DefaultSnapshotInterval
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.MaxKeyLength <= 0 {
+		cfg.MaxKeyLength = DefaultMaxKeyLength
+	}
+	if cfg.MaxValueSize <= 0 {
+		cfg.MaxValueSize = DefaultMaxValueSize
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create kv dir: %w", err)
+	}
+
+	s := NewMemoryStoreWithConfig(cfg.MaxBytes, cfg.MaxKeyLength, cfg.MaxValueSize, cfg.Options...)
+	s.durable = make(map[string]bool, len(cfg.Namespaces))
+	for _, ns := range cfg.Namespaces {
+		s.durable[ns] = true
+	}
+
+	lastSeq, err := s.recover(cfg.Dir)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	w, err := openWAL(cfg.Dir, lastSeq+1, cfg.SyncEveryWrite)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.wal = w
+
+	// Fold the recovered segments into a fresh snapshot straight away
+	if err := s.Snapshot(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	go s.durabilityLoop(cfg.SyncInterval, cfg.SnapshotInterval)
+	return s, nil
+}
+
+// Snapshot writes the durable namespaces to disk and removes WAL segments
+// the snapshot covers.
+func (s *MemoryStore) Snapshot() error {
+	if s.wal == nil {
+		return nil
+	}
+
+	s.wal.snapMu.Lock()
+	defer s.wal.snapMu.Unlock()
+
+	// Rotate with every shard locked so the snapshot and the new segment
+	// split the history at exactly one point
+	s.lockAll()
+	entries := s.snapshotLocked(s.durable)
+	coveredSeq, err := s.wal.rotate()
+	s.unlockAll()
+	if err != nil {
+		return err
+	}
+
+	if err := writeSnapshot(s.wal.dir, coveredSeq, entries); err != nil {
+		return err
+	}
+	return removeSegments(s.wal.dir, coveredSeq)
+}
+
+func (s *MemoryStore) durabilityLoop(syncInterval, snapshotInterval time.Duration) {
+	syncTicker := time.NewTicker(syncInterval)
+	defer syncTicker.Stop()
+	snapTicker := time.NewTicker(snapshotInterval)
+	defer snapTicker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-syncTicker.C:
+			_ = s.wal.sync()
+		case <-snapTicker.C:
+			_ = s.Snapshot()
+		}
+	}
+}
+
+// logLocked appends a mutation to the WAL if its namespace is durable.
+// Called with the key's shard lock held so log order matches apply order.
+func (s *MemoryStore) logLocked(op byte, namespace, key string, value []byte, expiresAt time.Time) error {
+	if s.wal == nil || !s.durable[namespace] {
+		return nil
+	}
+
+	rec := walRecord{op: op, namespace: namespace, key: key, value: value}
+	if !expiresAt.IsZero() {
+		rec.expiresAt = expiresAt.UnixNano()
+	}
+	if err := s.wal.append(rec); err != nil {
+		return fmt.Errorf("wal append failed: %w", err)
+	}
+	return nil
+}
+
+// recover loads the snapshot and replays newer WAL segments. It returns the
+// highest segment number seen so new writes go to a fresh segment.
+func (s *MemoryStore) recover(dir string) (uint64, error) {
+	snapSeq, entries, err := readSnapshot(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	s.lockAll()
+	defer s.unlockAll()
+
+	now := time.Now()
+	for _, se := range entries {
+		if se.ExpiresAt != 0 && now.UnixNano() >= se.ExpiresAt {
+			continue
+		}
+		if err := s.setLocked(se.Namespace, se.Key, se.Value, unixNanoTime(se.ExpiresAt)); err != nil {
+			return 0, err
+		}
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	lastSeq := snapSeq
+	for _, seq := range segments {
+		if seq > lastSeq {
+			lastSeq = seq
+		}
+		if seq <= snapSeq {
+			continue
+		}
+		if err := s.replaySegment(filepath.Join(dir, segmentName(seq)), now); err != nil {
+			return 0, err
+		}
+	}
+	return lastSeq, nil
+}
+
+func (s *MemoryStore) replaySegment(path string, now time.Time) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if errors.Is(err, errTornRecord) {
+			// A crash mid-append leaves a partial tail; drop it
+			return f.Truncate(offset)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read wal segment: %w", err)
+		}
+		offset += n
+
+		records := []walRecord{rec}
+		if rec.op == recordTxn {
+			if records, err = decodeTxnRecord(rec); err != nil {
+				return fmt.Errorf("failed to read wal segment: %w", err)
+			}
+		}
+		for _, rec := range records {
+			if err := s.replayRecordLocked(rec, now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *MemoryStore) replayRecordLocked(rec walRecord, now time.Time) error {
+	if rec.op == recordDelete || (rec.expiresAt != 0 && now.UnixNano() >= rec.expiresAt) {
+		s.deleteLocked(rec.namespace, rec.key)
+		return nil
+	}
+	return s.setLocked(rec.namespace, rec.key, rec.value, unixNanoTime(rec.expiresAt))
+}
+
+func openWAL(dir string, seq uint64, syncAll bool) (*wal, error) {
+	w := &wal{dir: dir, syncAll: syncAll}
+	if err := w.openSegment(seq); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *wal) openSegment(seq uint64) error {
+	f, err := os.OpenFile(filepath.Join(w.dir, segmentName(seq)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	w.file = f
+	w.buf = bufio.NewWriter(f)
+	w.seq = seq
+	return nil
+}
+
+func (w *wal) append(rec walRecord) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	if _, err := w.buf.Write(encodeRecord(rec)); err != nil {
+		return err
+	}
+	w.dirty = true
+	if w.syncAll {
+		return w.syncLocked()
+	}
+	return nil
+}
+
+// rotate seals the current segment and starts the next one, returning the
+// sequence number of the sealed segment
+func (w *wal) rotate() (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if err := w.syncLocked(); err != nil {
+		return 0, err
+	}
+	if err := w.file.Close(); err != nil {
+		return 0, err
+	}
+	sealed := w.seq
+	if err := w.openSegment(sealed + 1); err != nil {
+		w.file = nil
+		return 0, err
+	}
+	return sealed, nil
+}
+
+func (w *wal) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+func (w *wal) syncLocked() error {
+	if w.file == nil || !w.dirty {
+		return nil
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *wal) close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.syncLocked()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+// Record layout: length(4) crc32c(4) payload, where payload is
+// op(1) expiresAt(8) then uvarint-prefixed namespace, key and value.
+func encodeRecord(rec walRecord) []byte {
+	payload := make([]byte, 0, 1+8+3*binary.MaxVarintLen64+len(rec.namespace)+len(rec.key)+len(rec.value))
+	payload = append(payload, rec.op)
+	payload = binary.BigEndian.AppendUint64(payload, uint64(rec.expiresAt))
+	for _, field := range [][]byte{[]byte(rec.namespace), []byte(rec.key), rec.value} {
+		payload = binary.AppendUvarint(payload, uint64(len(field)))
+		payload = append(payload, field...)
+	}
+
+	out := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(out[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(out[4:8], crc32.Checksum(payload, crcTable))
+	return append(out, payload...)
+}
+
+// readRecord returns the next record and its encoded size. io.EOF means a
+// clean end; errTornRecord means a partial or corrupt tail.
+func readRecord(r *bufio.Reader) (walRecord, int64, error) {
+	var rec walRecord
+
+	var header [8]byte
+	n, err := io.ReadFull(r, header[:])
+	if err == io.EOF {
+		return rec, 0, io.EOF
+	}
+	if err != nil || n < len(header) {
+		return rec, 0, errTornRecord
+	}
+
+	size := binary.BigEndian.Uint32(header[0:4])
+	if size == 0 || size > walMaxRecord {
+		return rec, 0, errTornRecord
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, errTornRecord
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return rec, 0, errTornRecord
+	}
+
+	if len(payload) < 9 {
+		return rec, 0, errTornRecord
+	}
+	rec.op = payload[0]
+	rec.expiresAt = int64(binary.BigEndian.Uint64(payload[1:9]))
+	rest := payload[9:]
+
+	fields := make([][]byte, 3)
+	for i := range fields {
+		l, k := binary.Uvarint(rest)
+		if k <= 0 || uint64(len(rest)-k) < l {
+			return rec, 0, errTornRecord
+		}
+		fields[i] = rest[k : k+int(l)]
+		rest = rest[k+int(l):]
+	}
+	rec.namespace = string(fields[0])
+	rec.key = string(fields[1])
+	rec.value = fields[2]
+
+	switch rec.op {
+	case recordSet, recordDelete, recordIncrement, recordTxn, recordRaftState, recordRaftEntry:
+	default:
+		return rec, 0, errTornRecord
+	}
+	return rec, int64(len(header)) + int64(size), nil
+}
+
+// Snapshot layout: magic(8) seq(8) then one recordSet per entry. It is
+// written to a temp file and renamed so a crash never leaves a partial one.
+func writeSnapshot(dir string, seq uint64, entries []SnapshotEntry) error {
+	return writeSnapshotFile(dir, snapshotFile, snapshotMagic, []uint64{seq}, entries)
+}
+
+func readSnapshot(dir string) (uint64, []SnapshotEntry, error) {
+	header, entries, err := readSnapshotFile(dir, snapshotFile, snapshotMagic, 1)
+	if err != nil || header == nil {
+		return 0, nil, err
+	}
+	return header[0], entries, nil
+}
+
+// writeSnapshotFile writes magic, the header words and entries to name in dir
+func writeSnapshotFile(dir, name, magic string, header []uint64, entries []SnapshotEntry) error {
+	tmp := filepath.Join(dir, name+".tmp")
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+
+	buf := bufio.NewWriter(f)
+	buf.WriteString(magic)
+	var word [8]byte
+	for _, h := range header {
+		binary.BigEndian.PutUint64(word[:], h)
+		buf.Write(word[:])
+	}
+	for _, se := range entries {
+		buf.Write(encodeRecord(walRecord{
+			op:        recordSet,
+			namespace: se.Namespace,
+			key:       se.Key,
+			value:     se.Value,
+			expiresAt: se.ExpiresAt,
+		}))
+	}
+
+	if err := buf.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		return fmt.Errorf("failed to install snapshot: %w", err)
+	}
+	return syncDir(dir)
+}
+
+// readSnapshotFile reads a file written by writeSnapshotFile with words
+// header words. A missing file returns a nil header.
+func readSnapshotFile(dir, name, magic string, words int) ([]uint64, []SnapshotEntry, error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	raw := make([]byte, len(magic)+8*words)
+	if _, err := io.ReadFull(r, raw); err != nil || string(raw[:len(magic)]) != magic {
+		return nil, nil, ErrCorruptSnapshot
+	}
+	header := make([]uint64, words)
+	for i := range header {
+		header[i] = binary.BigEndian.Uint64(raw[len(magic)+8*i:])
+	}
+
+	var entries []SnapshotEntry
+	for {
+		rec, _, err := readRecord(r)
+		if err == io.EOF {
+			return header, entries, nil
+		}
+		if err != nil || rec.op != recordSet {
+			// Snapshots are renamed into place whole, so any damage is real
+			return nil, nil, ErrCorruptSnapshot
+		}
+		entries = append(entries, SnapshotEntry{
+			Namespace: rec.namespace,
+			Key:       rec.key,
+			Value:     rec.value,
+			ExpiresAt: rec.expiresAt,
+		})
+	}
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	names, err := filepath.Glob(filepath.Join(dir, walSegmentPrefix+"*"+walSegmentSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	seqs := make([]uint64, 0, len(names))
+	for _, name := range names {
+		base := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), walSegmentPrefix), walSegmentSuffix)
+		seq, err := strconv.ParseUint(base, 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	return seqs, nil
+}
+
+func removeSegments(dir string, upTo uint64) error {
+	seqs, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+	for _, seq := range seqs {
+		if seq > upTo {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, segmentName(seq))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove wal segment: %w", err)
+		}
+	}
+	return nil
+}
+
+func segmentName(seq uint64) string {
+	return fmt.Sprintf("%s%016d%s", walSegmentPrefix, seq, walSegmentSuffix)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,218 @@
+package kv
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func openDurable(t *testing.T, dir string, syncAll bool) *MemoryStore {
+	t.Helper()
+
+	s, err := NewDurableMemoryStore(DurabilityConfig{
+		Dir:            dir,
+		Namespaces:     []string{NamespaceRateLimits, NamespaceCircuitBreaker, NamespaceSystem},
+		SyncEveryWrite: syncAll,
+	})
+	if err != nil {
+		t.Fatalf("NewDurableMemoryStore() error = %v", err)
+	}
+	return s
+}
+
+func TestDurableStore_RecoversDurableNamespaces(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openDurable(t, dir, false)
+	if err := s.Set(NamespaceCircuitBreaker, "XRP/USD", []byte("open"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.SetLedgerIndex(89123456); err != nil {
+		t.Fatalf("SetLedgerIndex() error = %v", err)
+	}
+	if err := s.SetQuote([32]byte{1}, []byte("route"), time.Minute); err != nil {
+		t.Fatalf("SetQuote() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := s.IncrementRateLimit("partner-1", time.Minute); err != nil {
+			t.Fatalf("IncrementRateLimit() error = %v", err)
+		}
+	}
+	if err := s.Set(NamespaceCircuitBreaker, "XRP/EUR", []byte("open"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Delete(NamespaceCircuitBreaker, "XRP/EUR"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	s.Close()
+
+	s = openDurable(t, dir, false)
+	defer s.Close()
+
+	if v, ok := s.Get(NamespaceCircuitBreaker, "XRP/USD"); !ok || string(v) != "open" {
+		t.Errorf("circuit breaker state = %q, %v", v, ok)
+	}
+	if _, ok := s.Get(NamespaceCircuitBreaker, "XRP/EUR"); ok {
+		t.Error("deleted key should stay deleted")
+	}
+	if idx, ok := s.GetLedgerIndex(); !ok || idx != 89123456 {
+		t.Errorf("GetLedgerIndex() = %d, %v", idx, ok)
+	}
+	if _, ok := s.GetQuote([32]byte{1}); ok {
+		t.Error("quotes namespace is not durable and should not survive restart")
+	}
+
+	count, err := s.IncrementRateLimit("partner-1", time.Minute)
+	if err != nil {
+		t.Fatalf("IncrementRateLimit() error = %v", err)
+	}
+	if count != 4 {
+		t.Errorf("counter after restart = %d, want 4", count)
+	}
+}
+
+func TestDurableStore_DropsExpiredOnRecovery(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openDurable(t, dir, false)
+	if err := s.Set(NamespaceSystem, "short", []byte("v"), 50*time.Millisecond); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Set(NamespaceSystem, "long", []byte("v"), time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	s.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	s = openDurable(t, dir, false)
+	defer s.Close()
+
+	if _, ok := s.Get(NamespaceSystem, "short"); ok {
+		t.Error("expired entry should be dropped on recovery")
+	}
+	if _, ok := s.Get(NamespaceSystem, "long"); !ok {
+		t.Error("unexpired entry should be recovered")
+	}
+	if stats := s.Stats(); stats.TotalKeys != 1 {
+		t.Errorf("TotalKeys = %d, want 1", stats.TotalKeys)
+	}
+}
+
+func TestDurableStore_SnapshotCompactsSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openDurable(t, dir, false)
+	for i := 0; i < 10; i++ {
+		if _, err := s.IncrementRateLimit("partner-1", time.Minute); err != nil {
+			t.Fatalf("IncrementRateLimit() error = %v", err)
+		}
+	}
+	if err := s.Snapshot(); err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if _, err := s.IncrementRateLimit("partner-1", time.Minute); err != nil {
+		t.Fatalf("IncrementRateLimit() error = %v", err)
+	}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		t.Fatalf("listSegments() error = %v", err)
+	}
+	if len(segments) != 1 {
+		t.Errorf("segments after snapshot = %v, want only the active one", segments)
+	}
+	s.Close()
+
+	s = openDurable(t, dir, false)
+	defer s.Close()
+
+	if v, ok := s.Get(NamespaceRateLimits, "partner-1"); !ok || string(v) != "11" {
+		t.Errorf("counter = %q, %v, want 11", v, ok)
+	}
+}
+
+func TestDurableStore_TornTailIsTruncated(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openDurable(t, dir, true)
+	if err := s.Set(NamespaceSystem, "a", []byte("1"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := s.Set(NamespaceSystem, "b", []byte("2"), 0); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+
+	// Simulate a crash mid-append: no Close, half a record on disk
+	segments, _ := listSegments(dir)
+	path := filepath.Join(dir, segmentName(segments[len(segments)-1]))
+	full := encodeRecord(walRecord{op: recordSet, namespace: NamespaceSystem, key: "c", value: []byte("3")})
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	f.Write(full[:len(full)-2])
+	f.Close()
+
+	recovered := openDurable(t, dir, true)
+	defer recovered.Close()
+	defer s.Close()
+
+	for key, want := range map[string]string{"a": "1", "b": "2"} {
+		if v, ok := recovered.Get(NamespaceSystem, key); !ok || string(v) != want {
+			t.Errorf("Get(%s) = %q, %v, want %q", key, v, ok, want)
+		}
+	}
+	if _, ok := recovered.Get(NamespaceSystem, "c"); ok {
+		t.Error("torn record should not be applied")
+	}
+
+	// The store must keep working after dropping the tail
+	if err := recovered.Set(NamespaceSystem, "d", []byte("4"), 0); err != nil {
+		t.Fatalf("Set() after recovery error = %v", err)
+	}
+}
+
+func TestDurableStore_CorruptSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, snapshotFile), []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDurableMemoryStore(DurabilityConfig{Dir: dir}); err != ErrCorruptSnapshot {
+		t.Errorf("NewDurableMemoryStore() error = %v, want ErrCorruptSnapshot", err)
+	}
+}
+
+func TestWALRecord_RoundTrip(t *testing.T) {
+	rec := walRecord{op: recordIncrement, namespace: "ns", key: "key", value: []byte("42"), expiresAt: 123456789}
+	data := encodeRecord(rec)
+
+	path := filepath.Join(t.TempDir(), "rec")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f, _ := os.Open(path)
+	defer f.Close()
+
+	got, n, err := readRecord(bufio.NewReader(f))
+	if err != nil {
+		t.Fatalf("readRecord() error = %v", err)
+	}
+	if n != int64(len(data)) || got.op != rec.op || got.namespace != rec.namespace ||
+		got.key != rec.key || string(got.value) != "42" || got.expiresAt != rec.expiresAt {
+		t.Errorf("readRecord() = %+v (%d bytes), want %+v", got, n, rec)
+	}
+
+	data[len(data)-1] ^= 0xFF
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	f2, _ := os.Open(path)
+	defer f2.Close()
+	if _, _, err := readRecord(bufio.NewReader(f2)); err != errTornRecord {
+		t.Errorf("readRecord() with bad checksum error = %v, want errTornRecord", err)
+	}
+}
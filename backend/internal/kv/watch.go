@@ -0,0 +1,216 @@
+package kv
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultWatchBuffer = 256
+
+// EventType is the kind of change a watcher is told about
+type EventType uint8
+
+const (
+	EventSet EventType = iota + 1
+	EventDelete
+	EventExpire
+	EventEvict
+
+	// EventOverflow means events were dropped because the watcher fell
+	// behind; the receiver should re-read the keys it cares about
+	EventOverflow
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	case EventOverflow:
+		return "overflow"
+	}
+	return "unknown"
+}
+
+// Event is one change to a watched key. Value and ExpiresAt are only set
+// for EventSet; Namespace and Key are empty for EventOverflow.
+type Event struct {
+	Type      EventType
+	Namespace string
+	Key       string
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// watcher buffers events for one subscriber. Writers never block on it: the
+// last slot of the buffer is kept for EventOverflow, so when the rest is full
+// the event is dropped and the marker queued behind what the subscriber has
+// yet to read.
+type watcher struct {
+	namespace string
+	prefix    string
+	ch        chan Event
+	done      chan struct{} // closed with ch
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newWatcher(namespace, prefix string, buffer int) *watcher {
+	return &watcher{namespace: namespace, prefix: prefix, ch: make(chan Event, buffer+1), done: make(chan struct{})}
+}
+
+func (w *watcher) matches(namespace, key string) bool {
+	return namespace == w.namespace && strings.HasPrefix(key, w.prefix)
+}
+
+// deliver queues ev or, with the buffer full, the overflow marker. Every send
+// happens under w.mu, so the buffer cannot fill between check and send.
+func (w *watcher) deliver(ev Event) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	if len(w.ch) < cap(w.ch)-1 {
+		w.ch <- ev
+		return
+	}
+	w.overflowLocked()
+}
+
+// overflow tells the subscriber its view is stale, e.g. after a restore
+func (w *watcher) overflow() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.overflowLocked()
+	}
+}
+
+// overflowLocked queues EventOverflow. Only a marker ever takes the reserved
+// slot, so a full buffer already ends with one the subscriber has not read.
+func (w *watcher) overflowLocked() {
+	if len(w.ch) < cap(w.ch) {
+		w.ch <- Event{Type: EventOverflow}
+	}
+}
+
+func (w *watcher) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+		close(w.done)
+	}
+}
+
+// watchers is a copy-on-write registry so publishing from the write path
+// costs one atomic load when nobody is watching
+type watchers struct {
+	mu   sync.Mutex
+	list atomic.Pointer[[]*watcher]
+}
+
+func (ws *watchers) add(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	var next []*watcher
+	if cur := ws.list.Load(); cur != nil {
+		next = append(next, *cur...)
+	}
+	next = append(next, w)
+	ws.list.Store(&next)
+}
+
+func (ws *watchers) remove(w *watcher) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	cur := ws.list.Load()
+	if cur == nil {
+		return
+	}
+	next := make([]*watcher, 0, len(*cur))
+	for _, other := range *cur {
+		if other != w {
+			next = append(next, other)
+		}
+	}
+	ws.list.Store(&next)
+}
+
+func (ws *watchers) load() []*watcher {
+	if cur := ws.list.Load(); cur != nil {
+		return *cur
+	}
+	return nil
+}
+
+// Watch streams changes to keys in namespace that start with prefix (all
+// keys if empty) until ctx is done, then closes the channel. Events for one
+// key arrive in the order they were applied.
+func (s *MemoryStore) Watch(ctx context.Context, namespace, prefix string) (<-chan Event, error) {
+	if namespace == "" {
+		return nil, ErrNamespaceEmpty
+	}
+	if s.stopped.Load() {
+		return nil, ErrStoreClosed
+	}
+
+	w := newWatcher(namespace, prefix, DefaultWatchBuffer)
+	s.watchers.add(w)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.stopCh:
+		}
+		s.watchers.remove(w)
+		w.close()
+	}()
+	return w.ch, nil
+}
+
+// publish notifies matching watchers. Called with the key's shard lock held
+// so each watcher sees a key's changes in apply order.
+func (s *MemoryStore) publish(typ EventType, namespace, key string, value []byte, expiresAt time.Time) {
+	list := s.watchers.load()
+	if len(list) == 0 {
+		return
+	}
+
+	var ev *Event
+	for _, w := range list {
+		if !w.matches(namespace, key) {
+			continue
+		}
+		if ev == nil {
+			ev = &Event{Type: typ, Namespace: namespace, Key: key, ExpiresAt: expiresAt}
+			if value != nil {
+				ev.Value = append([]byte(nil), value...)
+			}
+		}
+		w.deliver(*ev)
+	}
+}
+
+// publishOverflow marks every watcher stale
+func (s *MemoryStore) publishOverflow() {
+	for _, w := range s.watchers.load() {
+		w.overflow()
+	}
+}
@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func nextEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for event")
+	}
+	return Event{}
+}
+
+func TestMemoryStore_Watch(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := store.Watch(ctx, NamespaceCircuitBreaker, "XRP/")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	_ = store.Set(NamespaceCircuitBreaker, "USD/EUR", []byte("open"), 0) // other prefix
+	_ = store.Set(NamespaceSystem, "XRP/USD", []byte("open"), 0)         // other namespace
+	_ = store.Set(NamespaceCircuitBreaker, "XRP/USD", []byte("open"), 0)
+	_ = store.Delete(NamespaceCircuitBreaker, "XRP/USD")
+	_ = store.Set(NamespaceCircuitBreaker, "XRP/EUR", []byte("half-open"), 20*time.Millisecond)
+
+	want := []struct {
+		typ   EventType
+		key   string
+		value string
+	}{
+		{EventSet, "XRP/USD", "open"},
+		{EventDelete, "XRP/USD", ""},
+		{EventSet, "XRP/EUR", "half-open"},
+		{EventExpire, "XRP/EUR", ""},
+	}
+	for _, w := range want {
+		ev := nextEvent(t, events)
+		if ev.Type != w.typ || ev.Key != w.key || string(ev.Value) != w.value || ev.Namespace != NamespaceCircuitBreaker {
+			t.Errorf("event = %v %s/%s %q, want %v %s %q", ev.Type, ev.Namespace, ev.Key, ev.Value, w.typ, w.key, w.value)
+		}
+		if ev.Type == EventSet && w.key == "XRP/EUR" && ev.ExpiresAt.IsZero() {
+			t.Error("set event should carry the expiry")
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("unexpected event after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("channel not closed after cancel")
+	}
+}
+
+func TestMemoryStore_WatchOverflow(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+
+	events, _ := store.Watch(context.Background(), "test", "")
+	for i := 0; i < DefaultWatchBuffer+10; i++ {
+		_ = store.Set("test", "k", []byte("v"), 0)
+	}
+
+	// The buffer fills and later events are dropped; the overflow marker is
+	// already queued behind the buffered events, with no write needed
+	for i := 0; i < DefaultWatchBuffer; i++ {
+		if ev := nextEvent(t, events); ev.Type != EventSet {
+			t.Fatalf("event %d = %v, want set", i, ev.Type)
+		}
+	}
+	if ev := nextEvent(t, events); ev.Type != EventOverflow {
+		t.Fatalf("event after drain = %v, want overflow", ev.Type)
+	}
+	_ = store.Delete("test", "k")
+	if ev := nextEvent(t, events); ev.Type != EventDelete {
+		t.Errorf("event after overflow = %v, want delete", ev.Type)
+	}
+}
+
+func TestMemoryStore_WatchRestoreAndClose(t *testing.T) {
+	store := NewMemoryStore()
+
+	events, _ := store.Watch(context.Background(), "test", "")
+	if err := store.restore([]SnapshotEntry{{Namespace: "test", Key: "k", Value: []byte("v")}}); err != nil {
+		t.Fatal(err)
+	}
+	if ev := nextEvent(t, events); ev.Type != EventOverflow {
+		t.Errorf("event after restore = %v, want overflow", ev.Type)
+	}
+
+	store.Close()
+	if _, ok := <-events; ok {
+		t.Error("channel should close with the store")
+	}
+	if _, err := store.Watch(context.Background(), "test", ""); err != ErrStoreClosed {
+		t.Errorf("Watch() after Close error = %v, want ErrStoreClosed", err)
+	}
+}
+
+func TestClient_Watch(t *testing.T) {
+	store := NewMemoryStore()
+	defer store.Close()
+	addr, pki := startTestServer(t, store)
+
+	router := dialAs(t, addr, pki, ServiceRouter)
+	indexer := dialAs(t, addr, pki, ServiceIndexer)
+
+	if _, err := indexer.Watch(context.Background(), NamespaceQuotes, ""); err != ErrForbidden {
+		t.Errorf("indexer Watch(quotes) error = %v, want ErrForbidden", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := router.Watch(ctx, NamespaceSystem, "ledger")
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+
+	if err := indexer.SetLedgerIndex(42); err != nil {
+		t.Fatal(err)
+	}
+	ev := nextEvent(t, events)
+	if ev.Type != EventSet || ev.Key != "ledger_index" || string(ev.Value) != "42" {
+		t.Errorf("event = %v %s %q", ev.Type, ev.Key, ev.Value)
+	}
+
+	cancel()
+	if _, ok := <-events; ok {
+		t.Error("channel should close after cancel")
+	}
+	waitFor(t, "server to drop the watch", func() bool {
+		return len(store.watchers.load()) == 0
+	})
+
+	// Losing the connection ends the stream
+	events, _ = router.Watch(context.Background(), NamespaceSystem, "")
+	router.mu.Lock()
+	router.conn.Close()
+	router.mu.Unlock()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("unexpected event")
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("channel not closed after connection loss")
+	}
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 
+	"github.com/lucendex/backend/internal/address"
 	"github.com/lucendex/backend/internal/store"
 	"github.com/lucendex/backend/internal/xrpl"
 )
@@ -122,7 +123,14 @@ func parseAmount(amount interface{}) (asset string, value string, err error) {
 			return "", "", fmt.Errorf("invalid IOU amount object")
 		}
 		
-		asset = xrpl.FormatAsset(currency, issuer)
+		if !address.IsValidClassic(issuer) {
+			return "", "", fmt.Errorf("invalid IOU issuer %q", issuer)
+		}
+
+		asset, err = xrpl.FormatAsset(currency, issuer)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid IOU amount: %w", err)
+		}
 		return asset, value, nil
 	}
 	
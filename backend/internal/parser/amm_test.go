@@ -32,7 +32,7 @@ func TestAMMParser_ParseTransaction(t *testing.T) {
 				"Amount":          "1000000", // XRP
 				"Amount2": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
+					"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 					"value":    "500.50",
 				},
 				"TradingFee": float64(30),
@@ -49,7 +49,7 @@ func TestAMMParser_ParseTransaction(t *testing.T) {
 				"Amount":          "1000000",
 				"Amount2": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rN7n7",
+					"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 					"value":    "500",
 				},
 			},
@@ -65,7 +65,7 @@ func TestAMMParser_ParseTransaction(t *testing.T) {
 				"Account":         "rAMMCreator",
 				"Amount2": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rN7n7",
+					"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 					"value":    "500",
 				},
 			},
@@ -157,10 +157,10 @@ func TestParseAmount(t *testing.T) {
 			name: "IOU object",
 			amount: map[string]interface{}{
 				"currency": "USD",
-				"issuer":   "rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
+				"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 				"value":    "500.50",
 			},
-			wantAsset: "USD.rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
+			wantAsset: "USD.rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 			wantValue: "500.50",
 			wantErr:   false,
 		},
@@ -174,7 +174,7 @@ func TestParseAmount(t *testing.T) {
 		{
 			name: "IOU missing currency",
 			amount: map[string]interface{}{
-				"issuer": "rN7n7",
+				"issuer": "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 				"value":  "500",
 			},
 			wantAsset: "",
@@ -233,7 +233,7 @@ func TestAMMParser_parseAMMCreate(t *testing.T) {
 				"Amount":  "1000000",
 				"Amount2": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rN7n7",
+					"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 					"value":    "500",
 				},
 				"TradingFee": float64(30),
@@ -249,12 +249,12 @@ func TestAMMParser_parseAMMCreate(t *testing.T) {
 				"Account": "rAMMAccount",
 				"Amount": map[string]interface{}{
 					"currency": "EUR",
-					"issuer":   "rEUR",
+					"issuer":   "rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq",
 					"value":    "1000",
 				},
 				"Amount2": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rUSD",
+					"issuer":   "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
 					"value":    "1200",
 				},
 			},
@@ -295,7 +295,7 @@ func BenchmarkAMMParser_ParseTransaction(b *testing.B) {
 		"Amount":          "1000000",
 		"Amount2": map[string]interface{}{
 			"currency": "USD",
-			"issuer":   "rN7n7",
+			"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 			"value":    "500",
 		},
 		"TradingFee": float64(30),
@@ -319,7 +319,7 @@ func BenchmarkParseAmount_XRP(b *testing.B) {
 func BenchmarkParseAmount_IOU(b *testing.B) {
 	amount := map[string]interface{}{
 		"currency": "USD",
-		"issuer":   "rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
+		"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 		"value":    "500.50",
 	}
 	
@@ -0,0 +1,119 @@
+package parser
+
+import (
+	"github.com/lucendex/backend/internal/address"
+	"github.com/lucendex/backend/internal/currency"
+	"github.com/lucendex/backend/internal/store"
+	"github.com/lucendex/backend/internal/xrpl"
+)
+
+// IssuerParser extracts issuer risk state from AccountRoot changes
+type IssuerParser struct{}
+
+// NewIssuerParser creates a new issuer parser
+func NewIssuerParser() *IssuerParser {
+	return &IssuerParser{}
+}
+
+// ParseTransaction returns the post-transaction state of every AccountRoot
+// the transaction created or whose Flags or TransferRate it changed.
+// Balance-only changes are skipped. Callers decide which accounts are issuers.
+func (p *IssuerParser) ParseTransaction(tx map[string]interface{}, ledgerIndex uint64) []*store.IssuerState {
+	meta, ok := tx["meta"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	nodes, ok := meta["AffectedNodes"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var states []*store.IssuerState
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// Deleted accounts no longer issue anything worth tracking
+		for _, kind := range []string{"CreatedNode", "ModifiedNode"} {
+			change, ok := node[kind].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if entryType, _ := change["LedgerEntryType"].(string); entryType != "AccountRoot" {
+				continue
+			}
+
+			fields, ok := change["NewFields"].(map[string]interface{})
+			if kind == "ModifiedNode" {
+				if !riskFieldsChanged(change) {
+					continue
+				}
+				fields, ok = change["FinalFields"].(map[string]interface{})
+			}
+			if !ok {
+				continue
+			}
+
+			if state := issuerStateFromFields(fields, ledgerIndex); state != nil {
+				states = append(states, state)
+			}
+		}
+	}
+
+	return states
+}
+
+// IssuerStateFromAccountRoot converts an account_info result into issuer state
+func IssuerStateFromAccountRoot(root xrpl.AccountRoot, ledgerIndex uint64) *store.IssuerState {
+	return &store.IssuerState{
+		Account:       root.Account,
+		Flags:         int64(root.Flags),
+		TransferRate:  int64(root.TransferRate),
+		GlobalFreeze:  root.Flags&xrpl.LsfGlobalFreeze != 0,
+		NoFreeze:      root.Flags&xrpl.LsfNoFreeze != 0,
+		AllowClawback: root.Flags&xrpl.LsfAllowTrustLineClawback != 0,
+		LedgerIndex:   int64(ledgerIndex),
+	}
+}
+
+// AssetIssuer returns the issuer of an asset in "CURRENCY.ISSUER" format,
+// or "" for XRP and unparseable assets
+func AssetIssuer(asset string) string {
+	_, issuer, err := currency.SplitAsset(asset)
+	if err != nil {
+		return ""
+	}
+	return issuer
+}
+
+// riskFieldsChanged reports whether a ModifiedNode touched Flags or TransferRate
+func riskFieldsChanged(change map[string]interface{}) bool {
+	previous, ok := change["PreviousFields"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, flags := previous["Flags"]
+	_, rate := previous["TransferRate"]
+	return flags || rate
+}
+
+// issuerStateFromFields decodes AccountRoot fields from transaction metadata
+func issuerStateFromFields(fields map[string]interface{}, ledgerIndex uint64) *store.IssuerState {
+	account, ok := fields["Account"].(string)
+	if !ok || !address.IsValidClassic(account) {
+		return nil
+	}
+
+	root := xrpl.AccountRoot{Account: account}
+	if flags, ok := fields["Flags"].(float64); ok {
+		root.Flags = uint32(flags)
+	}
+	if rate, ok := fields["TransferRate"].(float64); ok {
+		root.TransferRate = uint32(rate)
+	}
+
+	return IssuerStateFromAccountRoot(root, ledgerIndex)
+}
@@ -0,0 +1,111 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/lucendex/backend/internal/xrpl"
+)
+
+func TestIssuerParser_ParseTransaction(t *testing.T) {
+	parser := NewIssuerParser()
+
+	tx := map[string]interface{}{
+		"TransactionType": "AccountSet",
+		"Account":         "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
+		"meta": map[string]interface{}{
+			"AffectedNodes": []interface{}{
+				map[string]interface{}{
+					"ModifiedNode": map[string]interface{}{
+						"LedgerEntryType": "AccountRoot",
+						"FinalFields": map[string]interface{}{
+							"Account":      "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
+							"Flags":        float64(xrpl.LsfGlobalFreeze | xrpl.LsfAllowTrustLineClawback),
+							"TransferRate": float64(1002000000),
+						},
+						"PreviousFields": map[string]interface{}{
+							"Flags": float64(0),
+						},
+					},
+				},
+				map[string]interface{}{
+					"ModifiedNode": map[string]interface{}{
+						"LedgerEntryType": "RippleState",
+						"FinalFields":     map[string]interface{}{"Flags": float64(0)},
+					},
+				},
+				map[string]interface{}{
+					"ModifiedNode": map[string]interface{}{
+						"LedgerEntryType": "AccountRoot",
+						"FinalFields": map[string]interface{}{
+							"Account": "rFeePayer",
+							"Balance": "999990",
+						},
+						"PreviousFields": map[string]interface{}{
+							"Balance": "1000000",
+						},
+					},
+				},
+				map[string]interface{}{
+					"CreatedNode": map[string]interface{}{
+						"LedgerEntryType": "AccountRoot",
+						"NewFields": map[string]interface{}{
+							"Account": "rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	states := parser.ParseTransaction(tx, 12345)
+	if len(states) != 2 {
+		t.Fatalf("len(states) = %d, want 2", len(states))
+	}
+
+	issuer := states[0]
+	if !issuer.GlobalFreeze {
+		t.Error("GlobalFreeze = false, want true")
+	}
+	if !issuer.AllowClawback {
+		t.Error("AllowClawback = false, want true")
+	}
+	if issuer.NoFreeze {
+		t.Error("NoFreeze = true, want false")
+	}
+	if issuer.TransferRate != 1002000000 {
+		t.Errorf("TransferRate = %d, want 1002000000", issuer.TransferRate)
+	}
+	if issuer.LedgerIndex != 12345 {
+		t.Errorf("LedgerIndex = %d, want 12345", issuer.LedgerIndex)
+	}
+
+	if states[1].Account != "rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz" || states[1].Flags != 0 {
+		t.Errorf("unexpected created account state: %+v", states[1])
+	}
+}
+
+func TestIssuerParser_NoMeta(t *testing.T) {
+	parser := NewIssuerParser()
+
+	states := parser.ParseTransaction(map[string]interface{}{"TransactionType": "Payment"}, 1)
+	if states != nil {
+		t.Errorf("ParseTransaction() = %v, want nil", states)
+	}
+}
+
+func TestAssetIssuer(t *testing.T) {
+	tests := []struct {
+		asset string
+		want  string
+	}{
+		{"XRP", ""},
+		{"USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B", "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"},
+		{"USD.", ""},
+	}
+
+	for _, tt := range tests {
+		if got := AssetIssuer(tt.asset); got != tt.want {
+			t.Errorf("AssetIssuer(%q) = %q, want %q", tt.asset, got, tt.want)
+		}
+	}
+}
@@ -32,7 +32,7 @@ func TestOrderbookParser_ParseTransaction(t *testing.T) {
 				"TakerPays":       "1000000", // XRP
 				"TakerGets": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rN7n7",
+					"issuer":   "rN7n7otQDd6FczFgLdSqtcsAUxDkw6fzRH",
 					"value":    "500",
 				},
 			},
@@ -268,7 +268,7 @@ func TestOrderbookParser_parseOfferCreate(t *testing.T) {
 				"Sequence": float64(100),
 				"TakerPays": map[string]interface{}{
 					"currency": "USD",
-					"issuer":   "rUSD",
+					"issuer":   "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
 					"value":    "100",
 				},
 				"TakerGets": "1000000",
@@ -336,7 +336,7 @@ func BenchmarkOrderbookParser_ParseTransaction(b *testing.B) {
 		"TakerPays":       "1000000",
 		"TakerGets": map[string]interface{}{
 			"currency": "USD",
-			"issuer":   "rUSD",
+			"issuer":   "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
 			"value":    "500",
 		},
 	}
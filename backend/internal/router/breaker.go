@@ -1,6 +1,9 @@
 package router
 
 import (
+	"math"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
@@ -12,34 +15,105 @@ const (
 	StateOpen     = "open"
 	StateHalfOpen = "half-open"
 
-	DefaultThreshold      = 0.05
-	DefaultMaxPrices      = 100
-	DefaultFailureLimit   = 5
+	// DefaultThreshold is the maximum deviation from the reference price,
+	// expressed in standard deviations of realized volatility.
+	DefaultThreshold       = 4.0
+	DefaultMinVolatility   = 0.01
+	DefaultBucketWidth     = 4 * time.Second // ~one XRPL ledger
+	DefaultWindow          = 10 * time.Minute
+	DefaultMaxBuckets      = 150
+	DefaultEWMAAlpha       = 0.3
+	DefaultVarianceDecay   = 0.94
+	DefaultFailureLimit    = 5
+	DefaultOpenDuration    = 30 * time.Second
 	DefaultCautionDuration = 60 * time.Second
 )
 
+// BreakerConfig tunes the volatility model behind CircuitBreaker.
+type BreakerConfig struct {
+	Threshold     float64       // trip above this many standard deviations
+	MinVolatility float64       // volatility floor per bucket (log-return)
+	BucketWidth   time.Duration // observations within a bucket collapse to one close
+	Window        time.Duration // buckets older than this are dropped
+	EWMAAlpha     float64       // smoothing for the EWMA price
+	VarianceDecay float64       // lambda for the EWMA variance of returns
+}
+
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		Threshold:     DefaultThreshold,
+		MinVolatility: DefaultMinVolatility,
+		BucketWidth:   DefaultBucketWidth,
+		Window:        DefaultWindow,
+		EWMAAlpha:     DefaultEWMAAlpha,
+		VarianceDecay: DefaultVarianceDecay,
+	}
+}
+
 type CircuitBreaker struct {
 	mu              sync.RWMutex
 	states          map[string]*breakerState
-	threshold       float64
+	cfg             BreakerConfig
 	cautionMode     bool
 	cautionUntil    time.Time
+	now             func() time.Time
 	persistCallback func(pair string, state *breakerState)
 }
 
 type breakerState struct {
-	pair         string
-	recentPrices []decimal.Decimal
-	lastTradeTs  time.Time
-	state        string
-	failures     int
-	openedAt     time.Time
+	pair        string
+	buckets     []priceBucket
+	lastTradeTs time.Time
+	state       string
+	failures    int
+	openedAt    time.Time
+}
+
+type priceBucket struct {
+	start time.Time
+	close float64
+	count int
+}
+
+// BreakerStats summarizes the price series of a pair.
+type BreakerStats struct {
+	Buckets    int
+	Median     decimal.Decimal
+	EWMA       decimal.Decimal
+	Volatility float64
 }
 
 func NewCircuitBreaker(threshold float64) *CircuitBreaker {
+	cfg := DefaultBreakerConfig()
+	cfg.Threshold = threshold
+	return NewCircuitBreakerWithConfig(cfg)
+}
+
+func NewCircuitBreakerWithConfig(cfg BreakerConfig) *CircuitBreaker {
+	defaults := DefaultBreakerConfig()
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = defaults.Threshold
+	}
+	if cfg.MinVolatility <= 0 {
+		cfg.MinVolatility = defaults.MinVolatility
+	}
+	if cfg.BucketWidth <= 0 {
+		cfg.BucketWidth = defaults.BucketWidth
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaults.Window
+	}
+	if cfg.EWMAAlpha <= 0 || cfg.EWMAAlpha > 1 {
+		cfg.EWMAAlpha = defaults.EWMAAlpha
+	}
+	if cfg.VarianceDecay <= 0 || cfg.VarianceDecay >= 1 {
+		cfg.VarianceDecay = defaults.VarianceDecay
+	}
+
 	cb := &CircuitBreaker{
-		states:    make(map[string]*breakerState),
-		threshold: threshold,
+		states: make(map[string]*breakerState),
+		cfg:    cfg,
+		now:    time.Now,
 	}
 	cb.EnableCautionMode(DefaultCautionDuration)
 	return cb
@@ -50,7 +124,7 @@ func (cb *CircuitBreaker) EnableCautionMode(duration time.Duration) {
 	defer cb.mu.Unlock()
 
 	cb.cautionMode = true
-	cb.cautionUntil = time.Now().Add(duration)
+	cb.cautionUntil = cb.now().Add(duration)
 
 	time.AfterFunc(duration, func() {
 		cb.mu.Lock()
@@ -67,10 +141,11 @@ func (cb *CircuitBreaker) CheckPrice(pair string, price decimal.Decimal) error {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
+	now := cb.now()
 	state := cb.getOrCreateState(pair)
 
 	if state.state == StateOpen {
-		if time.Since(state.openedAt) > 30*time.Second {
+		if now.Sub(state.openedAt) > DefaultOpenDuration {
 			state.state = StateHalfOpen
 			state.failures = 0
 		} else {
@@ -78,24 +153,32 @@ func (cb *CircuitBreaker) CheckPrice(pair string, price decimal.Decimal) error {
 		}
 	}
 
-	threshold := cb.threshold
-	if cb.cautionMode && time.Now().Before(cb.cautionUntil) {
-		threshold = threshold * 0.5
+	if !price.IsPositive() {
+		return ErrInvalidAmount
 	}
 
-	if len(state.recentPrices) == 0 {
-		cb.recordPrice(state, price)
+	cb.prune(state, now)
+
+	if len(state.buckets) == 0 {
+		cb.recordPrice(state, price, now)
 		return nil
 	}
 
-	avgPrice := cb.calculateAverage(state.recentPrices)
-	deviation := price.Sub(avgPrice).Div(avgPrice).Abs()
+	threshold := cb.cfg.Threshold
+	if cb.cautionMode && cb.now().Before(cb.cautionUntil) {
+		threshold = threshold * 0.5
+	}
+
+	stats := cb.computeStats(state)
+	sigma := math.Max(stats.Volatility, cb.cfg.MinVolatility)
+	reference := stats.Median.InexactFloat64()
+	deviation := math.Abs(math.Log(price.InexactFloat64()/reference)) / sigma
 
-	if deviation.GreaterThan(decimal.NewFromFloat(threshold)) {
+	if deviation > threshold {
 		state.failures++
 		if state.failures >= DefaultFailureLimit {
 			state.state = StateOpen
-			state.openedAt = time.Now()
+			state.openedAt = now
 		}
 		return ErrCircuitBreakerOpen
 	}
@@ -105,17 +188,49 @@ func (cb *CircuitBreaker) CheckPrice(pair string, price decimal.Decimal) error {
 		state.failures = 0
 	}
 
-	cb.recordPrice(state, price)
+	cb.recordPrice(state, price, now)
 	return nil
 }
 
 func (cb *CircuitBreaker) RecordTrade(pair string, price decimal.Decimal) {
+	cb.RecordTradeAt(pair, price, cb.now())
+}
+
+// RecordTradeAt feeds an executed trade into the pair's price series at the
+// time it executed, which may lag the wall clock for indexed trades.
+func (cb *CircuitBreaker) RecordTradeAt(pair string, price decimal.Decimal, executedAt time.Time) {
+	if !price.IsPositive() {
+		return
+	}
+
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
 	state := cb.getOrCreateState(pair)
-	cb.recordPrice(state, price)
-	state.lastTradeTs = time.Now()
+	cb.recordPrice(state, price, executedAt)
+	if executedAt.After(state.lastTradeTs) {
+		state.lastTradeTs = executedAt
+	}
+}
+
+// RecordExecution records an on-ledger execution using the indexer's asset
+// and amount encoding (XRP amounts in drops). The price is out per unit in,
+// matching the prices checked by the quote engine.
+func (cb *CircuitBreaker) RecordExecution(inAsset, outAsset, amountIn, amountOut string, executedAt time.Time) error {
+	in, err := executionAmount(inAsset, amountIn)
+	if err != nil {
+		return err
+	}
+	out, err := executionAmount(outAsset, amountOut)
+	if err != nil {
+		return err
+	}
+	if !in.IsPositive() || !out.IsPositive() {
+		return ErrInvalidAmount
+	}
+
+	cb.RecordTradeAt(inAsset+"-"+outAsset, out.Div(in), executedAt)
+	return nil
 }
 
 func (cb *CircuitBreaker) GetState(pair string) string {
@@ -129,23 +244,51 @@ func (cb *CircuitBreaker) GetState(pair string) string {
 	return state.state
 }
 
+func (cb *CircuitBreaker) Stats(pair string) BreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	state, ok := cb.states[pair]
+	if !ok {
+		return BreakerStats{}
+	}
+	cb.prune(state, cb.now())
+	return cb.computeStats(state)
+}
+
 func (cb *CircuitBreaker) getOrCreateState(pair string) *breakerState {
 	state, ok := cb.states[pair]
 	if !ok {
 		state = &breakerState{
-			pair:         pair,
-			recentPrices: make([]decimal.Decimal, 0, DefaultMaxPrices),
-			state:        StateClosed,
+			pair:    pair,
+			buckets: make([]priceBucket, 0, DefaultMaxBuckets),
+			state:   StateClosed,
 		}
 		cb.states[pair] = state
 	}
 	return state
 }
 
-func (cb *CircuitBreaker) recordPrice(state *breakerState, price decimal.Decimal) {
-	state.recentPrices = append(state.recentPrices, price)
-	if len(state.recentPrices) > DefaultMaxPrices {
-		state.recentPrices = state.recentPrices[1:]
+func (cb *CircuitBreaker) recordPrice(state *breakerState, price decimal.Decimal, at time.Time) {
+	start := at.Truncate(cb.cfg.BucketWidth)
+	value := price.InexactFloat64()
+
+	i := sort.Search(len(state.buckets), func(i int) bool {
+		return !state.buckets[i].start.Before(start)
+	})
+
+	switch {
+	case i < len(state.buckets) && state.buckets[i].start.Equal(start):
+		state.buckets[i].close = value
+		state.buckets[i].count++
+	default:
+		state.buckets = append(state.buckets, priceBucket{})
+		copy(state.buckets[i+1:], state.buckets[i:])
+		state.buckets[i] = priceBucket{start: start, close: value, count: 1}
+	}
+
+	if len(state.buckets) > DefaultMaxBuckets {
+		state.buckets = state.buckets[len(state.buckets)-DefaultMaxBuckets:]
 	}
 
 	if cb.persistCallback != nil {
@@ -153,15 +296,70 @@ func (cb *CircuitBreaker) recordPrice(state *breakerState, price decimal.Decimal
 	}
 }
 
-func (cb *CircuitBreaker) calculateAverage(prices []decimal.Decimal) decimal.Decimal {
-	if len(prices) == 0 {
-		return decimal.Zero
+func (cb *CircuitBreaker) prune(state *breakerState, now time.Time) {
+	cutoff := now.Add(-cb.cfg.Window)
+	i := 0
+	for i < len(state.buckets) && state.buckets[i].start.Before(cutoff) {
+		i++
 	}
+	if i > 0 {
+		state.buckets = append(state.buckets[:0], state.buckets[i:]...)
+	}
+}
 
-	sum := decimal.Zero
-	for _, p := range prices {
-		sum = sum.Add(p)
+func (cb *CircuitBreaker) computeStats(state *breakerState) BreakerStats {
+	n := len(state.buckets)
+	if n == 0 {
+		return BreakerStats{}
 	}
 
-	return sum.Div(decimal.NewFromInt(int64(len(prices))))
+	closes := make([]float64, n)
+	ewma := state.buckets[0].close
+	variance := 0.0
+	returns := 0
+
+	for i, b := range state.buckets {
+		closes[i] = b.close
+		if i == 0 {
+			continue
+		}
+		ewma = cb.cfg.EWMAAlpha*b.close + (1-cb.cfg.EWMAAlpha)*ewma
+
+		r := math.Log(b.close / state.buckets[i-1].close)
+		if returns == 0 {
+			variance = r * r
+		} else {
+			variance = cb.cfg.VarianceDecay*variance + (1-cb.cfg.VarianceDecay)*r*r
+		}
+		returns++
+	}
+
+	return BreakerStats{
+		Buckets:    n,
+		Median:     decimal.NewFromFloat(median(closes)),
+		EWMA:       decimal.NewFromFloat(ewma),
+		Volatility: math.Sqrt(variance),
+	}
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func executionAmount(asset, amount string) (decimal.Decimal, error) {
+	value, err := decimal.NewFromString(strings.TrimSpace(amount))
+	if err != nil {
+		return decimal.Zero, ErrInvalidAmount
+	}
+	if asset == "XRP" {
+		value = value.Shift(-6)
+	}
+	return value, nil
 }
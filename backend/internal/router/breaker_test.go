@@ -31,7 +31,7 @@ func TestCircuitBreaker_NormalOperation(t *testing.T) {
 }
 
 func TestCircuitBreaker_PriceDeviation(t *testing.T) {
-	cb := NewCircuitBreaker(0.05)
+	cb := NewCircuitBreaker(DefaultThreshold)
 	cb.mu.Lock()
 	cb.cautionMode = false
 	cb.mu.Unlock()
@@ -56,7 +56,7 @@ func TestCircuitBreaker_PriceDeviation(t *testing.T) {
 }
 
 func TestCircuitBreaker_FailureLimit(t *testing.T) {
-	cb := NewCircuitBreaker(0.05)
+	cb := NewCircuitBreaker(DefaultThreshold)
 	cb.mu.Lock()
 	cb.cautionMode = false
 	cb.mu.Unlock()
@@ -91,9 +91,11 @@ func TestCircuitBreaker_FailureLimit(t *testing.T) {
 }
 
 func TestCircuitBreaker_Recovery(t *testing.T) {
-	cb := NewCircuitBreaker(0.05)
+	cb := NewCircuitBreaker(DefaultThreshold)
+	clock := time.Now()
 	cb.mu.Lock()
 	cb.cautionMode = false
+	cb.now = func() time.Time { return clock }
 	cb.mu.Unlock()
 
 	pair := "XRP-USD"
@@ -112,7 +114,7 @@ func TestCircuitBreaker_Recovery(t *testing.T) {
 		t.Fatal("Circuit breaker should be open")
 	}
 
-	time.Sleep(31 * time.Second)
+	clock = clock.Add(DefaultOpenDuration + time.Second)
 
 	err := cb.CheckPrice(pair, basePrice)
 	if err != nil {
@@ -125,7 +127,7 @@ func TestCircuitBreaker_Recovery(t *testing.T) {
 }
 
 func TestCircuitBreaker_CautionMode(t *testing.T) {
-	cb := NewCircuitBreaker(0.10)
+	cb := NewCircuitBreaker(DefaultThreshold)
 	cb.EnableCautionMode(500 * time.Millisecond)
 
 	pair := "XRP-USD"
@@ -135,18 +137,18 @@ func TestCircuitBreaker_CautionMode(t *testing.T) {
 		cb.RecordTrade(pair, basePrice)
 	}
 
-	moderatePrice := decimal.NewFromFloat(1.07)
+	moderatePrice := decimal.NewFromFloat(1.03)
 
 	err := cb.CheckPrice(pair, moderatePrice)
 	if err != ErrCircuitBreakerOpen {
-		t.Errorf("Caution mode should reject 7%% deviation with 10%% threshold (5%% in caution)")
+		t.Errorf("Caution mode should reject ~3 sigma deviation with 4 sigma threshold (2 in caution)")
 	}
 
 	time.Sleep(600 * time.Millisecond)
 
 	err = cb.CheckPrice(pair, moderatePrice)
 	if err != nil {
-		t.Errorf("After caution mode, 7%% deviation should be accepted with 10%% threshold")
+		t.Errorf("After caution mode, ~3 sigma deviation should be accepted with 4 sigma threshold")
 	}
 }
 
@@ -210,3 +212,118 @@ func TestCircuitBreaker_ConcurrentAccess(t *testing.T) {
 		<-done
 	}
 }
+
+func TestCircuitBreaker_BurstCollapsesIntoOneBucket(t *testing.T) {
+	cb := NewCircuitBreaker(DefaultThreshold)
+	clock := time.Now()
+	cb.now = func() time.Time { return clock }
+
+	pair := "XRP-USD"
+	for i := 0; i < 500; i++ {
+		cb.RecordTrade(pair, decimal.NewFromFloat(1.0))
+	}
+
+	stats := cb.Stats(pair)
+	if stats.Buckets != 1 {
+		t.Errorf("Buckets = %d, want 1", stats.Buckets)
+	}
+
+	clock = clock.Add(DefaultBucketWidth)
+	cb.RecordTrade(pair, decimal.NewFromFloat(1.01))
+
+	if got := cb.Stats(pair).Buckets; got != 2 {
+		t.Errorf("Buckets after next ledger = %d, want 2", got)
+	}
+}
+
+func TestCircuitBreaker_ThresholdScalesWithVolatility(t *testing.T) {
+	start := time.Now()
+
+	calm := NewCircuitBreaker(DefaultThreshold)
+	calm.cautionMode = false
+	volatile := NewCircuitBreaker(DefaultThreshold)
+	volatile.cautionMode = false
+
+	pair := "XRP-USD"
+	for i := 0; i < 40; i++ {
+		at := start.Add(time.Duration(i) * DefaultBucketWidth)
+		calm.RecordTradeAt(pair, decimal.NewFromFloat(1.0), at)
+
+		swing := 1.0
+		if i%2 == 0 {
+			swing = 1.05
+		}
+		volatile.RecordTradeAt(pair, decimal.NewFromFloat(swing), at)
+	}
+
+	now := start.Add(40 * DefaultBucketWidth)
+	calm.now = func() time.Time { return now }
+	volatile.now = func() time.Time { return now }
+
+	if v := calm.Stats(pair).Volatility; v != 0 {
+		t.Errorf("Calm volatility = %v, want 0", v)
+	}
+	if v := volatile.Stats(pair).Volatility; v < 0.04 {
+		t.Errorf("Volatile volatility = %v, want >= 0.04", v)
+	}
+
+	move := decimal.NewFromFloat(1.12)
+	if err := calm.CheckPrice(pair, move); err != ErrCircuitBreakerOpen {
+		t.Errorf("Calm pair CheckPrice() error = %v, want %v", err, ErrCircuitBreakerOpen)
+	}
+	if err := volatile.CheckPrice(pair, move); err != nil {
+		t.Errorf("Volatile pair CheckPrice() error = %v, want nil", err)
+	}
+}
+
+func TestCircuitBreaker_WindowExpiry(t *testing.T) {
+	cb := NewCircuitBreaker(DefaultThreshold)
+	cb.cautionMode = false
+	clock := time.Now()
+	cb.now = func() time.Time { return clock }
+
+	pair := "XRP-USD"
+	cb.RecordTrade(pair, decimal.NewFromFloat(1.0))
+
+	clock = clock.Add(DefaultWindow + DefaultBucketWidth)
+
+	if err := cb.CheckPrice(pair, decimal.NewFromFloat(2.0)); err != nil {
+		t.Errorf("CheckPrice() after window expiry error = %v, want nil", err)
+	}
+	if got := cb.Stats(pair).Buckets; got != 1 {
+		t.Errorf("Buckets = %d, want 1", got)
+	}
+}
+
+func TestCircuitBreaker_RecordExecution(t *testing.T) {
+	cb := NewCircuitBreaker(DefaultThreshold)
+	pair := "XRP-USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"
+
+	err := cb.RecordExecution("XRP", "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B", "2000000", "3", time.Now())
+	if err != nil {
+		t.Fatalf("RecordExecution() error = %v", err)
+	}
+
+	stats := cb.Stats(pair)
+	if !stats.Median.Equal(decimal.NewFromFloat(1.5)) {
+		t.Errorf("Median = %s, want 1.5", stats.Median)
+	}
+
+	if err := cb.RecordExecution("XRP", "USD.r1", "abc", "3", time.Now()); err != ErrInvalidAmount {
+		t.Errorf("RecordExecution() invalid amount error = %v, want %v", err, ErrInvalidAmount)
+	}
+}
+
+func TestCircuitBreaker_CautionModeUsesClock(t *testing.T) {
+	cb := NewCircuitBreaker(DefaultThreshold)
+	clock := time.Now()
+	cb.now = func() time.Time { return clock }
+	cb.EnableCautionMode(time.Hour)
+
+	cb.mu.RLock()
+	until := cb.cautionUntil
+	cb.mu.RUnlock()
+	if want := clock.Add(time.Hour); !until.Equal(want) {
+		t.Errorf("cautionUntil = %v, want %v", until, want)
+	}
+}
@@ -3,12 +3,14 @@ package router
 import "errors"
 
 var (
-	ErrInvalidAmount     = errors.New("invalid amount")
-	ErrAmountTooLarge    = errors.New("amount too large")
-	ErrInvalidAsset      = errors.New("invalid asset format")
-	ErrSameAssets        = errors.New("input and output assets cannot be the same")
-	ErrInvalidAddress    = errors.New("invalid XRPL address")
-	ErrNoRoute           = errors.New("no route found")
+	ErrInvalidAmount         = errors.New("invalid amount")
+	ErrAmountTooLarge        = errors.New("amount too large")
+	ErrInvalidAsset          = errors.New("invalid asset format")
+	ErrSameAssets            = errors.New("input and output assets cannot be the same")
+	ErrInvalidAddress        = errors.New("invalid XRPL address")
+	ErrNoRoute               = errors.New("no route found")
 	ErrInsufficientLiquidity = errors.New("insufficient liquidity")
-	ErrCircuitBreakerOpen = errors.New("circuit breaker open")
+	ErrCircuitBreakerOpen    = errors.New("circuit breaker open")
+	ErrAssetFrozen           = errors.New("asset issuer has global freeze enabled")
+	ErrAssetExcluded         = errors.New("asset excluded by policy")
 )
@@ -8,26 +8,28 @@ import (
 )
 
 type hashInput struct {
-	In          string  `json:"in"`
-	Out         string  `json:"out"`
-	Amount      string  `json:"amount"`
-	RouterBps   int     `json:"router_bps"`
-	TradingFees string  `json:"trading_fees"`
-	EstOutFee   string  `json:"est_out_fee"`
-	LedgerIndex uint32  `json:"ledger_index"`
-	TTL         uint16  `json:"ttl"`
+	In           string `json:"in"`
+	Out          string `json:"out"`
+	Amount       string `json:"amount"`
+	RouterBps    int    `json:"router_bps"`
+	TradingFees  string `json:"trading_fees"`
+	TransferFees string `json:"transfer_fees"`
+	EstOutFee    string `json:"est_out_fee"`
+	LedgerIndex  uint32 `json:"ledger_index"`
+	TTL          uint16 `json:"ttl"`
 }
 
 func ComputeQuoteHash(req *QuoteRequest, fees Fees, ledgerIndex uint32, ttl uint16) ([32]byte, error) {
 	input := hashInput{
-		In:          req.In.String(),
-		Out:         req.Out.String(),
-		Amount:      req.Amount.String(),
-		RouterBps:   fees.RouterBps,
-		TradingFees: fees.TradingFees.String(),
-		EstOutFee:   fees.EstOutFee.String(),
-		LedgerIndex: ledgerIndex,
-		TTL:         ttl,
+		In:           req.In.String(),
+		Out:          req.Out.String(),
+		Amount:       req.Amount.String(),
+		RouterBps:    fees.RouterBps,
+		TradingFees:  fees.TradingFees.String(),
+		TransferFees: fees.TransferFees.String(),
+		EstOutFee:    fees.EstOutFee.String(),
+		LedgerIndex:  ledgerIndex,
+		TTL:          ttl,
 	}
 
 	canonical, err := canonicalJSON(input)
@@ -159,3 +159,35 @@ func TestComputeQuoteHash_AssetOrdering(t *testing.T) {
 		t.Error("Swapped assets produced same hash")
 	}
 }
+
+func TestComputeQuoteHash_HexCurrencyRoundTrip(t *testing.T) {
+	fees := Fees{RouterBps: 20}
+
+	var hashes [][32]byte
+	for _, out := range []string{
+		"534f4c4f00000000000000000000000000000000.rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
+		"534F4C4F00000000000000000000000000000000.rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
+	} {
+		asset, err := ParseAsset(out)
+		if err != nil {
+			t.Fatalf("ParseAsset(%q) error = %v", out, err)
+		}
+
+		// Round-trip through the string form stored in the DB
+		stored, err := ParseAsset(asset.String())
+		if err != nil || stored != asset {
+			t.Fatalf("ParseAsset(%q) = %v, %v, want %v", asset.String(), stored, err, asset)
+		}
+
+		req := &QuoteRequest{In: Asset{Currency: "XRP"}, Out: stored, Amount: decimal.NewFromInt(100)}
+		hash, err := ComputeQuoteHash(req, fees, 12345, 100)
+		if err != nil {
+			t.Fatalf("ComputeQuoteHash() error = %v", err)
+		}
+		hashes = append(hashes, hash)
+	}
+
+	if hashes[0] != hashes[1] {
+		t.Error("equivalent hex currency codes produced different hashes")
+	}
+}
@@ -45,6 +45,13 @@ var (
 		},
 	)
 
+	PolicyCacheErrors = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "lucendex_policy_cache_errors_total",
+			Help: "Asset policies that could not be written to the KV cache",
+		},
+	)
+
 	MemoryUsage = promauto.NewGauge(
 		prometheus.GaugeOpts{
 			Name: "lucendex_kv_memory_bytes",
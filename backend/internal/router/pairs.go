@@ -0,0 +1,322 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/lucendex/backend/internal/currency"
+)
+
+// DefaultPairsMaxAge bounds how long computed pairs are served while the
+// ledger index doesn't move, e.g. when the ledger feed stalls
+const DefaultPairsMaxAge = time.Minute
+
+// pairValuePlaces rounds reference-asset values, which go through inexact
+// division, to the precision of XRP
+const pairValuePlaces = 6
+
+// MarketSnapshot is the indexed state pairs are computed from, in the
+// indexer's encoding: assets as "XRP" or "CODE.ISSUER", XRP amounts in drops
+type MarketSnapshot struct {
+	Pools   []PoolReserves
+	Books   []BookSummary
+	Volumes []TradeVolume
+}
+
+// PoolReserves is one AMM pool
+type PoolReserves struct {
+	Asset1, Asset2     string
+	Reserve1, Reserve2 string
+	TradingFeeBps      int
+}
+
+// BookSummary aggregates the active offers giving Gives for Wants
+type BookSummary struct {
+	Gives, Wants string
+	BestPrice    string // lowest Wants per Gives
+	Depth        string // total Gives offered
+}
+
+// TradeVolume sums the last day's executions from InAsset to OutAsset
+type TradeVolume struct {
+	InAsset, OutAsset   string
+	AmountIn, AmountOut string
+}
+
+// MarketLoader reads the current MarketSnapshot, with volumes since the
+// given time.
+type MarketLoader func(ctx context.Context, since time.Time) (*MarketSnapshot, error)
+
+// PairStats computes TradingPairInfo from a MarketLoader and caches it per
+// ledger.
+type PairStats struct {
+	load      MarketLoader
+	reference Asset
+	maxAge    time.Duration
+
+	mu       sync.Mutex
+	pairs    []TradingPairInfo
+	ledger   uint32
+	loadedAt time.Time
+}
+
+// NewPairStats values liquidity and volume in reference, usually XRP
+func NewPairStats(load MarketLoader, reference Asset) *PairStats {
+	return &PairStats{
+		load:      load,
+		reference: reference,
+		maxAge:    DefaultPairsMaxAge,
+	}
+}
+
+// Reference is the asset liquidity and volume are valued in
+func (ps *PairStats) Reference() Asset {
+	return ps.reference
+}
+
+// Pairs returns the pairs as of ledgerIndex, recomputing them once per
+// ledger. The returned slice is shared and must not be modified.
+func (ps *PairStats) Pairs(ctx context.Context, ledgerIndex uint32) ([]TradingPairInfo, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if !ps.loadedAt.IsZero() && ps.ledger == ledgerIndex && time.Since(ps.loadedAt) < ps.maxAge {
+		return ps.pairs, nil
+	}
+
+	now := time.Now()
+	snap, err := ps.load(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load market data: %w", err)
+	}
+	pairs := computePairs(snap, ps.reference)
+
+	ps.pairs, ps.ledger, ps.loadedAt = pairs, ledgerIndex, now
+	return pairs, nil
+}
+
+// pairMarket is one unordered pair, oriented base/quote with prices in
+// quote per base and amounts in whole units
+type pairMarket struct {
+	base, quote Asset
+
+	poolBase, poolQuote decimal.Decimal
+	poolFee             decimal.Decimal // fraction
+	hasPool             bool
+
+	bookAsk, bookBid        decimal.Decimal // zero without offers on that side
+	bookBase, bookQuote     decimal.Decimal // offered amounts
+	volumeBase, volumeQuote decimal.Decimal
+}
+
+// spot is the pool price, or the book mid, or whichever book side exists
+func (m *pairMarket) spot() decimal.Decimal {
+	if m.hasPool {
+		return m.poolQuote.Div(m.poolBase)
+	}
+	switch {
+	case m.bookAsk.IsPositive() && m.bookBid.IsPositive():
+		return m.bookAsk.Add(m.bookBid).Div(decimal.NewFromInt(2))
+	case m.bookAsk.IsPositive():
+		return m.bookAsk
+	default:
+		return m.bookBid
+	}
+}
+
+// bestAsk and bestBid combine the book with the pool's fee-adjusted spot
+func (m *pairMarket) bestAsk() decimal.Decimal {
+	ask := m.bookAsk
+	if m.hasPool {
+		amm := m.spot().Div(decimal.NewFromInt(1).Sub(m.poolFee))
+		if ask.IsZero() || amm.LessThan(ask) {
+			ask = amm
+		}
+	}
+	return ask
+}
+
+func (m *pairMarket) bestBid() decimal.Decimal {
+	bid := m.bookBid
+	if m.hasPool {
+		amm := m.spot().Mul(decimal.NewFromInt(1).Sub(m.poolFee))
+		if amm.GreaterThan(bid) {
+			bid = amm
+		}
+	}
+	return bid
+}
+
+// computePairs builds one TradingPairInfo per pair with a pool, offers or
+// recent trades, sorted by liquidity, largest first. An asset is valued in
+// reference through its direct pair with reference, or failing that through
+// the other asset of the pair being valued; liquidity and volume that can't
+// be valued either way count as zero. Rows with an asset or amount that
+// doesn't parse are logged and skipped.
+func computePairs(snap *MarketSnapshot, reference Asset) []TradingPairInfo {
+	markets := make(map[string]*pairMarket)
+	market := func(a, b string) (*pairMarket, bool, error) {
+		base, quote := a, b
+		if pairOrderLess(b, a) {
+			base, quote = b, a
+		}
+		key := base + "/" + quote
+		if m, ok := markets[key]; ok {
+			return m, base == a, nil
+		}
+		baseAsset, err := ParseAsset(base)
+		if err != nil {
+			return nil, false, fmt.Errorf("asset %q: %w", base, err)
+		}
+		quoteAsset, err := ParseAsset(quote)
+		if err != nil {
+			return nil, false, fmt.Errorf("asset %q: %w", quote, err)
+		}
+		m := &pairMarket{base: baseAsset, quote: quoteAsset}
+		markets[key] = m
+		return m, base == a, nil
+	}
+
+	for _, p := range snap.Pools {
+		r1, err1 := executionAmount(p.Asset1, p.Reserve1)
+		r2, err2 := executionAmount(p.Asset2, p.Reserve2)
+		if err := errors.Join(err1, err2); err != nil {
+			log.Printf("Skipping pool %s/%s: %v", p.Asset1, p.Asset2, err)
+			continue
+		}
+		if !r1.IsPositive() || !r2.IsPositive() {
+			continue
+		}
+		m, forward, err := market(p.Asset1, p.Asset2)
+		if err != nil {
+			log.Printf("Skipping pool %s/%s: %v", p.Asset1, p.Asset2, err)
+			continue
+		}
+		if !forward {
+			r1, r2 = r2, r1
+		}
+		m.poolBase, m.poolQuote, m.hasPool = r1, r2, true
+		m.poolFee = decimal.NewFromInt(int64(p.TradingFeeBps)).Div(decimal.NewFromInt(10000))
+	}
+
+	for _, b := range snap.Books {
+		depth, err1 := executionAmount(b.Gives, b.Depth)
+		price, err2 := decimal.NewFromString(b.BestPrice)
+		if err := errors.Join(err1, err2); err != nil {
+			log.Printf("Skipping book %s/%s: %v", b.Gives, b.Wants, err)
+			continue
+		}
+		price = price.Shift(unitScale(b.Gives) - unitScale(b.Wants))
+		if !depth.IsPositive() || !price.IsPositive() {
+			continue
+		}
+		m, forward, err := market(b.Gives, b.Wants)
+		if err != nil {
+			log.Printf("Skipping book %s/%s: %v", b.Gives, b.Wants, err)
+			continue
+		}
+		if forward {
+			// Offers giving base are asks
+			m.bookAsk, m.bookBase = price, depth
+		} else {
+			m.bookBid, m.bookQuote = decimal.NewFromInt(1).Div(price), depth
+		}
+	}
+
+	for _, v := range snap.Volumes {
+		in, err1 := executionAmount(v.InAsset, v.AmountIn)
+		out, err2 := executionAmount(v.OutAsset, v.AmountOut)
+		if err := errors.Join(err1, err2); err != nil {
+			log.Printf("Skipping volume %s/%s: %v", v.InAsset, v.OutAsset, err)
+			continue
+		}
+		m, forward, err := market(v.InAsset, v.OutAsset)
+		if err != nil {
+			log.Printf("Skipping volume %s/%s: %v", v.InAsset, v.OutAsset, err)
+			continue
+		}
+		if !forward {
+			in, out = out, in
+		}
+		m.volumeBase = m.volumeBase.Add(in)
+		m.volumeQuote = m.volumeQuote.Add(out)
+	}
+
+	// Direct reference prices, in reference per unit
+	prices := map[string]decimal.Decimal{reference.String(): decimal.NewFromInt(1)}
+	for _, m := range markets {
+		spot := m.spot()
+		if !spot.IsPositive() {
+			continue
+		}
+		switch reference {
+		case m.quote:
+			prices[m.base.String()] = spot
+		case m.base:
+			prices[m.quote.String()] = decimal.NewFromInt(1).Div(spot)
+		}
+	}
+
+	pairs := make([]TradingPairInfo, 0, len(markets))
+	for _, m := range markets {
+		pb, baseOK := prices[m.base.String()]
+		pq, quoteOK := prices[m.quote.String()]
+		if spot := m.spot(); spot.IsPositive() {
+			if baseOK && !quoteOK {
+				pq, quoteOK = pb.Div(spot), true
+			} else if quoteOK && !baseOK {
+				pb, baseOK = pq.Mul(spot), true
+			}
+		}
+
+		info := TradingPairInfo{
+			In:      m.base,
+			Out:     m.quote,
+			BestBid: m.bestBid(),
+			BestAsk: m.bestAsk(),
+		}
+		if baseOK && quoteOK {
+			info.Liquidity = m.poolBase.Add(m.bookBase).Mul(pb).
+				Add(m.poolQuote.Add(m.bookQuote).Mul(pq)).Round(pairValuePlaces)
+			info.DailyVolume = m.volumeBase.Mul(pb).Round(pairValuePlaces)
+		}
+		if info.BestBid.IsPositive() && info.BestAsk.IsPositive() {
+			mid := info.BestAsk.Add(info.BestBid).Div(decimal.NewFromInt(2))
+			info.AvgSpread = info.BestAsk.Sub(info.BestBid).Div(mid)
+		}
+		pairs = append(pairs, info)
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if !pairs[i].Liquidity.Equal(pairs[j].Liquidity) {
+			return pairs[i].Liquidity.GreaterThan(pairs[j].Liquidity)
+		}
+		return pairs[i].In.String()+"/"+pairs[i].Out.String() < pairs[j].In.String()+"/"+pairs[j].Out.String()
+	})
+	return pairs
+}
+
+// pairOrderLess orients pairs: XRP is always the base, other assets sort
+// by their string form
+func pairOrderLess(a, b string) bool {
+	if a == string(currency.XRP) || b == string(currency.XRP) {
+		return a == string(currency.XRP)
+	}
+	return a < b
+}
+
+// unitScale is the decimal exponent between the indexer's amounts and
+// whole units
+func unitScale(asset string) int32 {
+	if asset == string(currency.XRP) {
+		return 6
+	}
+	return 0
+}
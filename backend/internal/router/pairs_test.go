@@ -0,0 +1,120 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	testUSD = "USD.rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"
+	testEUR = "EUR.rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq"
+)
+
+func testMarket() *MarketSnapshot {
+	return &MarketSnapshot{
+		Pools: []PoolReserves{
+			// Stored with the quote first: orientation must not depend on it
+			{Asset1: testUSD, Asset2: "XRP", Reserve1: "15000", Reserve2: "10000000000", TradingFeeBps: 30},
+			{Asset1: testUSD, Asset2: testEUR, Reserve1: "1000", Reserve2: "900", TradingFeeBps: 0},
+			{Asset1: "ABC.rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq", Asset2: "DEF.rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq", Reserve1: "5", Reserve2: "5"},
+		},
+		Books: []BookSummary{
+			{Gives: "XRP", Wants: testUSD, BestPrice: "0.0000016", Depth: "1000000000"}, // ask 1.6 for 1000 XRP
+			{Gives: testUSD, Wants: "XRP", BestPrice: "700000", Depth: "300"},           // bid 1/0.7 for 300 USD
+		},
+		Volumes: []TradeVolume{
+			{InAsset: "XRP", OutAsset: testUSD, AmountIn: "100000000", AmountOut: "150"},
+			{InAsset: testUSD, OutAsset: "XRP", AmountIn: "30", AmountOut: "20000000"},
+		},
+	}
+}
+
+func TestComputePairs(t *testing.T) {
+	pairs := computePairs(testMarket(), Asset{Currency: "XRP"})
+	if len(pairs) != 3 {
+		t.Fatalf("got %d pairs, want 3", len(pairs))
+	}
+
+	xrp := pairs[0]
+	if xrp.In.String() != "XRP" || xrp.Out.String() != testUSD {
+		t.Fatalf("first pair = %s/%s, want XRP/USD by liquidity", xrp.In, xrp.Out)
+	}
+	// (10000 + 1000) XRP plus (15000 + 300) USD at 1.5
+	if !xrp.Liquidity.Equal(decimal.NewFromInt(21200)) {
+		t.Errorf("liquidity = %s, want 21200", xrp.Liquidity)
+	}
+	if !xrp.DailyVolume.Equal(decimal.NewFromInt(120)) {
+		t.Errorf("volume = %s, want 120 XRP across both directions", xrp.DailyVolume)
+	}
+	// The pool beats both book sides once its fee is applied
+	if !xrp.BestBid.Equal(decimal.RequireFromString("1.4955")) {
+		t.Errorf("best bid = %s, want 1.4955", xrp.BestBid)
+	}
+	if got := xrp.BestAsk.InexactFloat64(); math.Abs(got-1.5/0.997) > 1e-9 {
+		t.Errorf("best ask = %f, want %f", got, 1.5/0.997)
+	}
+	if got := xrp.AvgSpread.InexactFloat64(); math.Abs(got-0.006) > 1e-4 {
+		t.Errorf("spread = %f, want about 0.006", got)
+	}
+
+	// EUR has no XRP market and is valued through USD
+	eur := pairs[1]
+	if eur.In.String() != testEUR || eur.Out.String() != testUSD {
+		t.Fatalf("second pair = %s/%s, want EUR/USD", eur.In, eur.Out)
+	}
+	if got := eur.Liquidity.InexactFloat64(); math.Abs(got-2000/1.5) > 1e-6 {
+		t.Errorf("EUR/USD liquidity = %f, want %f", got, 2000/1.5)
+	}
+
+	if !pairs[2].Liquidity.IsZero() {
+		t.Errorf("unvalued pair liquidity = %s, want 0", pairs[2].Liquidity)
+	}
+}
+
+func TestComputePairs_SkipsUnparsableRows(t *testing.T) {
+	snap := testMarket()
+	snap.Pools = append(snap.Pools, PoolReserves{Asset1: "not-an-asset", Asset2: "XRP", Reserve1: "1", Reserve2: "1000000"})
+	snap.Books = append(snap.Books, BookSummary{Gives: "XRP", Wants: testEUR, BestPrice: "bad", Depth: "1000000"})
+	snap.Volumes = append(snap.Volumes, TradeVolume{InAsset: "XRP", OutAsset: "???", AmountIn: "1000000", AmountOut: "1"})
+
+	pairs := computePairs(snap, Asset{Currency: "XRP"})
+	if len(pairs) != 3 {
+		t.Fatalf("got %d pairs, want the 3 valid ones", len(pairs))
+	}
+	if !pairs[0].Liquidity.Equal(decimal.NewFromInt(21200)) {
+		t.Errorf("XRP/USD liquidity = %s, want 21200", pairs[0].Liquidity)
+	}
+}
+
+func TestPairStats_CachesPerLedger(t *testing.T) {
+	loads := 0
+	var fail error
+	ps := NewPairStats(func(ctx context.Context, since time.Time) (*MarketSnapshot, error) {
+		loads++
+		if time.Since(since) < 23*time.Hour {
+			t.Errorf("volumes since %v, want the last day", since)
+		}
+		return testMarket(), fail
+	}, Asset{Currency: "XRP"})
+	ctx := context.Background()
+
+	for _, ledger := range []uint32{100, 100, 101, 101} {
+		if _, err := ps.Pairs(ctx, ledger); err != nil {
+			t.Fatalf("Pairs(%d) error = %v", ledger, err)
+		}
+	}
+	if loads != 2 {
+		t.Errorf("loads = %d, want one per ledger", loads)
+	}
+
+	ps.maxAge = 0
+	fail = errors.New("db down")
+	if _, err := ps.Pairs(ctx, 101); err == nil {
+		t.Error("Pairs() with a stale cache and failing loader: want error")
+	}
+}
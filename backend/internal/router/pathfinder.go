@@ -2,30 +2,115 @@ package router
 
 import (
 	"container/heap"
+	"context"
+	"log"
 	"math"
+	"sync"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
 
 const MaxHops = 3
 
+// DefaultIssuerRefreshInterval is how often RefreshIssuers reloads issuer
+// state, a handful of ledgers
+const DefaultIssuerRefreshInterval = 30 * time.Second
+
+// IssuerLoader reads the current state of every synced issuer
+type IssuerLoader func(ctx context.Context) ([]IssuerInfo, error)
+
 type Pathfinder struct {
 	pools  []AMMPool
 	offers []Offer
+
+	mu      sync.RWMutex
+	issuers map[string]IssuerInfo
 }
 
 func NewPathfinder(pools []AMMPool, offers []Offer) *Pathfinder {
-	return &Pathfinder{
+	return NewPathfinderWithIssuers(pools, offers, nil)
+}
+
+func NewPathfinderWithIssuers(pools []AMMPool, offers []Offer, issuers []IssuerInfo) *Pathfinder {
+	pf := &Pathfinder{
 		pools:  pools,
 		offers: offers,
 	}
+	pf.SetIssuers(issuers)
+	return pf
+}
+
+// SetIssuers replaces the issuer state routes are checked against
+func (pf *Pathfinder) SetIssuers(issuers []IssuerInfo) {
+	byAccount := make(map[string]IssuerInfo, len(issuers))
+	for _, info := range issuers {
+		byAccount[info.Account] = info
+	}
+
+	pf.mu.Lock()
+	pf.issuers = byAccount
+	pf.mu.Unlock()
+}
+
+// RefreshIssuers reloads issuer state every interval until ctx is done, so
+// freezes and transfer fee changes reach routing without a restart. A
+// failed load keeps the previous state.
+func (pf *Pathfinder) RefreshIssuers(ctx context.Context, load IssuerLoader, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			loadCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			issuers, err := load(loadCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Issuer refresh failed: %v", err)
+				continue
+			}
+			pf.SetIssuers(issuers)
+		}
+	}
+}
+
+func (pf *Pathfinder) issuer(account string) (IssuerInfo, bool) {
+	pf.mu.RLock()
+	defer pf.mu.RUnlock()
+	info, ok := pf.issuers[account]
+	return info, ok
 }
 
 func (pf *Pathfinder) FindBestRoute(in, out Asset, amount decimal.Decimal) (*Route, error) {
-	graph := pf.buildGraph()
-	
+	return pf.FindBestRouteWithPolicy(in, out, amount, nil)
+}
+
+// FindBestRouteWithPolicy finds the best route that only touches assets the
+// policy allows. If policy is the only reason no route exists, the returned
+// *PolicyError names the first excluded asset on the unrestricted best path.
+func (pf *Pathfinder) FindBestRouteWithPolicy(in, out Asset, amount decimal.Decimal, policy *AssetPolicy) (*Route, error) {
+	if pf.isFrozen(in) || pf.isFrozen(out) {
+		return nil, ErrAssetFrozen
+	}
+
+	for _, asset := range []Asset{in, out} {
+		if reason := policy.Check(asset); reason != "" {
+			return nil, &PolicyError{Asset: asset, Reason: reason, Endpoint: true}
+		}
+	}
+
+	graph := pf.buildGraph(policy)
+
 	path, cost := pf.dijkstra(graph, in.String(), out.String())
 	if path == nil {
+		if !policy.Empty() {
+			if err := pf.explainExclusion(in, out, policy); err != nil {
+				return nil, err
+			}
+		}
 		return nil, ErrNoRoute
 	}
 
@@ -50,9 +135,9 @@ type edge struct {
 }
 
 type node struct {
-	asset    string
-	cost     decimal.Decimal
-	index    int
+	asset string
+	cost  decimal.Decimal
+	index int
 }
 
 type priorityQueue []*node
@@ -82,11 +167,50 @@ func (pq *priorityQueue) Pop() interface{} {
 	return item
 }
 
-func (pf *Pathfinder) buildGraph() map[string][]edge {
+// explainExclusion looks for a route ignoring policy and reports the first
+// asset on it that the policy excludes.
+func (pf *Pathfinder) explainExclusion(in, out Asset, policy *AssetPolicy) error {
+	path, _ := pf.dijkstra(pf.buildGraph(nil), in.String(), out.String())
+	if path == nil || len(path) > MaxHops+1 {
+		return nil
+	}
+
+	byKey := make(map[string]Asset)
+	for _, asset := range pf.assets() {
+		byKey[asset.String()] = asset
+	}
+	for _, key := range path {
+		asset, ok := byKey[key]
+		if !ok {
+			continue
+		}
+		if reason := policy.Check(asset); reason != "" {
+			return &PolicyError{Asset: asset, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// assets returns every asset that appears in a pool or offer.
+func (pf *Pathfinder) assets() []Asset {
+	assets := make([]Asset, 0, 2*(len(pf.pools)+len(pf.offers)))
+	for _, pool := range pf.pools {
+		assets = append(assets, pool.Asset1, pool.Asset2)
+	}
+	for _, offer := range pf.offers {
+		assets = append(assets, offer.TakerPays, offer.TakerGets)
+	}
+	return assets
+}
+
+func (pf *Pathfinder) buildGraph(policy *AssetPolicy) map[string][]edge {
 	graph := make(map[string][]edge)
 
 	for i := range pf.pools {
 		pool := &pf.pools[i]
+		if pf.isExcluded(pool.Asset1, policy) || pf.isExcluded(pool.Asset2, policy) {
+			continue
+		}
 		asset1 := Asset{Currency: pool.Asset1.Currency, Issuer: pool.Asset1.Issuer}.String()
 		asset2 := Asset{Currency: pool.Asset2.Currency, Issuer: pool.Asset2.Issuer}.String()
 
@@ -96,24 +220,27 @@ func (pf *Pathfinder) buildGraph() map[string][]edge {
 
 		graph[asset1] = append(graph[asset1], edge{
 			to:     asset2,
-			weight: feeMultiplier,
+			weight: pf.applyTransferFee(pool.Asset2, feeMultiplier),
 			pool:   pool,
 		})
 		graph[asset2] = append(graph[asset2], edge{
 			to:     asset1,
-			weight: feeMultiplier,
+			weight: pf.applyTransferFee(pool.Asset1, feeMultiplier),
 			pool:   pool,
 		})
 	}
 
 	for i := range pf.offers {
 		offer := &pf.offers[i]
+		if pf.isExcluded(offer.TakerPays, policy) || pf.isExcluded(offer.TakerGets, policy) {
+			continue
+		}
 		from := offer.TakerPays.String()
 		to := offer.TakerGets.String()
 
 		graph[from] = append(graph[from], edge{
 			to:     to,
-			weight: offer.Quality,
+			weight: pf.applyTransferFee(offer.TakerGets, offer.Quality),
 			offer:  offer,
 		})
 	}
@@ -203,49 +330,86 @@ func (pf *Pathfinder) buildRoute(path []string, amount decimal.Decimal) *Route {
 func (pf *Pathfinder) findHop(from, to string, amountIn decimal.Decimal) *Hop {
 	for i := range pf.pools {
 		pool := &pf.pools[i]
+		if pf.isFrozen(pool.Asset1) || pf.isFrozen(pool.Asset2) {
+			continue
+		}
 		asset1 := pool.Asset1.String()
 		asset2 := pool.Asset2.String()
 
 		if asset1 == from && asset2 == to {
 			amountOut := pf.calculateAMMOutput(pool, amountIn, true)
-			return &Hop{
-				Type:      "amm",
-				In:        pool.Asset1,
-				Out:       pool.Asset2,
-				AmountIn:  amountIn,
-				AmountOut: amountOut,
-			}
+			return pf.newHop("amm", pool.Asset1, pool.Asset2, amountIn, amountOut)
 		}
 
 		if asset2 == from && asset1 == to {
 			amountOut := pf.calculateAMMOutput(pool, amountIn, false)
-			return &Hop{
-				Type:      "amm",
-				In:        pool.Asset2,
-				Out:       pool.Asset1,
-				AmountIn:  amountIn,
-				AmountOut: amountOut,
-			}
+			return pf.newHop("amm", pool.Asset2, pool.Asset1, amountIn, amountOut)
 		}
 	}
 
 	for i := range pf.offers {
 		offer := &pf.offers[i]
+		if pf.isFrozen(offer.TakerPays) || pf.isFrozen(offer.TakerGets) {
+			continue
+		}
 		if offer.TakerPays.String() == from && offer.TakerGets.String() == to {
 			amountOut := amountIn.Mul(offer.Quality)
-			return &Hop{
-				Type:      "orderbook",
-				In:        offer.TakerPays,
-				Out:       offer.TakerGets,
-				AmountIn:  amountIn,
-				AmountOut: amountOut,
-			}
+			return pf.newHop("orderbook", offer.TakerPays, offer.TakerGets, amountIn, amountOut)
 		}
 	}
 
 	return nil
 }
 
+// newHop builds a hop whose output is net of the Out issuer's transfer fee.
+// XRPL charges the fee on delivery, so the next hop only receives gross/(1+fee).
+func (pf *Pathfinder) newHop(hopType string, in, out Asset, amountIn, grossOut decimal.Decimal) *Hop {
+	netOut := grossOut
+	if fee := pf.transferFee(out); fee.IsPositive() {
+		netOut = grossOut.Div(decimal.NewFromInt(1).Add(fee))
+	}
+
+	return &Hop{
+		Type:        hopType,
+		In:          in,
+		Out:         out,
+		AmountIn:    amountIn,
+		AmountOut:   netOut,
+		TransferFee: grossOut.Sub(netOut),
+	}
+}
+
+func (pf *Pathfinder) isFrozen(asset Asset) bool {
+	if asset.Issuer == "" {
+		return false
+	}
+	info, ok := pf.issuer(asset.Issuer)
+	return ok && info.GlobalFreeze
+}
+
+func (pf *Pathfinder) isExcluded(asset Asset, policy *AssetPolicy) bool {
+	return pf.isFrozen(asset) || policy.Check(asset) != ""
+}
+
+func (pf *Pathfinder) transferFee(asset Asset) decimal.Decimal {
+	if asset.Issuer == "" {
+		return decimal.Zero
+	}
+	info, ok := pf.issuer(asset.Issuer)
+	if !ok {
+		return decimal.Zero
+	}
+	return info.TransferFee
+}
+
+func (pf *Pathfinder) applyTransferFee(out Asset, weight decimal.Decimal) decimal.Decimal {
+	fee := pf.transferFee(out)
+	if !fee.IsPositive() {
+		return weight
+	}
+	return weight.Div(decimal.NewFromInt(1).Add(fee))
+}
+
 func (pf *Pathfinder) calculateAMMOutput(pool *AMMPool, amountIn decimal.Decimal, asset1ToAsset2 bool) decimal.Decimal {
 	var reserveIn, reserveOut decimal.Decimal
 
@@ -1,7 +1,9 @@
 package router
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 )
@@ -193,3 +195,133 @@ func TestPathfinder_MaxHopsLimit(t *testing.T) {
 		t.Errorf("Long path error = %v, want %v (max %d hops)", err, ErrNoRoute, MaxHops)
 	}
 }
+
+func TestPathfinder_GlobalFreeze(t *testing.T) {
+	usd := Asset{Currency: "USD", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"}
+	eur := Asset{Currency: "EUR", Issuer: "rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq"}
+
+	pools := []AMMPool{
+		{
+			Asset1:        Asset{Currency: "XRP"},
+			Asset2:        usd,
+			Asset1Reserve: decimal.NewFromInt(10000),
+			Asset2Reserve: decimal.NewFromInt(15000),
+			TradingFeeBps: 30,
+		},
+		{
+			Asset1:        usd,
+			Asset2:        eur,
+			Asset1Reserve: decimal.NewFromInt(15000),
+			Asset2Reserve: decimal.NewFromInt(14000),
+			TradingFeeBps: 30,
+		},
+		{
+			Asset1:        Asset{Currency: "XRP"},
+			Asset2:        eur,
+			Asset1Reserve: decimal.NewFromInt(10000),
+			Asset2Reserve: decimal.NewFromInt(5000),
+			TradingFeeBps: 30,
+		},
+	}
+	issuers := []IssuerInfo{{Account: usd.Issuer, GlobalFreeze: true}}
+
+	pf := NewPathfinderWithIssuers(pools, nil, issuers)
+
+	if _, err := pf.FindBestRoute(Asset{Currency: "XRP"}, usd, decimal.NewFromInt(100)); err != ErrAssetFrozen {
+		t.Errorf("FindBestRoute() to frozen asset error = %v, want %v", err, ErrAssetFrozen)
+	}
+
+	route, err := pf.FindBestRoute(Asset{Currency: "XRP"}, eur, decimal.NewFromInt(100))
+	if err != nil {
+		t.Fatalf("FindBestRoute() error = %v", err)
+	}
+	for _, hop := range route.Hops {
+		if hop.In == usd || hop.Out == usd {
+			t.Errorf("route hops through frozen asset: %+v", hop)
+		}
+	}
+}
+
+func TestPathfinder_RefreshIssuers(t *testing.T) {
+	usd := Asset{Currency: "USD", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"}
+	pools := []AMMPool{{
+		Asset1:        Asset{Currency: "XRP"},
+		Asset2:        usd,
+		Asset1Reserve: decimal.NewFromInt(10000),
+		Asset2Reserve: decimal.NewFromInt(15000),
+		TradingFeeBps: 30,
+	}}
+	pf := NewPathfinderWithIssuers(pools, nil, nil)
+
+	loaded := make(chan struct{}, 1)
+	load := func(ctx context.Context) ([]IssuerInfo, error) {
+		select {
+		case loaded <- struct{}{}:
+		default:
+		}
+		return []IssuerInfo{{Account: usd.Issuer, GlobalFreeze: true}}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pf.RefreshIssuers(ctx, load, time.Millisecond)
+
+	<-loaded
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, err := pf.FindBestRoute(Asset{Currency: "XRP"}, usd, decimal.NewFromInt(100))
+		if err == ErrAssetFrozen {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("FindBestRoute() after refresh error = %v, want %v", err, ErrAssetFrozen)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPathfinder_TransferFee(t *testing.T) {
+	usd := Asset{Currency: "USD", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"}
+	offers := []Offer{
+		{
+			TakerPays: Asset{Currency: "XRP"},
+			TakerGets: usd,
+			Quality:   decimal.NewFromFloat(1.5),
+		},
+	}
+	issuers := []IssuerInfo{{Account: usd.Issuer, TransferFee: TransferFeeFromRate(1002000000)}}
+
+	pf := NewPathfinderWithIssuers(nil, offers, issuers)
+
+	route, err := pf.FindBestRoute(Asset{Currency: "XRP"}, usd, decimal.NewFromInt(100))
+	if err != nil {
+		t.Fatalf("FindBestRoute() error = %v", err)
+	}
+
+	hop := route.Hops[0]
+	gross := decimal.NewFromInt(150)
+	if !hop.AmountOut.Add(hop.TransferFee).Equal(gross) {
+		t.Errorf("AmountOut + TransferFee = %s, want %s", hop.AmountOut.Add(hop.TransferFee), gross)
+	}
+	if !hop.AmountOut.LessThan(gross) {
+		t.Errorf("AmountOut = %s, want less than %s", hop.AmountOut, gross)
+	}
+}
+
+func TestTransferFeeFromRate(t *testing.T) {
+	tests := []struct {
+		rate int64
+		want string
+	}{
+		{0, "0"},
+		{1000000000, "0"},
+		{1002000000, "0.002"},
+		{2000000000, "1"},
+	}
+
+	for _, tt := range tests {
+		if got := TransferFeeFromRate(tt.rate); !got.Equal(decimal.RequireFromString(tt.want)) {
+			t.Errorf("TransferFeeFromRate(%d) = %s, want %s", tt.rate, got, tt.want)
+		}
+	}
+}
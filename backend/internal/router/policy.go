@@ -0,0 +1,193 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lucendex/backend/internal/currency"
+)
+
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+
+	// Reason codes returned when a policy excludes an asset
+	ReasonGlobalDenylist    = "global_denylist"
+	ReasonPartnerDenylist   = "partner_denylist"
+	ReasonNotGlobalAllowed  = "not_in_global_allowlist"
+	ReasonNotPartnerAllowed = "not_in_partner_allowlist"
+
+	DefaultPolicyCacheTTL = 60 * time.Second
+)
+
+// PolicyRule is one allow/deny list entry. An empty PartnerID applies to
+// every partner; an empty Currency matches every currency of Issuer.
+type PolicyRule struct {
+	PartnerID string        `json:"partner_id,omitempty"`
+	List      string        `json:"list"`
+	Currency  currency.Code `json:"currency,omitempty"`
+	Issuer    string        `json:"issuer,omitempty"`
+}
+
+func (r PolicyRule) matches(asset Asset) bool {
+	if r.Issuer != asset.Issuer {
+		return false
+	}
+	return r.Currency == "" || r.Currency == asset.Currency
+}
+
+// AssetPolicy combines the global lists with one partner's lists.
+// Deny entries always win. A non-empty allowlist restricts routing to the
+// listed assets; XRP is only subject to allowlists that mention it.
+type AssetPolicy struct {
+	global  ruleSet
+	partner ruleSet
+}
+
+type ruleSet struct {
+	allow []PolicyRule
+	deny  []PolicyRule
+}
+
+func NewAssetPolicy(rules []PolicyRule) *AssetPolicy {
+	p := &AssetPolicy{}
+	for _, r := range rules {
+		set := &p.global
+		if r.PartnerID != "" {
+			set = &p.partner
+		}
+		switch r.List {
+		case PolicyAllow:
+			set.allow = append(set.allow, r)
+		case PolicyDeny:
+			set.deny = append(set.deny, r)
+		}
+	}
+	return p
+}
+
+// Check returns the reason code excluding asset, or "" if it may be routed.
+func (p *AssetPolicy) Check(asset Asset) string {
+	if p == nil {
+		return ""
+	}
+	if p.global.denies(asset) {
+		return ReasonGlobalDenylist
+	}
+	if p.partner.denies(asset) {
+		return ReasonPartnerDenylist
+	}
+	if !p.global.allows(asset) {
+		return ReasonNotGlobalAllowed
+	}
+	if !p.partner.allows(asset) {
+		return ReasonNotPartnerAllowed
+	}
+	return ""
+}
+
+// Empty reports whether the policy has no rules at all.
+func (p *AssetPolicy) Empty() bool {
+	return p == nil || (len(p.global.allow) == 0 && len(p.global.deny) == 0 &&
+		len(p.partner.allow) == 0 && len(p.partner.deny) == 0)
+}
+
+func (s ruleSet) denies(asset Asset) bool {
+	for _, r := range s.deny {
+		if r.matches(asset) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ruleSet) allows(asset Asset) bool {
+	if len(s.allow) == 0 {
+		return true
+	}
+	xrpListed := false
+	for _, r := range s.allow {
+		if r.matches(asset) {
+			return true
+		}
+		if r.Issuer == "" {
+			xrpListed = true
+		}
+	}
+	return asset.IsXRP() && !xrpListed
+}
+
+// PolicyError reports an asset excluded from a route by policy.
+type PolicyError struct {
+	Asset    Asset
+	Reason   string
+	Endpoint bool // true for the quoted in/out assets, false for intermediate hops
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("asset %s excluded by policy: %s", e.Asset, e.Reason)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return ErrAssetExcluded
+}
+
+// PolicyLoader returns the global rules plus the rules for partnerID.
+type PolicyLoader func(ctx context.Context, partnerID string) ([]PolicyRule, error)
+
+type PolicyCache interface {
+	GetPolicy(key string) ([]byte, bool)
+	SetPolicy(key string, policy []byte, ttl time.Duration) error
+}
+
+// PolicyProvider loads asset policies from the database and caches the
+// rules per partner in KV.
+type PolicyProvider struct {
+	load  PolicyLoader
+	cache PolicyCache
+	ttl   time.Duration
+}
+
+func NewPolicyProvider(load PolicyLoader, cache PolicyCache) *PolicyProvider {
+	return &PolicyProvider{
+		load:  load,
+		cache: cache,
+		ttl:   DefaultPolicyCacheTTL,
+	}
+}
+
+func (pp *PolicyProvider) Policy(ctx context.Context, partnerID string) (*AssetPolicy, error) {
+	key := "global"
+	if partnerID != "" {
+		key = "partner:" + partnerID
+	}
+
+	if pp.cache != nil {
+		if cached, ok := pp.cache.GetPolicy(key); ok {
+			var rules []PolicyRule
+			if err := json.Unmarshal(cached, &rules); err == nil {
+				return NewAssetPolicy(rules), nil
+			}
+		}
+	}
+
+	rules, err := pp.load(ctx, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load asset policy: %w", err)
+	}
+
+	if pp.cache != nil {
+		if data, err := json.Marshal(rules); err == nil {
+			// The policy is still enforced; only the next lookup pays for it
+			if err := pp.cache.SetPolicy(key, data, pp.ttl); err != nil {
+				PolicyCacheErrors.Inc()
+				log.Printf("Failed to cache asset policy %s: %v", key, err)
+			}
+		}
+	}
+
+	return NewAssetPolicy(rules), nil
+}
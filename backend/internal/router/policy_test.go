@@ -0,0 +1,147 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	usdIssuer = "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"
+	eurIssuer = "rhub8VRN55s94qWKDv6jmDy1pUykJzF3wq"
+)
+
+func TestAssetPolicy_Check(t *testing.T) {
+	usd := Asset{Currency: "USD", Issuer: usdIssuer}
+	eur := Asset{Currency: "EUR", Issuer: eurIssuer}
+	xrp := Asset{Currency: "XRP"}
+
+	tests := []struct {
+		name  string
+		rules []PolicyRule
+		asset Asset
+		want  string
+	}{
+		{"no rules", nil, usd, ""},
+		{"global deny issuer", []PolicyRule{{List: PolicyDeny, Issuer: usdIssuer}}, usd, ReasonGlobalDenylist},
+		{"partner deny asset", []PolicyRule{{PartnerID: "p1", List: PolicyDeny, Currency: "USD", Issuer: usdIssuer}}, usd, ReasonPartnerDenylist},
+		{"deny other currency", []PolicyRule{{List: PolicyDeny, Currency: "BTC", Issuer: usdIssuer}}, usd, ""},
+		{"global allowlist miss", []PolicyRule{{List: PolicyAllow, Issuer: usdIssuer}}, eur, ReasonNotGlobalAllowed},
+		{"partner allowlist miss", []PolicyRule{{PartnerID: "p1", List: PolicyAllow, Issuer: usdIssuer}}, eur, ReasonNotPartnerAllowed},
+		{"allowlist hit", []PolicyRule{{List: PolicyAllow, Issuer: usdIssuer}}, usd, ""},
+		{"deny wins over allow", []PolicyRule{{List: PolicyAllow, Issuer: usdIssuer}, {PartnerID: "p1", List: PolicyDeny, Issuer: usdIssuer}}, usd, ReasonPartnerDenylist},
+		{"xrp exempt from allowlist", []PolicyRule{{List: PolicyAllow, Issuer: usdIssuer}}, xrp, ""},
+		{"xrp denied", []PolicyRule{{List: PolicyDeny, Currency: "XRP"}}, xrp, ReasonGlobalDenylist},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewAssetPolicy(tt.rules).Check(tt.asset); got != tt.want {
+				t.Errorf("Check(%s) = %q, want %q", tt.asset, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathfinder_PolicyExcludesIntermediateHop(t *testing.T) {
+	usd := Asset{Currency: "USD", Issuer: usdIssuer}
+	eur := Asset{Currency: "EUR", Issuer: eurIssuer}
+
+	pools := []AMMPool{
+		{
+			Asset1:        Asset{Currency: "XRP"},
+			Asset2:        usd,
+			Asset1Reserve: decimal.NewFromInt(10000),
+			Asset2Reserve: decimal.NewFromInt(15000),
+			TradingFeeBps: 30,
+		},
+		{
+			Asset1:        usd,
+			Asset2:        eur,
+			Asset1Reserve: decimal.NewFromInt(15000),
+			Asset2Reserve: decimal.NewFromInt(14000),
+			TradingFeeBps: 30,
+		},
+	}
+	pf := NewPathfinder(pools, nil)
+	policy := NewAssetPolicy([]PolicyRule{{PartnerID: "p1", List: PolicyDeny, Issuer: usdIssuer}})
+
+	_, err := pf.FindBestRouteWithPolicy(Asset{Currency: "XRP"}, eur, decimal.NewFromInt(100), policy)
+
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("FindBestRouteWithPolicy() error = %v, want *PolicyError", err)
+	}
+	if policyErr.Asset != usd || policyErr.Reason != ReasonPartnerDenylist || policyErr.Endpoint {
+		t.Errorf("unexpected policy error: %+v", policyErr)
+	}
+	if !errors.Is(err, ErrAssetExcluded) {
+		t.Error("errors.Is(err, ErrAssetExcluded) = false, want true")
+	}
+
+	_, err = pf.FindBestRouteWithPolicy(Asset{Currency: "XRP"}, usd, decimal.NewFromInt(100), policy)
+	if !errors.As(err, &policyErr) || !policyErr.Endpoint {
+		t.Errorf("endpoint exclusion error = %v, want endpoint *PolicyError", err)
+	}
+
+	// An alternative path avoids the excluded asset
+	pf = NewPathfinder(append(pools, AMMPool{
+		Asset1:        Asset{Currency: "XRP"},
+		Asset2:        eur,
+		Asset1Reserve: decimal.NewFromInt(10000),
+		Asset2Reserve: decimal.NewFromInt(5000),
+		TradingFeeBps: 30,
+	}), nil)
+
+	route, err := pf.FindBestRouteWithPolicy(Asset{Currency: "XRP"}, eur, decimal.NewFromInt(100), policy)
+	if err != nil {
+		t.Fatalf("FindBestRouteWithPolicy() error = %v", err)
+	}
+	if len(route.Hops) != 1 {
+		t.Errorf("Hops = %d, want 1", len(route.Hops))
+	}
+}
+
+type mockPolicyCache struct {
+	data map[string][]byte
+}
+
+func (m *mockPolicyCache) GetPolicy(key string) ([]byte, bool) {
+	v, ok := m.data[key]
+	return v, ok
+}
+
+func (m *mockPolicyCache) SetPolicy(key string, policy []byte, ttl time.Duration) error {
+	m.data[key] = policy
+	return nil
+}
+
+func TestPolicyProvider_CachesRules(t *testing.T) {
+	loads := 0
+	load := func(ctx context.Context, partnerID string) ([]PolicyRule, error) {
+		loads++
+		return []PolicyRule{{PartnerID: partnerID, List: PolicyDeny, Issuer: usdIssuer}}, nil
+	}
+	cache := &mockPolicyCache{data: make(map[string][]byte)}
+	pp := NewPolicyProvider(load, cache)
+
+	for i := 0; i < 3; i++ {
+		policy, err := pp.Policy(context.Background(), "p1")
+		if err != nil {
+			t.Fatalf("Policy() error = %v", err)
+		}
+		if got := policy.Check(Asset{Currency: "USD", Issuer: usdIssuer}); got != ReasonPartnerDenylist {
+			t.Errorf("Check() = %q, want %q", got, ReasonPartnerDenylist)
+		}
+	}
+
+	if loads != 1 {
+		t.Errorf("loads = %d, want 1", loads)
+	}
+	if _, ok := cache.data["partner:p1"]; !ok {
+		t.Error("expected rules cached under partner:p1")
+	}
+}
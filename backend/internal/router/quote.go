@@ -12,6 +12,7 @@ type QuoteEngine struct {
 	pathfinder *Pathfinder
 	breaker    *CircuitBreaker
 	kv         KVStore
+	policies   *PolicyProvider
 	routerBps  int
 }
 
@@ -32,12 +33,26 @@ func NewQuoteEngine(validator *Validator, pathfinder *Pathfinder, breaker *Circu
 	}
 }
 
+// SetPolicyProvider enables asset allow/deny list enforcement
+func (qe *QuoteEngine) SetPolicyProvider(pp *PolicyProvider) {
+	qe.policies = pp
+}
+
 func (qe *QuoteEngine) GenerateQuote(ctx context.Context, req *QuoteRequest, ledgerIndex uint32) (*QuoteResponse, error) {
 	if err := qe.validator.ValidateQuoteRequest(req); err != nil {
 		return nil, err
 	}
 
-	route, err := qe.pathfinder.FindBestRoute(req.In, req.Out, req.Amount)
+	var policy *AssetPolicy
+	if qe.policies != nil {
+		p, err := qe.policies.Policy(ctx, req.PartnerID)
+		if err != nil {
+			return nil, err
+		}
+		policy = p
+	}
+
+	route, err := qe.pathfinder.FindBestRouteWithPolicy(req.In, req.Out, req.Amount, policy)
 	if err != nil {
 		return nil, err
 	}
@@ -78,17 +93,23 @@ func (qe *QuoteEngine) GenerateQuote(ctx context.Context, req *QuoteRequest, led
 
 func (qe *QuoteEngine) calculateTotalFees(route *Route) Fees {
 	totalTradingFees := decimal.Zero
+	totalTransferFees := decimal.Zero
 
 	for _, hop := range route.Hops {
 		if hop.Type == "amm" {
 			fee := hop.AmountIn.Sub(hop.AmountOut).Div(hop.AmountIn)
 			totalTradingFees = totalTradingFees.Add(fee)
 		}
+		if hop.TransferFee.IsPositive() {
+			gross := hop.AmountOut.Add(hop.TransferFee)
+			totalTransferFees = totalTransferFees.Add(hop.TransferFee.Div(gross))
+		}
 	}
 
 	return Fees{
-		TradingFees: totalTradingFees,
-		EstOutFee:   decimal.Zero,
+		TradingFees:  totalTradingFees,
+		TransferFees: totalTransferFees,
+		EstOutFee:    decimal.Zero,
 	}
 }
 
@@ -51,7 +51,7 @@ func TestQuoteEngine_GenerateQuote(t *testing.T) {
 
 	validator := NewValidator()
 	pathfinder := NewPathfinder(pools, nil)
-	breaker := NewCircuitBreaker(0.05)
+	breaker := NewCircuitBreaker(DefaultThreshold)
 	breaker.mu.Lock()
 	breaker.cautionMode = false
 	breaker.mu.Unlock()
@@ -115,7 +115,7 @@ func TestQuoteEngine_CircuitBreakerRejection(t *testing.T) {
 
 	validator := NewValidator()
 	pathfinder := NewPathfinder(pools, nil)
-	breaker := NewCircuitBreaker(0.01)
+	breaker := NewCircuitBreaker(1.0)
 	breaker.mu.Lock()
 	breaker.cautionMode = false
 	breaker.mu.Unlock()
@@ -154,7 +154,7 @@ func TestQuoteEngine_FeeCalculation(t *testing.T) {
 
 	validator := NewValidator()
 	pathfinder := NewPathfinder(pools, nil)
-	breaker := NewCircuitBreaker(0.05)
+	breaker := NewCircuitBreaker(DefaultThreshold)
 	breaker.mu.Lock()
 	breaker.cautionMode = false
 	breaker.mu.Unlock()
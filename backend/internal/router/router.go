@@ -2,8 +2,11 @@ package router
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
+
+	"github.com/lucendex/backend/internal/currency"
 )
 
 type Router struct {
@@ -13,6 +16,7 @@ type Router struct {
 	breaker     *CircuitBreaker
 	store       RouterStoreInterface
 	kv          KVStore
+	pairs       *PairStats
 	mu          sync.RWMutex
 	stopped     bool
 }
@@ -49,14 +53,21 @@ func (r *Router) Quote(ctx context.Context, req *QuoteRequest, ledgerIndex uint3
 		errorCode = &code
 	}
 
+	metadata := map[string]interface{}{
+		"pair": req.In.String() + "-" + req.Out.String(),
+	}
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		metadata["policy_asset"] = policyErr.Asset.String()
+		metadata["policy_reason"] = policyErr.Reason
+	}
+
 	auditLog := map[string]interface{}{
 		"event":       "quote_request",
 		"severity":    severity,
 		"duration_ms": durationMs,
 		"outcome":     outcome,
-		"metadata": map[string]interface{}{
-			"pair": req.In.String() + "-" + req.Out.String(),
-		},
+		"metadata":    metadata,
 	}
 	if errorCode != nil {
 		auditLog["error_code"] = *errorCode
@@ -87,8 +98,26 @@ func (r *Router) SetCurrentLedgerIndex(idx uint32) {
 	}
 }
 
+// SetPairStats enables GetAvailablePairs; without it no pairs are listed
+func (r *Router) SetPairStats(ps *PairStats) {
+	r.pairs = ps
+}
+
+// GetAvailablePairs returns the indexed pairs as of the current ledger,
+// most liquid first
 func (r *Router) GetAvailablePairs(ctx context.Context) ([]TradingPairInfo, error) {
-	return []TradingPairInfo{}, nil
+	if r.pairs == nil {
+		return []TradingPairInfo{}, nil
+	}
+	return r.pairs.Pairs(ctx, r.GetCurrentLedgerIndex())
+}
+
+// ReferenceAsset is the asset pair liquidity and volume are valued in
+func (r *Router) ReferenceAsset() Asset {
+	if r.pairs == nil {
+		return Asset{Currency: currency.XRP}
+	}
+	return r.pairs.Reference()
 }
 
 func (r *Router) Close() error {
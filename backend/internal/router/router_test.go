@@ -42,7 +42,7 @@ func TestRouter_Quote(t *testing.T) {
 
 	validator := NewValidator()
 	pathfinder := NewPathfinder(pools, nil)
-	breaker := NewCircuitBreaker(0.05)
+	breaker := NewCircuitBreaker(DefaultThreshold)
 	breaker.mu.Lock()
 	breaker.cautionMode = false
 	breaker.mu.Unlock()
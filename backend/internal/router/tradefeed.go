@@ -0,0 +1,101 @@
+package router
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultTradeFeedBatch is how many executions a TradeFeed reads per query
+const DefaultTradeFeedBatch = 500
+
+// Execution is an on-ledger trade recorded by the indexer
+type Execution struct {
+	ID         int64
+	InAsset    string
+	OutAsset   string
+	AmountIn   string
+	AmountOut  string
+	ExecutedAt time.Time
+}
+
+// ExecutionLoader reads up to limit executions with IDs above afterID, in ID
+// order.
+type ExecutionLoader func(ctx context.Context, afterID int64, limit int) ([]Execution, error)
+
+// ExecutionCursor returns the highest execution ID recorded before since,
+// so a feed starting up skips history the breaker would prune anyway.
+type ExecutionCursor func(ctx context.Context, since time.Time) (int64, error)
+
+// TradeFeed feeds executions recorded by the indexer into a CircuitBreaker
+// so reference prices track the ledger.
+type TradeFeed struct {
+	breaker *CircuitBreaker
+	load    ExecutionLoader
+	seed    ExecutionCursor
+	batch   int
+
+	lastID int64
+	seeded bool
+}
+
+func NewTradeFeed(breaker *CircuitBreaker, seed ExecutionCursor, load ExecutionLoader) *TradeFeed {
+	return &TradeFeed{
+		breaker: breaker,
+		load:    load,
+		seed:    seed,
+		batch:   DefaultTradeFeedBatch,
+	}
+}
+
+// Sync records every execution since the last call and returns how many
+// were recorded. The first call starts one breaker window back. Executions
+// the breaker rejects are logged and skipped.
+func (f *TradeFeed) Sync(ctx context.Context) (int, error) {
+	if !f.seeded {
+		lastID, err := f.seed(ctx, f.breaker.now().Add(-f.breaker.cfg.Window))
+		if err != nil {
+			return 0, err
+		}
+		f.lastID, f.seeded = lastID, true
+	}
+
+	recorded := 0
+	for {
+		trades, err := f.load(ctx, f.lastID, f.batch)
+		if err != nil {
+			return recorded, err
+		}
+		for _, t := range trades {
+			if err := f.breaker.RecordExecution(t.InAsset, t.OutAsset, t.AmountIn, t.AmountOut, t.ExecutedAt); err != nil {
+				log.Printf("Skipping trade %d: %v", t.ID, err)
+			} else {
+				recorded++
+			}
+			f.lastID = t.ID
+		}
+		if len(trades) < f.batch {
+			return recorded, nil
+		}
+	}
+}
+
+// Run calls Sync every interval until ctx is done
+func (f *TradeFeed) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			syncCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			_, err := f.Sync(syncCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Trade feed failed: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTradeFeed_SeedsAndCatchesUp(t *testing.T) {
+	cb := NewCircuitBreaker(DefaultThreshold)
+	clock := time.Now()
+	cb.now = func() time.Time { return clock }
+
+	var seededSince time.Time
+	seed := func(ctx context.Context, since time.Time) (int64, error) {
+		seededSince = since
+		return 100, nil
+	}
+
+	var afterIDs []int64
+	load := func(ctx context.Context, afterID int64, limit int) ([]Execution, error) {
+		afterIDs = append(afterIDs, afterID)
+		if afterID >= 105 {
+			return nil, nil
+		}
+		var trades []Execution
+		for id := afterID + 1; id <= afterID+int64(limit) && id <= 105; id++ {
+			trades = append(trades, Execution{ID: id, InAsset: "XRP", OutAsset: "USD.r1", AmountIn: "2000000", AmountOut: "3", ExecutedAt: clock})
+		}
+		return trades, nil
+	}
+
+	feed := NewTradeFeed(cb, seed, load)
+	feed.batch = 2
+
+	recorded, err := feed.Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	if want := clock.Add(-DefaultWindow); !seededSince.Equal(want) {
+		t.Errorf("seeded since %v, want %v", seededSince, want)
+	}
+	if recorded != 5 {
+		t.Errorf("recorded = %d, want 5", recorded)
+	}
+	if want := []int64{100, 102, 104}; len(afterIDs) != len(want) || afterIDs[0] != want[0] || afterIDs[2] != want[2] {
+		t.Errorf("loaded after %v, want %v", afterIDs, want)
+	}
+
+	afterIDs = nil
+	if recorded, err = feed.Sync(context.Background()); err != nil || recorded != 0 {
+		t.Fatalf("second Sync() = %d, %v; want 0, nil", recorded, err)
+	}
+	if len(afterIDs) != 1 || afterIDs[0] != 105 {
+		t.Errorf("second Sync loaded after %v, want [105]", afterIDs)
+	}
+}
@@ -2,28 +2,42 @@ package router
 
 import (
 	"github.com/shopspring/decimal"
+
+	"github.com/lucendex/backend/internal/currency"
 )
 
 type Asset struct {
-	Currency string
+	Currency currency.Code
 	Issuer   string
 }
 
+// ParseAsset parses "XRP" or "CODE.ISSUER" into an Asset with a canonical code
+func ParseAsset(s string) (Asset, error) {
+	code, issuer, err := currency.SplitAsset(s)
+	if err != nil {
+		return Asset{}, err
+	}
+	return Asset{Currency: code, Issuer: issuer}, nil
+}
+
 func (a Asset) String() string {
 	if a.Issuer == "" {
-		return a.Currency
+		return a.Currency.String()
 	}
-	return a.Currency + "." + a.Issuer
+	return a.Currency.String() + "." + a.Issuer
 }
 
 func (a Asset) IsXRP() bool {
-	return a.Currency == "XRP" && a.Issuer == ""
+	return a.Currency.IsXRP() && a.Issuer == ""
 }
 
 type QuoteRequest struct {
 	In     Asset
 	Out    Asset
 	Amount decimal.Decimal
+
+	// PartnerID selects the partner's asset policy; not part of the QuoteHash
+	PartnerID string
 }
 
 type QuoteResponse struct {
@@ -43,17 +57,19 @@ type Route struct {
 }
 
 type Hop struct {
-	Type      string
-	In        Asset
-	Out       Asset
-	AmountIn  decimal.Decimal
-	AmountOut decimal.Decimal
+	Type        string
+	In          Asset
+	Out         Asset
+	AmountIn    decimal.Decimal
+	AmountOut   decimal.Decimal
+	TransferFee decimal.Decimal // withheld by the Out issuer, already deducted from AmountOut
 }
 
 type Fees struct {
-	RouterBps   int
-	TradingFees decimal.Decimal
-	EstOutFee   decimal.Decimal
+	RouterBps    int
+	TradingFees  decimal.Decimal
+	TransferFees decimal.Decimal
+	EstOutFee    decimal.Decimal
 }
 
 type AMMPool struct {
@@ -74,10 +90,33 @@ type Offer struct {
 	Sequence  uint32
 }
 
+type IssuerInfo struct {
+	Account       string
+	GlobalFreeze  bool
+	NoFreeze      bool
+	AllowClawback bool
+	TransferFee   decimal.Decimal // fraction, e.g. 0.002 for TransferRate 1002000000
+}
+
+// TransferFeeFromRate converts an XRPL TransferRate (1000000000 = no fee)
+// into a fee fraction.
+func TransferFeeFromRate(rate int64) decimal.Decimal {
+	if rate <= 1000000000 {
+		return decimal.Zero
+	}
+	return decimal.NewFromInt(rate - 1000000000).Div(decimal.NewFromInt(1000000000))
+}
+
+// TradingPairInfo describes a pair with In as base and Out as quote.
+// Liquidity and DailyVolume are valued in the reference asset; BestBid and
+// BestAsk are Out per In, zero when that side is empty, and AvgSpread is
+// (ask - bid) / mid, zero unless both sides exist.
 type TradingPairInfo struct {
 	In          Asset
 	Out         Asset
 	Liquidity   decimal.Decimal
 	AvgSpread   decimal.Decimal
 	DailyVolume decimal.Decimal
+	BestBid     decimal.Decimal
+	BestAsk     decimal.Decimal
 }
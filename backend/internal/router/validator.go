@@ -1,15 +1,12 @@
 package router
 
 import (
-	"regexp"
 	"strings"
 
 	"github.com/shopspring/decimal"
-)
 
-var (
-	xrplAddressRegex = regexp.MustCompile(`^r[1-9A-HJ-NP-Za-km-z]{24,34}$`)
-	currencyRegex    = regexp.MustCompile(`^[A-Z0-9]{3,40}$`)
+	"github.com/lucendex/backend/internal/address"
+	"github.com/lucendex/backend/internal/currency"
 )
 
 const (
@@ -47,22 +44,21 @@ func (v *Validator) ValidateQuoteRequest(req *QuoteRequest) error {
 	return nil
 }
 
+// ValidateAsset requires the currency code in canonical form (see
+// currency.Parse) so equal assets always hash to the same QuoteHash.
 func (v *Validator) ValidateAsset(asset Asset) error {
-	if asset.Currency == "" {
+	code, err := currency.Parse(asset.Currency.String())
+	if err != nil || code != asset.Currency {
 		return ErrInvalidAsset
 	}
 
-	if asset.Currency == "XRP" {
+	if code.IsXRP() {
 		if asset.Issuer != "" {
 			return ErrInvalidAsset
 		}
 		return nil
 	}
 
-	if !currencyRegex.MatchString(asset.Currency) {
-		return ErrInvalidAsset
-	}
-
 	if asset.Issuer == "" {
 		return ErrInvalidAsset
 	}
@@ -74,16 +70,8 @@ func (v *Validator) ValidateAsset(asset Asset) error {
 	return nil
 }
 
-func (v *Validator) IsValidXRPLAddress(address string) bool {
-	if address == "" {
-		return false
-	}
-
-	address = strings.TrimSpace(address)
-
-	if !xrplAddressRegex.MatchString(address) {
-		return false
-	}
-
-	return true
+// IsValidXRPLAddress reports whether address is a classic "r..." address
+// with a valid base58check checksum. X-addresses must be converted first.
+func (v *Validator) IsValidXRPLAddress(addr string) bool {
+	return address.IsValidClassic(strings.TrimSpace(addr))
 }
@@ -123,6 +123,26 @@ func TestValidator_ValidateAsset(t *testing.T) {
 			asset:   Asset{Currency: "USD", Issuer: "invalid"},
 			wantErr: ErrInvalidAddress,
 		},
+		{
+			name:    "lowercase standard code",
+			asset:   Asset{Currency: "usd", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"},
+			wantErr: nil,
+		},
+		{
+			name:    "hex currency code",
+			asset:   Asset{Currency: "534F4C4F00000000000000000000000000000000", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"},
+			wantErr: nil,
+		},
+		{
+			name:    "non-canonical hex currency code",
+			asset:   Asset{Currency: "534f4c4f00000000000000000000000000000000", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"},
+			wantErr: ErrInvalidAsset,
+		},
+		{
+			name:    "four character code",
+			asset:   Asset{Currency: "SOLO", Issuer: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B"},
+			wantErr: ErrInvalidAsset,
+		},
 	}
 
 	for _, tt := range tests {
@@ -178,6 +198,16 @@ func TestValidator_IsValidXRPLAddress(t *testing.T) {
 			address: " rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B ",
 			want:    true,
 		},
+		{
+			name:    "bad checksum",
+			address: "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59C",
+			want:    false,
+		},
+		{
+			name:    "x-address",
+			address: "X7AcgcsBL6XDcUb289X4mJ8djcdyKaB5hJDWMArnXr61cqZ",
+			want:    false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStore_TrackIssuer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &Store{db: db}
+	ctx := context.Background()
+
+	mock.ExpectExec("INSERT INTO core.issuers").
+		WithArgs("rIssuer", int64(100)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO core.issuers").
+		WithArgs("rIssuer", int64(101)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	added, err := store.TrackIssuer(ctx, "rIssuer", 100)
+	if err != nil || !added {
+		t.Errorf("TrackIssuer() = %v, %v, want true, nil", added, err)
+	}
+
+	added, err = store.TrackIssuer(ctx, "rIssuer", 101)
+	if err != nil || added {
+		t.Errorf("TrackIssuer() = %v, %v, want false, nil", added, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStore_UpsertIssuer(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &Store{db: db}
+	ctx := context.Background()
+
+	issuer := &IssuerState{
+		Account:      "rIssuer",
+		Flags:        0x00400000,
+		TransferRate: 1002000000,
+		GlobalFreeze: true,
+		LedgerIndex:  200,
+	}
+
+	mock.ExpectExec("INSERT INTO core.issuers").
+		WithArgs(issuer.Account, issuer.Flags, issuer.TransferRate, true, false, false, issuer.LedgerIndex).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE core.issuers").
+		WithArgs(issuer.Account, issuer.Flags, issuer.TransferRate, true, false, false, issuer.LedgerIndex).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := store.UpsertIssuer(ctx, issuer); err != nil {
+		t.Errorf("UpsertIssuer() error = %v", err)
+	}
+	if err := store.UpdateTrackedIssuer(ctx, issuer); err != nil {
+		t.Errorf("UpdateTrackedIssuer() error = %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStore_ListUnsyncedIssuers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &Store{db: db}
+
+	mock.ExpectQuery("SELECT account FROM core.issuers WHERE NOT synced").
+		WithArgs(100).
+		WillReturnRows(sqlmock.NewRows([]string{"account"}).AddRow("rIssuer1").AddRow("rIssuer2"))
+
+	accounts, err := store.ListUnsyncedIssuers(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("ListUnsyncedIssuers() error = %v", err)
+	}
+	if len(accounts) != 2 || accounts[0] != "rIssuer1" {
+		t.Errorf("accounts = %v, want [rIssuer1 rIssuer2]", accounts)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
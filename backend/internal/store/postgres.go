@@ -326,12 +326,16 @@ type UsageEvent struct {
 	PartnerID   string
 	QuoteHash   []byte
 	Pair        string
+	InAsset     string
+	OutAsset    string
 	AmountIn    string
 	AmountOut   string
 	RouterBps   int
 	FeeAmount   string
 	TxHash      string
 	LedgerIndex int64
+	InUSDPrice  *string // USD per whole unit, nil without a reference market
+	OutUSDPrice *string
 }
 
 // InsertCompletedTrade records a completed Lucendex trade
@@ -465,12 +469,36 @@ func (s *Store) DeleteQuoteRegistryEntry(ctx context.Context, quoteHash []byte)
 	return nil
 }
 
+// GetPoolReserves returns the reserves of the AMM pool between a and b, in
+// that order, or found false without one
+func (s *Store) GetPoolReserves(ctx context.Context, a, b string) (reserveA, reserveB string, found bool, err error) {
+	var asset1 string
+	err = s.db.QueryRowContext(ctx, `
+		SELECT asset1, asset1_reserve, asset2_reserve
+		FROM core.amm_pools
+		WHERE (asset1 = $1 AND asset2 = $2) OR (asset1 = $2 AND asset2 = $1)
+		ORDER BY ledger_index DESC
+		LIMIT 1
+	`, a, b).Scan(&asset1, &reserveA, &reserveB)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, fmt.Errorf("failed to get pool reserves: %w", err)
+	}
+	if asset1 != a {
+		reserveA, reserveB = reserveB, reserveA
+	}
+	return reserveA, reserveB, true, nil
+}
+
 func (s *Store) InsertUsageEvent(ctx context.Context, event *UsageEvent) error {
 	query := `
 		INSERT INTO usage_events
-			(partner_id, quote_hash, pair, amount_in, amount_out, router_bps, fee_amount, tx_hash, ledger_index)
+			(partner_id, quote_hash, pair, in_asset, out_asset, amount_in, amount_out, router_bps, fee_amount,
+			 tx_hash, ledger_index, in_usd_price, out_usd_price)
 		VALUES
-			($1, $2, $3, $4::numeric, $5::numeric, $6, $7::numeric, $8, $9)
+			($1, $2, $3, $4, $5, $6::numeric, $7::numeric, $8, $9::numeric, $10, $11, $12::numeric, $13::numeric)
 		ON CONFLICT DO NOTHING
 	`
 
@@ -478,15 +506,146 @@ func (s *Store) InsertUsageEvent(ctx context.Context, event *UsageEvent) error {
 		event.PartnerID,
 		event.QuoteHash,
 		event.Pair,
+		event.InAsset,
+		event.OutAsset,
 		event.AmountIn,
 		event.AmountOut,
 		event.RouterBps,
 		event.FeeAmount,
 		event.TxHash,
 		event.LedgerIndex,
+		event.InUSDPrice,
+		event.OutUSDPrice,
 	)
 	if err != nil {
 		return fmt.Errorf("failed to insert usage event: %w", err)
 	}
 	return nil
 }
+
+// IssuerState represents the risk-relevant AccountRoot state of an issuer
+type IssuerState struct {
+	Account       string
+	Flags         int64
+	TransferRate  int64
+	GlobalFreeze  bool
+	NoFreeze      bool
+	AllowClawback bool
+	LedgerIndex   int64
+}
+
+// TrackIssuer registers an issuer seen in a pool or offer.
+// Returns true if the issuer was not tracked before.
+func (s *Store) TrackIssuer(ctx context.Context, account string, ledgerIndex int64) (bool, error) {
+	query := `
+		INSERT INTO core.issuers (account, ledger_index)
+		VALUES ($1, $2)
+		ON CONFLICT (account) DO NOTHING
+	`
+
+	result, err := s.db.ExecContext(ctx, query, account, ledgerIndex)
+	if err != nil {
+		return false, fmt.Errorf("failed to track issuer: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	return rows > 0, nil
+}
+
+// ListUnsyncedIssuers returns up to limit tracked issuers whose AccountRoot
+// has not been loaded yet, oldest first
+func (s *Store) ListUnsyncedIssuers(ctx context.Context, limit int) ([]string, error) {
+	query := `
+		SELECT account
+		FROM core.issuers
+		WHERE NOT synced
+		ORDER BY created_at
+		LIMIT $1
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unsynced issuers: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []string
+	for rows.Next() {
+		var account string
+		if err := rows.Scan(&account); err != nil {
+			return nil, fmt.Errorf("failed to scan issuer: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate issuers: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// UpsertIssuer stores issuer state fetched from rippled
+func (s *Store) UpsertIssuer(ctx context.Context, issuer *IssuerState) error {
+	query := `
+		INSERT INTO core.issuers
+			(account, flags, transfer_rate, global_freeze, no_freeze, allow_clawback, synced, ledger_index)
+		VALUES
+			($1, $2, $3, $4, $5, $6, TRUE, $7)
+		ON CONFLICT (account)
+		DO UPDATE SET
+			flags = EXCLUDED.flags,
+			transfer_rate = EXCLUDED.transfer_rate,
+			global_freeze = EXCLUDED.global_freeze,
+			no_freeze = EXCLUDED.no_freeze,
+			allow_clawback = EXCLUDED.allow_clawback,
+			synced = TRUE,
+			ledger_index = EXCLUDED.ledger_index,
+			updated_at = now()
+		WHERE core.issuers.ledger_index <= EXCLUDED.ledger_index
+	`
+
+	_, err := s.db.ExecContext(ctx, query,
+		issuer.Account,
+		issuer.Flags,
+		issuer.TransferRate,
+		issuer.GlobalFreeze,
+		issuer.NoFreeze,
+		issuer.AllowClawback,
+		issuer.LedgerIndex,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to upsert issuer: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateTrackedIssuer applies an AccountRoot change to an already tracked issuer.
+// Changes to accounts that are not issuers of indexed assets are ignored.
+func (s *Store) UpdateTrackedIssuer(ctx context.Context, issuer *IssuerState) error {
+	query := `
+		UPDATE core.issuers
+		SET flags = $2, transfer_rate = $3, global_freeze = $4, no_freeze = $5,
+		    allow_clawback = $6, synced = TRUE, ledger_index = $7, updated_at = now()
+		WHERE account = $1 AND ledger_index <= $7
+	`
+
+	_, err := s.db.ExecContext(ctx, query,
+		issuer.Account,
+		issuer.Flags,
+		issuer.TransferRate,
+		issuer.GlobalFreeze,
+		issuer.NoFreeze,
+		issuer.AllowClawback,
+		issuer.LedgerIndex,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update issuer: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lucendex/backend/internal/currency"
+	"github.com/lucendex/backend/internal/router"
+)
+
+// LoadIssuers reads synced issuer state so routing can skip frozen assets
+// and account for transfer fees.
+func (s *RouterStore) LoadIssuers(ctx context.Context) ([]router.IssuerInfo, error) {
+	risks, err := s.GetIssuers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	issuers := make([]router.IssuerInfo, 0, len(risks))
+	for _, r := range risks {
+		issuers = append(issuers, router.IssuerInfo{
+			Account:       r.Account,
+			GlobalFreeze:  r.GlobalFreeze,
+			NoFreeze:      r.NoFreeze,
+			AllowClawback: r.AllowClawback,
+			TransferFee:   router.TransferFeeFromRate(r.TransferRate),
+		})
+	}
+	return issuers, nil
+}
+
+// PolicyLoader adapts GetAssetPolicies to router.PolicyLoader.
+func (s *RouterStore) PolicyLoader() router.PolicyLoader {
+	return func(ctx context.Context, partnerID string) ([]router.PolicyRule, error) {
+		rules, err := s.GetAssetPolicies(ctx, partnerID)
+		if err != nil {
+			return nil, err
+		}
+
+		policy := make([]router.PolicyRule, 0, len(rules))
+		for _, r := range rules {
+			var code currency.Code
+			if r.Currency != "" {
+				// Fail closed: a deny rule we cannot parse must not be dropped
+				if code, err = currency.Parse(r.Currency); err != nil {
+					return nil, fmt.Errorf("asset policy currency %q: %w", r.Currency, err)
+				}
+			}
+			policy = append(policy, router.PolicyRule{
+				PartnerID: r.PartnerID,
+				List:      r.List,
+				Currency:  code,
+				Issuer:    r.Issuer,
+			})
+		}
+		return policy, nil
+	}
+}
+
+// MarketLoader adapts the pool, book and volume queries to
+// router.MarketLoader.
+func (s *RouterStore) MarketLoader() router.MarketLoader {
+	return func(ctx context.Context, since time.Time) (*router.MarketSnapshot, error) {
+		pools, err := s.GetPoolReserves(ctx)
+		if err != nil {
+			return nil, err
+		}
+		books, err := s.GetBookSummaries(ctx)
+		if err != nil {
+			return nil, err
+		}
+		volumes, err := s.GetTradeVolumes(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+
+		snap := &router.MarketSnapshot{}
+		for _, p := range pools {
+			snap.Pools = append(snap.Pools, router.PoolReserves{
+				Asset1:        p.Asset1,
+				Asset2:        p.Asset2,
+				Reserve1:      p.Reserve1,
+				Reserve2:      p.Reserve2,
+				TradingFeeBps: p.TradingFeeBps,
+			})
+		}
+		for _, b := range books {
+			snap.Books = append(snap.Books, router.BookSummary{
+				Gives:     b.Gives,
+				Wants:     b.Wants,
+				BestPrice: b.BestPrice,
+				Depth:     b.Depth,
+			})
+		}
+		for _, v := range volumes {
+			snap.Volumes = append(snap.Volumes, router.TradeVolume{
+				InAsset:   v.InAsset,
+				OutAsset:  v.OutAsset,
+				AmountIn:  v.AmountIn,
+				AmountOut: v.AmountOut,
+			})
+		}
+		return snap, nil
+	}
+}
+
+// ExecutionLoader adapts GetTradesSince to router.ExecutionLoader.
+func (s *RouterStore) ExecutionLoader() router.ExecutionLoader {
+	return func(ctx context.Context, afterID int64, limit int) ([]router.Execution, error) {
+		trades, err := s.GetTradesSince(ctx, afterID, limit)
+		if err != nil {
+			return nil, err
+		}
+
+		executions := make([]router.Execution, 0, len(trades))
+		for _, t := range trades {
+			executions = append(executions, router.Execution{
+				ID:         t.ID,
+				InAsset:    t.InAsset,
+				OutAsset:   t.OutAsset,
+				AmountIn:   t.AmountIn,
+				AmountOut:  t.AmountOut,
+				ExecutedAt: t.ExecutedAt,
+			})
+		}
+		return executions, nil
+	}
+}
+
+// TradeFeed feeds the trades recorded by the indexer into breaker
+func (s *RouterStore) TradeFeed(breaker *router.CircuitBreaker) *router.TradeFeed {
+	return router.NewTradeFeed(breaker, s.GetLastTradeIDBefore, s.ExecutionLoader())
+}
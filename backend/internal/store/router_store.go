@@ -6,6 +6,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"time"
+
+	"github.com/lucendex/backend/internal/xrpl"
 )
 
 type RouterStore struct {
@@ -217,6 +219,254 @@ func (s *RouterStore) SaveCircuitBreakerState(ctx context.Context, cbInterface i
 	return nil
 }
 
+type ExecutedTrade struct {
+	ID         int64
+	InAsset    string
+	OutAsset   string
+	AmountIn   string
+	AmountOut  string
+	ExecutedAt time.Time
+}
+
+func (s *RouterStore) GetTradesSince(ctx context.Context, afterID int64, limit int) ([]*ExecutedTrade, error) {
+	query := `
+		SELECT ct.id, ct.in_asset, ct.out_asset, ct.amount_in, ct.amount_out,
+		       COALESCE(lc.close_time_human, ct.created_at)
+		FROM core.completed_trades ct
+		LEFT JOIN core.ledger_checkpoints lc ON lc.ledger_index = ct.ledger_index
+		WHERE ct.id > $1
+		ORDER BY ct.id
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trades: %w", err)
+	}
+	defer rows.Close()
+
+	var trades []*ExecutedTrade
+	for rows.Next() {
+		t := &ExecutedTrade{}
+		if err := rows.Scan(&t.ID, &t.InAsset, &t.OutAsset, &t.AmountIn, &t.AmountOut, &t.ExecutedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan trade: %w", err)
+		}
+		trades = append(trades, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate trades: %w", err)
+	}
+
+	return trades, nil
+}
+
+// GetLastTradeIDBefore returns the highest completed trade ID that executed
+// before t, or 0 when there is none
+func (s *RouterStore) GetLastTradeIDBefore(ctx context.Context, t time.Time) (int64, error) {
+	query := `
+		SELECT COALESCE(MAX(ct.id), 0)
+		FROM core.completed_trades ct
+		LEFT JOIN core.ledger_checkpoints lc ON lc.ledger_index = ct.ledger_index
+		WHERE COALESCE(lc.close_time_human, ct.created_at) < $1
+	`
+
+	var id int64
+	if err := s.db.QueryRowContext(ctx, query, t).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to get trade cursor: %w", err)
+	}
+	return id, nil
+}
+
+type PoolReserves struct {
+	Asset1        string
+	Asset2        string
+	Reserve1      string
+	Reserve2      string
+	TradingFeeBps int
+}
+
+// GetPoolReserves returns every indexed AMM pool
+func (s *RouterStore) GetPoolReserves(ctx context.Context) ([]*PoolReserves, error) {
+	query := `
+		SELECT asset1, asset2, asset1_reserve, asset2_reserve, trading_fee
+		FROM core.amm_pools
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pools: %w", err)
+	}
+	defer rows.Close()
+
+	var pools []*PoolReserves
+	for rows.Next() {
+		p := &PoolReserves{}
+		if err := rows.Scan(&p.Asset1, &p.Asset2, &p.Reserve1, &p.Reserve2, &p.TradingFeeBps); err != nil {
+			return nil, fmt.Errorf("failed to scan pool: %w", err)
+		}
+		pools = append(pools, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate pools: %w", err)
+	}
+
+	return pools, nil
+}
+
+type BookSummary struct {
+	Gives     string
+	Wants     string
+	BestPrice string
+	Depth     string
+}
+
+// GetBookSummaries returns the best price and total size of the active,
+// unexpired offers of each book, keyed by the asset given and the asset
+// wanted
+func (s *RouterStore) GetBookSummaries(ctx context.Context) ([]*BookSummary, error) {
+	query := `
+		SELECT base_asset, quote_asset, MIN(price::NUMERIC)::TEXT, SUM(amount::NUMERIC)::TEXT
+		FROM core.orderbook_state
+		WHERE status = 'active'
+		  AND amount::NUMERIC > 0
+		  AND (expiration IS NULL OR expiration > $1)
+		GROUP BY base_asset, quote_asset
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, time.Now().Unix()-xrpl.RippleEpoch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get books: %w", err)
+	}
+	defer rows.Close()
+
+	var books []*BookSummary
+	for rows.Next() {
+		b := &BookSummary{}
+		if err := rows.Scan(&b.Gives, &b.Wants, &b.BestPrice, &b.Depth); err != nil {
+			return nil, fmt.Errorf("failed to scan book: %w", err)
+		}
+		books = append(books, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate books: %w", err)
+	}
+
+	return books, nil
+}
+
+type TradeVolume struct {
+	InAsset   string
+	OutAsset  string
+	AmountIn  string
+	AmountOut string
+}
+
+// GetTradeVolumes sums completed trades executed since the given time per
+// direction. Trades are placed by their ledger's close time, falling back to
+// when they were recorded, so indexer backfill lands in the right window.
+func (s *RouterStore) GetTradeVolumes(ctx context.Context, since time.Time) ([]*TradeVolume, error) {
+	query := `
+		SELECT ct.in_asset, ct.out_asset, SUM(ct.amount_in::NUMERIC)::TEXT, SUM(ct.amount_out::NUMERIC)::TEXT
+		FROM core.completed_trades ct
+		LEFT JOIN core.ledger_checkpoints lc ON lc.ledger_index = ct.ledger_index
+		WHERE COALESCE(lc.close_time_human, ct.created_at) >= $1
+		GROUP BY ct.in_asset, ct.out_asset
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trade volumes: %w", err)
+	}
+	defer rows.Close()
+
+	var volumes []*TradeVolume
+	for rows.Next() {
+		v := &TradeVolume{}
+		if err := rows.Scan(&v.InAsset, &v.OutAsset, &v.AmountIn, &v.AmountOut); err != nil {
+			return nil, fmt.Errorf("failed to scan trade volume: %w", err)
+		}
+		volumes = append(volumes, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate trade volumes: %w", err)
+	}
+
+	return volumes, nil
+}
+
+type IssuerRisk struct {
+	Account       string
+	TransferRate  int64
+	GlobalFreeze  bool
+	NoFreeze      bool
+	AllowClawback bool
+}
+
+func (s *RouterStore) GetIssuers(ctx context.Context) ([]*IssuerRisk, error) {
+	query := `
+		SELECT account, transfer_rate, global_freeze, no_freeze, allow_clawback
+		FROM core.issuers
+		WHERE synced
+	`
+
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issuers: %w", err)
+	}
+	defer rows.Close()
+
+	var issuers []*IssuerRisk
+	for rows.Next() {
+		i := &IssuerRisk{}
+		if err := rows.Scan(&i.Account, &i.TransferRate, &i.GlobalFreeze, &i.NoFreeze, &i.AllowClawback); err != nil {
+			return nil, fmt.Errorf("failed to scan issuer: %w", err)
+		}
+		issuers = append(issuers, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate issuers: %w", err)
+	}
+
+	return issuers, nil
+}
+
+type AssetPolicyRule struct {
+	PartnerID string // empty for global rules
+	List      string // "allow" or "deny"
+	Currency  string // empty matches every currency of Issuer
+	Issuer    string
+}
+
+// GetAssetPolicies returns the global allow/deny rules plus those of partnerID
+func (s *RouterStore) GetAssetPolicies(ctx context.Context, partnerID string) ([]*AssetPolicyRule, error) {
+	query := `
+		SELECT COALESCE(partner_id::text, ''), list, COALESCE(currency, ''), COALESCE(issuer, '')
+		FROM metering.asset_policies
+		WHERE partner_id IS NULL OR partner_id::text = $1
+		ORDER BY id
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get asset policies: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*AssetPolicyRule
+	for rows.Next() {
+		r := &AssetPolicyRule{}
+		if err := rows.Scan(&r.PartnerID, &r.List, &r.Currency, &r.Issuer); err != nil {
+			return nil, fmt.Errorf("failed to scan asset policy: %w", err)
+		}
+		rules = append(rules, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate asset policies: %w", err)
+	}
+
+	return rules, nil
+}
+
 type RouterAuditLog struct {
 	Event      string
 	PartnerID  *string
@@ -219,6 +219,71 @@ func TestRouterStore_LogAudit(t *testing.T) {
 	}
 }
 
+func TestRouterStore_GetTradesSince(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &RouterStore{db: db}
+	ctx := context.Background()
+
+	executedAt := time.Now().Truncate(time.Second)
+	rows := sqlmock.NewRows([]string{"id", "in_asset", "out_asset", "amount_in", "amount_out", "executed_at"}).
+		AddRow(int64(7), "XRP", "USD.rIssuer", "1000000", "1.5", executedAt).
+		AddRow(int64(8), "USD.rIssuer", "XRP", "3", "2000000", executedAt)
+
+	mock.ExpectQuery("SELECT (.+) FROM core.completed_trades").
+		WithArgs(int64(6), 100).
+		WillReturnRows(rows)
+
+	trades, err := store.GetTradesSince(ctx, 6, 100)
+	if err != nil {
+		t.Fatalf("GetTradesSince() error = %v", err)
+	}
+	if len(trades) != 2 {
+		t.Fatalf("len(trades) = %d, want 2", len(trades))
+	}
+	if trades[0].ID != 7 || trades[1].OutAsset != "XRP" {
+		t.Errorf("unexpected trades: %+v, %+v", trades[0], trades[1])
+	}
+	if !trades[0].ExecutedAt.Equal(executedAt) {
+		t.Errorf("ExecutedAt = %v, want %v", trades[0].ExecutedAt, executedAt)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRouterStore_GetLastTradeIDBefore(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &RouterStore{db: db}
+	since := time.Now().Add(-10 * time.Minute)
+
+	mock.ExpectQuery("SELECT COALESCE\\(MAX\\(ct.id\\), 0\\) FROM core.completed_trades").
+		WithArgs(since).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(42)))
+
+	id, err := store.GetLastTradeIDBefore(context.Background(), since)
+	if err != nil {
+		t.Fatalf("GetLastTradeIDBefore() error = %v", err)
+	}
+	if id != 42 {
+		t.Errorf("id = %d, want 42", id)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
 func TestRouterStore_SQLInjectionPrevention(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -244,3 +309,129 @@ func TestRouterStore_SQLInjectionPrevention(t *testing.T) {
 		t.Errorf("Unfulfilled expectations: %v", err)
 	}
 }
+
+func TestRouterStore_GetIssuers(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &RouterStore{db: db}
+	ctx := context.Background()
+
+	rows := sqlmock.NewRows([]string{"account", "transfer_rate", "global_freeze", "no_freeze", "allow_clawback"}).
+		AddRow("rIssuerA", int64(1002000000), false, true, false).
+		AddRow("rIssuerB", int64(0), true, false, true)
+
+	mock.ExpectQuery("SELECT (.+) FROM core.issuers").
+		WillReturnRows(rows)
+
+	issuers, err := store.GetIssuers(ctx)
+	if err != nil {
+		t.Fatalf("GetIssuers() error = %v", err)
+	}
+	if len(issuers) != 2 {
+		t.Fatalf("len(issuers) = %d, want 2", len(issuers))
+	}
+	if issuers[0].TransferRate != 1002000000 || !issuers[0].NoFreeze {
+		t.Errorf("unexpected issuer: %+v", issuers[0])
+	}
+	if !issuers[1].GlobalFreeze || !issuers[1].AllowClawback {
+		t.Errorf("unexpected issuer: %+v", issuers[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRouterStore_GetAssetPolicies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &RouterStore{db: db}
+	ctx := context.Background()
+
+	partnerID := "6f1c2f6e-5d43-4c53-9c2e-8f1f3b0a9d11"
+	rows := sqlmock.NewRows([]string{"partner_id", "list", "currency", "issuer"}).
+		AddRow("", "deny", "", "rBadIssuer").
+		AddRow(partnerID, "allow", "USD", "rIssuer")
+
+	mock.ExpectQuery("SELECT (.+) FROM metering.asset_policies").
+		WithArgs(partnerID).
+		WillReturnRows(rows)
+
+	rules, err := store.GetAssetPolicies(ctx, partnerID)
+	if err != nil {
+		t.Fatalf("GetAssetPolicies() error = %v", err)
+	}
+	if len(rules) != 2 {
+		t.Fatalf("len(rules) = %d, want 2", len(rules))
+	}
+	if rules[0].PartnerID != "" || rules[0].List != "deny" || rules[0].Issuer != "rBadIssuer" {
+		t.Errorf("unexpected global rule: %+v", rules[0])
+	}
+	if rules[1].PartnerID != partnerID || rules[1].Currency != "USD" {
+		t.Errorf("unexpected partner rule: %+v", rules[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRouterStore_MarketData(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("sqlmock.New() error = %v", err)
+	}
+	defer db.Close()
+
+	store := &RouterStore{db: db}
+	ctx := context.Background()
+	since := time.Now().Add(-24 * time.Hour)
+
+	mock.ExpectQuery("SELECT (.+) FROM core.amm_pools").
+		WillReturnRows(sqlmock.NewRows([]string{"asset1", "asset2", "asset1_reserve", "asset2_reserve", "trading_fee"}).
+			AddRow("XRP", "USD.rIssuerA", "10000000000", "15000", 30))
+	mock.ExpectQuery("SELECT (.+) FROM core.orderbook_state (.+) GROUP BY base_asset, quote_asset").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"base_asset", "quote_asset", "min", "sum"}).
+			AddRow("XRP", "USD.rIssuerA", "0.0000016", "1000000000"))
+	mock.ExpectQuery("SELECT (.+) FROM core.completed_trades ct LEFT JOIN core.ledger_checkpoints lc (.+) WHERE COALESCE\\(lc.close_time_human, ct.created_at\\) >= \\$1 GROUP BY ct.in_asset, ct.out_asset").
+		WithArgs(since).
+		WillReturnRows(sqlmock.NewRows([]string{"in_asset", "out_asset", "sum", "sum"}).
+			AddRow("USD.rIssuerA", "XRP", "30", "20000000"))
+
+	pools, err := store.GetPoolReserves(ctx)
+	if err != nil {
+		t.Fatalf("GetPoolReserves() error = %v", err)
+	}
+	if len(pools) != 1 || pools[0].Reserve1 != "10000000000" || pools[0].TradingFeeBps != 30 {
+		t.Errorf("unexpected pools: %+v", pools)
+	}
+
+	books, err := store.GetBookSummaries(ctx)
+	if err != nil {
+		t.Fatalf("GetBookSummaries() error = %v", err)
+	}
+	if len(books) != 1 || books[0].Gives != "XRP" || books[0].BestPrice != "0.0000016" {
+		t.Errorf("unexpected books: %+v", books)
+	}
+
+	volumes, err := store.GetTradeVolumes(ctx, since)
+	if err != nil {
+		t.Fatalf("GetTradeVolumes() error = %v", err)
+	}
+	if len(volumes) != 1 || volumes[0].AmountOut != "20000000" {
+		t.Errorf("unexpected volumes: %+v", volumes)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -455,6 +455,46 @@ func GetServerInfoHTTP(rpcURL string) (*ServerInfoResponse, error) {
 	return &serverInfo, nil
 }
 
+// GetAccountInfoHTTP requests the validated AccountRoot of an account via HTTP RPC
+func GetAccountInfoHTTP(rpcURL, account string) (*AccountInfoResponse, error) {
+	reqBody := map[string]interface{}{
+		"method": "account_info",
+		"params": []interface{}{
+			map[string]interface{}{
+				"account":      account,
+				"ledger_index": "validated",
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	resp, err := http.Post(rpcURL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var info AccountInfoResponse
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if info.Result.Error != "" {
+		return nil, fmt.Errorf("account_info failed: %s", info.Result.Error)
+	}
+
+	return &info, nil
+}
+
 // Helper function
 func min(a, b int) int {
 	if a < b {
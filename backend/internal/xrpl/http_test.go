@@ -78,3 +78,65 @@ func TestGetServerInfoHTTP_NetworkError(t *testing.T) {
 		t.Error("Expected error for invalid URL, got nil")
 	}
 }
+
+func TestGetAccountInfoHTTP(t *testing.T) {
+	tests := []struct {
+		name         string
+		serverResp   string
+		wantErr      bool
+		wantFlags    uint32
+		wantTransfer uint32
+	}{
+		{
+			name: "issuer with global freeze and transfer fee",
+			serverResp: `{
+				"result": {
+					"account_data": {
+						"Account": "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B",
+						"Flags": 4194304,
+						"TransferRate": 1002000000
+					},
+					"validated": true,
+					"status": "success"
+				}
+			}`,
+			wantFlags:    LsfGlobalFreeze,
+			wantTransfer: 1002000000,
+		},
+		{
+			name: "account not found",
+			serverResp: `{
+				"result": {
+					"error": "actNotFound",
+					"status": "error"
+				}
+			}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(tt.serverResp))
+			}))
+			defer server.Close()
+
+			info, err := GetAccountInfoHTTP(server.URL, "rvYAfWj5gh67oV6fW32ZzP3Aw4Eubs59B")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetAccountInfoHTTP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if info.Result.AccountData.Flags != tt.wantFlags {
+				t.Errorf("Flags = %d, want %d", info.Result.AccountData.Flags, tt.wantFlags)
+			}
+			if info.Result.AccountData.TransferRate != tt.wantTransfer {
+				t.Errorf("TransferRate = %d, want %d", info.Result.AccountData.TransferRate, tt.wantTransfer)
+			}
+		})
+	}
+}
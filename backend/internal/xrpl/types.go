@@ -1,9 +1,17 @@
 package xrpl
 
-import "encoding/json"
+import (
+	"encoding/json"
+
+	"github.com/lucendex/backend/internal/currency"
+)
 
 // Core XRPL types for indexer
 
+// RippleEpoch is 2000-01-01T00:00:00Z in Unix seconds, the zero of XRPL
+// ledger close times and offer expirations
+const RippleEpoch = 946684800
+
 // LedgerResponse represents a ledger from the subscription stream
 type LedgerResponse struct {
 	Type          string `json:"type"`
@@ -107,6 +115,34 @@ type ServerInfoResponse struct {
 	Type string `json:"type,omitempty"`
 }
 
+// AccountRoot flags relevant to issuer risk
+const (
+	LsfNoFreeze               uint32 = 0x00200000
+	LsfGlobalFreeze           uint32 = 0x00400000
+	LsfAllowTrustLineClawback uint32 = 0x80000000
+
+	// TransferRateParity is the TransferRate value meaning "no fee"
+	TransferRateParity uint32 = 1000000000
+)
+
+// AccountRoot holds the AccountRoot fields the indexer tracks for issuers
+type AccountRoot struct {
+	Account      string `json:"Account"`
+	Flags        uint32 `json:"Flags"`
+	TransferRate uint32 `json:"TransferRate,omitempty"`
+}
+
+// AccountInfoResponse represents account_info response
+type AccountInfoResponse struct {
+	Result struct {
+		AccountData AccountRoot `json:"account_data"`
+		LedgerIndex uint64      `json:"ledger_index,omitempty"`
+		Validated   bool        `json:"validated,omitempty"`
+		Status      string      `json:"status"`
+		Error       string      `json:"error,omitempty"`
+	} `json:"result"`
+}
+
 // Amount represents an XRPL amount (XRP or IOU)
 type Amount struct {
 	Currency string `json:"currency,omitempty"`
@@ -140,10 +176,8 @@ func (a *Amount) ParseAmount() string {
 	return a.Value
 }
 
-// FormatAsset returns asset in standard format: "XRP" or "CURRENCY.ISSUER"
-func FormatAsset(currency, issuer string) string {
-	if currency == "XRP" || issuer == "" {
-		return "XRP"
-	}
-	return currency + "." + issuer
+// FormatAsset returns asset in standard format: "XRP" or "CURRENCY.ISSUER",
+// with the currency code canonicalized (see currency.Parse)
+func FormatAsset(code, issuer string) (string, error) {
+	return currency.FormatAsset(code, issuer)
 }
@@ -115,6 +115,7 @@ func TestFormatAsset(t *testing.T) {
 		currency string
 		issuer   string
 		want     string
+		wantErr  bool
 	}{
 		{
 			name:     "XRP",
@@ -123,10 +124,10 @@ func TestFormatAsset(t *testing.T) {
 			want:     "XRP",
 		},
 		{
-			name:     "XRP with issuer (should ignore)",
+			name:     "XRP with issuer",
 			currency: "XRP",
 			issuer:   "rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
-			want:     "XRP",
+			wantErr:  true,
 		},
 		{
 			name:     "USD IOU",
@@ -135,22 +136,37 @@ func TestFormatAsset(t *testing.T) {
 			want:     "USD.rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
 		},
 		{
-			name:     "Custom token",
+			name:     "Hex token",
+			currency: "534f4c4f00000000000000000000000000000000",
+			issuer:   "rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
+			want:     "534F4C4F00000000000000000000000000000000.rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
+		},
+		{
+			name:     "Standard code in hex form",
+			currency: "0000000000000000000000005553440000000000",
+			issuer:   "rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
+			want:     "USD.rN7n7otQDd6FczFgLdlqtyMVrn3HMfWi",
+		},
+		{
+			name:     "Four character code",
 			currency: "SOLO",
 			issuer:   "rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
-			want:     "SOLO.rsoLo2S1kiGeCcn6hCUXVrCpGMWLrRrLZz",
+			wantErr:  true,
 		},
 		{
 			name:     "Empty issuer",
 			currency: "USD",
 			issuer:   "",
-			want:     "XRP", // Defaults to XRP
+			wantErr:  true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := FormatAsset(tt.currency, tt.issuer)
+			got, err := FormatAsset(tt.currency, tt.issuer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FormatAsset() error = %v, wantErr %v", err, tt.wantErr)
+			}
 			if got != tt.want {
 				t.Errorf("FormatAsset() = %v, want %v", got, tt.want)
 			}
@@ -54,6 +54,7 @@ echo "Created roles:"
 echo "  - indexer_rw (read/write for indexer)"
 echo "  - router_ro (read-only for router)"
 echo "  - api_ro (read-only for API + metering write)"
+echo "  - partner_admin (partner admin API, writes partners/keys/audit)"
 echo ""
 echo "Next steps:"
 echo "  1. Set passwords for database roles"